@@ -0,0 +1,75 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+)
+
+// containerNameFormat matches the container names docker accepts
+var containerNameFormat = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// Accessory is a long-lived service, such as a database or cache, that runs
+// next to the app. Accessories are created on the first deployment and left
+// untouched by app deployments. The container is named after the accessory.
+type Accessory struct {
+	Name    string            `json:"name"`
+	Image   string            `json:"image"`
+	Host    string            `json:"host,omitempty"`
+	Port    string            `json:"port,omitempty"`
+	Network string            `json:"network,omitempty"`
+	Volumes []string          `json:"volumes,omitempty"`
+	Env     map[string]string `json:"env,omitempty"`
+	EnvFile string            `json:"envFile,omitempty"`
+	Cmd     []string          `json:"cmd,omitempty"`
+}
+
+// AccessoryConfig returns the configuration used to reach the host of an
+// accessory. Accessories run on the first host of the app unless they set
+// their own, and join the app's network unless they set their own.
+func (c *Config) AccessoryConfig(accessory Accessory) Config {
+	config := *c
+	config.Stack = nil
+	config.ServiceName = ""
+	config.ContainerName = accessory.Name
+	if accessory.Host != "" {
+		config.Host = accessory.Host
+	}
+	config.Hosts = []string{config.Host}
+	config.Network = accessory.Network
+	if config.Network == "" {
+		config.Network = c.Network
+	}
+	config.Volumes = accessory.Volumes
+	return config
+}
+
+// FindAccessory returns the accessory with the given name
+func (c *Config) FindAccessory(name string) (Accessory, error) {
+	for _, accessory := range c.Accessories {
+		if accessory.Name == name {
+			return accessory, nil
+		}
+	}
+	return Accessory{}, fmt.Errorf("accessory %s is not defined in the config file", name)
+}
+
+// validateAccessories checks that every accessory has a usable name and an
+// image, and that names are unique
+func (c *Config) validateAccessories() error {
+	var names []string
+	for _, accessory := range c.Accessories {
+		if !containerNameFormat.MatchString(accessory.Name) {
+			return fmt.Errorf("invalid accessory name %q: expected letters, digits, '_', '.' or '-'", accessory.Name)
+		}
+		if slices.Contains(names, accessory.Name) {
+			return fmt.Errorf("accessory %s is defined more than once", accessory.Name)
+		}
+		names = append(names, accessory.Name)
+
+		if accessory.Image == "" {
+			return fmt.Errorf("accessory %s needs an image", accessory.Name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// Agent configures the optional agent that watches the container on every
+// host between deployments
+type Agent struct {
+	Enabled   bool   `json:"enabled,omitempty"`
+	Interval  string `json:"interval,omitempty"`
+	Failures  int    `json:"failures,omitempty"`
+	Rollback  bool   `json:"rollback,omitempty"`
+	ReportURL string `json:"reportUrl,omitempty"`
+}
+
+// Agent defaults
+const (
+	defaultAgentInterval = 30 * time.Second
+	defaultAgentFailures = 3
+)
+
+// CheckInterval returns how often the agent checks the container
+func (a Agent) CheckInterval() time.Duration {
+	interval, err := time.ParseDuration(a.Interval)
+	if err != nil || interval <= 0 {
+		return defaultAgentInterval
+	}
+	return interval
+}
+
+// FailureThreshold returns the number of consecutive failed checks after
+// which the agent acts
+func (a Agent) FailureThreshold() int {
+	if a.Failures < 1 {
+		return defaultAgentFailures
+	}
+	return a.Failures
+}
+
+// validate checks the agent settings
+func (a Agent) validate() error {
+	if a.Interval != "" {
+		interval, err := time.ParseDuration(a.Interval)
+		if err != nil {
+			return fmt.Errorf("invalid agent interval %q: %v", a.Interval, err)
+		}
+		if interval < time.Second {
+			return fmt.Errorf("invalid agent interval %q: expected at least 1s", a.Interval)
+		}
+	}
+	if a.Failures < 0 {
+		return fmt.Errorf("invalid agent failures %d: expected at least 1", a.Failures)
+	}
+	return nil
+}
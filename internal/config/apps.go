@@ -0,0 +1,258 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// templateVariable matches a ${name} variable in an app template, or an
+// escaped $${name} that is kept as ${name}
+var templateVariable = regexp.MustCompile(`\$?\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// App is one of the independent apps of a workspace, a config file running
+// several apps on the same hosts. Its settings use the same names as the
+// config file and override the top-level configuration, which holds what the
+// apps share, such as the hosts, the network and the accessories. An app can
+// inherit the settings of a template, filled in with its variables.
+type App struct {
+	Name     string
+	Template string
+	Vars     map[string]string
+	settings json.RawMessage
+}
+
+// Templates are the settings shared by apps of a workspace, by template name
+type Templates map[string]json.RawMessage
+
+// UnmarshalJSON reads the name, template and variables of an app and keeps
+// the remaining settings to apply on top of the top-level configuration
+func (a *App) UnmarshalJSON(data []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(fields["name"], &a.Name); err != nil || a.Name == "" {
+		return fmt.Errorf("every app needs a name")
+	}
+	delete(fields, "name")
+
+	if template, ok := fields["template"]; ok {
+		if err := json.Unmarshal(template, &a.Template); err != nil {
+			return fmt.Errorf("invalid template of app %s: %v", a.Name, err)
+		}
+		delete(fields, "template")
+	}
+	if vars, ok := fields["vars"]; ok {
+		if err := json.Unmarshal(vars, &a.Vars); err != nil {
+			return fmt.Errorf("invalid vars of app %s: %v", a.Name, err)
+		}
+		delete(fields, "vars")
+	}
+
+	settings, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	a.settings = settings
+	return nil
+}
+
+// AppConfigs returns the configuration of every app of the workspace, in the
+// order they are defined. Apps sharing a host may not use the same container
+// name or host port, and no two apps the same domain. Without apps the
+// configuration itself is the only app.
+func (c *Config) AppConfigs() ([]Config, error) {
+	if len(c.Apps) == 0 {
+		return []Config{*c}, nil
+	}
+
+	var apps []Config
+	owners := make(map[string]string)
+	claim := func(key string, app string, conflict string) error {
+		if owner, taken := owners[key]; taken && owner != app {
+			return fmt.Errorf("apps %s and %s both use %s", owner, app, conflict)
+		}
+		owners[key] = app
+		return nil
+	}
+
+	for _, app := range c.Apps {
+		if _, exists := owners["app/"+app.Name]; exists {
+			return nil, fmt.Errorf("app %s is defined more than once", app.Name)
+		}
+		owners["app/"+app.Name] = app.Name
+
+		appConfig, err := c.app(app)
+		if err != nil {
+			return nil, err
+		}
+		if appConfig.Domain != "" {
+			if err := claim("domain/"+appConfig.Domain, app.Name, "domain "+appConfig.Domain); err != nil {
+				return nil, err
+			}
+		}
+
+		services, err := appConfig.Services()
+		if err != nil {
+			return nil, fmt.Errorf("invalid stack of app %s: %v", app.Name, err)
+		}
+		for _, service := range services {
+			for _, host := range service.Hosts {
+				if err := claim("container/"+host+"/"+service.ContainerName, app.Name,
+					fmt.Sprintf("container name %s on %s", service.ContainerName, host)); err != nil {
+					return nil, err
+				}
+				if err := claim("port/"+host+"/"+service.HostPort, app.Name,
+					fmt.Sprintf("host port %s on %s", service.HostPort, host)); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		apps = append(apps, appConfig)
+	}
+	return apps, nil
+}
+
+// App returns the configuration of a single app of the workspace
+func (c *Config) App(name string) (Config, error) {
+	apps, err := c.AppConfigs()
+	if err != nil {
+		return Config{}, err
+	}
+	for _, app := range apps {
+		if app.AppName == name {
+			return app, nil
+		}
+	}
+	return Config{}, fmt.Errorf("app %s is not defined in the config file, the apps are: %s", name, strings.Join(c.AppNames(), ", "))
+}
+
+// AppNames returns the names of the apps of the workspace
+func (c *Config) AppNames() []string {
+	names := make([]string, len(c.Apps))
+	for i, app := range c.Apps {
+		names[i] = app.Name
+	}
+	return names
+}
+
+// app applies the settings of an app, and of its template, on top of the
+// configuration. The container and image are named after the app unless it
+// sets them.
+func (c *Config) app(app App) (Config, error) {
+	config := *c
+	config.Apps = nil
+	config.Templates = nil
+	config.AppName = app.Name
+	config.ContainerName = app.Name
+	config.Image = app.Name
+	config.BuildArgs = maps.Clone(c.BuildArgs)
+	config.Env = nil
+	config.Host = ""
+	config.Hosts = nil
+
+	if app.Template != "" {
+		template, ok := c.Templates[app.Template]
+		if !ok {
+			return Config{}, fmt.Errorf("app %s uses unknown template %s", app.Name, app.Template)
+		}
+		settings, err := app.fill(template)
+		if err != nil {
+			return Config{}, err
+		}
+		if err := decodeSettings(settings, &config); err != nil {
+			return Config{}, fmt.Errorf("invalid settings in template %s: %v", app.Template, err)
+		}
+	}
+
+	if err := decodeSettings(app.settings, &config); err != nil {
+		return Config{}, fmt.Errorf("invalid settings for app %s: %v", app.Name, err)
+	}
+	if err := interpolateEnv(config.Env); err != nil {
+		return Config{}, fmt.Errorf("app %s: %v", app.Name, err)
+	}
+	if err := config.interpolate(c); err != nil {
+		return Config{}, fmt.Errorf("app %s: %v", app.Name, err)
+	}
+	config.inheritEnv(c.Env)
+
+	// Apps run on the top-level hosts unless they set their own
+	switch {
+	case len(config.Hosts) > 0:
+	case config.Host != "":
+		config.Hosts = []string{config.Host}
+	case len(c.Hosts) > 0:
+		config.Hosts = c.Hosts
+	case c.Host != "":
+		config.Hosts = []string{c.Host}
+	}
+	if len(config.Hosts) > 0 {
+		config.Host = config.Hosts[0]
+	}
+
+	if config.PrebuiltImage != "" {
+		config.Tag = referenceTag(config.PrebuiltImage)
+	}
+
+	config.SSHKey = expandHome(config.SSHKey)
+	config.KnownHosts = expandHome(config.KnownHosts)
+
+	return config, nil
+}
+
+// decodeSettings applies the settings of an app or template on top of the
+// configuration, which may not define further apps or templates
+func decodeSettings(settings json.RawMessage, config *Config) error {
+	decoder := json.NewDecoder(bytes.NewReader(settings))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(config); err != nil {
+		return err
+	}
+	if config.Apps != nil || config.Templates != nil {
+		return fmt.Errorf("apps and templates cannot be nested")
+	}
+	return nil
+}
+
+// fill replaces the ${name} variables in the settings of a template with the
+// variables of the app, where ${app} is the name of the app. $${name} is left
+// as ${name}, for shell variables in hooks.
+func (a App) fill(template json.RawMessage) (json.RawMessage, error) {
+	var missing []string
+	filled := templateVariable.ReplaceAllFunc(template, func(match []byte) []byte {
+		if bytes.HasPrefix(match, []byte("$$")) {
+			return match[1:]
+		}
+		name := string(templateVariable.FindSubmatch(match)[1])
+		value, ok := a.Vars[name]
+		if name == "app" && !ok {
+			value, ok = a.Name, true
+		}
+		if !ok {
+			missing = append(missing, string(match))
+			return match
+		}
+		// The variable is inside a JSON string, so its value is escaped
+		escaped, _ := json.Marshal(value)
+		return escaped[1 : len(escaped)-1]
+	})
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("app %s does not set %s, used by template %s", a.Name, strings.Join(missing, ", "), a.Template)
+	}
+	return filled, nil
+}
+
+// appName returns the app given with --app or PIPE_APP
+func appName(args []string) string {
+	if name := flagValue(args, "app"); name != "" {
+		return name
+	}
+	return os.Getenv("PIPE_APP")
+}
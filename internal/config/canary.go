@@ -0,0 +1,44 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// ReplacesAlongside reports whether the strategy starts the new version next
+// to the running container and only replaces it once the new version is
+// healthy
+func (c *Config) ReplacesAlongside() bool {
+	return c.Strategy == StrategyBlueGreen || c.Strategy == StrategyCanary
+}
+
+// CanaryHosts splits the hosts into the canary hosts, the weight's share of
+// them but at least one, and the hosts deployed after the canary
+func (c *Config) CanaryHosts() ([]string, []string) {
+	count := max(len(c.Hosts)*c.CanaryWeight/100, 1)
+	return c.Hosts[:count], c.Hosts[count:]
+}
+
+// CanaryBakeDuration returns how long the canary hosts must stay healthy
+func (c *Config) CanaryBakeDuration() (time.Duration, error) {
+	bake, err := time.ParseDuration(c.CanaryBake)
+	if err != nil || bake < 0 {
+		return 0, fmt.Errorf("invalid canary bake %q: expected a duration such as 5m", c.CanaryBake)
+	}
+	return bake, nil
+}
+
+// validateCanary checks the canary weight and bake time. The canary is a
+// share of the hosts, so it needs more than one.
+func (c *Config) validateCanary() error {
+	if c.CanaryWeight < 1 || c.CanaryWeight > 99 {
+		return fmt.Errorf("invalid canary weight %d: expected a percentage between 1 and 99", c.CanaryWeight)
+	}
+	if _, err := c.CanaryBakeDuration(); err != nil {
+		return err
+	}
+	if len(c.Hosts) < 2 {
+		return fmt.Errorf("the canary strategy deploys a share of the hosts first and needs at least two hosts")
+	}
+	return nil
+}
@@ -0,0 +1,390 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// subcommands are completed as the first argument of their command
+var subcommands = map[string][]string{
+	"releases":    {"show", "contains"},
+	"maintenance": {"on", "off"},
+	"compare":     {"hosts"},
+	"jobs":        {"run", "history", "logs", "scheduled", "unschedule"},
+	"host":        {"reboot"},
+	"accessory":   {"start", "stop", "logs"},
+	"fleet":       {"exec"},
+	"agent":       {"install", "uninstall", "status"},
+	"metrics":     {"install", "uninstall", "targets"},
+	"completion":  {"bash", "zsh", "fish"},
+}
+
+// fileFlags take a path and complete file names
+var fileFlags = map[string]bool{
+	"config":           true,
+	"env-file":         true,
+	"ssh-key":          true,
+	"known-hosts":      true,
+	"docker-cert-path": true,
+	"dockerfile":       true,
+	"build-secret":     true,
+	"log-file":         true,
+	"output":           true,
+	"workflow":         true,
+}
+
+// nameFlags complete the names of the apps or stack services defined in the
+// config file, listed by the hidden __complete command
+var nameFlags = map[string]string{
+	"app":     "app",
+	"service": "service",
+}
+
+// completionCommand is a command as seen by the completion scripts
+type completionCommand struct {
+	name        string
+	description string
+	flags       []completionFlag
+	subcommands []string
+}
+
+// completionFlag is a flag of a command as seen by the completion scripts
+type completionFlag struct {
+	name        string
+	description string
+	boolean     bool
+}
+
+// Completion returns the completion script of pipe for a shell: bash, zsh
+// or fish
+func Completion(shell string) (string, error) {
+	commands := completionCommands()
+	switch shell {
+	case "bash":
+		return bashCompletion(commands), nil
+	case "zsh":
+		return zshCompletion(commands), nil
+	case "fish":
+		return fishCompletion(commands), nil
+	}
+	return "", fmt.Errorf("unsupported shell %q: expected bash, zsh or fish", shell)
+}
+
+// CompletionNames returns the names the completion scripts offer for --app
+// or --service, read from the config file the arguments point to
+func CompletionNames(kind string, args []string) ([]string, error) {
+	config := Defaults()
+	if path := configPath(args); path != "" {
+		if err := loadFile(path, &config); err != nil {
+			return nil, fmt.Errorf("failed to load config file %s: %v", path, err)
+		}
+	}
+
+	switch kind {
+	case "app":
+		return config.AppNames(), nil
+	case "service":
+		names := make([]string, len(config.Stack))
+		for i, service := range config.Stack {
+			names[i] = service.Name
+		}
+		return names, nil
+	}
+	return nil, fmt.Errorf("unknown completion %q: expected app or service", kind)
+}
+
+// completionCommands returns the commands in the order of the help text,
+// with the flags they accept
+func completionCommands() []completionCommand {
+	var commands []completionCommand
+	for _, entry := range helpCommands() {
+		command := completionCommand{name: entry[0], description: entry[1], subcommands: subcommands[entry[0]]}
+		if _, ok := commandFlags[command.name]; ok {
+			config := Defaults()
+			fs := newFlagSet(command.name, &config, "")
+			fs.VisitAll(func(f *flag.Flag) {
+				if hiddenFlags[f.Name] {
+					return
+				}
+				boolFlag, ok := f.Value.(interface{ IsBoolFlag() bool })
+				command.flags = append(command.flags, completionFlag{
+					name:        f.Name,
+					description: f.Usage,
+					boolean:     ok && boolFlag.IsBoolFlag(),
+				})
+			})
+		}
+		commands = append(commands, command)
+	}
+	return commands
+}
+
+// helpCommands returns the name and description of every command listed in
+// the help text. Descriptions start at the same column, or on the next line
+// after usages running into it.
+func helpCommands() [][2]string {
+	const column = 26
+
+	var commands [][2]string
+	_, section, _ := strings.Cut(helpText, "\nCommands:\n")
+	section, _, _ = strings.Cut(section, "\n\n")
+	for _, line := range strings.Split(section, "\n") {
+		usage, description := strings.TrimSpace(line), ""
+		if len(line) > column && line[column-1] == ' ' {
+			usage, description = strings.TrimSpace(line[:column]), strings.TrimSpace(line[column:])
+		}
+
+		// A continuation line describes the previous command
+		if usage == "" {
+			if n := len(commands); n > 0 && commands[n-1][1] == "" {
+				commands[n-1][1] = description
+			}
+			continue
+		}
+
+		name := strings.Fields(usage)[0]
+		if slices.ContainsFunc(commands, func(command [2]string) bool { return command[0] == name }) {
+			continue
+		}
+		commands = append(commands, [2]string{name, description})
+	}
+	return commands
+}
+
+// bashCompletion returns the bash completion script
+func bashCompletion(commands []completionCommand) string {
+	var b strings.Builder
+	b.WriteString(`# bash completion for pipe, generated by 'pipe completion bash'
+
+_pipe_names() {
+    local config="" i
+    for ((i = 1; i < COMP_CWORD - 1; i++)); do
+        if [[ ${COMP_WORDS[i]} == --config || ${COMP_WORDS[i]} == -config ]]; then
+            config=${COMP_WORDS[i+1]}
+        fi
+    done
+    "${COMP_WORDS[0]}" __complete "$1" ${config:+--config "$config"} 2>/dev/null
+}
+
+_pipe() {
+    local cur=${COMP_WORDS[COMP_CWORD]} prev=${COMP_WORDS[COMP_CWORD-1]}
+    local command=deploy
+    if [[ $COMP_CWORD -gt 1 && ${COMP_WORDS[1]} != -* ]]; then
+        command=${COMP_WORDS[1]}
+    fi
+
+    case $prev in
+`)
+	for _, flag := range sortedKeys(nameFlags) {
+		fmt.Fprintf(&b, "        --%s|-%s)\n            COMPREPLY=($(compgen -W \"$(_pipe_names %s)\" -- \"$cur\"))\n            return ;;\n", flag, flag, nameFlags[flag])
+	}
+	var files []string
+	for _, flag := range sortedKeys(fileFlags) {
+		files = append(files, "--"+flag, "-"+flag)
+	}
+	fmt.Fprintf(&b, "        %s)\n            COMPREPLY=($(compgen -f -- \"$cur\"))\n            return ;;\n", strings.Join(files, "|"))
+	b.WriteString(`    esac
+
+    if [[ $cur == -* ]]; then
+        local flags=""
+        case $command in
+`)
+	for _, command := range commands {
+		if len(command.flags) == 0 {
+			continue
+		}
+		var names []string
+		for _, flag := range command.flags {
+			names = append(names, "--"+flag.name)
+		}
+		fmt.Fprintf(&b, "            %s) flags=\"%s\" ;;\n", command.name, strings.Join(names, " "))
+	}
+	b.WriteString(`        esac
+        COMPREPLY=($(compgen -W "$flags" -- "$cur"))
+        return
+    fi
+
+    if [[ $COMP_CWORD -eq 1 ]]; then
+`)
+	var names []string
+	for _, command := range commands {
+		names = append(names, command.name)
+	}
+	fmt.Fprintf(&b, "        COMPREPLY=($(compgen -W \"%s\" -- \"$cur\"))\n", strings.Join(names, " "))
+	b.WriteString(`    elif [[ $COMP_CWORD -eq 2 ]]; then
+        case $command in
+`)
+	for _, command := range commands {
+		if len(command.subcommands) > 0 {
+			fmt.Fprintf(&b, "            %s) COMPREPLY=($(compgen -W \"%s\" -- \"$cur\")) ;;\n", command.name, strings.Join(command.subcommands, " "))
+		}
+	}
+	b.WriteString(`        esac
+    fi
+}
+
+complete -o default -F _pipe pipe
+`)
+	return b.String()
+}
+
+// zshCompletion returns the zsh completion script
+func zshCompletion(commands []completionCommand) string {
+	var b strings.Builder
+	b.WriteString(`#compdef pipe
+# zsh completion for pipe, generated by 'pipe completion zsh'
+
+_pipe_names() {
+  local -a names config
+  local i=${words[(I)--config]}
+  (( i > 0 && i < CURRENT - 1 )) && config=(--config ${words[i+1]})
+  names=(${(f)"$($_pipe_bin __complete $1 $config 2>/dev/null)"})
+  compadd -a names
+}
+
+_pipe() {
+  local _pipe_bin=$words[1]
+  local -a commands
+  commands=(
+`)
+	for _, command := range commands {
+		fmt.Fprintf(&b, "    %s\n", shellQuote(command.name+":"+command.description))
+	}
+	b.WriteString(`  )
+
+  local command=deploy
+  if [[ $words[2] != -* ]]; then
+    if (( CURRENT == 2 )); then
+      _describe -t commands 'pipe command' commands
+      return
+    fi
+    command=$words[2]
+    shift words
+    (( CURRENT-- ))
+  fi
+
+  case $command in
+`)
+	for _, command := range commands {
+		if len(command.flags) == 0 && len(command.subcommands) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "    %s)\n      _arguments", command.name)
+		for _, flag := range command.flags {
+			spec := fmt.Sprintf("*--%s[%s]", flag.name, zshDescription(flag.description))
+			switch {
+			case flag.boolean:
+			case fileFlags[flag.name]:
+				spec += ":file:_files"
+			case nameFlags[flag.name] != "":
+				spec += fmt.Sprintf(":%s:_pipe_names %s", flag.name, nameFlags[flag.name])
+			default:
+				spec += ":" + flag.name + ":"
+			}
+			fmt.Fprintf(&b, " \\\n        %s", shellQuote(spec))
+		}
+		if len(command.subcommands) > 0 {
+			fmt.Fprintf(&b, " \\\n        %s", shellQuote(fmt.Sprintf("1:%s:(%s)", command.name, strings.Join(command.subcommands, " "))))
+		}
+		b.WriteString(" \\\n        '*::argument:_default' ;;\n")
+	}
+	b.WriteString(`  esac
+}
+
+# Run when autoloaded from fpath, register when sourced
+if [[ $funcstack[1] == _pipe ]]; then
+  _pipe "$@"
+else
+  compdef _pipe pipe
+fi
+`)
+	return b.String()
+}
+
+// fishCompletion returns the fish completion script
+func fishCompletion(commands []completionCommand) string {
+	var b strings.Builder
+	b.WriteString(`# fish completion for pipe, generated by 'pipe completion fish'
+
+function __pipe_names
+    set -l tokens (commandline -opc)
+    set -l config
+    set -l i (contains -i -- --config $tokens)
+    and test $i -lt (count $tokens)
+    and set config --config $tokens[(math $i + 1)]
+    $tokens[1] __complete $argv[1] $config 2>/dev/null
+end
+
+function __pipe_command
+    set -l tokens (commandline -opc)
+    if test (count $tokens) -gt 1; and not string match -q -- '-*' $tokens[2]
+        echo $tokens[2]
+    else
+        echo deploy
+    end
+end
+
+function __pipe_using
+    test (__pipe_command) = $argv[1]
+end
+
+complete -c pipe -f
+`)
+	for _, command := range commands {
+		fmt.Fprintf(&b, "complete -c pipe -n 'test (count (commandline -opc)) -eq 1' -a %s -d %s\n",
+			command.name, fishQuote(command.description))
+	}
+	for _, command := range commands {
+		if len(command.subcommands) > 0 {
+			fmt.Fprintf(&b, "complete -c pipe -n '__pipe_using %s; and test (count (commandline -opc)) -eq 2' -a %s\n",
+				command.name, fishQuote(strings.Join(command.subcommands, " ")))
+		}
+		for _, flag := range command.flags {
+			line := fmt.Sprintf("complete -c pipe -n '__pipe_using %s' -l %s", command.name, flag.name)
+			switch {
+			case flag.boolean:
+			case fileFlags[flag.name]:
+				line += " -r -F"
+			case nameFlags[flag.name] != "":
+				line += fmt.Sprintf(" -x -a '(__pipe_names %s)'", nameFlags[flag.name])
+			default:
+				line += " -x"
+			}
+			fmt.Fprintf(&b, "%s -d %s\n", line, fishQuote(flag.description))
+		}
+	}
+	return b.String()
+}
+
+// zshDescription shortens a flag usage to its first clause and escapes the
+// characters _arguments gives a meaning to
+func zshDescription(usage string) string {
+	if i := strings.Index(usage, " ("); i > 0 {
+		usage = usage[:i]
+	}
+	return strings.NewReplacer(`\`, `\\`, "[", `\[`, "]", `\]`).Replace(usage)
+}
+
+// shellQuote quotes a value for bash and zsh scripts
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// fishQuote quotes a value for fish scripts, which escape quotes inside
+// single quotes
+func fishQuote(value string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(value) + "'"
+}
+
+// sortedKeys returns the keys of a map in order, so the generated scripts
+// do not change between runs
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,47 @@
+package config
+
+import "fmt"
+
+// Compression algorithms of image transfers
+const (
+	CompressGzip = "gzip"
+	CompressZstd = "zstd"
+	CompressNone = "none"
+)
+
+// BandwidthLimit returns the bytes per second image transfers are limited
+// to, or zero without a limit
+func (c *Config) BandwidthLimit() (int64, error) {
+	limit, err := ParseMemory(c.BwLimit)
+	if err != nil {
+		return 0, fmt.Errorf("invalid bandwidth limit %q: %v", c.BwLimit, err)
+	}
+	return limit, nil
+}
+
+// validateTransfer checks the compression algorithm, its level and the
+// bandwidth limit of image transfers
+func (c *Config) validateTransfer() error {
+	if _, err := c.BandwidthLimit(); err != nil {
+		return err
+	}
+
+	maxLevel := 0
+	switch c.Compress {
+	case CompressGzip:
+		maxLevel = 9
+	case CompressZstd:
+		maxLevel = 19
+	case CompressNone:
+	default:
+		return fmt.Errorf("invalid compression %q: expected %q, %q or %q", c.Compress, CompressGzip, CompressZstd, CompressNone)
+	}
+
+	if c.CompressLevel < 0 || c.CompressLevel > maxLevel {
+		if maxLevel == 0 {
+			return fmt.Errorf("--compress-level needs --compress %s or %s", CompressGzip, CompressZstd)
+		}
+		return fmt.Errorf("invalid compression level %d: expected 1-%d for %s", c.CompressLevel, maxLevel, c.Compress)
+	}
+	return nil
+}
@@ -1,34 +1,257 @@
 package config
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"os"
-	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // Config holds the deployment configuration
 type Config struct {
-	Host          string            `json:"host"`
-	User          string            `json:"user"`
-	Image         string            `json:"image"`
-	Dockerfile    string            `json:"dockerfile"`
-	Tag           string            `json:"tag"`
-	Platform      string            `json:"platform"`
-	SSHKey        string            `json:"sshKey"`
-	ContainerName string            `json:"containerName"`
-	ContainerPort string            `json:"containerPort"`
-	HostPort      string            `json:"hostPort"`
-	EnvFile       string            `json:"envFile"`
-	Rollback      bool              `json:"rollback"`
-	BuildArgs     map[string]string `json:"buildArgs"`
-	Network       string            `json:"network"`
-	Volumes       []string          `json:"volumes"`
-	CPUs          string            `json:"cpus"`
-	Memory        string            `json:"memory"`
+	Host              string            `json:"host,omitempty"`
+	Hosts             []string          `json:"hosts,omitempty"`
+	Cordoned          []string          `json:"cordoned,omitempty"`
+	Protected         []string          `json:"protected,omitempty"`
+	User              string            `json:"user,omitempty"`
+	Image             string            `json:"image,omitempty"`
+	Dockerfile        string            `json:"dockerfile,omitempty"`
+	Tag               string            `json:"tag,omitempty"`
+	TagStrategy       string            `json:"tagStrategy,omitempty"`
+	Platform          string            `json:"platform,omitempty"`
+	SSHKey            string            `json:"sshKey,omitempty"`
+	JumpHost          string            `json:"jumpHost,omitempty"`
+	SSHPort           string            `json:"sshPort,omitempty"`
+	SSHAgent          bool              `json:"sshAgent,omitempty"`
+	SSHPassword       string            `json:"-"`
+	HostKeyCheck      string            `json:"hostKeyCheck,omitempty"`
+	KnownHosts        string            `json:"knownHosts,omitempty"`
+	DockerUser        string            `json:"dockerUser,omitempty"`
+	DockerCommand     string            `json:"dockerCommand,omitempty"`
+	Transport         string            `json:"transport,omitempty"`
+	DockerContext     string            `json:"dockerContext,omitempty"`
+	DockerCertPath    string            `json:"dockerCertPath,omitempty"`
+	ContainerName     string            `json:"containerName,omitempty"`
+	ContainerPort     string            `json:"containerPort,omitempty"`
+	HostPort          string            `json:"hostPort,omitempty"`
+	EnvFile           string            `json:"envFile,omitempty"`
+	EnvFiles          []string          `json:"envFiles,omitempty"`
+	Env               map[string]string `json:"env,omitempty"`
+	Rollback          bool              `json:"rollback,omitempty"`
+	RollbackTo        string            `json:"-"`
+	BuildArgs         map[string]string `json:"buildArgs,omitempty"`
+	Network           string            `json:"network,omitempty"`
+	NetworkDriver     string            `json:"networkDriver,omitempty"`
+	NetworkOpts       map[string]string `json:"networkOpts,omitempty"`
+	Volumes           []string          `json:"volumes,omitempty"`
+	VolumeDriver      string            `json:"volumeDriver,omitempty"`
+	VolumeOpts        map[string]string `json:"volumeOpts,omitempty"`
+	Copy              []string          `json:"copy,omitempty"`
+	Runtime           Runtime           `json:"runtime,omitempty"`
+	CPUs              string            `json:"cpus,omitempty"`
+	Memory            string            `json:"memory,omitempty"`
+	MemoryReservation string            `json:"memoryReservation,omitempty"`
+	MemorySwap        string            `json:"memorySwap,omitempty"`
+	PidsLimit         int               `json:"pidsLimit,omitempty"`
+	CPUShares         int               `json:"cpuShares,omitempty"`
+	OOMKillDisable    bool              `json:"oomKillDisable,omitempty"`
+	CPUsetCPUs        string            `json:"cpusetCpus,omitempty"`
+	CgroupParent      string            `json:"cgroupParent,omitempty"`
+	GPUs              string            `json:"gpus,omitempty"`
+	Strategy          string            `json:"strategy,omitempty"`
+	Registry          string            `json:"registry,omitempty"`
+	PrebuiltImage     string            `json:"imageRef,omitempty"`
+	SkipBuild         bool              `json:"skipBuild,omitempty"`
+	BuildSecrets      []string          `json:"buildSecrets,omitempty"`
+	BuildSSH          []string          `json:"buildSsh,omitempty"`
+	Reproducible      bool              `json:"reproducible,omitempty"`
+	BuildParallel     int               `json:"buildParallel,omitempty"`
+	Compress          string            `json:"compress,omitempty"`
+	CompressLevel     int               `json:"compressLevel,omitempty"`
+	BwLimit           string            `json:"bwLimit,omitempty"`
+	AutoApprove       bool              `json:"autoApprove,omitempty"`
+	Confirm           bool              `json:"-"`
+	Yes               bool              `json:"-"`
+	DryRun            bool              `json:"-"`
+	RegistryUser      string            `json:"registryUser,omitempty"`
+	RegistryPass      string            `json:"-"`
+	AlternatePort     string            `json:"alternatePort,omitempty"`
+	CanaryWeight      int               `json:"canaryWeight,omitempty"`
+	Replicas          int               `json:"replicas,omitempty"`
+	ReplicaPorts      string            `json:"replicaPorts,omitempty"`
+	CanaryBake        string            `json:"canaryBake,omitempty"`
+	Rolling           bool              `json:"rolling,omitempty"`
+	MaxUnavailable    int               `json:"maxUnavailable,omitempty"`
+	RollingRollback   bool              `json:"rollingRollback,omitempty"`
+	HealthURL         string            `json:"healthUrl,omitempty"`
+	HealthTimeout     string            `json:"healthTimeout,omitempty"`
+	HealthRetries     int               `json:"healthRetries,omitempty"`
+	HealthCmd         string            `json:"healthCmd,omitempty"`
+	HealthInterval    string            `json:"healthInterval,omitempty"`
+	HealthStartPeriod string            `json:"healthStartPeriod,omitempty"`
+	HealthCmdRetries  int               `json:"healthCmdRetries,omitempty"`
+	WatchLogs         string            `json:"watchLogs,omitempty"`
+	WatchLogsPatterns []string          `json:"watchLogsPatterns,omitempty"`
+	Retries           int               `json:"retries,omitempty"`
+	RetryDelay        string            `json:"retryDelay,omitempty"`
+	KeepReleases      int               `json:"keepReleases,omitempty"`
+	Prune             bool              `json:"prune,omitempty"`
+	SkipUnchanged     bool              `json:"skipUnchanged,omitempty"`
+	OnConflict        string            `json:"onConflict,omitempty"`
+	Initial           []string          `json:"initial,omitempty"`
+	SystemPackages    []string          `json:"systemPackages,omitempty"`
+	LockTimeout       string            `json:"lockTimeout,omitempty"`
+	RemoteShell       string            `json:"remoteShell,omitempty"`
+	RemoteDir         string            `json:"remoteDir,omitempty"`
+	Hooks             Hooks             `json:"hooks,omitempty"`
+	HooksDir          string            `json:"hooksDir,omitempty"`
+	Migrate           Migrate           `json:"migrate,omitempty"`
+	Updates           Updates           `json:"updates,omitempty"`
+	Agent             Agent             `json:"agent,omitempty"`
+	Quarantine        Quarantine        `json:"quarantine,omitempty"`
+	Scan              Scan              `json:"scan,omitempty"`
+	Signing           Signing           `json:"signing,omitempty"`
+	SBOM              SBOM              `json:"sbom,omitempty"`
+	Notifications     []Notification    `json:"notifications,omitempty"`
+	Telemetry         Telemetry         `json:"telemetry,omitempty"`
+	StatusPage        StatusPage        `json:"statusPage,omitempty"`
+	Monitor           Monitor           `json:"monitor,omitempty"`
+	LogShipping       LogShipping       `json:"logShipping,omitempty"`
+	Metrics           Metrics           `json:"metrics,omitempty"`
+	Stack             []Service         `json:"stack,omitempty"`
+	Apps              []App             `json:"apps,omitempty"`
+	Templates         Templates         `json:"templates,omitempty"`
+	Environments      Environments      `json:"environments,omitempty"`
+	Domain            string            `json:"domain,omitempty"`
+	Proxy             Proxy             `json:"proxy,omitempty"`
+	Accessories       []Accessory       `json:"accessories,omitempty"`
+	ServiceName       string            `json:"-"`
+	AppName           string            `json:"-"`
+	Output            string            `json:"-"`
+	OutputFormat      string            `json:"-"`
+	Workflow          string            `json:"-"`
+	Tail              string            `json:"-"`
+	Since             string            `json:"-"`
+	Follow            bool              `json:"-"`
+	JSON              bool              `json:"-"`
+	Wide              bool              `json:"-"`
+	TTY               bool              `json:"-"`
+	Fix               bool              `json:"-"`
+	Schedule          string            `json:"-"`
+	JobName           string            `json:"-"`
+	HistoryLimit      int               `json:"-"`
+	MaintenanceImage  string            `json:"maintenanceImage,omitempty"`
+	MaintenancePage   string            `json:"maintenancePage,omitempty"`
+	DestroyStorage    bool              `json:"-"`
+	BootstrapDocker   bool              `json:"bootstrapDocker,omitempty"`
+	Remote            bool              `json:"-"`
+	RebootTimeout     string            `json:"-"`
+	MirrorFrom        string            `json:"-"`
+	MirrorTo          string            `json:"-"`
+	Parallel          int               `json:"-"`
+	Quiet             bool              `json:"-"`
+	Verbose           bool              `json:"-"`
+	LogFormat         string            `json:"logFormat,omitempty"`
+	LogFile           string            `json:"logFile,omitempty"`
+	LogMaxSize        string            `json:"logMaxSize,omitempty"`
+	LogMaxAge         string            `json:"logMaxAge,omitempty"`
+	Timeout           string            `json:"-"`
+	ReadOnly          bool              `json:"-"`
+	FailAt            string            `json:"-"`
+	Target            string            `json:"-"`
+	Command           string            `json:"-"`
+	Args              []string          `json:"-"`
+
+	ctx     context.Context
+	flagEnv map[string]string
+}
+
+// Hooks are commands run at fixed points of a deployment
+type Hooks struct {
+	PreBuild     []Hook `json:"preBuild,omitempty"`
+	PreDeploy    []Hook `json:"preDeploy,omitempty"`
+	PostDeploy   []Hook `json:"postDeploy,omitempty"`
+	OnFailure    []Hook `json:"onFailure,omitempty"`
+	PreRollback  []Hook `json:"preRollback,omitempty"`
+	PostRollback []Hook `json:"postRollback,omitempty"`
+	Report       []Hook `json:"report,omitempty"`
+}
+
+// DefaultHooksDir is the directory of hook executables used when the
+// configuration names none
+const DefaultHooksDir = ".pipe/hooks"
+
+// HookPath returns the directory of the hook executables
+func (c *Config) HookPath() string {
+	if c.HooksDir == "" {
+		return DefaultHooksDir
+	}
+	return c.HooksDir
+}
+
+// Hook is a single command run either locally or on the remote host
+type Hook struct {
+	Local  string `json:"local,omitempty"`
+	Remote string `json:"remote,omitempty"`
+	// Env holds extra KEY=VALUE variables set by pipe, such as
+	// PIPE_ROLLBACK_FROM for rollback hooks
+	Env []string `json:"-"`
+}
+
+// Monitor is an uptime monitor pinged after every deployment, such as a
+// Healthchecks.io check, an Uptime Kuma push monitor or a Better Stack
+// heartbeat. StatusURL and APIKey let pipe status read the monitor's state.
+type Monitor struct {
+	PingURL   string `json:"pingUrl,omitempty"`
+	StatusURL string `json:"statusUrl,omitempty"`
+	APIKey    string `json:"apiKey,omitempty"`
+}
+
+// Console log formats
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// Result documents printed by deploy and rollback
+const (
+	OutputText = "text"
+	OutputJSON = "json"
+)
+
+// Updates configures unattended security updates on the hosts
+type Updates struct {
+	Unattended   bool   `json:"unattended,omitempty"`
+	RebootWindow string `json:"rebootWindow,omitempty"`
 }
 
+// Deployment strategies
+const (
+	StrategyRecreate  = "recreate"
+	StrategyBlueGreen = "blue-green"
+	StrategyCanary    = "canary"
+)
+
+// Answers to a container changed outside pipe since its last deployment
+const (
+	ConflictAsk       = "ask"
+	ConflictOverwrite = "overwrite"
+	ConflictAdopt     = "adopt"
+	ConflictAbort     = "abort"
+)
+
+// TargetLocalDocker deploys to Docker-in-Docker containers on this machine
+// instead of the configured hosts
+const TargetLocalDocker = "local-docker"
+
 // arrayFlags allows for multiple flag values
 type arrayFlags []string
 
@@ -41,59 +264,466 @@ func (i *arrayFlags) Set(value string) error {
 	return nil
 }
 
-// Load loads configuration from command line flags and environment variables
-func Load() Config {
-	var config Config
-	var showHelp bool
-	var showVersion bool
-	var buildArgs arrayFlags
-	var volumeFlags arrayFlags
-
-	// Initialize BuildArgs map
-	config.BuildArgs = make(map[string]string)
-
-	// Define command line flags
-	flag.StringVar(&config.Host, "host", getEnv("HOST", ""), "Remote host to deploy to")
-	flag.StringVar(&config.User, "user", getEnv("HOST_USER", ""), "SSH user for remote host")
-	flag.StringVar(&config.Image, "image", getEnv("DOCKER_IMAGE_NAME", "app"), "Docker image name")
-	flag.StringVar(&config.Dockerfile, "dockerfile", "Dockerfile", "Path to the Dockerfile")
-	flag.StringVar(&config.Tag, "tag", getEnv("DOCKER_IMAGE_TAG", "latest"), "Docker image tag")
-	flag.StringVar(&config.Platform, "platform", getEnv("HOST_PLATFORM", "linux/amd64"), "Docker platform")
-	flag.StringVar(&config.SSHKey, "ssh-key", getEnv("SSH_KEY_PATH", ""), "Path to SSH key")
-	flag.StringVar(&config.ContainerName, "container-name", getEnv("DOCKER_CONTAINER_NAME", "app"), "Name for the container")
-	flag.StringVar(&config.ContainerPort, "container-port", getEnv("DOCKER_CONTAINER_PORT", "3000"), "Container port")
-	flag.StringVar(&config.HostPort, "host-port", getEnv("HOST_PORT", "3000"), "Host port")
-	flag.StringVar(&config.EnvFile, "env-file", getEnv("DOCKER_CONTAINER_ENV_FILE", ""), "Environment file")
-	flag.Var(&buildArgs, "build-arg", "Build argument in KEY=VALUE format (can be specified multiple times)")
-	flag.Var(&volumeFlags, "volume", "Volume mount in format 'host:container' (can be specified multiple times)")
-	flag.StringVar(&config.Network, "network", getEnv("DOCKER_NETWORK", ""), "Docker network to connect to")
-	flag.StringVar(&config.CPUs, "cpus", getEnv("DOCKER_CPUS", ""), "Number of CPUs (e.g., '0.5' or '2')")
-	flag.StringVar(&config.Memory, "memory", getEnv("DOCKER_MEMORY", ""), "Memory limit (e.g., '512m' or '2g')")
-	flag.BoolVar(&showHelp, "help", false, "Show help message")
-	flag.BoolVar(&config.Rollback, "rollback", false, "Rollback to previous version")
-	flag.BoolVar(&showVersion, "version", false, "Show version information")
+// commandFlags defines which flag groups each command accepts
+var commandFlags = map[string][]func(*flagSet){
+	"deploy":      {(*flagSet).connectionFlags, (*flagSet).buildFlags, (*flagSet).runFlags, (*flagSet).deployFlags, (*flagSet).lockFlags, (*flagSet).confirmFlags, (*flagSet).resultFlags},
+	"plan":        {(*flagSet).connectionFlags, (*flagSet).buildFlags, (*flagSet).runFlags},
+	"rollback":    {(*flagSet).connectionFlags, (*flagSet).runFlags, (*flagSet).rollbackFlags, (*flagSet).lockFlags, (*flagSet).confirmFlags, (*flagSet).resultFlags},
+	"releases":    {(*flagSet).connectionFlags},
+	"history":     {(*flagSet).connectionFlags, (*flagSet).historyFlags},
+	"maintenance": {(*flagSet).connectionFlags, (*flagSet).runFlags, (*flagSet).maintenanceFlags},
+	"compare":     {(*flagSet).connectionFlags},
+	"adopt":       {(*flagSet).connectionFlags, (*flagSet).adoptFlags},
+	"init":        {(*flagSet).connectionFlags, (*flagSet).initFlags},
+	"logs":        {(*flagSet).connectionFlags, (*flagSet).logsFlags},
+	"status":      {(*flagSet).connectionFlags, (*flagSet).statusFlags},
+	"list":        {(*flagSet).connectionFlags, (*flagSet).listFlags},
+	"discover":    {(*flagSet).connectionFlags, (*flagSet).discoverFlags},
+	"exec":        {(*flagSet).connectionFlags, (*flagSet).execFlags},
+	"run":         {(*flagSet).connectionFlags, (*flagSet).buildFlags, (*flagSet).runFlags, (*flagSet).scheduleFlags},
+	"jobs":        {(*flagSet).connectionFlags, (*flagSet).jobsFlags},
+	"unlock":      {(*flagSet).connectionFlags},
+	"doctor":      {(*flagSet).connectionFlags, (*flagSet).doctorFlags},
+	"validate":    {(*flagSet).connectionFlags, (*flagSet).buildFlags, (*flagSet).runFlags, (*flagSet).validateFlags},
+	"host":        {(*flagSet).connectionFlags, (*flagSet).runFlags, (*flagSet).hostFlags},
+	"mirror":      {(*flagSet).connectionFlags, (*flagSet).mirrorFlags},
+	"pull-remote": {(*flagSet).connectionFlags},
+	"accessory":   {(*flagSet).connectionFlags, (*flagSet).logsFlags},
+	"fleet":       {(*flagSet).connectionFlags, (*flagSet).fleetFlags},
+	"metrics":     {(*flagSet).connectionFlags},
+	"agent":       {(*flagSet).connectionFlags, (*flagSet).runFlags},
+	"destroy":     {(*flagSet).connectionFlags, (*flagSet).runFlags, (*flagSet).lockFlags, (*flagSet).destroyFlags},
+}
+
+// flagSet holds a command's flags and the raw values that need processing
+// after parsing
+type flagSet struct {
+	*flag.FlagSet
+	config       *Config
+	hosts        arrayFlags
+	buildArgs    arrayFlags
+	buildSecrets arrayFlags
+	buildSSH     arrayFlags
+	volumes      arrayFlags
+	copies       arrayFlags
+	watchLogs    arrayFlags
+	envFiles     arrayFlags
+	env          arrayFlags
+	help         bool
+	version      bool
+}
+
+// newFlagSet defines the flags of a command, which set the fields of config
+func newFlagSet(command string, config *Config, configFile string) *flagSet {
+	fs := &flagSet{FlagSet: flag.NewFlagSet("pipe "+command, flag.ExitOnError), config: config}
+	fs.String("config", configFile, "Path to a JSON config file (default: pipe.json if present)")
+	for _, group := range commandFlags[command] {
+		group(fs)
+	}
+	fs.BoolVar(&config.Quiet, "quiet", false, "Only print warnings, errors and results")
+	fs.BoolVar(&config.Verbose, "verbose", false, "Also print the executed commands and other debug messages")
+	fs.StringVar(&config.LogFormat, "log-format", getEnv("LOG_FORMAT", config.LogFormat), "Console log format (text or json)")
+	fs.StringVar(&config.LogFile, "log-file", getEnv("LOG_FILE", config.LogFile), "File to write the log to, or 'none' to disable the log file")
+	fs.StringVar(&config.LogMaxSize, "log-max-size", getEnv("LOG_MAX_SIZE", config.LogMaxSize), "Size at which the log file is rotated (e.g. '10m'), or 0 to never rotate it")
+	fs.StringVar(&config.LogMaxAge, "log-max-age", getEnv("LOG_MAX_AGE", config.LogMaxAge), "How long rotated log files are kept (e.g. '720h'), or 0 to keep them")
+	fs.StringVar(&config.Timeout, "timeout", getEnv("PIPE_TIMEOUT", ""), "Cancel the command after this long (e.g. '15m')")
+	fs.BoolVar(&config.ReadOnly, "read-only", getEnvBool("PIPE_READ_ONLY", false), "Only allow commands that do not change the hosts")
+	fs.BoolVar(&fs.help, "help", false, "Show help message")
+	fs.BoolVar(&fs.version, "version", false, "Show version information")
+	return fs
+}
+
+// Load loads configuration for a command from the config file, environment
+// variables and the command's flags. Positional arguments may be mixed with
+// flags and are collected in Args.
+func Load(command string, args []string) (Config, error) {
+	config := Defaults()
+	config.Command = command
+
+	if _, ok := commandFlags[command]; !ok {
+		return config, fmt.Errorf("unknown command %q, run 'pipe --help' for usage", command)
+	}
+
+	// Load the config file, whose values become the defaults for flags and
+	// environment variables
+	configFile := configPath(args)
+	if configFile != "" {
+		if err := loadFile(configFile, &config); err != nil {
+			return config, fmt.Errorf("failed to load config file %s: %v", configFile, err)
+		}
+		if err := config.interpolate(nil); err != nil {
+			return config, fmt.Errorf("config file %s: %v", configFile, err)
+		}
+		if config.BuildArgs == nil {
+			config.BuildArgs = make(map[string]string)
+		}
+	}
+
+	// Apply the settings of the chosen environment, such as production,
+	// on top of the shared settings of the config file
+	if name := environmentName(args); name != "" {
+		environment, err := config.environment(name)
+		if err != nil {
+			return config, err
+		}
+		config = environment
+	}
+
+	// Narrow a workspace down to the selected app, whose settings become the
+	// defaults for flags and environment variables
+	if name := appName(args); name != "" && command != "list" {
+		if len(config.Apps) == 0 {
+			return config, fmt.Errorf("app %s was chosen, but the config file defines no apps", name)
+		}
+		app, err := config.App(name)
+		if err != nil {
+			return config, err
+		}
+		config = app
+	}
+
+	fs := newFlagSet(command, &config, configFile)
 
 	// Custom usage message
-	flag.Usage = func() {
-		fmt.Println(helpText)
+	fs.SetOutput(os.Stdout)
+	fs.Usage = func() {
+		fmt.Printf("Usage:\n  pipe %s [options]\n\nOptions:\n", command)
+		fs.printVisible()
+	}
+
+	// Arguments after -- are passed on untouched
+	var passthrough []string
+	if i := slices.Index(args, "--"); i >= 0 {
+		args, passthrough = args[:i], args[i+1:]
 	}
 
-	// Parse command line flags
-	flag.Parse()
+	// Parse command line flags, collecting positional arguments along the way
+	for {
+		fs.Parse(args)
+		args = fs.Args()
+		if len(args) == 0 {
+			break
+		}
+		config.Args = append(config.Args, args[0])
+		args = args[1:]
+	}
+	config.Args = append(config.Args, passthrough...)
 
 	// Show help if requested
-	if showHelp {
-		flag.Usage()
+	if fs.help {
+		fs.Usage()
 		os.Exit(0)
 	}
 
-	if showVersion {
-		fmt.Printf("pipe version %s\n", version)
+	if fs.version {
+		PrintVersion()
 		os.Exit(0)
 	}
 
+	fs.process()
+	if err := config.applyEnv(); err != nil {
+		return config, err
+	}
+
+	if config.Quiet && config.Verbose {
+		return config, fmt.Errorf("--quiet and --verbose cannot be used together")
+	}
+	switch config.LogFormat {
+	case "", LogFormatText, LogFormatJSON:
+	default:
+		return config, fmt.Errorf("invalid log format %q: expected %q or %q", config.LogFormat, LogFormatText, LogFormatJSON)
+	}
+	switch config.OutputFormat {
+	case "", OutputText, OutputJSON:
+	default:
+		return config, fmt.Errorf("invalid output %q: expected %q or %q", config.OutputFormat, OutputText, OutputJSON)
+	}
+	if _, _, err := config.LogRotation(); err != nil {
+		return config, err
+	}
+	if _, err := config.TimeoutDuration(); err != nil {
+		return config, err
+	}
+	if err := config.validateFailAt(); err != nil {
+		return config, err
+	}
+	if command == "deploy" || command == "plan" || command == "validate" || command == "run" {
+		if err := config.applyTagStrategy(); err != nil {
+			return config, err
+		}
+	}
+	if config.Target != "" && config.Target != TargetLocalDocker {
+		return config, fmt.Errorf("invalid target %q: expected %q", config.Target, TargetLocalDocker)
+	}
+
+	if len(config.Apps) > 0 && command != "list" {
+		return config, fmt.Errorf("the config file defines several apps, choose one with --app: %s", strings.Join(config.AppNames(), ", "))
+	}
+
+	// Narrow a stack down to the selected service
+	if config.ServiceName != "" {
+		return config.Service(config.ServiceName)
+	}
+
+	return config, nil
+}
+
+// connectionFlags defines the flags needed to reach the app on its hosts
+func (fs *flagSet) connectionFlags() {
+	config := fs.config
+	fs.Var(&fs.hosts, "host", "Remote host to deploy to (can be specified multiple times or comma-separated)")
+	fs.StringVar(&config.ServiceName, "service", "", "Only use this service of the stack defined in the config file")
+	fs.String("app", config.AppName, "App of the workspace defined in the config file")
+	fs.String("environment", "", "Environment defined in the config file, such as staging or production")
+	fs.StringVar(&config.User, "user", getEnv("HOST_USER", config.User), "SSH user for remote host")
+	fs.StringVar(&config.SSHKey, "ssh-key", getEnv("SSH_KEY_PATH", config.SSHKey), "Path to SSH key")
+	fs.StringVar(&config.JumpHost, "jump-host", getEnv("SSH_JUMP_HOST", config.JumpHost), "Bastion to connect through, as [user@]host[:port]")
+	fs.StringVar(&config.SSHPort, "ssh-port", getEnv("SSH_PORT", config.SSHPort), "SSH port of hosts given without a port")
+	fs.BoolVar(&config.SSHAgent, "ssh-agent", getEnvBool("SSH_AGENT", config.SSHAgent), "Only authenticate with the keys of the running ssh-agent")
+	fs.StringVar(&config.HostKeyCheck, "host-key-check", getEnv("SSH_HOST_KEY_CHECK", config.HostKeyCheck), "Host key verification: strict, or accept-new to record the keys of unknown hosts")
+	fs.StringVar(&config.KnownHosts, "known-hosts", getEnv("SSH_KNOWN_HOSTS", config.KnownHosts), "known_hosts file to verify host keys against (default: ~/.ssh/known_hosts)")
+	// The password is only read from the environment, to keep it out of the process list
+	config.SSHPassword = getEnv("SSH_PASSWORD", config.SSHPassword)
+	fs.StringVar(&config.DockerUser, "docker-user", getEnv("DOCKER_USER", config.DockerUser), "Run docker on the hosts as this user through passwordless sudo, instead of the SSH user")
+	fs.StringVar(&config.DockerCommand, "docker-cmd", getEnv("DOCKER_CMD", config.DockerCommand), "Command running docker on the hosts, such as 'sudo docker', 'podman' or 'docker -H unix:///run/user/1000/docker.sock'")
+	fs.StringVar(&config.Transport, "transport", getEnv("PIPE_TRANSPORT", config.Transport), "How docker commands reach the hosts: ssh, docker-host to drive the remote engine with the local docker CLI, or docker-tls to reach it over TCP with TLS and no SSH")
+	fs.StringVar(&config.DockerContext, "docker-context", getEnv("PIPE_DOCKER_CONTEXT", config.DockerContext), "Docker context addressing the host with --transport docker-host or docker-tls")
+	fs.StringVar(&config.DockerCertPath, "docker-cert-path", getEnv("PIPE_DOCKER_CERT_PATH", config.DockerCertPath), "Directory with the ca.pem, cert.pem and key.pem of --transport docker-tls (default: ~/.docker)")
+	fs.StringVar(&config.ContainerName, "container-name", getEnv("DOCKER_CONTAINER_NAME", config.ContainerName), "Name for the container")
+	fs.StringVar(&config.Image, "image", getEnv("DOCKER_IMAGE_NAME", config.Image), "Docker image name")
+	fs.StringVar(&config.Tag, "tag", getEnv("DOCKER_IMAGE_TAG", config.Tag), "Docker image tag")
+	fs.StringVar(&config.Registry, "registry", getEnv("DOCKER_REGISTRY", config.Registry), "Push the image to this registry (e.g. 'ghcr.io/org') and pull it on the remote host instead of transferring it over SSH")
+	fs.StringVar(&config.PrebuiltImage, "image-ref", getEnv("DOCKER_IMAGE_REF", config.PrebuiltImage), "Deploy an existing image reference (e.g. 'ghcr.io/org/app:1.2.0') without building or transferring it")
+	fs.IntVar(&config.Retries, "retries", getEnvInt("RETRIES", config.Retries), "Number of times a step failing on a network error is retried")
+	fs.StringVar(&config.RetryDelay, "retry-delay", getEnv("RETRY_DELAY", config.RetryDelay), "Wait before the first retry, doubled for every further retry (e.g. '2s')")
+}
+
+// buildFlags defines the flags for building and transferring the image
+func (fs *flagSet) buildFlags() {
+	config := fs.config
+	fs.StringVar(&config.Dockerfile, "dockerfile", config.Dockerfile, "Path to the Dockerfile")
+	fs.StringVar(&config.Platform, "platform", getEnv("HOST_PLATFORM", config.Platform), "Docker platform, a comma-separated list of platforms built with buildx, or 'auto' for the platforms of the hosts")
+	fs.Var(&fs.buildArgs, "build-arg", "Build argument in KEY=VALUE format (can be specified multiple times)")
+	fs.Var(&fs.buildSecrets, "build-secret", "Secret exposed to the build, e.g. 'id=npmrc,src=.npmrc' (can be specified multiple times)")
+	fs.Var(&fs.buildSSH, "build-ssh", "SSH agent or keys exposed to the build, e.g. 'default' (can be specified multiple times)")
+	fs.StringVar(&config.TagStrategy, "tag-strategy", getEnv("DOCKER_TAG_STRATEGY", config.TagStrategy), "Generate the image tag: git-sha, timestamp or semver from the latest git tag")
+	fs.BoolVar(&config.Reproducible, "reproducible", getEnvBool("DOCKER_REPRODUCIBLE", config.Reproducible), "Pin base images by digest and build with SOURCE_DATE_EPOCH, so builds of the same commit produce the same image")
+	fs.StringVar(&config.Scan.Scanner, "scan", getEnv("SCAN", config.Scan.Scanner), "Scan the image for vulnerabilities before it is transferred, with trivy or grype")
+	fs.StringVar(&config.Scan.Severity, "scan-severity", getEnv("SCAN_SEVERITY", config.Scan.Severity), "Lowest severity of the vulnerabilities failing the deployment: low, medium, high or critical (default: high)")
+	fs.StringVar(&config.Scan.IgnoreFile, "scan-ignore-file", getEnv("SCAN_IGNORE_FILE", config.Scan.IgnoreFile), "File listing accepted vulnerability IDs, one per line")
+	fs.BoolVar(&config.SBOM.Enabled, "sbom", getEnvBool("SBOM", config.SBOM.Enabled), "Generate an SBOM of the image with syft and keep it on the hosts")
+	fs.StringVar(&config.RegistryUser, "registry-user", getEnv("DOCKER_REGISTRY_USER", config.RegistryUser), "Username for docker login on the registry")
+	fs.BoolVar(&config.SkipBuild, "skip-build", config.SkipBuild, "Skip building and transfer the existing local image")
+	fs.StringVar(&config.Compress, "compress", getEnv("TRANSFER_COMPRESS", config.Compress), "Compression of the image sent to the hosts: gzip, zstd or none")
+	fs.IntVar(&config.CompressLevel, "compress-level", getEnvInt("TRANSFER_COMPRESS_LEVEL", config.CompressLevel), "Compression level, 1-9 for gzip and 1-19 for zstd (default: 6 for gzip, 3 for zstd)")
+	fs.StringVar(&config.BwLimit, "bwlimit", getEnv("TRANSFER_BWLIMIT", config.BwLimit), "Limit the image upload to this many bytes per second (e.g. '5m')")
+	fs.IntVar(&config.BuildParallel, "build-parallel", getEnvInt("DOCKER_BUILD_PARALLEL", config.BuildParallel), "Maximum number of stack services built at the same time")
+}
+
+// runFlags defines the flags for running the container
+func (fs *flagSet) runFlags() {
+	config := fs.config
+	fs.StringVar(&config.ContainerPort, "container-port", getEnv("DOCKER_CONTAINER_PORT", config.ContainerPort), "Container port")
+	fs.StringVar(&config.HostPort, "host-port", getEnv("HOST_PORT", config.HostPort), "Host port")
+	fs.Var(&fs.envFiles, "env-file", "Environment file (can be specified multiple times, later files override earlier ones)")
+	fs.Var(&fs.env, "env", "Environment variable in KEY=VALUE format, or NAME to pass a variable of the local environment through (can be specified multiple times)")
+	fs.Var(&fs.volumes, "volume", "Volume mount in format 'host:container' (can be specified multiple times)")
+	fs.Var(&fs.copies, "copy", "File or directory copied to the hosts before the container starts, in format 'local:remote[:mode]' (can be specified multiple times)")
+	fs.StringVar(&config.Network, "network", getEnv("DOCKER_NETWORK", config.Network), "Docker network to connect to")
+	fs.StringVar(&config.NetworkDriver, "network-driver", getEnv("DOCKER_NETWORK_DRIVER", config.NetworkDriver), "Driver of the network when it is created")
+	fs.StringVar(&config.VolumeDriver, "volume-driver", getEnv("DOCKER_VOLUME_DRIVER", config.VolumeDriver), "Driver of named volumes when they are created")
+	fs.StringVar(&config.Domain, "domain", getEnv("APP_DOMAIN", config.Domain), "Domain the reverse proxy routes to the app")
+	fs.StringVar(&config.CPUs, "cpus", getEnv("DOCKER_CPUS", config.CPUs), "Number of CPUs (e.g., '0.5' or '2')")
+	fs.StringVar(&config.Memory, "memory", getEnv("DOCKER_MEMORY", config.Memory), "Memory limit (e.g., '512m' or '2g')")
+	fs.StringVar(&config.MemoryReservation, "memory-reservation", getEnv("DOCKER_MEMORY_RESERVATION", config.MemoryReservation), "Memory soft limit, below the memory limit (e.g., '256m')")
+	fs.StringVar(&config.MemorySwap, "memory-swap", getEnv("DOCKER_MEMORY_SWAP", config.MemorySwap), "Memory plus swap limit (e.g., '1g'), or -1 for unlimited swap")
+	fs.IntVar(&config.PidsLimit, "pids-limit", getEnvInt("DOCKER_PIDS_LIMIT", config.PidsLimit), "Maximum number of processes in the container, or -1 for unlimited")
+	fs.IntVar(&config.CPUShares, "cpu-shares", getEnvInt("DOCKER_CPU_SHARES", config.CPUShares), "Relative CPU weight (default weight is 1024)")
+	fs.BoolVar(&config.OOMKillDisable, "oom-kill-disable", getEnvBool("DOCKER_OOM_KILL_DISABLE", config.OOMKillDisable), "Do not kill the container when it runs out of memory (needs --memory)")
+	fs.StringVar(&config.CPUsetCPUs, "cpuset-cpus", getEnv("DOCKER_CPUSET_CPUS", config.CPUsetCPUs), "CPUs the container may run on (e.g., '0-3' or '1,3')")
+	fs.StringVar(&config.CgroupParent, "cgroup-parent", getEnv("DOCKER_CGROUP_PARENT", config.CgroupParent), "Parent cgroup of the container (e.g., 'latency.slice' with the systemd cgroup driver)")
+	fs.StringVar(&config.GPUs, "gpus", getEnv("DOCKER_GPUS", config.GPUs), "GPUs to give the container (e.g., 'all' or 'device=0'), needs the NVIDIA Container Toolkit")
+	fs.StringVar(&config.Strategy, "strategy", getEnv("DEPLOY_STRATEGY", config.Strategy), "Deployment strategy (recreate, blue-green or canary)")
+	fs.StringVar(&config.AlternatePort, "alternate-port", getEnv("HOST_ALTERNATE_PORT", config.AlternatePort), "Host port for the new version during blue-green deployments (default: host port + 1)")
+	fs.IntVar(&config.Replicas, "replicas", getEnvInt("DOCKER_REPLICAS", config.Replicas), "Number of copies of the container on every host, restarted one at a time during a deployment")
+	fs.StringVar(&config.ReplicaPorts, "replica-ports", getEnv("DOCKER_REPLICA_PORTS", config.ReplicaPorts), "Host ports of the replicas: sequential from the host port, or dynamic ports picked by docker (needs the traefik proxy to route to them)")
+	fs.IntVar(&config.CanaryWeight, "canary-weight", getEnvInt("CANARY_WEIGHT", config.CanaryWeight), "Percentage of the hosts, not of the requests, that get the new version first in canary deployments (needs at least two hosts)")
+	fs.StringVar(&config.CanaryBake, "canary-bake", getEnv("CANARY_BAKE", config.CanaryBake), "How long the canary hosts must stay healthy before the other hosts are deployed (e.g. '5m')")
+	fs.BoolVar(&config.Rolling, "rolling", getEnvBool("ROLLING_DEPLOY", config.Rolling), "Deploy the hosts in batches, each verified before the next, and stop at the first failing batch")
+	fs.IntVar(&config.MaxUnavailable, "max-unavailable", getEnvInt("MAX_UNAVAILABLE", config.MaxUnavailable), "Number of hosts deployed at once in rolling deployments")
+	fs.BoolVar(&config.RollingRollback, "rolling-rollback", getEnvBool("ROLLING_ROLLBACK", config.RollingRollback), "Roll back the hosts already deployed when a rolling deployment fails")
+	fs.StringVar(&config.HealthURL, "health-url", getEnv("HEALTH_CHECK_URL", config.HealthURL), "Path (e.g. '/health') or URL polled from the host after starting the container until it returns 200, or 'tcp' to wait for the port to accept connections")
+	fs.StringVar(&config.HealthTimeout, "health-timeout", getEnv("HEALTH_CHECK_TIMEOUT", config.HealthTimeout), "How long to wait for the health check to pass")
+	fs.IntVar(&config.HealthRetries, "health-retries", getEnvInt("HEALTH_CHECK_RETRIES", config.HealthRetries), "Number of health check attempts, spread over the health timeout")
+	fs.StringVar(&config.HealthCmd, "health-cmd", getEnv("DOCKER_HEALTH_CMD", config.HealthCmd), "Command docker runs in the container to check its health (e.g. 'curl -f http://localhost:8080/health')")
+	fs.StringVar(&config.HealthInterval, "health-interval", getEnv("DOCKER_HEALTH_INTERVAL", config.HealthInterval), "Time between the health commands docker runs (e.g. '10s')")
+	fs.StringVar(&config.HealthStartPeriod, "health-start-period", getEnv("DOCKER_HEALTH_START_PERIOD", config.HealthStartPeriod), "Time the container gets to start before failing health commands count (e.g. '60s')")
+	fs.IntVar(&config.HealthCmdRetries, "health-cmd-retries", getEnvInt("DOCKER_HEALTH_RETRIES", config.HealthCmdRetries), "Failing health commands in a row before docker marks the container unhealthy")
+	fs.StringVar(&config.WatchLogs, "watch-logs", getEnv("WATCH_LOGS", config.WatchLogs), "How long to follow the logs of the new container after it passed its health checks (e.g. '30s'), failing the deployment when it logs a failure pattern, stops or restarts")
+	fs.Var(&fs.watchLogs, "watch-logs-pattern", "Regular expression of a log line failing the deployment while the logs are watched (can be specified multiple times, default: panics, FATAL and stack traces)")
+	fs.BoolVar(&config.Quarantine.Enabled, "quarantine", getEnvBool("QUARANTINE", config.Quarantine.Enabled), "Commit a container failing verification to a quarantine image and export its logs and quarantine paths to the host")
+}
+
+// hiddenFlags are left out of the usage message
+var hiddenFlags = map[string]bool{"fail-at": true}
+
+// printVisible prints the defaults of the flags that are not hidden
+func (fs *flagSet) printVisible() {
+	visible := flag.NewFlagSet(fs.Name(), flag.ContinueOnError)
+	visible.SetOutput(fs.Output())
+	fs.VisitAll(func(f *flag.Flag) {
+		if !hiddenFlags[f.Name] {
+			visible.Var(f.Value, f.Name, f.Usage)
+			visible.Lookup(f.Name).DefValue = f.DefValue
+		}
+	})
+	visible.PrintDefaults()
+}
+
+// deployFlags defines flags that only apply to deploy
+func (fs *flagSet) deployFlags() {
+	config := fs.config
+	fs.BoolVar(&config.AutoApprove, "auto-approve", config.AutoApprove, "Deploy without showing the plan and asking for confirmation")
+	fs.BoolVar(&config.Rollback, "rollback", config.Rollback, "Rollback to previous version (deprecated, use 'pipe rollback')")
+	fs.IntVar(&config.KeepReleases, "keep-releases", getEnvInt("KEEP_RELEASES", config.KeepReleases), "Number of release images to keep on each host")
+	fs.BoolVar(&config.Prune, "prune", getEnvBool("DOCKER_PRUNE", config.Prune), "Also remove dangling image layers after cleaning up old releases")
+	fs.BoolVar(&config.SkipUnchanged, "skip-unchanged", getEnvBool("SKIP_UNCHANGED", config.SkipUnchanged), "Leave the container running when it already runs the image with the same settings")
+	fs.StringVar(&config.OnConflict, "on-conflict", getEnv("PIPE_ON_CONFLICT", config.OnConflict), "What to do when the container was changed outside pipe since its last deployment: ask, overwrite, adopt or abort (default: ask)")
+	fs.BoolVar(&config.DryRun, "dry-run", false, "Print the commands a deployment would run, with secrets masked, without running them")
+	fs.StringVar(&config.RemoteShell, "remote-shell", getEnv("REMOTE_SHELL", config.RemoteShell), "Shell running remote hooks and initial commands (e.g. 'sh'), instead of the login shell")
+	fs.StringVar(&config.RemoteDir, "remote-dir", getEnv("REMOTE_DIR", config.RemoteDir), "Working directory of remote hooks and initial commands, instead of the login directory")
+	fs.StringVar(&config.Target, "target", "", "Deploy to local Docker-in-Docker containers standing in for the hosts ("+TargetLocalDocker+")")
+	fs.BoolVar(&config.BootstrapDocker, "bootstrap", getEnvBool("BOOTSTRAP_DOCKER", config.BootstrapDocker), "Install Docker on Ubuntu and Debian hosts that do not have it, and add the SSH user to the docker group")
+
+	// Hidden from the usage message, for rehearsing rollbacks and notifications
+	fs.StringVar(&config.FailAt, "fail-at", "", "Fail the deployment on purpose at this step ("+strings.Join(FailAtSteps, ", ")+")")
+}
+
+// rollbackFlags defines flags that only apply to rollback
+func (fs *flagSet) rollbackFlags() {
+	fs.StringVar(&fs.config.RollbackTo, "to", "", "Roll back to this tag instead of the previous version (see 'pipe releases')")
+}
+
+// lockFlags defines flags that apply to the commands taking the deploy lock
+func (fs *flagSet) lockFlags() {
+	fs.StringVar(&fs.config.LockTimeout, "lock-timeout", getEnv("PIPE_LOCK_TIMEOUT", fs.config.LockTimeout), "How long to wait for another deployment of the app to release its lock on a host, or 0 to fail at once")
+}
+
+// confirmFlags defines flags that apply to the commands confirmed on
+// protected hosts
+func (fs *flagSet) confirmFlags() {
+	fs.BoolVar(&fs.config.Confirm, "confirm", false, "Ask to confirm by typing the host name on every host, not only the protected ones")
+	fs.BoolVar(&fs.config.Yes, "yes", false, "Deploy or roll back protected hosts without asking for confirmation")
+}
+
+// resultFlags defines flags that apply to the commands printing a result
+// document
+func (fs *flagSet) resultFlags() {
+	fs.StringVar(&fs.config.OutputFormat, "output", getEnv("PIPE_OUTPUT", fs.config.OutputFormat), "Print a result document on stdout when done: text or json (the log then goes to stderr)")
+}
+
+// adoptFlags defines flags that only apply to adopt
+func (fs *flagSet) adoptFlags() {
+	fs.StringVar(&fs.config.Output, "output", defaultConfigFile, "Path to write the generated config file to")
+}
+
+// initFlags defines flags that only apply to init
+func (fs *flagSet) initFlags() {
+	fs.StringVar(&fs.config.Output, "output", defaultConfigFile, "Path to write the generated config file to")
+	fs.StringVar(&fs.config.Workflow, "workflow", ".github/workflows/deploy.yml", "Path to write the GitHub Actions workflow to, or empty to skip it")
+}
+
+// logsFlags defines flags that only apply to logs
+func (fs *flagSet) logsFlags() {
+	fs.StringVar(&fs.config.Tail, "tail", "100", "Number of lines to show from the end of the logs, or 'all'")
+	fs.StringVar(&fs.config.Since, "since", "", "Show logs since a timestamp (e.g. 2024-06-01T12:00:00) or relative duration (e.g. 10m)")
+	fs.BoolVar(&fs.config.Follow, "follow", true, "Keep streaming new log output")
+}
+
+// statusFlags defines flags that only apply to status
+func (fs *flagSet) statusFlags() {
+	fs.BoolVar(&fs.config.JSON, "json", false, "Print the status as JSON")
+	fs.BoolVar(&fs.config.Wide, "wide", false, "Also show resource usage against the limits, image storage and disk space")
+}
+
+// historyFlags defines flags that only apply to history
+func (fs *flagSet) historyFlags() {
+	fs.BoolVar(&fs.config.JSON, "json", false, "Print the deployment history as JSON")
+	fs.IntVar(&fs.config.HistoryLimit, "limit", 20, "Number of most recent deployments to show, or 0 for all")
+}
+
+// listFlags defines flags that only apply to list
+func (fs *flagSet) listFlags() {
+	fs.BoolVar(&fs.config.JSON, "json", false, "Print the apps as JSON")
+}
+
+// discoverFlags defines flags that only apply to discover
+func (fs *flagSet) discoverFlags() {
+	fs.BoolVar(&fs.config.JSON, "json", false, "Print the inventory as JSON")
+}
+
+// jobsFlags defines flags that only apply to jobs
+func (fs *flagSet) jobsFlags() {
+	fs.BoolVar(&fs.config.JSON, "json", false, "Print the job history as JSON")
+}
+
+// scheduleFlags defines flags that only apply to run
+func (fs *flagSet) scheduleFlags() {
+	fs.StringVar(&fs.config.Schedule, "schedule", "", "Install the command as a cron job on the host with this schedule (e.g. '0 3 * * *') instead of running it now")
+	fs.StringVar(&fs.config.JobName, "job-name", "job", "Name of the scheduled job, installing it again replaces it")
+}
+
+// maintenanceFlags defines flags that only apply to maintenance
+func (fs *flagSet) maintenanceFlags() {
+	fs.StringVar(&fs.config.MaintenanceImage, "maintenance-image", getEnv("MAINTENANCE_IMAGE", fs.config.MaintenanceImage), "nginx image serving the maintenance page (default: nginx:alpine)")
+	fs.StringVar(&fs.config.MaintenancePage, "maintenance-page", getEnv("MAINTENANCE_PAGE", fs.config.MaintenancePage), "Local HTML file served during maintenance instead of the default page")
+}
+
+// destroyFlags defines flags that only apply to destroy
+func (fs *flagSet) destroyFlags() {
+	fs.BoolVar(&fs.config.DestroyStorage, "volumes", false, "Also remove the named volumes and network of the app, with the data in them")
+	fs.BoolVar(&fs.config.Yes, "yes", false, "Destroy without asking for confirmation")
+}
+
+// execFlags defines flags that only apply to exec
+func (fs *flagSet) execFlags() {
+	fs.BoolVar(&fs.config.TTY, "tty", true, "Allocate a TTY and attach stdin when stdin is a terminal")
+}
+
+// doctorFlags defines flags that only apply to doctor
+func (fs *flagSet) doctorFlags() {
+	fs.BoolVar(&fs.config.Fix, "fix", false, "Fix the problems that can be fixed automatically")
+	fs.BoolVar(&fs.config.BootstrapDocker, "bootstrap", getEnvBool("BOOTSTRAP_DOCKER", fs.config.BootstrapDocker), "Install Docker on Ubuntu and Debian hosts that do not have it, and add the SSH user to the docker group")
+}
+
+// validateFlags defines flags that only apply to validate
+func (fs *flagSet) validateFlags() {
+	fs.BoolVar(&fs.config.Remote, "remote", false, "Also check that the hosts are reachable over SSH and run Docker")
+}
+
+// hostFlags defines flags that only apply to host
+func (fs *flagSet) hostFlags() {
+	fs.StringVar(&fs.config.RebootTimeout, "reboot-timeout", "5m", "How long to wait for a host to come back after rebooting")
+}
+
+// mirrorFlags defines flags that only apply to mirror
+func (fs *flagSet) mirrorFlags() {
+	fs.StringVar(&fs.config.MirrorFrom, "from", "", "Host to copy the deployed image from")
+	fs.StringVar(&fs.config.MirrorTo, "to", "", "Host to copy the image to")
+}
+
+// fleetFlags defines flags that only apply to fleet
+func (fs *flagSet) fleetFlags() {
+	fs.IntVar(&fs.config.Parallel, "parallel", 10, "Maximum number of hosts the command runs on at the same time")
+}
+
+// process applies the parsed flag values that need more than a direct
+// assignment
+func (fs *flagSet) process() {
+	config := fs.config
+
 	// Process build arguments from command line
-	for _, arg := range buildArgs {
+	for _, arg := range fs.buildArgs {
 		parts := strings.SplitN(arg, "=", 2)
 		if len(parts) == 2 {
 			config.BuildArgs[parts[0]] = parts[1]
@@ -110,28 +740,436 @@ func Load() Config {
 		}
 	}
 
-	// Expand home directory in SSH key path
-	if strings.HasPrefix(config.SSHKey, "~/") {
-		home, err := os.UserHomeDir()
-		if err == nil {
-			config.SSHKey = filepath.Join(home, config.SSHKey[2:])
+	// Registry passwords are only read from the environment to keep them out of shell history
+	config.RegistryPass = os.Getenv("DOCKER_REGISTRY_PASSWORD")
+
+	// Use the tag of a pre-built image reference
+	if config.PrebuiltImage != "" {
+		config.Tag = referenceTag(config.PrebuiltImage)
+	}
+
+	// Process hosts from command line, falling back to environment variable
+	// and config file
+	hosts := fs.hosts
+	if len(hosts) == 0 {
+		if value, exists := os.LookupEnv("HOST"); exists {
+			hosts = arrayFlags{value}
+		} else if len(config.Hosts) > 0 {
+			hosts = arrayFlags(config.Hosts)
+		} else {
+			hosts = arrayFlags{config.Host}
+		}
+	}
+	config.Hosts = nil
+	for _, value := range hosts {
+		for _, host := range strings.Split(value, ",") {
+			if host = strings.TrimSpace(host); host != "" {
+				config.Hosts = append(config.Hosts, host)
+			}
 		}
 	}
+	if len(config.Hosts) > 0 {
+		config.Host = config.Hosts[0]
+	}
+
+	// Expand home directory in SSH key and known_hosts paths
+	config.SSHKey = expandHome(config.SSHKey)
+	config.KnownHosts = expandHome(config.KnownHosts)
 
-	// Assign volume flags to config
-	config.Volumes = []string(volumeFlags)
+	// Volume flags replace volumes from the config file
+	if len(fs.volumes) > 0 {
+		config.Volumes = []string(fs.volumes)
+	}
 
-	return config
+	// Copy flags replace the files to copy from the config file
+	if len(fs.copies) > 0 {
+		config.Copy = []string(fs.copies)
+	}
+
+	// Watch logs pattern flags replace the patterns from the config file
+	if len(fs.watchLogs) > 0 {
+		config.WatchLogsPatterns = []string(fs.watchLogs)
+	}
+
+	// Env file flags, or a comma-separated list in the environment, replace
+	// the env files from the config file
+	envFiles := fs.envFiles
+	if len(envFiles) == 0 {
+		if value := os.Getenv("DOCKER_CONTAINER_ENV_FILE"); value != "" {
+			envFiles = arrayFlags(strings.Split(value, ","))
+		}
+	}
+	if len(envFiles) > 0 {
+		config.EnvFile, config.EnvFiles = envFiles[0], []string(envFiles[1:])
+	}
+
+	// Environment variables from the command line override the config file.
+	// A name without a value passes the local variable through, like docker.
+	for _, variable := range fs.env {
+		key, value, ok := strings.Cut(variable, "=")
+		if !ok {
+			value = "${" + key + "}"
+		}
+		if config.flagEnv == nil {
+			config.flagEnv = make(map[string]string)
+		}
+		config.flagEnv[key] = value
+	}
+
+	// Build secret and SSH flags replace those from the config file
+	if len(fs.buildSecrets) > 0 {
+		config.BuildSecrets = []string(fs.buildSecrets)
+	}
+	if len(fs.buildSSH) > 0 {
+		config.BuildSSH = []string(fs.buildSSH)
+	}
+}
+
+// PrintHelp prints the general help message
+func PrintHelp() {
+	fmt.Print(helpText)
+}
+
+// PrintVersion prints the version information
+func PrintVersion() {
+	fmt.Printf("pipe version %s\n", version)
 }
 
-// Validate validates the configuration
+// Validate validates the configuration, returning the first problem found
 func (c *Config) Validate() error {
-	if c.Host == "" || c.User == "" {
-		return fmt.Errorf("missing required configuration: host and user must be provided")
+	for _, check := range c.checks() {
+		if err := check(); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
+// Problems validates the whole configuration and returns every problem
+// found, where Validate stops at the first
+func (c *Config) Problems() []error {
+	var problems []error
+	for _, check := range c.checks() {
+		if err := check(); err != nil {
+			problems = append(problems, err)
+		}
+	}
+	return problems
+}
+
+// checks returns the checks of the configuration, in the order Validate
+// runs them
+func (c *Config) checks() []func() error {
+	return []func() error{
+		func() error {
+			if c.Host == "" || c.User == "" {
+				return fmt.Errorf("missing required configuration: host and user must be provided")
+			}
+			return nil
+		},
+		func() error {
+			if user, host := c.Jump(); c.JumpHost != "" && (user == "" || host == "") {
+				return fmt.Errorf("invalid jump host %q: expected [user@]host[:port]", c.JumpHost)
+			}
+			return nil
+		},
+		c.validateSSH,
+		c.validatePlatform,
+		func() error {
+			if c.RemoteShell != "" && strings.ContainsAny(c.RemoteShell, " \t\n'\"") {
+				return fmt.Errorf("invalid remote shell %q: expected a shell name or path such as sh or /bin/bash", c.RemoteShell)
+			}
+			return nil
+		},
+		func() error {
+			for _, secret := range c.BuildSecrets {
+				if !slices.ContainsFunc(strings.Split(secret, ","), func(field string) bool { return strings.HasPrefix(field, "id=") }) {
+					return fmt.Errorf("invalid build secret %q: expected id=<id>,src=<file> or id=<id>,env=<variable>", secret)
+				}
+			}
+			return nil
+		},
+		func() error {
+			switch c.Strategy {
+			case StrategyRecreate, StrategyBlueGreen:
+			case StrategyCanary:
+				if err := c.validateCanary(); err != nil {
+					return err
+				}
+			default:
+				return fmt.Errorf("invalid strategy %q: expected %q, %q or %q", c.Strategy, StrategyRecreate, StrategyBlueGreen, StrategyCanary)
+			}
+			return nil
+		},
+		func() error {
+			switch c.OnConflict {
+			case "", ConflictAsk, ConflictOverwrite, ConflictAdopt, ConflictAbort:
+				return nil
+			}
+			return fmt.Errorf("invalid on-conflict %q: expected %q, %q, %q or %q", c.OnConflict, ConflictAsk, ConflictOverwrite, ConflictAdopt, ConflictAbort)
+		},
+		func() error {
+			for _, hooks := range [][]Hook{c.Hooks.PreBuild, c.Hooks.PreDeploy, c.Hooks.PostDeploy, c.Hooks.OnFailure,
+				c.Hooks.PreRollback, c.Hooks.PostRollback} {
+				for _, hook := range hooks {
+					if (hook.Local == "") == (hook.Remote == "") {
+						return fmt.Errorf("invalid hook: set either a local or a remote command")
+					}
+				}
+			}
+			return nil
+		},
+		func() error {
+			if c.HooksDir == "" {
+				return nil
+			}
+			if info, err := os.Stat(c.HooksDir); err != nil || !info.IsDir() {
+				return fmt.Errorf("hooks directory %s not found", c.HooksDir)
+			}
+			return nil
+		},
+		func() error {
+			for _, hook := range c.Hooks.Report {
+				if hook.Local == "" || hook.Remote != "" {
+					return fmt.Errorf("invalid report hook: report hooks run on this machine, set a local command")
+				}
+			}
+			return nil
+		},
+		func() error {
+			if c.Updates.RebootWindow == "" {
+				return nil
+			}
+			_, _, err := parseRebootWindow(c.Updates.RebootWindow)
+			return err
+		},
+		c.validateResources,
+		c.validateReplicas,
+		c.validateRolling,
+		c.validateCopy,
+		c.validateWatchLogs,
+		c.Runtime.validate,
+		c.validateAccessories,
+		c.Agent.validate,
+		c.Quarantine.validate,
+		c.Scan.validate,
+		c.Signing.validate,
+		c.SBOM.validate,
+		c.Migrate.validate,
+		c.Telemetry.validate,
+		func() error {
+			if c.Signing.Enabled() && c.Registry == "" && c.PrebuiltImage == "" {
+				return fmt.Errorf("signing needs a registry or a pre-built image, as cosign keeps the signatures in the registry")
+			}
+			return nil
+		},
+		c.LogShipping.validate,
+		func() error {
+			return c.Metrics.validate(c.HostPort)
+		},
+		c.validateProxy,
+		c.validateTransport,
+		func() error {
+			_, err := c.LockTimeoutDuration()
+			return err
+		},
+		c.validateSystemPackages,
+		c.StatusPage.validate,
+		func() error {
+			for _, notification := range c.Notifications {
+				if err := notification.validate(); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		func() error {
+			if c.KeepReleases < 1 {
+				return fmt.Errorf("invalid keep releases %d: expected at least 1", c.KeepReleases)
+			}
+			return nil
+		},
+		c.validateTransfer,
+		func() error {
+			if c.BuildParallel < 1 {
+				return fmt.Errorf("invalid build parallel %d: expected at least 1", c.BuildParallel)
+			}
+			return nil
+		},
+		func() error {
+			if c.Retries < 0 {
+				return fmt.Errorf("invalid retries %d: expected 0 or more", c.Retries)
+			}
+			return nil
+		},
+		func() error {
+			_, err := c.RetryBackoff()
+			return err
+		},
+		c.validateHealthCmd,
+		func() error {
+			if c.HealthURL != "" {
+				if _, err := time.ParseDuration(c.HealthTimeout); err != nil {
+					return fmt.Errorf("invalid health timeout %q: %v", c.HealthTimeout, err)
+				}
+				if c.HealthRetries < 1 {
+					return fmt.Errorf("invalid health retries %d: expected at least 1", c.HealthRetries)
+				}
+			}
+			return nil
+		},
+	}
+}
+
+// Repository returns the image repository, including the registry if one is configured
+func (c *Config) Repository() string {
+	if c.PrebuiltImage != "" {
+		return referenceRepository(c.PrebuiltImage)
+	}
+	if c.Registry != "" {
+		return fmt.Sprintf("%s/%s", strings.TrimSuffix(c.Registry, "/"), c.Image)
+	}
+	return c.Image
+}
+
+// ImageRef returns the full image reference to build and deploy
+func (c *Config) ImageRef() string {
+	if c.PrebuiltImage != "" {
+		return c.PrebuiltImage
+	}
+	return fmt.Sprintf("%s:%s", c.Repository(), c.Tag)
+}
+
+// RegistryHost returns the registry server used for docker login
+func (c *Config) RegistryHost() string {
+	if c.Registry == "" && c.PrebuiltImage != "" {
+		return strings.SplitN(c.PrebuiltImage, "/", 2)[0]
+	}
+	return strings.SplitN(c.Registry, "/", 2)[0]
+}
+
+// SetImage sets the image name and tag from an image reference
+func (c *Config) SetImage(ref string) {
+	c.Image = referenceRepository(ref)
+	c.Tag = referenceTag(ref)
+}
+
+// referenceRepository strips the tag or digest from an image reference
+func referenceRepository(ref string) string {
+	if i := strings.Index(ref, "@"); i >= 0 {
+		return ref[:i]
+	}
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		return ref[:i]
+	}
+	return ref
+}
+
+// referenceTag returns the tag or digest of an image reference
+func referenceTag(ref string) string {
+	if i := strings.Index(ref, "@"); i >= 0 {
+		return ref[i+1:]
+	}
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		return ref[i+1:]
+	}
+	return "latest"
+}
+
+// Settings returns the configuration values that materially affect the
+// running container, used to detect drift between deployments. Build argument
+// values are hashed so they are not stored in plain text.
+func (c *Config) Settings() map[string]string {
+	buildArgs := make([]string, 0, len(c.BuildArgs))
+	for key, value := range c.BuildArgs {
+		buildArgs = append(buildArgs, fmt.Sprintf("%s=%s", key, value))
+	}
+	sort.Strings(buildArgs)
+
+	settings := map[string]string{
+		"image":         c.Repository(),
+		"dockerfile":    c.Dockerfile,
+		"platform":      c.Platform,
+		"containerName": c.ContainerName,
+		"containerPort": c.ContainerPort,
+		"hostPort":      c.HostPort,
+		"envFile":       c.EnvFile,
+		"buildArgs":     shortHash(strings.Join(buildArgs, "\n")),
+		"network":       c.Network,
+		"volumes":       strings.Join(c.Volumes, ","),
+		"cpus":          c.CPUs,
+		"memory":        c.Memory,
+	}
+
+	// Settings added later are only included when set, so configurations
+	// without them keep their hash
+	for name, value := range map[string]string{
+		"memoryReservation": c.MemoryReservation,
+		"memorySwap":        c.MemorySwap,
+		"pidsLimit":         strconv.Itoa(c.PidsLimit),
+		"cpuShares":         strconv.Itoa(c.CPUShares),
+		"oomKillDisable":    strconv.FormatBool(c.OOMKillDisable),
+		"cpusetCpus":        c.CPUsetCPUs,
+		"cgroupParent":      c.CgroupParent,
+		"gpus":              c.GPUs,
+		"healthCmd":         c.HealthCmd,
+		"healthInterval":    c.HealthInterval,
+		"healthStartPeriod": c.HealthStartPeriod,
+		"healthCmdRetries":  strconv.Itoa(c.HealthCmdRetries),
+		"domain":            c.Domain,
+		"proxy":             c.Proxy.Type,
+		"envFiles":          strings.Join(c.EnvFiles, ","),
+		"copy":              c.copyChecksum(),
+	} {
+		if value != "" && value != "0" && value != "false" {
+			settings[name] = value
+		}
+	}
+
+	if len(c.Env) > 0 {
+		variables := make([]string, 0, len(c.Env))
+		for key, value := range c.Env {
+			variables = append(variables, fmt.Sprintf("%s=%s", key, value))
+		}
+		sort.Strings(variables)
+		settings["env"] = shortHash(strings.Join(variables, "\n"))
+	}
+
+	if runtime, err := json.Marshal(c.Runtime); err == nil && string(runtime) != "{}" {
+		settings["runtime"] = shortHash(string(runtime))
+	}
+
+	return settings
+}
+
+// Hash returns a hash of the configuration's Settings
+func (c *Config) Hash() string {
+	data, _ := json.Marshal(c.Settings())
+	return shortHash(string(data))
+}
+
+// shortHash returns the first 12 hex characters of the SHA-256 of value
+func shortHash(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// getEnvInt gets an integer environment variable with a default value
+func getEnvInt(key string, defaultValue int) int {
+	if value, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+// getEnvBool gets a boolean environment variable with a default value
+func getEnvBool(key string, defaultValue bool) bool {
+	if value, err := strconv.ParseBool(os.Getenv(key)); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
 // getEnv gets an environment variable with a default value
 func getEnv(key, defaultValue string) string {
 	if value, exists := os.LookupEnv(key); exists {
@@ -147,50 +1185,402 @@ const helpText = `
 Docker Deployment Tool
 
 Usage:
-  pipe [options]
+  pipe [command] [options]
+
+Commands:
+  init                    Write a starter config file and GitHub Actions workflow interactively
+  deploy                  Build, transfer and start the container (default)
+  rollback                Roll back to the previous version, or the one given with --to
+  plan                    Show the actions a deployment would perform
+  releases                List past deployments and the versions kept on the host
+  releases show <id>      Show the full transcript of a past deployment
+  releases contains <lib> List the releases whose SBOM contains a library
+  history                 Show who deployed or rolled back what and when, with the result
+  maintenance on|off      Swap the container for a maintenance page, or back
+  compare hosts           Compare the deployed container across all hosts
+  adopt <container>       Bring an existing container under pipe management
+  logs                    Stream the container logs from the host
+  status                  Show the state of the container and the releases kept on the host
+  list                    Show the apps of the workspace and the state of their containers
+  discover                Show the containers, images, networks and volumes on the hosts
+  exec -- <command>       Run a command inside the running container
+  run -- <command>        Build the image and run a command to completion in a new container from it
+  jobs run -- <command>   Run a one-off job in the container, recording its output and exit code
+  jobs history|logs <id>  List the jobs that ran on the host or show the output of one
+  jobs scheduled|unschedule <name>
+                          List the jobs scheduled with run --schedule or remove one
+  unlock                  Release the deploy lock left on the hosts by a run that was killed
+  doctor                  Check that the hosts are set up to run the app
+  validate                Check the whole configuration without deploying
+  host reboot             Reboot the hosts one at a time and verify the container comes back
+  mirror                  Copy the deployed image from one host directly to another
+  pull-remote             Download the image of the running container to the local docker
+  destroy                 Remove the app, its images and its state from the hosts
+  accessory start|stop|logs [name]
+                          Manage the accessories defined in the config file
+  fleet exec -- <command> Run a shell command on every host of the app, its stack and accessories
+  agent install|uninstall|status
+                          Manage the optional agent watching the container on the hosts
+  metrics install|uninstall|targets
+                          Manage node-exporter and cAdvisor on every host and print their scrape targets
+  completion bash|zsh|fish
+                          Print the shell completion script
+  help                    Show this help message
+  version                 Show version information
 
-Options:
-  --host            Remote host to deploy to
+Run 'pipe <command> --help' to see the options of a command.
+
+Connection options (all commands):
+  --config          Path to a JSON config file (default: pipe.json if present)
+  --host            Remote host to deploy to (can be specified multiple times or comma-separated)
+  --service         Only use this service of the stack defined in the config file
+  --app             App of the workspace defined in the config file (also PIPE_APP)
+  --environment     Environment defined in the config file, such as staging or production (also
+                    PIPE_ENV)
   --user            SSH user for remote host
-  --image           Docker image name (default: app)
-  --dockerfile      Path to the dockerfile (default: Dockerfile)
-  --tag             Docker image tag (default: latest)
-  --platform        Docker platform (default: linux/amd64)
   --ssh-key         Path to SSH key (default: "")
+  --jump-host       Bastion to connect through, as [user@]host[:port] (default: none)
+  --ssh-port        SSH port of hosts given without a port (default: 22)
+  --ssh-agent       Only authenticate with the keys of the running ssh-agent
+  --host-key-check  Host key verification: strict, or accept-new to record unknown hosts (default: strict)
+  --known-hosts     known_hosts file to verify host keys against (default: ~/.ssh/known_hosts)
+  --docker-user     Run docker on the hosts as this user with 'sudo -n -u', for SSH users
+                    without access to the Docker socket (default: the SSH user)
+  --docker-cmd      Command running docker on the hosts, such as 'sudo docker', 'podman' or
+                    'docker -H unix:///run/user/1000/docker.sock' for rootless Docker
+  --transport       How docker commands reach the hosts: ssh, docker-host to drive the remote
+                    engine with the local docker CLI over ssh://, or docker-tls to reach it at
+                    tcp://<host>:2376 with client certificates and no SSH (default: ssh)
+  --docker-context  Docker context of the host for --transport docker-host or docker-tls
+  --docker-cert-path
+                    Directory with the ca.pem, cert.pem and key.pem of --transport docker-tls
+                    (default: ~/.docker)
   --container-name  Name for the container (default: app)
+  --image           Docker image name (default: app)
+  --tag             Docker image tag (default: latest)
+  --registry        Push the image to this registry and pull it on the host (e.g. 'ghcr.io/org')
+  --image-ref       Deploy an existing image reference without building or transferring it
+  --retries         Times a step failing on a network error is retried (default: 3)
+  --retry-delay     Wait before the first retry, doubled for every further retry (default: 2s)
+
+Output options (all commands):
+  --quiet           Only print warnings, errors and results
+  --verbose         Also print the executed commands and other debug messages
+  --log-format      Console log format: text or json, one JSON object per line (default: text)
+  --log-file        File to write the log to, or 'none' to disable it (default: deploy.log)
+  --log-max-size    Size at which the log file is rotated, or 0 to never rotate it (default: 10m)
+  --log-max-age     How long rotated log files are kept, or 0 to keep them (default: 720h)
+  --timeout         Cancel the command after this long (e.g. 15m), like Ctrl+C (default: none)
+  --read-only       Only allow commands that do not change the hosts, such as status, logs,
+                    releases and plan
+
+Build options (deploy, plan):
+  --dockerfile      Path to the dockerfile (default: Dockerfile)
+  --platform        Docker platform, a comma-separated list built with buildx, or 'auto' for
+                    the platforms of the hosts (default: linux/amd64)
+  --build-arg       Build arguments (can be specified multiple times, format: KEY=VALUE)
+  --build-secret    Secret exposed to the build without ending up in the image or the log,
+                    e.g. 'id=npmrc,src=.npmrc' (can be specified multiple times)
+  --build-ssh       SSH agent or keys exposed to the build, e.g. 'default' (can be specified
+                    multiple times)
+  --tag-strategy    Generate the image tag instead of deploying --tag: git-sha for the short
+                    commit hash, timestamp for the time in UTC, or semver for the latest git
+                    tag such as v1.2.0
+  --reproducible    Pin base images by digest and build with SOURCE_DATE_EPOCH, so builds of
+                    the same commit produce the same image
+  --scan            Scan the image for vulnerabilities with trivy or grype before it is
+                    transferred, failing the deployment on findings
+  --scan-severity   Lowest severity failing the deployment: low, medium, high or critical
+                    (default: high)
+  --scan-ignore-file
+                    File listing accepted vulnerability IDs such as CVE-2024-1234, one per line
+  --sbom            Generate an SBOM of the image with syft and keep it on the hosts next to
+                    the deployment history (see 'pipe releases contains')
+  --registry-user   Username for docker login on the registry
+  --skip-build      Skip building and transfer the existing local image
+  --compress        Compression of the image sent to the hosts: gzip, zstd or none (default: gzip)
+  --compress-level  Compression level, 1-9 for gzip and 1-19 for zstd
+  --bwlimit         Limit the image upload over SSH to this many bytes per second, with an
+                    optional unit k, m or g (e.g. 5m for 5 MB/s, default: no limit)
+  --build-parallel  Maximum number of stack services built at the same time (default: 4)
+
+Container options (deploy, plan, rollback):
   --container-port  Container port (default: 3000)
   --host-port       Host port (default: 3000)
-  --env-file        Environment file (default: "")
-  --build-arg       Build arguments (can be specified multiple times, format: KEY=VALUE)
-  --network         Docker network to connect to
+  --env-file        Environment file, optionally SOPS or age encrypted (can be specified multiple times, later files override earlier ones)
+  --env             Environment variable in KEY=VALUE format, ${NAME} is taken from the local environment;
+                    a NAME alone passes the local variable through (can be specified multiple times)
+  --network         Docker network to connect to, created when missing
+  --network-driver  Driver of the network when it is created (default: "")
+  --domain          Domain the reverse proxy routes to the app
   --volume          Volume mount (can be specified multiple times, format: host:container)
+  --copy            File or directory copied to the hosts before the container starts, so
+                    volumes can mount it (can be specified multiple times, format:
+                    local:remote[:mode], e.g. nginx.conf:/srv/myapp/nginx.conf:0644)
+  --volume-driver   Driver of named volumes when they are created (default: "")
   --cpus            Number of CPUs (e.g., '0.5' or '2')
   --memory          Memory limit (e.g., '512m' or '2g')
-  --rollback        Rollback to the previous version
-  --version         Show version information
-  --help            Show this help message
+  --memory-reservation
+                    Memory soft limit, below the memory limit (e.g., '256m')
+  --memory-swap     Memory plus swap limit (e.g., '1g'), or -1 for unlimited swap
+  --pids-limit      Maximum number of processes in the container, or -1 for unlimited
+  --cpu-shares      Relative CPU weight (default weight is 1024)
+  --oom-kill-disable
+                    Do not kill the container when it runs out of memory (needs --memory)
+  --cpuset-cpus     CPUs the container may run on (e.g., '0-3' or '1,3')
+  --cgroup-parent   Parent cgroup of the container (e.g., 'latency.slice' with the systemd cgroup driver)
+  --gpus            GPUs to give the container (e.g., 'all' or 'device=0'), needs the NVIDIA
+                    Container Toolkit on the host
+  --strategy        Deployment strategy: recreate, blue-green or canary (default: recreate)
+  --alternate-port  Host port for the new version during blue-green deployments (default: host port + 1)
+  --replicas        Number of copies of the container on every host, named <container>-1 to
+                    <container>-N and restarted one at a time during a deployment (default: 1)
+  --replica-ports   Host ports of the replicas: sequential from the host port, or dynamic ports
+                    picked by docker, reached through the traefik proxy (default: sequential)
+  --canary-weight   Percentage of the hosts deployed first in canary deployments (default: 10); it
+                    is a share of whole hosts, not of the requests, so canary needs at least two hosts
+  --canary-bake     How long the canary hosts must stay healthy before the rest follow (default: 5m)
+  --rolling         Deploy the hosts in batches, each verified before the next, and stop at the
+                    first failing batch
+  --max-unavailable Number of hosts deployed at once in rolling deployments (default: 1)
+  --rolling-rollback
+                    Roll back the hosts already deployed when a rolling deployment fails
+  --health-url      Path (e.g. '/health') or URL polled from the host until it returns 200, or 'tcp' to wait for the port
+  --health-timeout  How long to wait for the health check to pass (default: 60s)
+  --health-retries  Number of health check attempts, spread over the health timeout (default: 12)
+  --health-cmd      Command docker runs in the container to check its health; the deployment
+                    waits for the container to become healthy
+  --health-interval Time between the health commands (docker's default: 30s)
+  --health-start-period
+                    Time the container gets to start before failing health commands count
+  --health-cmd-retries
+                    Failing health commands in a row before the container is unhealthy (docker's default: 3)
+  --watch-logs      Follow the logs of the new container for this long after it passed its health
+                    checks (e.g. '30s'), and fail when it logs a failure pattern, stops or restarts
+  --watch-logs-pattern
+                    Regular expression of a log line failing the deployment (can be specified
+                    multiple times, default: panics, FATAL and stack traces)
+  --quarantine      Commit a container failing verification to a quarantine image and export its
+                    logs and the quarantine paths to the state directory on the host
+
+Rollback options:
+  --to              Roll back to this tag instead of the previous version (see 'pipe releases')
+
+Lock options (deploy, rollback):
+  --lock-timeout    How long to wait for another deployment of the app to release its lock on
+                    a host, or 0 to fail at once (default: 5m)
+
+Confirmation options (deploy, rollback):
+  --confirm         Ask to confirm by typing the host name on every host, not only the
+                    protected ones
+  --yes             Deploy or roll back protected hosts without asking for confirmation
+
+Result options (deploy, rollback):
+  --output          text, or json to print a document with the outcome, the duration of every
+                    step, and the image digest and container ID on every host on stdout when
+                    done; the log then goes to stderr (default: text)
+
+Adopt options:
+  --output          Path to write the generated config file to (default: pipe.json)
+
+Init options:
+  --output          Path to write the generated config file to (default: pipe.json)
+  --workflow        Path to write the GitHub Actions workflow to, or '' to skip it
+                    (default: .github/workflows/deploy.yml)
+
+Logs options (logs, accessory logs):
+  --tail            Number of lines to show from the end of the logs, or 'all' (default: 100)
+  --since           Show logs since a timestamp or relative duration (e.g. 10m)
+  --follow          Keep streaming new log output (default: true)
+
+Status options:
+  --json            Print the status as JSON
+  --wide            Also show resource usage against the limits, image storage and disk space
+
+History options:
+  --json            Print the deployment history as JSON
+  --limit           Number of most recent deployments to show, or 0 for all (default: 20)
+
+List options:
+  --json            Print the apps as JSON
+
+Discover options:
+  --json            Print the inventory as JSON
+
+Run options (also takes the build and container options):
+  --schedule        Install the command as a cron job on the host with this schedule
+                    (e.g. '0 3 * * *' or '@daily') instead of running it now
+  --job-name        Name of the scheduled job, installing it again replaces it (default: job)
+
+Jobs options:
+  --json            Print the job history as JSON
+
+Maintenance options (also takes the container options):
+  --maintenance-image
+                    nginx image serving the maintenance page (default: nginx:alpine)
+  --maintenance-page
+                    Local HTML file served during maintenance instead of the default page
+
+Destroy options (also takes the container options):
+  --volumes         Also remove the named volumes and network of the app, with the data in them
+  --yes             Destroy without asking for confirmation
+
+Exec options:
+  --tty             Allocate a TTY and attach stdin when stdin is a terminal (default: true)
+
+Fleet options:
+  --parallel        Maximum number of hosts the command runs on at the same time (default: 10)
+
+Doctor options:
+  --fix             Fix the problems that can be fixed automatically
+  --bootstrap       Install Docker on Ubuntu and Debian hosts that do not have it, and add the
+                    SSH user to the docker group
+
+Validate options (also takes the build and container options):
+  --remote          Also check that the hosts are reachable over SSH and run Docker
+
+Host options (also takes the container options for the health check):
+  --reboot-timeout  How long to wait for a host to come back after rebooting (default: 5m)
+
+Mirror options:
+  --from            Host to copy the deployed image from
+  --to              Host to copy the image to
+
+Deploy options:
+  --auto-approve    Deploy without showing the plan and asking for confirmation (interactive terminals only)
+  --rollback        Rollback to the previous version (deprecated, use 'pipe rollback')
+  --keep-releases   Number of release images to keep on each host (default: 5)
+  --prune           Also remove dangling image layers after cleaning up old releases
+  --skip-unchanged  Leave the container running, without running hooks, when it already runs
+                    the same image with the same settings and env file
+  --on-conflict     What to do when the container was changed outside pipe since its last
+                    deployment: ask, overwrite, adopt or abort (default: ask, overwrite
+                    without a terminal)
+  --dry-run         Print the docker, ssh and sftp commands a deployment would run, with
+                    secrets masked, without running them
+  --remote-shell    Shell running remote hooks and initial commands (e.g. sh), instead of the
+                    login shell of the SSH user
+  --remote-dir      Working directory of remote hooks and initial commands (default: the
+                    login directory)
+  --target          local-docker to deploy to local Docker-in-Docker containers standing
+                    in for the hosts, to test a config without touching real servers
+  --bootstrap       Install Docker on Ubuntu and Debian hosts that do not have it, and add the
+                    SSH user to the docker group
 
 Environment Variables:
-  HOST                        Remote host to deploy to
+  HOST                        Remote host(s) to deploy to (comma-separated)
   HOST_USER                   SSH user for remote host
   HOST_PORT                   Host port
   HOST_PLATFORM              Docker platform
   SSH_KEY_PATH               Path to SSH key
+  SSH_PASSWORD               Password for password or keyboard-interactive SSH authentication
+  DOCKER_USER                User running docker on the hosts through sudo
+  BOOTSTRAP_DOCKER           Install Docker on Ubuntu and Debian hosts without it (true/false)
+  PIPE_TRANSPORT             How docker commands reach the hosts (ssh, docker-host or docker-tls)
+  PIPE_DOCKER_CONTEXT        Docker context of the host for the docker-host or docker-tls transport
+  PIPE_DOCKER_CERT_PATH      Directory with the client certificates of the docker-tls transport
+  PIPE_LOCK_TIMEOUT          How long deploy and rollback wait for the deploy lock of a host
+  PIPE_OUTPUT                Result document of deploy and rollback (text or json)
+  PIPE_ON_CONFLICT           What deploy does with a container changed outside pipe (ask, overwrite, adopt or abort)
+  PIPE_LOCAL_SHELL           Shell running local hooks and $(command) values (sh, pwsh, powershell or cmd)
   DOCKER_IMAGE_NAME          Docker image name
   DOCKER_IMAGE_TAG           Docker image tag
   DOCKER_CONTAINER_NAME      Name for the container
   DOCKER_CONTAINER_PORT      Container port
   DOCKER_BUILD_ARGS          Build arguments (comma-separated KEY=VALUE pairs)
-  DOCKER_CONTAINER_ENV_FILE  Environment file
+  DOCKER_TAG_STRATEGY        Generate the image tag (git-sha, timestamp or semver)
+  DOCKER_REPRODUCIBLE        Build reproducibly (true/false)
+  SCAN                       Scan the image for vulnerabilities (trivy or grype)
+  SCAN_SEVERITY              Lowest severity of the vulnerabilities failing the deployment
+  SCAN_IGNORE_FILE           File listing accepted vulnerability IDs
+  SBOM                       Generate an SBOM of the image (true/false)
+  DOCKER_CONTAINER_ENV_FILE  Environment files (comma-separated)
   DOCKER_NETWORK             Docker network to connect to
+  DOCKER_NETWORK_DRIVER      Driver of the network when it is created
+  DOCKER_VOLUME_DRIVER       Driver of named volumes when they are created
+  APP_DOMAIN                 Domain the reverse proxy routes to the app
   DOCKER_CPUS                Number of CPUs
   DOCKER_MEMORY             Memory limit
+  DOCKER_MEMORY_RESERVATION Memory soft limit
+  DOCKER_MEMORY_SWAP        Memory plus swap limit
+  DOCKER_PIDS_LIMIT         Maximum number of processes
+  DOCKER_CPU_SHARES         Relative CPU weight
+  DOCKER_OOM_KILL_DISABLE   Disable the OOM killer
+  DOCKER_CPUSET_CPUS        CPUs the container may run on
+  DOCKER_CGROUP_PARENT      Parent cgroup of the container
+  DEPLOY_STRATEGY            Deployment strategy
+  CANARY_WEIGHT              Percentage of the hosts (not requests) deployed first in canary deployments
+  CANARY_BAKE                How long the canary hosts must stay healthy
+  ROLLING_DEPLOY             Deploy the hosts in batches (true/false)
+  MAX_UNAVAILABLE            Number of hosts deployed at once in rolling deployments
+  ROLLING_ROLLBACK           Roll back deployed hosts when a rolling deployment fails
+  HOST_ALTERNATE_PORT        Alternate host port for blue-green deployments
+  DOCKER_REPLICAS            Number of copies of the container on every host
+  DOCKER_REPLICA_PORTS       Host ports of the replicas (sequential or dynamic)
+  HEALTH_CHECK_URL           Health check path or URL
+  HEALTH_CHECK_TIMEOUT       Health check timeout
+  HEALTH_CHECK_RETRIES       Health check attempts
+  WATCH_LOGS                 How long to watch the logs of the new container
+  DOCKER_REGISTRY            Registry to push to and pull from
+  DOCKER_IMAGE_REF           Existing image reference to deploy
+  DOCKER_REGISTRY_USER       Username for docker login on the registry
+  DOCKER_REGISTRY_PASSWORD   Password or token for docker login on the registry
+  MAINTENANCE_IMAGE          nginx image serving the maintenance page
+  MAINTENANCE_PAGE           Local HTML file served during maintenance
+  PIPE_CONFIG                Path to a JSON config file
+  PIPE_ENV                   Environment defined in the config file, such as staging or production
+
+Config file:
+  All options can also be set in a JSON config file using camelCase names.
+  Environment variables and flags override values from the file.
+
+  {
+    "hosts": ["example.com"],
+    "user": "deploy",
+    "image": "myapp",
+    "containerName": "myapp",
+    "volumes": ["myapp_data:/data"],
+    "initial": ["mkdir -p ~/backups"]
+  }
+
+  A "stack" list of services, each with a "name", optional "dependsOn" and
+  its own settings, deploys several apps to different hosts as a unit.
+  Services receive <NAME>_HOST, <NAME>_PORT and <NAME>_URL variables for
+  the services they depend on.
+
+  "hooks" runs local or remote commands at the preBuild, preDeploy,
+  postDeploy and onFailure points of a deployment:
+
+  "hooks": {"preDeploy": [{"remote": "docker run --rm $PIPE_IMAGE migrate"}]}
+
+  "updates" sets up unattended security updates on the hosts, rebooting
+  them one after another within the reboot window when needed:
 
+  "updates": {"unattended": true, "rebootWindow": "02:00-05:00"}
 
 Examples:
-  pipe --host example.com --user deploy
-  pipe --host example.com --user deploy --build-arg VERSION=1.0.0 --build-arg ENV=prod
-  pipe --env-file .env.production --build-arg GIT_HASH=$(git rev-parse HEAD)
-  pipe --host example.com --user deploy --cpus "0.5" --memory "512m"
-  pipe --rollback # Rollback to the previous version
-` 
\ No newline at end of file
+  pipe deploy --host example.com --user deploy
+  pipe deploy --host example.com --user deploy --build-arg VERSION=1.0.0 --build-arg ENV=prod
+  pipe deploy --env-file .env.production --build-arg GIT_HASH=$(git rev-parse HEAD)
+  pipe deploy --host example.com --user deploy --cpus "0.5" --memory "512m"
+  pipe deploy --host web1.example.com,web2.example.com --user deploy
+  pipe deploy --host example.com --user deploy --strategy blue-green --alternate-port 3001
+  pipe deploy --host example.com --user deploy --health-url /health --health-timeout 90s
+  pipe deploy --host example.com --user deploy --registry ghcr.io/myorg --registry-user myuser
+  pipe deploy --host example.com --user deploy --image-ref ghcr.io/myorg/app:1.2.0
+  pipe rollback --host example.com --user deploy
+  pipe rollback --host example.com --user deploy --to 1.2.0
+  pipe maintenance on --host example.com --user deploy
+  pipe destroy --host example.com --user deploy --container-name oldapp
+  pipe adopt myapp --host example.com --user deploy
+  pipe logs --host example.com --user deploy --tail 50 --since 10m
+  pipe status --host example.com --user deploy --json
+  pipe exec --host example.com --user deploy -- sh
+  pipe jobs run --host example.com --user deploy -- bin/cleanup
+  pipe run --host example.com --user deploy -- bin/report --yesterday
+  pipe run --host example.com --user deploy --schedule "0 3 * * *" --job-name report -- bin/report
+  pipe doctor --host example.com --user deploy --fix
+  pipe accessory logs postgres --tail 50
+  pipe fleet exec --parallel 5 -- "docker system df"
+  pipe host reboot --host web1.example.com,web2.example.com --user deploy --health-url /health
+`
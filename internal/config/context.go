@@ -0,0 +1,55 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Context returns the context the command runs under. It is cancelled on
+// Ctrl+C or when the timeout passes.
+func (c *Config) Context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
+// WithContext returns a copy of the configuration running under ctx
+func (c *Config) WithContext(ctx context.Context) *Config {
+	config := *c
+	config.ctx = ctx
+	return &config
+}
+
+// Detached returns a copy of the configuration that is not cancelled with
+// the command, for cleaning up after a cancelled run
+func (c *Config) Detached() *Config {
+	return c.WithContext(context.WithoutCancel(c.Context()))
+}
+
+// TimeoutDuration returns how long the command may run, or zero without a
+// timeout
+func (c *Config) TimeoutDuration() (time.Duration, error) {
+	if c.Timeout == "" || c.Timeout == "0" {
+		return 0, nil
+	}
+	timeout, err := time.ParseDuration(c.Timeout)
+	if err != nil || timeout < 0 {
+		return 0, fmt.Errorf("invalid timeout %q: expected a duration such as 15m, or 0", c.Timeout)
+	}
+	return timeout, nil
+}
+
+// LockTimeoutDuration returns how long to wait for the deploy lock of a
+// host, or zero to fail at once when it is held
+func (c *Config) LockTimeoutDuration() (time.Duration, error) {
+	if c.LockTimeout == "" || c.LockTimeout == "0" {
+		return 0, nil
+	}
+	timeout, err := time.ParseDuration(c.LockTimeout)
+	if err != nil || timeout < 0 {
+		return 0, fmt.Errorf("invalid lock timeout %q: expected a duration such as 5m, or 0", c.LockTimeout)
+	}
+	return timeout, nil
+}
@@ -0,0 +1,104 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// copyMode matches the optional file mode at the end of a copy
+var copyMode = regexp.MustCompile(`^[0-9]{3,4}$`)
+
+// Copy is a local file or directory copied to the hosts before the container
+// is started, for configuration and certificates the container mounts
+type Copy struct {
+	Local  string
+	Remote string
+	Mode   os.FileMode
+}
+
+// Copies returns the files to copy to the hosts, given as local:remote[:mode]
+func (c *Config) Copies() ([]Copy, error) {
+	copies := make([]Copy, 0, len(c.Copy))
+	for _, value := range c.Copy {
+		file, err := parseCopy(value)
+		if err != nil {
+			return nil, err
+		}
+		copies = append(copies, file)
+	}
+	return copies, nil
+}
+
+// parseCopy parses local:remote[:mode]. The local path may contain a colon,
+// as in a Windows drive letter, so the paths are split at the last colon
+// after the optional octal mode.
+func parseCopy(value string) (Copy, error) {
+	invalid := fmt.Errorf("invalid copy %q: expected local:remote[:mode], e.g. nginx.conf:/srv/myapp/nginx.conf:0644", value)
+
+	var file Copy
+	rest := value
+	if i := strings.LastIndex(rest, ":"); i >= 0 && copyMode.MatchString(rest[i+1:]) {
+		mode, err := strconv.ParseUint(rest[i+1:], 8, 32)
+		if err != nil || mode > 0777 {
+			return Copy{}, fmt.Errorf("invalid copy %q: mode %s is not an octal file mode such as 0644", value, rest[i+1:])
+		}
+		file.Mode = os.FileMode(mode)
+		rest = rest[:i]
+	}
+
+	i := strings.LastIndex(rest, ":")
+	if i < 0 {
+		return Copy{}, invalid
+	}
+	file.Local, file.Remote = rest[:i], rest[i+1:]
+	if file.Local == "" || file.Remote == "" {
+		return Copy{}, invalid
+	}
+	return file, nil
+}
+
+// validateCopy checks the files to copy exist
+func (c *Config) validateCopy() error {
+	copies, err := c.Copies()
+	if err != nil {
+		return err
+	}
+	for _, file := range copies {
+		if _, err := os.Stat(file.Local); err != nil {
+			return fmt.Errorf("file to copy %s not found", file.Local)
+		}
+	}
+	return nil
+}
+
+// copyChecksum returns a short hash of the files to copy and their contents,
+// so changing a copied file counts as a changed setting
+func (c *Config) copyChecksum() string {
+	copies, err := c.Copies()
+	if err != nil || len(copies) == 0 {
+		return ""
+	}
+
+	hash := sha256.New()
+	for _, file := range copies {
+		fmt.Fprintf(hash, "%s:%s:%o\n", file.Local, file.Remote, file.Mode)
+		filepath.WalkDir(file.Local, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil || entry.IsDir() {
+				return nil
+			}
+			if data, err := os.ReadFile(path); err == nil {
+				fmt.Fprintf(hash, "%s\n", path)
+				hash.Write(data)
+			}
+			return nil
+		})
+	}
+	return hex.EncodeToString(hash.Sum(nil))[:12]
+}
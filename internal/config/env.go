@@ -0,0 +1,90 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// EnvFilePaths returns the environment files of the app in the order they
+// are merged, later files overriding the variables of earlier ones
+func (c *Config) EnvFilePaths() []string {
+	var paths []string
+	if c.EnvFile != "" {
+		paths = append(paths, c.EnvFile)
+	}
+	return append(paths, c.EnvFiles...)
+}
+
+// Interpolate replaces ${NAME} in a value with the variable from the local
+// environment. $${NAME} is kept as ${NAME}. Unset variables are an error,
+// so a typo does not silently deploy an empty value.
+func Interpolate(value string) (string, error) {
+	var missing []string
+	interpolated := templateVariable.ReplaceAllStringFunc(value, func(match string) string {
+		if strings.HasPrefix(match, "$$") {
+			return match[1:]
+		}
+		name := templateVariable.FindStringSubmatch(match)[1]
+		variable, ok := os.LookupEnv(name)
+		if !ok {
+			missing = append(missing, name)
+		}
+		return variable
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("%s not set in the local environment", strings.Join(missing, ", "))
+	}
+	return interpolated, nil
+}
+
+// applyEnv interpolates the environment variables of the config file and
+// those given with --env, which take precedence over the file
+func (c *Config) applyEnv() error {
+	if err := interpolateEnv(c.Env); err != nil {
+		return err
+	}
+	if err := interpolateEnv(c.flagEnv); err != nil {
+		return err
+	}
+	c.inheritEnv(nil)
+	return nil
+}
+
+// inheritEnv adds the environment variables of the configuration an app or
+// service belongs to, unless the app or service sets them itself. Variables
+// given with --env are applied last, so they override both.
+func (c *Config) inheritEnv(parent map[string]string) {
+	if len(parent) == 0 && len(c.flagEnv) == 0 {
+		return
+	}
+	if c.Env == nil {
+		c.Env = make(map[string]string)
+	}
+	for key, value := range parent {
+		if _, set := c.Env[key]; !set {
+			c.Env[key] = value
+		}
+	}
+	for key, value := range c.flagEnv {
+		c.Env[key] = value
+	}
+}
+
+// interpolateEnv interpolates environment variables in place
+func interpolateEnv(env map[string]string) error {
+	keys := make([]string, 0, len(env))
+	for key := range env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value, err := Interpolate(env[key])
+		if err != nil {
+			return fmt.Errorf("invalid environment variable %s: %v", key, err)
+		}
+		env[key] = value
+	}
+	return nil
+}
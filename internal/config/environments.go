@@ -0,0 +1,85 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Environments are named sets of settings, such as staging and production,
+// chosen with --environment <name>. Their settings use the same names as the config
+// file and override the rest of it, which holds what the environments share.
+type Environments map[string]json.RawMessage
+
+// EnvironmentNames returns the names of the environments in the config
+// file, sorted
+func (c *Config) EnvironmentNames() []string {
+	names := make([]string, 0, len(c.Environments))
+	for name := range c.Environments {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// environmentName returns the environment given with --environment <name>
+// or PIPE_ENV
+func environmentName(args []string) string {
+	if name := flagValue(args, "environment"); name != "" {
+		return name
+	}
+	return os.Getenv("PIPE_ENV")
+}
+
+// environment applies the settings of a named environment on top of the
+// configuration. Hosts set by the environment replace the shared ones.
+func (c *Config) environment(name string) (Config, error) {
+	settings, ok := c.Environments[name]
+	if !ok {
+		if len(c.Environments) == 0 {
+			return Config{}, fmt.Errorf("environment %s was chosen, but the config file defines no environments", name)
+		}
+		return Config{}, fmt.Errorf("environment %s is not defined in the config file, the environments are: %s",
+			name, strings.Join(c.EnvironmentNames(), ", "))
+	}
+
+	config := *c
+	config.Environments = nil
+	config.Apps, config.Templates = nil, nil
+	config.BuildArgs = maps.Clone(c.BuildArgs)
+	config.Env = maps.Clone(c.Env)
+	config.Runtime.Labels = maps.Clone(c.Runtime.Labels)
+	config.Host = ""
+	config.Hosts = nil
+
+	if err := decodeSettings(settings, &config); err != nil {
+		return Config{}, fmt.Errorf("invalid settings for environment %s: %v", name, err)
+	}
+	if config.Environments != nil {
+		return Config{}, fmt.Errorf("invalid settings for environment %s: environments cannot be nested", name)
+	}
+	config.Apps, config.Templates = c.Apps, c.Templates
+	if err := config.interpolate(c); err != nil {
+		return Config{}, fmt.Errorf("environment %s: %v", name, err)
+	}
+
+	switch {
+	case len(config.Hosts) > 0:
+	case config.Host != "":
+		config.Hosts = []string{config.Host}
+	default:
+		config.Host, config.Hosts = c.Host, c.Hosts
+	}
+	if len(config.Hosts) > 0 {
+		config.Host = config.Hosts[0]
+	}
+
+	if config.PrebuiltImage != "" {
+		config.Tag = referenceTag(config.PrebuiltImage)
+	}
+
+	return config, nil
+}
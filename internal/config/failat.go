@@ -0,0 +1,28 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// FailAtSteps are the steps of a deployment that --fail-at can fail, in
+// pipeline order
+var FailAtSteps = []string{"preBuild", "build", "push", "transfer", "migrate", "preDeploy", "healthcheck", "postDeploy"}
+
+// InjectFailure returns an error when --fail-at chose step, so teams can
+// rehearse the failure handling of a deployment without breaking a build
+func (c *Config) InjectFailure(step string) error {
+	if c.FailAt != step {
+		return nil
+	}
+	return fmt.Errorf("injected failure at %s (--fail-at)", step)
+}
+
+// validateFailAt checks that --fail-at names a known step
+func (c *Config) validateFailAt() error {
+	if c.FailAt != "" && !slices.Contains(FailAtSteps, c.FailAt) {
+		return fmt.Errorf("invalid --fail-at %q: expected one of %s", c.FailAt, strings.Join(FailAtSteps, ", "))
+	}
+	return nil
+}
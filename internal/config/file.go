@@ -0,0 +1,108 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+)
+
+// defaultConfigFile is loaded when it exists and no config file is given
+const defaultConfigFile = "pipe.json"
+
+// Defaults returns the configuration used when nothing else is set
+func Defaults() Config {
+	return Config{
+		Image:          "app",
+		Dockerfile:     "Dockerfile",
+		Tag:            "latest",
+		Platform:       "linux/amd64",
+		ContainerName:  "app",
+		ContainerPort:  "3000",
+		HostPort:       "3000",
+		Strategy:       StrategyRecreate,
+		CanaryWeight:   10,
+		CanaryBake:     "5m",
+		MaxUnavailable: 1,
+		SSHPort:        "22",
+		HostKeyCheck:   HostKeyStrict,
+		HealthTimeout:  "60s",
+		HealthRetries:  12,
+		KeepReleases:   5,
+		LockTimeout:    "5m",
+		BuildParallel:  4,
+		Compress:       CompressGzip,
+		Retries:        3,
+		RetryDelay:     "2s",
+		LogFile:        "deploy.log",
+		LogMaxSize:     "10m",
+		LogMaxAge:      "720h",
+		BuildArgs:      make(map[string]string),
+	}
+}
+
+// configPath returns the config file given with --config or PIPE_CONFIG, or
+// the default config file if it exists
+func configPath(args []string) string {
+	if path := flagValue(args, "config"); path != "" {
+		return path
+	}
+
+	if path := os.Getenv("PIPE_CONFIG"); path != "" {
+		return path
+	}
+
+	if _, err := os.Stat(defaultConfigFile); err == nil {
+		return defaultConfigFile
+	}
+
+	return ""
+}
+
+// flagValue returns the value of a flag in the arguments before they are
+// parsed, for the flags deciding how the configuration is loaded
+func flagValue(args []string, flag string) string {
+	for i, arg := range args {
+		name := strings.TrimLeft(arg, "-")
+		if name == arg {
+			continue
+		}
+		if name == flag && i+1 < len(args) {
+			return args[i+1]
+		}
+		if strings.HasPrefix(name, flag+"=") {
+			return strings.TrimPrefix(name, flag+"=")
+		}
+	}
+	return ""
+}
+
+// loadFile reads a JSON config file on top of config, rejecting unknown keys
+func loadFile(path string, config *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(config)
+}
+
+// WriteFile writes config as a JSON config file, refusing to overwrite an
+// existing file
+func WriteFile(path string, config Config) error {
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.Write(append(data, '\n'))
+	return err
+}
@@ -0,0 +1,49 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// Docker's defaults for the health command of a container
+const (
+	defaultHealthInterval = 30 * time.Second
+	defaultHealthRetries  = 3
+)
+
+// minHealthStatusWait is the shortest time a new container gets to report
+// that it is healthy
+const minHealthStatusWait = 60 * time.Second
+
+// HealthStatusWait returns how long to wait for docker to report the
+// container healthy: its start period and enough health commands to pass
+// after a failing one, but at least a minute for health checks built into
+// the image
+func (c *Config) HealthStatusWait() time.Duration {
+	interval, err := time.ParseDuration(c.HealthInterval)
+	if err != nil || c.HealthInterval == "" {
+		interval = defaultHealthInterval
+	}
+	startPeriod, _ := time.ParseDuration(c.HealthStartPeriod)
+	retries := c.HealthCmdRetries
+	if retries == 0 {
+		retries = defaultHealthRetries
+	}
+	return max(startPeriod+interval*time.Duration(retries+1), minHealthStatusWait)
+}
+
+// validateHealthCmd checks the timings of the health command
+func (c *Config) validateHealthCmd() error {
+	for name, value := range map[string]string{"health interval": c.HealthInterval, "health start period": c.HealthStartPeriod} {
+		if value == "" {
+			continue
+		}
+		if duration, err := time.ParseDuration(value); err != nil || duration <= 0 {
+			return fmt.Errorf("invalid %s %q: expected a duration such as 30s", name, value)
+		}
+	}
+	if c.HealthCmdRetries < 0 {
+		return fmt.Errorf("invalid health command retries %d: expected 1 or more", c.HealthCmdRetries)
+	}
+	return nil
+}
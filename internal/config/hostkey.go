@@ -0,0 +1,58 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Host key verification modes
+const (
+	HostKeyStrict    = "strict"
+	HostKeyAcceptNew = "accept-new"
+)
+
+// KnownHostsFile returns the known_hosts file host keys are verified
+// against, ~/.ssh/known_hosts unless one is configured
+func (c *Config) KnownHostsFile() (string, error) {
+	if c.KnownHosts != "" {
+		return c.KnownHosts, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate home directory: %v", err)
+	}
+	return filepath.Join(home, ".ssh", "known_hosts"), nil
+}
+
+// validateSSH checks the SSH port, authentication, host key, Docker user and
+// Docker command options
+func (c *Config) validateSSH() error {
+	if port, err := strconv.Atoi(c.SSHPort); err != nil || port < 1 || port > 65535 {
+		return fmt.Errorf("invalid SSH port %q: expected a number between 1 and 65535", c.SSHPort)
+	}
+	if c.HostKeyCheck != HostKeyStrict && c.HostKeyCheck != HostKeyAcceptNew {
+		return fmt.Errorf("invalid host key check %q: expected %q or %q", c.HostKeyCheck, HostKeyStrict, HostKeyAcceptNew)
+	}
+	if c.SSHAgent && c.SSHKey != "" {
+		return fmt.Errorf("--ssh-agent and --ssh-key cannot be used together")
+	}
+	if c.DockerUser != "" && strings.ContainsAny(c.DockerUser, " \t\n'\"") {
+		return fmt.Errorf("invalid Docker user %q: expected a user name", c.DockerUser)
+	}
+	if c.DockerUser != "" && c.Target == TargetLocalDocker {
+		return fmt.Errorf("--docker-user cannot be used with --target %s", TargetLocalDocker)
+	}
+	if c.DockerCommand != "" && (strings.TrimSpace(c.DockerCommand) == "" || strings.ContainsAny(c.DockerCommand, "\n;&|")) {
+		return fmt.Errorf("invalid Docker command %q: expected a command such as 'sudo docker' or 'podman'", c.DockerCommand)
+	}
+	if c.DockerCommand != "" && c.DockerUser != "" {
+		return fmt.Errorf("--docker-cmd and --docker-user cannot be used together, use --docker-cmd 'sudo -u %s docker'", c.DockerUser)
+	}
+	if c.DockerCommand != "" && c.Target == TargetLocalDocker {
+		return fmt.Errorf("--docker-cmd cannot be used with --target %s", TargetLocalDocker)
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// valueTemplate matches ${NAME}, ${NAME:-default} and $(command) in the
+// config values that are interpolated, or $$ for a literal $
+var valueTemplate = regexp.MustCompile(`\$\$|\$\{([A-Za-z_][A-Za-z0-9_]*)(?::-([^}]*))?\}|\$\(([^)]+)\)`)
+
+var (
+	// commandOutputs holds the output of every $(command) run so far, so a
+	// command used by several values, apps or services runs once
+	commandOutputs = make(map[string]string)
+
+	// resolvedValues describes the values filled in since they were last
+	// returned by ResolvedValues
+	resolvedValues []string
+)
+
+// ResolvedValues returns the config values filled in by interpolation since
+// the last call, such as "tag ${GIT_SHA:-latest} = 1a2b3c4", for logging
+func ResolvedValues() []string {
+	values := resolvedValues
+	resolvedValues = nil
+	return values
+}
+
+// interpolate fills in ${NAME}, ${NAME:-default} and $(command) in the tag,
+// the build arguments and the labels. The values an app or service inherits
+// unchanged from parent were filled in already and are left as they are.
+func (c *Config) interpolate(parent *Config) error {
+	if parent == nil || c.Tag != parent.Tag {
+		tag, err := interpolateValue("tag", c.Tag)
+		if err != nil {
+			return err
+		}
+		c.Tag = tag
+	}
+
+	var inheritedArgs, inheritedLabels map[string]string
+	if parent != nil {
+		inheritedArgs, inheritedLabels = parent.BuildArgs, parent.Runtime.Labels
+	}
+	buildArgs, err := interpolateValues("build argument", c.BuildArgs, inheritedArgs)
+	if err != nil {
+		return err
+	}
+	labels, err := interpolateValues("label", c.Runtime.Labels, inheritedLabels)
+	if err != nil {
+		return err
+	}
+	c.BuildArgs, c.Runtime.Labels = buildArgs, labels
+	return nil
+}
+
+// interpolateValues returns a copy of values with every value filled in,
+// except those equal to the inherited value
+func interpolateValues(kind string, values map[string]string, inherited map[string]string) (map[string]string, error) {
+	if values == nil {
+		return nil, nil
+	}
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	filled := make(map[string]string, len(values))
+	for _, key := range keys {
+		value := values[key]
+		if previous, ok := inherited[key]; ok && previous == value {
+			filled[key] = value
+			continue
+		}
+		value, err := interpolateValue(kind+" "+key, value)
+		if err != nil {
+			return nil, err
+		}
+		filled[key] = value
+	}
+	return filled, nil
+}
+
+// interpolateValue fills in a single value. Unset variables without a
+// default are an error, so a typo does not silently build with an empty
+// value, and so is a failing command.
+func interpolateValue(name string, value string) (string, error) {
+	if !strings.Contains(value, "$") {
+		return value, nil
+	}
+
+	var failure error
+	filled := valueTemplate.ReplaceAllStringFunc(value, func(match string) string {
+		if match == "$$" || failure != nil {
+			return "$"
+		}
+
+		parts := valueTemplate.FindStringSubmatch(match)
+		if command := parts[3]; command != "" {
+			output, err := commandOutput(command)
+			if err != nil {
+				failure = err
+			}
+			return output
+		}
+
+		variable, _ := os.LookupEnv(parts[1])
+		switch {
+		case variable != "":
+		case strings.Contains(match, ":-"):
+			variable = parts[2]
+		default:
+			if _, set := os.LookupEnv(parts[1]); !set {
+				failure = fmt.Errorf("%s not set in the local environment", parts[1])
+			}
+		}
+		return variable
+	})
+	if failure != nil {
+		return "", fmt.Errorf("invalid %s: %v", name, failure)
+	}
+
+	if filled != value {
+		resolvedValues = append(resolvedValues, fmt.Sprintf("%s %s = %s", name, value, filled))
+	}
+	return filled, nil
+}
+
+// commandOutput runs a $(command) with the local shell in the current
+// directory and returns its output without the trailing newline
+func commandOutput(command string) (string, error) {
+	if output, ok := commandOutputs[command]; ok {
+		return output, nil
+	}
+
+	var stderr bytes.Buffer
+	shell := LocalShell(command)
+	cmd := exec.Command(shell[0], shell[1:]...)
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("$(%s) failed: %v: %s", command, err, strings.TrimSpace(stderr.String()))
+	}
+
+	commandOutputs[command] = strings.TrimRight(string(output), "\n")
+	return commandOutputs[command], nil
+}
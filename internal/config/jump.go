@@ -0,0 +1,16 @@
+package config
+
+import "strings"
+
+// Jump returns the user and the host[:port] of the jump host. The user
+// defaults to the SSH user of the app.
+func (c *Config) Jump() (string, string) {
+	if c.JumpHost == "" {
+		return "", ""
+	}
+	user, host, found := strings.Cut(c.JumpHost, "@")
+	if !found {
+		return c.User, c.JumpHost
+	}
+	return user, host
+}
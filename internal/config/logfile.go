@@ -0,0 +1,36 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// noLogFile disables the log file
+const noLogFile = "none"
+
+// LogFilename returns the file the log is written to, or an empty string when
+// the log file is disabled
+func (c *Config) LogFilename() string {
+	if c.LogFile == noLogFile {
+		return ""
+	}
+	return c.LogFile
+}
+
+// LogRotation returns the size at which the log file is rotated and how long
+// rotated files are kept. Zero disables either limit.
+func (c *Config) LogRotation() (int64, time.Duration, error) {
+	maxSize, err := ParseMemory(c.LogMaxSize)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid log max size %q: %v", c.LogMaxSize, err)
+	}
+
+	var maxAge time.Duration
+	if c.LogMaxAge != "" && c.LogMaxAge != "0" {
+		maxAge, err = time.ParseDuration(c.LogMaxAge)
+		if err != nil || maxAge < 0 {
+			return 0, 0, fmt.Errorf("invalid log max age %q: expected a duration such as 720h, or 0", c.LogMaxAge)
+		}
+	}
+	return maxSize, maxAge, nil
+}
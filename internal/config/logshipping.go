@@ -0,0 +1,62 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// LogShipping configures the sidecar that ships the container logs to a
+// central Loki or Elasticsearch endpoint
+type LogShipping struct {
+	Type     string            `json:"type,omitempty"`
+	URL      string            `json:"url,omitempty"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	User     string            `json:"user,omitempty"`
+	Password string            `json:"password,omitempty"`
+	Image    string            `json:"image,omitempty"`
+}
+
+// Log shipping endpoint types
+const (
+	LogsLoki          = "loki"
+	LogsElasticsearch = "elasticsearch"
+)
+
+// defaultLogShipperImage is the Vector image shipping the logs
+const defaultLogShipperImage = "timberio/vector:0.39.0-alpine"
+
+// Enabled reports whether log shipping is configured
+func (l LogShipping) Enabled() bool {
+	return l.URL != ""
+}
+
+// ShipperImage returns the image of the log shipping sidecar
+func (l LogShipping) ShipperImage() string {
+	if l.Image == "" {
+		return defaultLogShipperImage
+	}
+	return l.Image
+}
+
+// validate checks the log shipping settings
+func (l LogShipping) validate() error {
+	if !l.Enabled() {
+		if l.Type != "" {
+			return fmt.Errorf("invalid log shipping: url is required")
+		}
+		return nil
+	}
+
+	switch l.Type {
+	case LogsLoki, LogsElasticsearch:
+	default:
+		return fmt.Errorf("invalid log shipping type %q: expected %q or %q", l.Type, LogsLoki, LogsElasticsearch)
+	}
+	if parsed, err := url.Parse(l.URL); err != nil || parsed.Host == "" {
+		return fmt.Errorf("invalid log shipping url %q: expected an http(s) endpoint", l.URL)
+	}
+	if l.Password != "" && l.User == "" {
+		return fmt.Errorf("invalid log shipping: a password needs a user")
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Metrics configures the node-exporter and cAdvisor containers pipe runs on
+// every host to expose host and container metrics to Prometheus
+type Metrics struct {
+	Enabled           bool   `json:"enabled,omitempty"`
+	NodeExporterPort  string `json:"nodeExporterPort,omitempty"`
+	CadvisorPort      string `json:"cadvisorPort,omitempty"`
+	NodeExporterImage string `json:"nodeExporterImage,omitempty"`
+	CadvisorImage     string `json:"cadvisorImage,omitempty"`
+}
+
+// Metrics defaults
+const (
+	defaultNodeExporterPort  = "9100"
+	defaultCadvisorPort      = "8080"
+	defaultNodeExporterImage = "quay.io/prometheus/node-exporter:v1.8.1"
+	defaultCadvisorImage     = "gcr.io/cadvisor/cadvisor:v0.49.1"
+)
+
+// Ports returns the ports node-exporter and cAdvisor listen on
+func (m Metrics) Ports() (nodeExporter string, cadvisor string) {
+	nodeExporter, cadvisor = m.NodeExporterPort, m.CadvisorPort
+	if nodeExporter == "" {
+		nodeExporter = defaultNodeExporterPort
+	}
+	if cadvisor == "" {
+		cadvisor = defaultCadvisorPort
+	}
+	return nodeExporter, cadvisor
+}
+
+// Images returns the node-exporter and cAdvisor images
+func (m Metrics) Images() (nodeExporter string, cadvisor string) {
+	nodeExporter, cadvisor = m.NodeExporterImage, m.CadvisorImage
+	if nodeExporter == "" {
+		nodeExporter = defaultNodeExporterImage
+	}
+	if cadvisor == "" {
+		cadvisor = defaultCadvisorImage
+	}
+	return nodeExporter, cadvisor
+}
+
+// validate checks the metrics ports, which must not clash with each other or
+// with the app
+func (m Metrics) validate(hostPort string) error {
+	if !m.Enabled {
+		return nil
+	}
+
+	nodeExporter, cadvisor := m.Ports()
+	for name, port := range map[string]string{"node exporter": nodeExporter, "cadvisor": cadvisor} {
+		if number, err := strconv.Atoi(port); err != nil || number < 1 || number > 65535 {
+			return fmt.Errorf("invalid metrics %s port %q: expected a port number", name, port)
+		}
+		if port == hostPort {
+			return fmt.Errorf("metrics %s port %s is the host port of the app", name, port)
+		}
+	}
+	if nodeExporter == cadvisor {
+		return fmt.Errorf("metrics node exporter and cadvisor ports are both %s", cadvisor)
+	}
+	return nil
+}
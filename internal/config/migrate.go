@@ -0,0 +1,34 @@
+package config
+
+import "fmt"
+
+// Migrate configures the database migration run before the container is
+// replaced. The command runs to completion in a one-off container from the
+// deployed image, or from a dedicated image, with the environment, volumes
+// and network of the app.
+type Migrate struct {
+	Command []string `json:"command,omitempty"`
+	Image   string   `json:"image,omitempty"`
+}
+
+// Enabled reports whether a migration is configured
+func (m Migrate) Enabled() bool {
+	return len(m.Command) > 0
+}
+
+// ImageRef returns the image the migration runs in, the deployed image
+// unless a dedicated one is configured
+func (m Migrate) ImageRef(deployed string) string {
+	if m.Image != "" {
+		return m.Image
+	}
+	return deployed
+}
+
+// validate checks the migration settings
+func (m Migrate) validate() error {
+	if m.Image != "" && !m.Enabled() {
+		return fmt.Errorf("a migration image needs a migration command")
+	}
+	return nil
+}
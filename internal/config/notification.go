@@ -0,0 +1,90 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"text/template"
+)
+
+// Notification is a Slack, Discord or generic webhook told when a deployment
+// or rollback starts, succeeds or fails. The type is detected from the URL
+// unless set. A template replaces the payload, to record deployments in
+// systems such as a CMDB or release tracker.
+type Notification struct {
+	URL      string            `json:"url"`
+	Type     string            `json:"type,omitempty"`
+	On       []string          `json:"on,omitempty"`
+	Method   string            `json:"method,omitempty"`
+	Headers  map[string]string `json:"headers,omitempty"`
+	Template string            `json:"template,omitempty"`
+}
+
+// Notification types
+const (
+	NotifySlack   = "slack"
+	NotifyDiscord = "discord"
+	NotifyWebhook = "webhook"
+)
+
+// NotifyStatuses are the statuses a notification can be sent for
+var NotifyStatuses = []string{"started", "succeeded", "failed"}
+
+// templateFuncs are the functions available in notification templates
+var templateFuncs = template.FuncMap{
+	"json": func(value any) (string, error) {
+		data, err := json.Marshal(value)
+		return string(data), err
+	},
+}
+
+// Wants reports whether the notification is sent for the status
+func (n Notification) Wants(status string) bool {
+	return len(n.On) == 0 || slices.Contains(n.On, status)
+}
+
+// RequestMethod returns the HTTP method of the notification
+func (n Notification) RequestMethod() string {
+	if n.Method == "" {
+		return http.MethodPost
+	}
+	return n.Method
+}
+
+// ParseTemplate parses the payload template of the notification, or returns
+// nil when the notification has none
+func (n Notification) ParseTemplate() (*template.Template, error) {
+	if n.Template == "" {
+		return nil, nil
+	}
+	tmpl, err := template.New("payload").Funcs(templateFuncs).Option("missingkey=error").Parse(n.Template)
+	if err != nil {
+		return nil, fmt.Errorf("invalid notification template: %v", err)
+	}
+	return tmpl, nil
+}
+
+// validate checks the notification settings
+func (n Notification) validate() error {
+	if n.URL == "" {
+		return fmt.Errorf("invalid notification: url is required")
+	}
+	switch n.Type {
+	case "", NotifySlack, NotifyDiscord, NotifyWebhook:
+	default:
+		return fmt.Errorf("invalid notification type %q: expected %q, %q or %q", n.Type, NotifySlack, NotifyDiscord, NotifyWebhook)
+	}
+	for _, status := range n.On {
+		if !slices.Contains(NotifyStatuses, status) {
+			return fmt.Errorf("invalid notification status %q: expected %q, %q or %q", status, NotifyStatuses[0], NotifyStatuses[1], NotifyStatuses[2])
+		}
+	}
+	switch n.RequestMethod() {
+	case http.MethodPost, http.MethodPut, http.MethodPatch:
+	default:
+		return fmt.Errorf("invalid notification method %q: expected POST, PUT or PATCH", n.Method)
+	}
+	_, err := n.ParseTemplate()
+	return err
+}
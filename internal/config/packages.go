@@ -0,0 +1,21 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// packageName matches the name of an apt or dnf package, optionally with a
+// version or architecture
+var packageName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9.+_:=~-]*$`)
+
+// validateSystemPackages checks the names of the system packages, which end
+// up in a shell command on the hosts
+func (c *Config) validateSystemPackages() error {
+	for _, name := range c.SystemPackages {
+		if !packageName.MatchString(name) {
+			return fmt.Errorf("invalid system package %q", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// PlatformAuto builds for the platforms of the hosts, detected on each host
+const PlatformAuto = "auto"
+
+// platformFormat matches a docker platform such as linux/amd64 or linux/arm/v7
+var platformFormat = regexp.MustCompile(`^[a-z0-9]+/[a-z0-9_]+(/[a-z0-9]+)?$`)
+
+// machinePlatforms maps the machine names printed by `uname -m` to docker
+// platforms
+var machinePlatforms = map[string]string{
+	"x86_64":  "linux/amd64",
+	"amd64":   "linux/amd64",
+	"aarch64": "linux/arm64",
+	"arm64":   "linux/arm64",
+	"armv7l":  "linux/arm/v7",
+	"armv6l":  "linux/arm/v6",
+	"i686":    "linux/386",
+	"i386":    "linux/386",
+	"ppc64le": "linux/ppc64le",
+	"s390x":   "linux/s390x",
+	"riscv64": "linux/riscv64",
+}
+
+// Platforms returns the platforms the image is built for
+func (c *Config) Platforms() []string {
+	var platforms []string
+	for _, platform := range strings.Split(c.Platform, ",") {
+		if platform = strings.TrimSpace(platform); platform != "" {
+			platforms = append(platforms, platform)
+		}
+	}
+	return platforms
+}
+
+// MultiPlatform reports whether the image is built for more than one platform
+func (c *Config) MultiPlatform() bool {
+	return len(c.Platforms()) > 1
+}
+
+// PlatformRef returns the local reference of the image built for a single
+// platform of a multi-platform build, such as app:latest-arm64
+func (c *Config) PlatformRef(platform string) string {
+	_, arch, _ := strings.Cut(platform, "/")
+	return fmt.Sprintf("%s:%s-%s", c.Repository(), c.Tag, strings.ReplaceAll(arch, "/", ""))
+}
+
+// MachinePlatform returns the docker platform of a host from the machine
+// name printed by `uname -m`
+func MachinePlatform(machine string) (string, error) {
+	platform, ok := machinePlatforms[strings.TrimSpace(machine)]
+	if !ok {
+		return "", fmt.Errorf("unsupported machine %q, set --platform", strings.TrimSpace(machine))
+	}
+	return platform, nil
+}
+
+// validatePlatform checks that the platform is auto or a list of docker
+// platforms
+func (c *Config) validatePlatform() error {
+	if c.Platform == PlatformAuto {
+		return nil
+	}
+	platforms := c.Platforms()
+	if len(platforms) == 0 {
+		return fmt.Errorf("missing platform: set --platform (e.g. linux/amd64)")
+	}
+	for _, platform := range platforms {
+		if !platformFormat.MatchString(platform) {
+			return fmt.Errorf("invalid platform %q: expected os/arch (e.g. linux/arm64), a comma-separated list or %q", platform, PlatformAuto)
+		}
+	}
+	if len(platforms) > 1 && c.SkipBuild {
+		return fmt.Errorf("--skip-build cannot be used with multiple platforms")
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package config
+
+import "fmt"
+
+// Reverse proxies pipe can run on the hosts
+const (
+	ProxyTraefik = "traefik"
+	ProxyCaddy   = "caddy"
+)
+
+// Proxy configures the reverse proxy pipe runs on every host to route the
+// domains of the apps to their containers, with certificates from Let's
+// Encrypt
+type Proxy struct {
+	Type  string `json:"type,omitempty"`
+	Email string `json:"email,omitempty"`
+	Image string `json:"image,omitempty"`
+}
+
+// Proxy image defaults
+const (
+	defaultTraefikImage = "traefik:v3.1"
+	defaultCaddyImage   = "lucaslorentz/caddy-docker-proxy:2.9"
+)
+
+// Enabled reports whether a reverse proxy is configured
+func (p Proxy) Enabled() bool {
+	return p.Type != ""
+}
+
+// ImageRef returns the image of the reverse proxy
+func (p Proxy) ImageRef() string {
+	switch {
+	case p.Image != "":
+		return p.Image
+	case p.Type == ProxyCaddy:
+		return defaultCaddyImage
+	default:
+		return defaultTraefikImage
+	}
+}
+
+// validateProxy checks the proxy type. The proxy listens on ports 80 and 443
+// of the host, so the app cannot use them.
+func (c *Config) validateProxy() error {
+	if !c.Proxy.Enabled() {
+		return nil
+	}
+	if c.Proxy.Type != ProxyTraefik && c.Proxy.Type != ProxyCaddy {
+		return fmt.Errorf("invalid proxy type %q: expected %q or %q", c.Proxy.Type, ProxyTraefik, ProxyCaddy)
+	}
+	if c.HostPort == "80" || c.HostPort == "443" {
+		return fmt.Errorf("host port %s is used by the %s proxy, publish the app on another port", c.HostPort, c.Proxy.Type)
+	}
+	return nil
+}
@@ -0,0 +1,23 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Quarantine configures keeping the evidence of a container that failed its
+// verification, before it is replaced or the previous version restored
+type Quarantine struct {
+	Enabled bool     `json:"enabled,omitempty"`
+	Paths   []string `json:"paths,omitempty"`
+}
+
+// validate checks the quarantine settings
+func (q Quarantine) validate() error {
+	for _, path := range q.Paths {
+		if !strings.HasPrefix(path, "/") {
+			return fmt.Errorf("invalid quarantine path %q: expected an absolute path in the container", path)
+		}
+	}
+	return nil
+}
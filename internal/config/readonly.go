@@ -0,0 +1,44 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+)
+
+// readOnlyCommands are the commands that only inspect the hosts, with the
+// actions they allow. Commands without actions are allowed as a whole.
+var readOnlyCommands = map[string][]string{
+	"plan":      nil,
+	"releases":  nil,
+	"history":   nil,
+	"compare":   nil,
+	"logs":      nil,
+	"status":    nil,
+	"list":      nil,
+	"discover":  nil,
+	"doctor":    nil,
+	"validate":  nil,
+	"accessory": {"logs"},
+	"agent":     {"status"},
+	"metrics":   {"targets"},
+	"jobs":      {"history", "logs", "scheduled"},
+}
+
+// CheckReadOnly returns an error when the command could change the hosts
+// while pipe runs in read-only mode
+func (c *Config) CheckReadOnly() error {
+	if !c.ReadOnly {
+		return nil
+	}
+
+	actions, allowed := readOnlyCommands[c.Command]
+	switch {
+	case !allowed:
+		return fmt.Errorf("%s is not allowed in read-only mode", c.Command)
+	case c.Command == "doctor" && c.Fix:
+		return fmt.Errorf("doctor --fix is not allowed in read-only mode")
+	case actions != nil && (len(c.Args) == 0 || !slices.Contains(actions, c.Args[0])):
+		return fmt.Errorf("%s is not allowed in read-only mode, only %s %s", c.Command, c.Command, actions[0])
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Host ports of replicas
+const (
+	ReplicaPortsSequential = "sequential"
+	ReplicaPortsDynamic    = "dynamic"
+)
+
+// Replicated reports whether several copies of the container run on every
+// host, named <container>-1 to <container>-N
+func (c *Config) Replicated() bool {
+	return c.Replicas > 1
+}
+
+// ReplicaName returns the container name of a replica, counting from 1
+func (c *Config) ReplicaName(replica int) string {
+	return fmt.Sprintf("%s-%d", c.ContainerName, replica)
+}
+
+// Containers returns the names of the containers of the app on a host: its
+// replicas, or the container itself
+func (c *Config) Containers() []string {
+	if !c.Replicated() {
+		return []string{c.ContainerName}
+	}
+	names := make([]string, c.Replicas)
+	for i := range names {
+		names[i] = c.ReplicaName(i + 1)
+	}
+	return names
+}
+
+// ReplicaPort returns the host port of a replica, counting from 1: the host
+// port plus the replica number minus one, or an empty string for ports
+// docker picks
+func (c *Config) ReplicaPort(replica int) string {
+	if c.ReplicaPorts == ReplicaPortsDynamic {
+		return ""
+	}
+	port, _ := strconv.Atoi(c.HostPort)
+	return strconv.Itoa(port + replica - 1)
+}
+
+// validateReplicas checks the replica settings
+func (c *Config) validateReplicas() error {
+	if c.Replicas < 0 {
+		return fmt.Errorf("invalid replicas %d: expected a positive number", c.Replicas)
+	}
+	switch c.ReplicaPorts {
+	case "", ReplicaPortsSequential, ReplicaPortsDynamic:
+	default:
+		return fmt.Errorf("invalid replica ports %q: expected %q or %q", c.ReplicaPorts, ReplicaPortsSequential, ReplicaPortsDynamic)
+	}
+	if !c.Replicated() {
+		return nil
+	}
+
+	switch {
+	case c.Strategy != StrategyRecreate:
+		return fmt.Errorf("--replicas restarts the replicas one at a time and cannot be combined with --strategy %s", c.Strategy)
+	case c.Agent.Enabled:
+		return fmt.Errorf("--replicas cannot be combined with the agent, which watches a single container")
+	case c.ReplicaPorts == ReplicaPortsDynamic && c.Proxy.Type == ProxyCaddy:
+		return fmt.Errorf("--replica-ports %s needs the traefik proxy, Caddy reaches the replicas on their host ports", ReplicaPortsDynamic)
+	}
+	if c.ReplicaPorts != ReplicaPortsDynamic {
+		port, err := strconv.Atoi(c.HostPort)
+		if err != nil {
+			return fmt.Errorf("--replicas numbers the host ports of the replicas from the host port, which must be a number, or use --replica-ports %s", ReplicaPortsDynamic)
+		}
+		if port+c.Replicas-1 > 65535 {
+			return fmt.Errorf("--replicas %d needs the host ports %d to %d, which run past 65535", c.Replicas, port, port+c.Replicas-1)
+		}
+	}
+	return nil
+}
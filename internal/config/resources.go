@@ -0,0 +1,136 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// memoryFormat matches docker memory sizes such as 512m or 2g
+var memoryFormat = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?[bkmg]?$`)
+
+// gpusFormat matches the docker --gpus values all, a GPU count, or options
+// such as device=0 or "device=0,1"
+var gpusFormat = regexp.MustCompile(`^(all|[0-9]+|"?[a-z]+=[^\s]+)$`)
+
+// CPUsetMax returns the highest CPU number in a cpuset such as 0-3,6. An
+// empty cpuset is -1.
+func CPUsetMax(cpuset string) (int, error) {
+	highest := -1
+	if cpuset == "" {
+		return highest, nil
+	}
+
+	for _, part := range strings.Split(cpuset, ",") {
+		first, last, isRange := strings.Cut(part, "-")
+		start, err := strconv.Atoi(first)
+		if err != nil || start < 0 {
+			return 0, fmt.Errorf("expected CPU numbers or ranges separated by commas (e.g. 0-3,6)")
+		}
+		end := start
+		if isRange {
+			end, err = strconv.Atoi(last)
+			if err != nil || end < start {
+				return 0, fmt.Errorf("range %q must be two ascending CPU numbers (e.g. 0-3)", part)
+			}
+		}
+		highest = max(highest, end)
+	}
+	return highest, nil
+}
+
+// ParseMemory converts a docker memory size such as 512m to bytes. An empty
+// size is zero.
+func ParseMemory(memory string) (int64, error) {
+	memory = strings.ToLower(strings.TrimSpace(memory))
+	if memory == "" {
+		return 0, nil
+	}
+	if !memoryFormat.MatchString(memory) {
+		return 0, fmt.Errorf("expected a number with an optional unit b, k, m or g (e.g. 512m)")
+	}
+
+	multiplier := int64(1)
+	switch memory[len(memory)-1] {
+	case 'k':
+		multiplier = 1024
+	case 'm':
+		multiplier = 1024 * 1024
+	case 'g':
+		multiplier = 1024 * 1024 * 1024
+	}
+	if multiplier > 1 || memory[len(memory)-1] == 'b' {
+		memory = memory[:len(memory)-1]
+	}
+
+	value, err := strconv.ParseFloat(memory, 64)
+	if err != nil {
+		return 0, err
+	}
+	return int64(value * float64(multiplier)), nil
+}
+
+// validateResources checks the format of the resource limits and reservations
+// and that they are consistent with each other
+func (c *Config) validateResources() error {
+	if c.CPUs != "" {
+		if cpus, err := strconv.ParseFloat(c.CPUs, 64); err != nil || cpus <= 0 {
+			return fmt.Errorf("invalid cpus %q: expected a positive number (e.g. 0.5 or 2)", c.CPUs)
+		}
+	}
+
+	memory, err := ParseMemory(c.Memory)
+	if err != nil {
+		return fmt.Errorf("invalid memory %q: %v", c.Memory, err)
+	}
+
+	reservation, err := ParseMemory(c.MemoryReservation)
+	if err != nil {
+		return fmt.Errorf("invalid memory reservation %q: %v", c.MemoryReservation, err)
+	}
+	if memory > 0 && reservation > memory {
+		return fmt.Errorf("memory reservation %s is larger than the memory limit %s", c.MemoryReservation, c.Memory)
+	}
+
+	if c.MemorySwap != "" {
+		if memory == 0 {
+			return fmt.Errorf("memory swap needs a memory limit, set --memory")
+		}
+		if c.MemorySwap != "-1" {
+			swap, err := ParseMemory(c.MemorySwap)
+			if err != nil {
+				return fmt.Errorf("invalid memory swap %q: %v, or -1 for unlimited swap", c.MemorySwap, err)
+			}
+			if swap < memory {
+				return fmt.Errorf("memory swap %s is smaller than the memory limit %s, it includes the memory", c.MemorySwap, c.Memory)
+			}
+		}
+	}
+
+	if c.PidsLimit < -1 {
+		return fmt.Errorf("invalid pids limit %d: expected a positive number, or -1 for unlimited", c.PidsLimit)
+	}
+
+	if c.CPUShares != 0 && (c.CPUShares < 2 || c.CPUShares > 262144) {
+		return fmt.Errorf("invalid cpu shares %d: expected a number between 2 and 262144", c.CPUShares)
+	}
+
+	if c.OOMKillDisable && memory == 0 {
+		return fmt.Errorf("disabling the OOM killer needs a memory limit, set --memory")
+	}
+
+	if _, err := CPUsetMax(c.CPUsetCPUs); err != nil {
+		return fmt.Errorf("invalid cpuset cpus %q: %v", c.CPUsetCPUs, err)
+	}
+
+	if strings.ContainsAny(c.CgroupParent, " \t\n") {
+		return fmt.Errorf("invalid cgroup parent %q: must not contain whitespace", c.CgroupParent)
+	}
+
+	if c.GPUs != "" && !gpusFormat.MatchString(c.GPUs) {
+		return fmt.Errorf("invalid gpus %q: expected all, a number of GPUs or options such as device=0", c.GPUs)
+	}
+
+	return nil
+}
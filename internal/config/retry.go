@@ -0,0 +1,22 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// defaultRetryDelay is the wait before the first retry when none is set
+const defaultRetryDelay = 2 * time.Second
+
+// RetryBackoff returns the wait before the first retry of a step that failed
+// on a network error
+func (c *Config) RetryBackoff() (time.Duration, error) {
+	if c.RetryDelay == "" {
+		return defaultRetryDelay, nil
+	}
+	delay, err := time.ParseDuration(c.RetryDelay)
+	if err != nil || delay < 0 {
+		return 0, fmt.Errorf("invalid retry delay %q: expected a duration such as 2s", c.RetryDelay)
+	}
+	return delay, nil
+}
@@ -0,0 +1,29 @@
+package config
+
+import "fmt"
+
+// RollingBatches splits the hosts into the batches of a rolling deployment,
+// at most the maximum number of unavailable hosts each, in order
+func (c *Config) RollingBatches() [][]string {
+	var batches [][]string
+	for hosts := c.Hosts; len(hosts) > 0; {
+		size := min(c.MaxUnavailable, len(hosts))
+		batches = append(batches, hosts[:size])
+		hosts = hosts[size:]
+	}
+	return batches
+}
+
+// validateRolling checks the rolling deployment settings
+func (c *Config) validateRolling() error {
+	if !c.Rolling {
+		return nil
+	}
+	if c.MaxUnavailable < 1 {
+		return fmt.Errorf("invalid max unavailable %d: expected at least one host", c.MaxUnavailable)
+	}
+	if c.Strategy == StrategyCanary {
+		return fmt.Errorf("--rolling deploys the hosts in batches and cannot be combined with --strategy %s", StrategyCanary)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ulimitFormat matches a docker ulimit such as nofile=1024:2048
+var ulimitFormat = regexp.MustCompile(`^[a-z]+=-?[0-9]+(:-?[0-9]+)?$`)
+
+// Runtime holds further docker run options of the app container, passed on
+// to docker as they are
+type Runtime struct {
+	Labels      map[string]string `json:"labels,omitempty"`
+	User        string            `json:"user,omitempty"`
+	Ulimits     []string          `json:"ulimits,omitempty"`
+	CapAdd      []string          `json:"capAdd,omitempty"`
+	CapDrop     []string          `json:"capDrop,omitempty"`
+	ReadOnly    bool              `json:"readOnly,omitempty"`
+	SecurityOpt []string          `json:"securityOpt,omitempty"`
+	LogDriver   string            `json:"logDriver,omitempty"`
+	LogOpts     map[string]string `json:"logOpts,omitempty"`
+	DNS         []string          `json:"dns,omitempty"`
+	ExtraHosts  []string          `json:"extraHosts,omitempty"`
+	Tmpfs       []string          `json:"tmpfs,omitempty"`
+	Devices     []string          `json:"devices,omitempty"`
+}
+
+// validate checks the format of the options docker would otherwise only
+// reject once the running container has been stopped
+func (r Runtime) validate() error {
+	for key := range r.Labels {
+		if key == "" || strings.HasPrefix(key, "pipe.") {
+			return fmt.Errorf("invalid runtime label %q: labels starting with pipe. are reserved", key)
+		}
+	}
+	if strings.ContainsAny(r.User, " \t\n") {
+		return fmt.Errorf("invalid runtime user %q: expected a user or uid[:gid]", r.User)
+	}
+	for _, ulimit := range r.Ulimits {
+		if !ulimitFormat.MatchString(ulimit) {
+			return fmt.Errorf("invalid ulimit %q: expected name=soft[:hard] (e.g. nofile=1024:2048)", ulimit)
+		}
+	}
+	for _, capability := range append(append([]string{}, r.CapAdd...), r.CapDrop...) {
+		if capability == "" || strings.ContainsAny(capability, " \t\n") {
+			return fmt.Errorf("invalid capability %q", capability)
+		}
+	}
+	for _, extraHost := range r.ExtraHosts {
+		if name, address, ok := strings.Cut(extraHost, ":"); !ok || name == "" || address == "" {
+			return fmt.Errorf("invalid extra host %q: expected name:address (e.g. db.internal:10.0.0.5)", extraHost)
+		}
+	}
+	for _, mount := range r.Tmpfs {
+		if !strings.HasPrefix(mount, "/") {
+			return fmt.Errorf("invalid tmpfs mount %q: expected an absolute path in the container (e.g. /tmp:size=64m)", mount)
+		}
+	}
+	for _, device := range r.Devices {
+		if !strings.HasPrefix(device, "/") {
+			return fmt.Errorf("invalid device %q: expected a device path on the host (e.g. /dev/fuse)", device)
+		}
+	}
+	return nil
+}
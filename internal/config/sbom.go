@@ -0,0 +1,80 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// SBOM formats written by syft
+const (
+	SBOMCycloneDX = "cyclonedx-json"
+	SBOMSPDX      = "spdx-json"
+)
+
+// SBOM configures generating a software bill of materials of the image with
+// syft. It is kept on every host next to the deployment history, so the
+// deployed versions containing a library can be found, and optionally
+// uploaded to an artifact endpoint. Header values may be secret references.
+type SBOM struct {
+	Enabled bool              `json:"enabled,omitempty"`
+	Format  string            `json:"format,omitempty"`
+	Upload  string            `json:"upload,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// OutputFormat returns the format of the SBOM, CycloneDX by default
+func (s SBOM) OutputFormat() string {
+	if s.Format == "" {
+		return SBOMCycloneDX
+	}
+	return s.Format
+}
+
+// Extension returns the file extension of SBOMs of the format
+func (s SBOM) Extension() string {
+	if s.OutputFormat() == SBOMSPDX {
+		return ".spdx.json"
+	}
+	return ".cdx.json"
+}
+
+// validate checks the SBOM settings
+func (s SBOM) validate() error {
+	switch s.Format {
+	case "", SBOMCycloneDX, SBOMSPDX:
+	default:
+		return fmt.Errorf("invalid SBOM format %q: expected %q or %q", s.Format, SBOMCycloneDX, SBOMSPDX)
+	}
+	if s.Upload == "" {
+		return nil
+	}
+	if !s.Enabled {
+		return fmt.Errorf("an SBOM upload endpoint needs the SBOM to be generated, set --sbom")
+	}
+	if u, err := url.Parse(s.Upload); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid SBOM upload endpoint %q: expected an http or https URL", s.Upload)
+	}
+	return nil
+}
+
+// SBOMDir returns the directory of the SBOMs of the deployed images on the
+// host
+func (c *Config) SBOMDir() string {
+	return c.StateDir() + "/sbom"
+}
+
+// SBOMFile returns the path of the SBOM of the image on the host, named
+// after its tag, or the digest of a pre-built image pinned by digest
+func (c *Config) SBOMFile() string {
+	tag := c.Tag
+	if ref := c.PrebuiltImage; ref != "" {
+		tag = "latest"
+		if i := strings.Index(ref, "@"); i >= 0 {
+			tag = ref[i+1:]
+		} else if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+			tag = ref[i+1:]
+		}
+	}
+	return c.SBOMDir() + "/" + strings.NewReplacer("/", "-", ":", "-", "@", "-").Replace(tag) + c.SBOM.Extension()
+}
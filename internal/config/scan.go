@@ -0,0 +1,56 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"slices"
+)
+
+// Vulnerability scanners
+const (
+	ScannerTrivy = "trivy"
+	ScannerGrype = "grype"
+)
+
+// ScanSeverities are the severities of vulnerabilities, from least to most
+// severe
+var ScanSeverities = []string{"low", "medium", "high", "critical"}
+
+// Scan configures scanning the image for vulnerabilities between the build
+// and the transfer, failing the deployment on findings of at least the
+// severity. The ignore file lists accepted vulnerability IDs, one per line.
+type Scan struct {
+	Scanner    string `json:"scanner,omitempty"`
+	Severity   string `json:"severity,omitempty"`
+	IgnoreFile string `json:"ignoreFile,omitempty"`
+}
+
+// Threshold returns the lowest severity failing the deployment, high by
+// default
+func (s Scan) Threshold() string {
+	if s.Severity == "" {
+		return "high"
+	}
+	return s.Severity
+}
+
+// validate checks the scan settings
+func (s Scan) validate() error {
+	switch s.Scanner {
+	case "", ScannerTrivy, ScannerGrype:
+	default:
+		return fmt.Errorf("invalid scanner %q: expected %q or %q", s.Scanner, ScannerTrivy, ScannerGrype)
+	}
+	if s.Severity != "" && !slices.Contains(ScanSeverities, s.Severity) {
+		return fmt.Errorf("invalid scan severity %q: expected low, medium, high or critical", s.Severity)
+	}
+	if s.IgnoreFile != "" {
+		if s.Scanner == "" {
+			return fmt.Errorf("a scan ignore file needs a scanner, set --scan trivy or grype")
+		}
+		if _, err := os.Stat(s.IgnoreFile); err != nil {
+			return fmt.Errorf("scan ignore file %s not found", s.IgnoreFile)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package config
+
+import (
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+)
+
+// windowsExecutables are the extensions of the files Windows can run as
+// hook executables
+var windowsExecutables = []string{".exe", ".cmd", ".bat", ".ps1"}
+
+// LocalShell returns the argument vector running a command line, such as a
+// local hook, with the shell of this machine: sh, or on Windows sh from Git
+// for Windows when it is on the PATH and PowerShell otherwise.
+// PIPE_LOCAL_SHELL picks another shell, such as bash, pwsh or cmd. Commands
+// run on the hosts always use their POSIX shell.
+func LocalShell(command string) []string {
+	shell := os.Getenv("PIPE_LOCAL_SHELL")
+	if shell == "" {
+		shell = defaultShell()
+	}
+
+	switch strings.ToLower(strings.TrimSuffix(filepath.Base(shell), filepath.Ext(shell))) {
+	case "cmd":
+		return []string{shell, "/C", command}
+	case "powershell", "pwsh":
+		return []string{shell, "-NoProfile", "-NonInteractive", "-Command", command}
+	}
+	return []string{shell, "-c", command}
+}
+
+// defaultShell returns the shell running local commands when none is set
+func defaultShell() string {
+	if runtime.GOOS != "windows" {
+		return "sh"
+	}
+	for _, shell := range []string{"sh", "pwsh"} {
+		if _, err := exec.LookPath(shell); err == nil {
+			return shell
+		}
+	}
+	return "powershell"
+}
+
+// LocalExecutable returns the argument vector running an executable file on
+// this machine. Windows runs PowerShell scripts with PowerShell, and other
+// scripts without a known extension with sh when it is on the PATH.
+func LocalExecutable(path string) []string {
+	if runtime.GOOS != "windows" {
+		return []string{path}
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".ps1":
+		return []string{defaultPowerShell(), "-NoProfile", "-NonInteractive", "-ExecutionPolicy", "Bypass", "-File", path}
+	case ".exe", ".cmd", ".bat":
+		return []string{path}
+	}
+	return []string{"sh", path}
+}
+
+// defaultPowerShell returns PowerShell 7 when it is installed and Windows
+// PowerShell otherwise
+func defaultPowerShell() string {
+	if _, err := exec.LookPath("pwsh"); err == nil {
+		return "pwsh"
+	}
+	return "powershell"
+}
+
+// IsLocalExecutable reports whether a file can be run by LocalExecutable:
+// it has an executable bit, or on Windows, which has none, an executable
+// extension, or any name when sh is on the PATH to run scripts with
+func IsLocalExecutable(path string, info fs.FileInfo) bool {
+	if runtime.GOOS != "windows" {
+		return info.Mode().Perm()&0111 != 0
+	}
+	if slices.Contains(windowsExecutables, strings.ToLower(filepath.Ext(path))) {
+		return true
+	}
+	_, err := exec.LookPath("sh")
+	return err == nil
+}
@@ -0,0 +1,48 @@
+package config
+
+import (
+	"fmt"
+	"os"
+)
+
+// Signing configures signing built images with cosign and verifying the
+// signature on the hosts before the container starts. Images are verified
+// with a public key, or keyless by the identity and OIDC issuer of the
+// signing certificate. Built images are signed with the private key, or
+// keyless with an identity; with only a public key, images signed elsewhere
+// are verified.
+type Signing struct {
+	Key       string `json:"key,omitempty"`
+	PublicKey string `json:"publicKey,omitempty"`
+	Identity  string `json:"identity,omitempty"`
+	Issuer    string `json:"issuer,omitempty"`
+}
+
+// Enabled reports whether images are verified on the hosts
+func (s Signing) Enabled() bool {
+	return s.PublicKey != "" || s.Identity != ""
+}
+
+// Keyless reports whether images are signed and verified with a certificate
+// of an OIDC identity rather than a key pair
+func (s Signing) Keyless() bool {
+	return s.Identity != ""
+}
+
+// validate checks the signing settings
+func (s Signing) validate() error {
+	switch {
+	case s.Identity != "" && (s.PublicKey != "" || s.Key != ""):
+		return fmt.Errorf("signing uses either a key pair or a keyless identity, not both")
+	case s.Key != "" && s.PublicKey == "":
+		return fmt.Errorf("signing with a key needs the public key to verify the image on the hosts")
+	case s.Identity != "" && s.Issuer == "":
+		return fmt.Errorf("keyless signing needs the OIDC issuer of the identity (e.g. https://token.actions.githubusercontent.com)")
+	}
+	if s.PublicKey != "" {
+		if _, err := os.Stat(s.PublicKey); err != nil {
+			return fmt.Errorf("signing public key %s not found", s.PublicKey)
+		}
+	}
+	return nil
+}
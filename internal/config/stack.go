@@ -0,0 +1,257 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// nonAlphanumeric matches the characters replaced in environment variable names
+var nonAlphanumeric = regexp.MustCompile(`[^A-Za-z0-9]+`)
+
+// Service is a single app of a stack. Its settings use the same names as the
+// config file and override the top-level configuration.
+type Service struct {
+	Name      string
+	DependsOn []string
+	settings  json.RawMessage
+}
+
+// UnmarshalJSON reads the name and dependencies of a service and keeps the
+// remaining settings to apply on top of the top-level configuration
+func (s *Service) UnmarshalJSON(data []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(fields["name"], &s.Name); err != nil || s.Name == "" {
+		return fmt.Errorf("every stack service needs a name")
+	}
+	delete(fields, "name")
+
+	if dependsOn, ok := fields["dependsOn"]; ok {
+		if err := json.Unmarshal(dependsOn, &s.DependsOn); err != nil {
+			return fmt.Errorf("invalid dependsOn of service %s: %v", s.Name, err)
+		}
+		delete(fields, "dependsOn")
+	}
+
+	settings, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	s.settings = settings
+	return nil
+}
+
+// Services returns the configuration of every service of the stack in
+// deployment order, so that a service comes after the services it depends
+// on. Without a stack the configuration itself is the only service.
+func (c *Config) Services() ([]Config, error) {
+	if len(c.Stack) == 0 {
+		return []Config{*c}, nil
+	}
+
+	byName := make(map[string]Service)
+	for _, service := range c.Stack {
+		if _, exists := byName[service.Name]; exists {
+			return nil, fmt.Errorf("service %s is defined more than once", service.Name)
+		}
+		byName[service.Name] = service
+	}
+
+	// Order services by their dependencies, keeping the order of the config
+	// file where possible
+	var ordered []Config
+	resolved := make(map[string]*Config)
+	state := make(map[string]int)
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		service, ok := byName[name]
+		if !ok {
+			return fmt.Errorf("service %s depends on unknown service %s", path[len(path)-1], name)
+		}
+		switch state[name] {
+		case 1:
+			return fmt.Errorf("dependency cycle between services: %s", strings.Join(append(path, name), " -> "))
+		case 2:
+			return nil
+		}
+
+		state[name] = 1
+		for _, dependency := range service.DependsOn {
+			if err := visit(dependency, append(path, name)); err != nil {
+				return err
+			}
+		}
+		state[name] = 2
+
+		serviceConfig, err := c.service(service)
+		if err != nil {
+			return err
+		}
+		for _, dependency := range service.DependsOn {
+			serviceConfig.addDiscoveryEnv(dependency, resolved[dependency])
+		}
+		resolved[name] = &serviceConfig
+		ordered = append(ordered, serviceConfig)
+		return nil
+	}
+
+	for _, service := range c.Stack {
+		if err := visit(service.Name, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	return ordered, nil
+}
+
+// Service returns the configuration of a single service of the stack
+func (c *Config) Service(name string) (Config, error) {
+	services, err := c.Services()
+	if err != nil {
+		return Config{}, err
+	}
+	for _, service := range services {
+		if service.ServiceName == name {
+			return service, nil
+		}
+	}
+	return Config{}, fmt.Errorf("service %s is not defined in the stack", name)
+}
+
+// Inventory returns a configuration for every distinct host of the app, the
+// services of its stack and its accessories, in the order they are defined
+func (c *Config) Inventory() ([]Config, error) {
+	services, err := c.Services()
+	if err != nil {
+		return nil, err
+	}
+	for _, accessory := range c.Accessories {
+		services = append(services, c.AccessoryConfig(accessory))
+	}
+
+	var inventory []Config
+	seen := make(map[string]bool)
+	for _, service := range services {
+		for _, host := range service.Hosts {
+			if host == "" || seen[host] {
+				continue
+			}
+			seen[host] = true
+
+			hostConfig := service
+			hostConfig.Host, hostConfig.Hosts = host, []string{host}
+			inventory = append(inventory, hostConfig)
+		}
+	}
+	return inventory, nil
+}
+
+// service applies the settings of a service on top of the configuration. The
+// container and image are named after the service unless it sets them.
+func (c *Config) service(service Service) (Config, error) {
+	config := *c
+	config.Stack = nil
+	config.ServiceName = service.Name
+	config.ContainerName = service.Name
+	config.Image = service.Name
+	config.BuildArgs = maps.Clone(c.BuildArgs)
+	config.Env = nil
+	config.Host = ""
+	config.Hosts = nil
+
+	decoder := json.NewDecoder(bytes.NewReader(service.settings))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&config); err != nil {
+		return Config{}, fmt.Errorf("invalid settings for service %s: %v", service.Name, err)
+	}
+	if err := interpolateEnv(config.Env); err != nil {
+		return Config{}, fmt.Errorf("service %s: %v", service.Name, err)
+	}
+	if err := config.interpolate(c); err != nil {
+		return Config{}, fmt.Errorf("service %s: %v", service.Name, err)
+	}
+	config.inheritEnv(c.Env)
+
+	// Services run on the top-level hosts unless they set their own
+	switch {
+	case len(config.Hosts) > 0:
+	case config.Host != "":
+		config.Hosts = []string{config.Host}
+	default:
+		config.Hosts = c.Hosts
+	}
+	if len(config.Hosts) > 0 {
+		config.Host = config.Hosts[0]
+	}
+
+	if config.PrebuiltImage != "" {
+		config.Tag = referenceTag(config.PrebuiltImage)
+	}
+
+	config.SSHKey = expandHome(config.SSHKey)
+	config.KnownHosts = expandHome(config.KnownHosts)
+
+	return config, nil
+}
+
+// addDiscoveryEnv sets <NAME>_HOST, <NAME>_PORT and <NAME>_URL variables
+// pointing at a service the configuration depends on, unless they are set
+// explicitly. Services on the same docker network and hosts reach each other
+// by container name, others through the published port on the first host of
+// the dependency.
+func (c *Config) addDiscoveryEnv(name string, dependency *Config) {
+	host := dependency.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	port := dependency.HostPort
+
+	sharesHosts := true
+	for _, h := range c.Hosts {
+		if !slices.Contains(dependency.Hosts, h) {
+			sharesHosts = false
+		}
+	}
+	if c.Network != "" && c.Network == dependency.Network && sharesHosts {
+		host, port = dependency.ContainerName, dependency.ContainerPort
+	}
+
+	prefix := strings.ToUpper(nonAlphanumeric.ReplaceAllString(name, "_"))
+	variables := map[string]string{
+		prefix + "_HOST": host,
+		prefix + "_PORT": port,
+		prefix + "_URL":  fmt.Sprintf("http://%s", net.JoinHostPort(host, port)),
+	}
+
+	if c.Env == nil {
+		c.Env = make(map[string]string)
+	}
+	for key, value := range variables {
+		if _, set := c.Env[key]; !set {
+			c.Env[key] = value
+		}
+	}
+}
+
+// expandHome expands a leading ~/ to the home directory
+func expandHome(path string) string {
+	if !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, path[2:])
+}
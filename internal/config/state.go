@@ -0,0 +1,81 @@
+package config
+
+import "fmt"
+
+// StateRoot is the remote directory holding the state directories of all
+// apps and accessories on a host
+const StateRoot = "~/.copepod"
+
+// StateDir returns the remote directory where pipe keeps state for the app.
+// Its layout is:
+//
+//	env            environment file the container is started with
+//	history.jsonl  deployment history
+//	packages       system packages installed for the app, one per line
+//	jobs/          output of the latest jobs and the job history
+//	cron/          scripts of the jobs scheduled with cron
+//	locks/         locks held by running commands
+//	backups/       files moved aside, such as env files of earlier versions
+//	quarantine/    logs and files of containers that failed verification
+//	sbom/          software bills of materials of the deployed images
+func (c *Config) StateDir() string {
+	return fmt.Sprintf("%s/%s", StateRoot, c.ContainerName)
+}
+
+// RemoteEnvFile returns the path of the app's environment file on the host
+func (c *Config) RemoteEnvFile() string {
+	return c.StateDir() + "/env"
+}
+
+// HistoryFile returns the path of the deployment history on the host
+func (c *Config) HistoryFile() string {
+	return c.StateDir() + "/history.jsonl"
+}
+
+// PackagesFile returns the path of the record of the system packages
+// installed for the app on the host
+func (c *Config) PackagesFile() string {
+	return c.StateDir() + "/packages"
+}
+
+// JobsDir returns the directory of the job outputs and history on the host
+func (c *Config) JobsDir() string {
+	return c.StateDir() + "/jobs"
+}
+
+// JobsHistory returns the path of the job history on the host
+func (c *Config) JobsHistory() string {
+	return c.JobsDir() + "/history.jsonl"
+}
+
+// JobLog returns the path of the output of a job on the host
+func (c *Config) JobLog(id string) string {
+	return fmt.Sprintf("%s/%s.log", c.JobsDir(), id)
+}
+
+// CronScript returns the path of the script of a scheduled job on the host
+func (c *Config) CronScript(name string) string {
+	return fmt.Sprintf("%s/cron/%s.sh", c.StateDir(), name)
+}
+
+// LocksDir returns the directory of the app's locks on the host
+func (c *Config) LocksDir() string {
+	return c.StateDir() + "/locks"
+}
+
+// DeployLock returns the lock directory held by the deployment or rollback
+// running on the host
+func (c *Config) DeployLock() string {
+	return c.LocksDir() + "/deploy"
+}
+
+// BackupsDir returns the directory files are moved aside to on the host
+func (c *Config) BackupsDir() string {
+	return c.StateDir() + "/backups"
+}
+
+// QuarantineDir returns the directory the evidence of failed containers is
+// exported to on the host
+func (c *Config) QuarantineDir() string {
+	return c.StateDir() + "/quarantine"
+}
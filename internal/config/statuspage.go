@@ -0,0 +1,47 @@
+package config
+
+import "fmt"
+
+// StatusPage is a component of a Statuspage or Instatus page, or a
+// self-hosted status page behind a webhook. The component is under
+// maintenance while pipe deploys or rolls back, operational when it
+// succeeds, and an incident is opened when it fails. The API key may be a
+// secret reference.
+type StatusPage struct {
+	Type        string `json:"type,omitempty"`
+	PageID      string `json:"pageId,omitempty"`
+	ComponentID string `json:"componentId,omitempty"`
+	APIKey      string `json:"apiKey,omitempty"`
+	URL         string `json:"url,omitempty"`
+}
+
+// Status page types
+const (
+	StatusPageStatuspage = "statuspage"
+	StatusPageInstatus   = "instatus"
+	StatusPageWebhook    = "webhook"
+)
+
+// Enabled reports whether a status page is configured
+func (s StatusPage) Enabled() bool {
+	return s.Type != ""
+}
+
+// validate checks the status page settings
+func (s StatusPage) validate() error {
+	switch s.Type {
+	case "":
+		return nil
+	case StatusPageStatuspage, StatusPageInstatus:
+		if s.PageID == "" || s.ComponentID == "" || s.APIKey == "" {
+			return fmt.Errorf("invalid status page: %s needs a pageId, componentId and apiKey", s.Type)
+		}
+	case StatusPageWebhook:
+		if s.URL == "" {
+			return fmt.Errorf("invalid status page: webhook needs a url")
+		}
+	default:
+		return fmt.Errorf("invalid status page type %q: expected %q, %q or %q", s.Type, StatusPageStatuspage, StatusPageInstatus, StatusPageWebhook)
+	}
+	return nil
+}
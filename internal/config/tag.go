@@ -0,0 +1,69 @@
+package config
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Tag strategies generating the image tag
+const (
+	TagStrategyGitSHA    = "git-sha"
+	TagStrategyTimestamp = "timestamp"
+	TagStrategySemver    = "semver"
+)
+
+// semverTag matches a git tag such as v1.2.3, or v1.2.3-4-gabc1234 for a
+// commit after it
+var semverTag = regexp.MustCompile(`^v?[0-9]+\.[0-9]+\.[0-9]+([-+][0-9A-Za-z.-]+)?$`)
+
+// applyTagStrategy replaces the tag with one generated by the tag strategy:
+// the short commit hash, the current time in UTC, or the version of the
+// latest git tag without its leading v, followed by the commits since for
+// untagged commits. A tag set by hand cannot be combined with a strategy.
+func (c *Config) applyTagStrategy() error {
+	if c.TagStrategy == "" {
+		return nil
+	}
+	if c.PrebuiltImage != "" {
+		return fmt.Errorf("--tag-strategy cannot be used with --image-ref, whose tag is deployed")
+	}
+	if c.Tag != "" && c.Tag != Defaults().Tag {
+		return fmt.Errorf("--tag-strategy cannot be used with --tag %s, remove one of them", c.Tag)
+	}
+
+	switch c.TagStrategy {
+	case TagStrategyGitSHA:
+		sha, err := git("rev-parse", "--short", "HEAD")
+		if err != nil {
+			return fmt.Errorf("tag strategy %s needs a git repository: %v", c.TagStrategy, err)
+		}
+		c.Tag = sha
+	case TagStrategyTimestamp:
+		c.Tag = time.Now().UTC().Format("2006-01-02T15-04-05")
+	case TagStrategySemver:
+		version, err := git("describe", "--tags", "--match", "v[0-9]*")
+		if err != nil || !semverTag.MatchString(version) {
+			return fmt.Errorf("tag strategy %s needs a git tag such as v1.2.0 on or before the current commit", c.TagStrategy)
+		}
+		c.Tag = strings.TrimPrefix(version, "v")
+	default:
+		return fmt.Errorf("invalid tag strategy %q: expected %q, %q or %q", c.TagStrategy, TagStrategyGitSHA, TagStrategyTimestamp, TagStrategySemver)
+	}
+	resolvedValues = append(resolvedValues, fmt.Sprintf("tag from the %s strategy = %s", c.TagStrategy, c.Tag))
+	return nil
+}
+
+// git runs a git command in the current directory and returns its output
+func git(args ...string) (string, error) {
+	output, err := exec.Command("git", args...).Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return "", fmt.Errorf("%s", strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return "", err
+	}
+	return strings.TrimSpace(string(output)), nil
+}
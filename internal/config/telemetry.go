@@ -0,0 +1,53 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+)
+
+// Telemetry configures exporting the duration of every phase of a
+// deployment or rollback and its outcome: pushed to a Prometheus
+// pushgateway, sent to StatsD over UDP, or exported as an OpenTelemetry
+// trace over OTLP/HTTP. The headers, whose values may be secret references,
+// are sent to the pushgateway and the OTLP endpoint.
+type Telemetry struct {
+	Pushgateway string            `json:"pushgateway,omitempty"`
+	StatsD      string            `json:"statsd,omitempty"`
+	OTLP        string            `json:"otlp,omitempty"`
+	Job         string            `json:"job,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+}
+
+// Enabled reports whether the runs are exported anywhere
+func (t Telemetry) Enabled() bool {
+	return t.Pushgateway != "" || t.StatsD != "" || t.OTLP != ""
+}
+
+// JobName returns the pushgateway job, the StatsD prefix and the service
+// name of the traces, pipe by default
+func (t Telemetry) JobName() string {
+	if t.Job == "" {
+		return "pipe"
+	}
+	return t.Job
+}
+
+// validate checks the telemetry endpoints
+func (t Telemetry) validate() error {
+	endpoints := [][2]string{{"pushgateway", t.Pushgateway}, {"OTLP endpoint", t.OTLP}}
+	for _, endpoint := range endpoints {
+		if endpoint[1] == "" {
+			continue
+		}
+		if u, err := url.Parse(endpoint[1]); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid telemetry %s %q: expected an http or https URL", endpoint[0], endpoint[1])
+		}
+	}
+	if t.StatsD != "" {
+		if _, _, err := net.SplitHostPort(t.StatsD); err != nil {
+			return fmt.Errorf("invalid telemetry StatsD address %q: expected host:port", t.StatsD)
+		}
+	}
+	return nil
+}
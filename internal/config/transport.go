@@ -0,0 +1,114 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+)
+
+// Transports of the docker commands run on the hosts
+const (
+	TransportSSH        = "ssh"
+	TransportDockerHost = "docker-host"
+	TransportDockerTLS  = "docker-tls"
+)
+
+// DockerTLSPort is the port of the Docker API of hosts given without a port
+// in the docker-tls transport
+const DockerTLSPort = "2376"
+
+// DockerHost reports whether docker commands are sent to the remote engine
+// by the local docker CLI, instead of running on the host over SSH
+func (c *Config) DockerHost() bool {
+	return c.Transport == TransportDockerHost || c.Transport == TransportDockerTLS
+}
+
+// DockerTLS reports whether the hosts are only reached through the Docker
+// API over TCP with TLS, without SSH
+func (c *Config) DockerTLS() bool {
+	return c.Transport == TransportDockerTLS
+}
+
+// DockerHostArgs returns the global docker CLI options addressing the
+// engine of the host: the configured docker context, a tcp:// URL verified
+// with the client certificates, or an ssh:// URL
+func (c *Config) DockerHostArgs() []string {
+	if c.DockerContext != "" {
+		return []string{"--context", c.DockerContext}
+	}
+	host := c.Host
+	if c.DockerTLS() {
+		if _, _, err := net.SplitHostPort(host); err != nil {
+			host = net.JoinHostPort(host, DockerTLSPort)
+		}
+		args := []string{"--host", "tcp://" + host, "--tlsverify"}
+		if c.DockerCertPath != "" {
+			args = append(args,
+				"--tlscacert", filepath.Join(c.DockerCertPath, "ca.pem"),
+				"--tlscert", filepath.Join(c.DockerCertPath, "cert.pem"),
+				"--tlskey", filepath.Join(c.DockerCertPath, "key.pem"))
+		}
+		return args
+	}
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(host, c.SSHPort)
+	}
+	return []string{"--host", fmt.Sprintf("ssh://%s@%s", c.User, host)}
+}
+
+// validateTransport checks the transport. The docker CLI connects with the
+// local ssh client, so settings only the built-in client knows are refused.
+func (c *Config) validateTransport() error {
+	if c.DockerCertPath != "" && !c.DockerTLS() {
+		return fmt.Errorf("--docker-cert-path needs --transport %s", TransportDockerTLS)
+	}
+
+	switch c.Transport {
+	case "", TransportSSH:
+		if c.DockerContext != "" {
+			return fmt.Errorf("--docker-context needs --transport %s or %s", TransportDockerHost, TransportDockerTLS)
+		}
+	case TransportDockerHost:
+		if c.DockerUser != "" {
+			return fmt.Errorf("--transport %s cannot run docker as another user, remove --docker-user", TransportDockerHost)
+		}
+		if c.DockerCommand != "" {
+			return fmt.Errorf("--transport %s runs the local docker CLI, remove --docker-cmd", TransportDockerHost)
+		}
+		if c.JumpHost != "" {
+			return fmt.Errorf("--transport %s connects with the local ssh client, configure the jump host in ~/.ssh/config instead", TransportDockerHost)
+		}
+		if c.DockerContext != "" && len(c.Hosts) > 1 {
+			return fmt.Errorf("a docker context addresses a single host, but %d hosts are configured", len(c.Hosts))
+		}
+	case TransportDockerTLS:
+		// Commands that are not a single docker invocation run in a helper
+		// container on the engine, which has neither sudo nor zstd
+		if c.DockerUser != "" {
+			return fmt.Errorf("--transport %s has no SSH user, remove --docker-user", TransportDockerTLS)
+		}
+		if c.DockerCommand != "" {
+			return fmt.Errorf("--transport %s runs the local docker CLI, remove --docker-cmd", TransportDockerTLS)
+		}
+		if c.JumpHost != "" {
+			return fmt.Errorf("--transport %s does not use SSH, remove --jump-host", TransportDockerTLS)
+		}
+		if c.Compress == CompressZstd {
+			return fmt.Errorf("--transport %s cannot load zstd compressed images, use --compress gzip", TransportDockerTLS)
+		}
+		if c.DockerContext != "" && len(c.Hosts) > 1 {
+			return fmt.Errorf("a docker context addresses a single host, but %d hosts are configured", len(c.Hosts))
+		}
+		if c.DockerCertPath != "" {
+			for _, name := range []string{"ca.pem", "cert.pem", "key.pem"} {
+				if _, err := os.Stat(filepath.Join(c.DockerCertPath, name)); err != nil {
+					return fmt.Errorf("invalid --docker-cert-path: %v", err)
+				}
+			}
+		}
+	default:
+		return fmt.Errorf("invalid transport %q: expected %q, %q or %q", c.Transport, TransportSSH, TransportDockerHost, TransportDockerTLS)
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// RebootTime returns the time of day the host may reboot after installing
+// updates, or an empty string when automatic reboots are disabled. Hosts are
+// spread evenly over the reboot window so they do not reboot at the same time.
+func (c *Config) RebootTime() (string, error) {
+	if c.Updates.RebootWindow == "" {
+		return "", nil
+	}
+
+	start, length, err := parseRebootWindow(c.Updates.RebootWindow)
+	if err != nil {
+		return "", err
+	}
+
+	index := max(slices.Index(c.Hosts, c.Host), 0)
+	count := max(len(c.Hosts), 1)
+	offset := (length / time.Duration(count) * time.Duration(index)).Truncate(time.Minute)
+
+	rebootAt := time.Date(0, 1, 1, 0, 0, 0, 0, time.UTC).Add(start + offset)
+	return rebootAt.Format("15:04"), nil
+}
+
+// parseRebootWindow parses a reboot window such as "02:00-04:00" into its
+// start as an offset from midnight and its length. Windows may span midnight.
+func parseRebootWindow(window string) (time.Duration, time.Duration, error) {
+	from, to, ok := strings.Cut(window, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid reboot window %q: expected HH:MM-HH:MM", window)
+	}
+
+	start, err := time.Parse("15:04", strings.TrimSpace(from))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid reboot window %q: expected HH:MM-HH:MM", window)
+	}
+	end, err := time.Parse("15:04", strings.TrimSpace(to))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid reboot window %q: expected HH:MM-HH:MM", window)
+	}
+
+	length := end.Sub(start)
+	if length <= 0 {
+		length += 24 * time.Hour
+	}
+
+	return start.Sub(time.Date(0, 1, 1, 0, 0, 0, 0, time.UTC)), length, nil
+}
@@ -0,0 +1,62 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// defaultWatchPatterns are the log lines failing a deployment while its logs
+// are watched, unless other patterns are configured: Go panics, fatal errors
+// and the stack traces of Python, Java, .NET and Node.js
+var defaultWatchPatterns = []string{
+	`\bpanic:`,
+	`\bFATAL\b`,
+	`^goroutine \d+ \[running\]`,
+	`Traceback \(most recent call last\)`,
+	`Exception in thread "`,
+	`Unhandled exception`,
+	`UnhandledPromiseRejection`,
+	`Segmentation fault`,
+}
+
+// WatchLogsDuration returns how long the logs of a new container are
+// watched after it passed its health checks, or zero when they are not
+func (c *Config) WatchLogsDuration() (time.Duration, error) {
+	if c.WatchLogs == "" || c.WatchLogs == "0" {
+		return 0, nil
+	}
+	window, err := time.ParseDuration(c.WatchLogs)
+	if err != nil || window < 0 {
+		return 0, fmt.Errorf("invalid watch logs duration %q: expected a duration such as 30s, or 0", c.WatchLogs)
+	}
+	return window, nil
+}
+
+// LogFailurePatterns returns the regular expressions of the log lines
+// failing a deployment while its logs are watched
+func (c *Config) LogFailurePatterns() ([]*regexp.Regexp, error) {
+	patterns := c.WatchLogsPatterns
+	if len(patterns) == 0 {
+		patterns = defaultWatchPatterns
+	}
+
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid watch logs pattern %q: %v", pattern, err)
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
+// validateWatchLogs checks the watch window and the failure patterns
+func (c *Config) validateWatchLogs() error {
+	if _, err := c.WatchLogsDuration(); err != nil {
+		return err
+	}
+	_, err := c.LogFailurePatterns()
+	return err
+}
@@ -0,0 +1,131 @@
+package deploy
+
+import (
+	"fmt"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/docker"
+	"github.com/bjarneo/pipe/internal/logger"
+	"github.com/bjarneo/pipe/internal/ssh"
+)
+
+// Accessory starts, stops or streams the logs of the accessories defined in
+// the config file, or of a single accessory when a name is given
+func Accessory(cfg *config.Config, log *logger.Logger, args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return fmt.Errorf("usage: pipe accessory start|stop|logs [name]")
+	}
+
+	accessories := cfg.Accessories
+	if len(args) == 2 {
+		accessory, err := cfg.FindAccessory(args[1])
+		if err != nil {
+			return err
+		}
+		accessories = []config.Accessory{accessory}
+	}
+	if len(accessories) == 0 {
+		return fmt.Errorf("no accessories are defined in the config file")
+	}
+
+	switch args[0] {
+	case "start":
+		if err := resolveSecrets(cfg, log, nil); err != nil {
+			return err
+		}
+		return eachAccessory(cfg, log, accessories, startAccessory)
+	case "stop":
+		return eachAccessory(cfg, log, accessories, func(cfg *config.Config, log *logger.Logger, accessory config.Accessory) error {
+			return docker.Stop(cfg, log, accessory.Name)
+		})
+	case "logs":
+		if len(accessories) > 1 {
+			return fmt.Errorf("choose an accessory: pipe accessory logs <name>")
+		}
+		logsCmd, err := logsCommand(cfg, accessories[0].Name)
+		if err != nil {
+			return err
+		}
+		return eachAccessory(cfg, log, accessories, func(cfg *config.Config, log *logger.Logger, accessory config.Accessory) error {
+			return ssh.Stream(cfg, log, logsCmd, fmt.Sprintf("Streaming logs of %s", accessory.Name))
+		})
+	default:
+		return fmt.Errorf("unknown accessory action %q: expected start, stop or logs", args[0])
+	}
+}
+
+// bootAccessories creates the accessories that do not exist yet. Existing
+// accessories are left untouched, running or not, so app deployments never
+// restart a database.
+func bootAccessories(cfg *config.Config, log *logger.Logger) error {
+	return eachAccessory(cfg, log, cfg.Accessories, func(cfg *config.Config, log *logger.Logger, accessory config.Accessory) error {
+		exists, err := docker.Exists(cfg, log, accessory.Name)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return nil
+		}
+		return docker.RunAccessory(cfg, log, accessory)
+	})
+}
+
+// startAccessory starts an existing accessory, or creates it if it does not
+// exist yet
+func startAccessory(cfg *config.Config, log *logger.Logger, accessory config.Accessory) error {
+	exists, err := docker.Exists(cfg, log, accessory.Name)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return docker.RunAccessory(cfg, log, accessory)
+	}
+	return docker.Start(cfg, log, accessory.Name)
+}
+
+// eachAccessory validates the configuration of every accessory and runs fn
+// against it on its host, one accessory at a time
+func eachAccessory(cfg *config.Config, log *logger.Logger, accessories []config.Accessory,
+	fn func(cfg *config.Config, log *logger.Logger, accessory config.Accessory) error) error {
+	for _, accessory := range accessories {
+		accessoryCfg := cfg.AccessoryConfig(accessory)
+		if err := accessoryCfg.Validate(); err != nil {
+			return err
+		}
+
+		if err := fn(&accessoryCfg, log.WithService(accessory.Name), accessory); err != nil {
+			return fmt.Errorf("accessory %s: %v", accessory.Name, err)
+		}
+	}
+	return nil
+}
+
+// planAccessories returns the plan for the accessories of the configuration
+func planAccessories(cfg *config.Config, log *logger.Logger) (string, error) {
+	if len(cfg.Accessories) == 0 {
+		return "", nil
+	}
+
+	actions := make(map[string]string)
+	err := eachAccessory(cfg, log, cfg.Accessories, func(cfg *config.Config, log *logger.Logger, accessory config.Accessory) error {
+		exists, err := docker.Exists(cfg, log, accessory.Name)
+		if err != nil {
+			return err
+		}
+		if exists {
+			actions[accessory.Name] = fmt.Sprintf("= keep accessory %s on %s (left untouched)", accessory.Name, cfg.Host)
+		} else {
+			actions[accessory.Name] = fmt.Sprintf("+ create accessory %s from %s on %s", accessory.Name, accessory.Image, cfg.Host)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	plan := "\nAccessories:\n\n"
+	for _, accessory := range cfg.Accessories {
+		plan += "  " + actions[accessory.Name] + "\n"
+	}
+	return plan, nil
+}
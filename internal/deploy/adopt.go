@@ -0,0 +1,181 @@
+package deploy
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/history"
+	"github.com/bjarneo/pipe/internal/logger"
+	"github.com/bjarneo/pipe/internal/ssh"
+)
+
+// Adopt brings a container that was started by hand under pipe management. It
+// writes a config file matching the running container and records it as the
+// baseline deployment on the host, without touching the container itself.
+func Adopt(cfg *config.Config, log *logger.Logger, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: pipe adopt <container>")
+	}
+	name := args[0]
+
+	// Validate configuration
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	if len(cfg.Hosts) > 1 {
+		return fmt.Errorf("adopt works on a single host, got %d hosts", len(cfg.Hosts))
+	}
+
+	if _, err := os.Stat(cfg.Output); err == nil {
+		return fmt.Errorf("%s already exists, choose another file with --output", cfg.Output)
+	}
+
+	if err := log.Info(fmt.Sprintf("Adopting container %s on %s", name, cfg.Host)); err != nil {
+		return err
+	}
+
+	// Check SSH connection
+	if err := ssh.Check(cfg, log); err != nil {
+		return err
+	}
+
+	container, err := inspectContainer(cfg, log, name)
+	if err != nil {
+		return err
+	}
+
+	adopted, err := adoptedConfig(cfg, log, name, container)
+	if err != nil {
+		return err
+	}
+
+	// Record the adopted settings so later deploys can detect drift
+	record := history.NewRecord(&adopted, log, "adopt", nil)
+	record.ContainerEnv = history.EnvHashes(container.Config.Env)
+	if err := history.Append(&adopted, log, record); err != nil {
+		return fmt.Errorf("failed to record adoption: %v", err)
+	}
+
+	if err := config.WriteFile(cfg.Output, adopted); err != nil {
+		return fmt.Errorf("failed to write config file: %v", err)
+	}
+
+	return log.Info(fmt.Sprintf("Container %s adopted, configuration written to %s", name, cfg.Output))
+}
+
+// adoptedConfig returns the configuration that reproduces the inspected container
+func adoptedConfig(cfg *config.Config, log *logger.Logger, name string, container containerInspect) (config.Config, error) {
+	adopted := config.Config{
+		Host:          cfg.Host,
+		Hosts:         []string{cfg.Host},
+		User:          cfg.User,
+		SSHKey:        cfg.SSHKey,
+		Dockerfile:    cfg.Dockerfile,
+		Platform:      cfg.Platform,
+		ContainerName: strings.TrimPrefix(container.Name, "/"),
+		CPUs:          formatCPUs(container.HostConfig.NanoCpus),
+		Memory:        formatMemory(container.HostConfig.Memory),
+		Volumes:       container.HostConfig.Binds,
+		Strategy:      config.StrategyRecreate,
+	}
+	adopted.SetImage(container.Config.Image)
+
+	// pipe publishes a single port
+	ports := strings.Split(currentPorts(container), ",")
+	if ports[0] != "" {
+		hostPort, containerPort, _ := strings.Cut(ports[0], ":")
+		adopted.HostPort, adopted.ContainerPort = hostPort, containerPort
+	}
+	if len(ports) > 1 {
+		log.Warn(fmt.Sprintf("container %s publishes several ports, only %s is kept", name, ports[0]))
+	}
+
+	network := container.HostConfig.NetworkMode
+	if network != "default" && network != "bridge" {
+		adopted.Network = network
+	}
+
+	adopted.MemoryReservation = formatMemory(container.HostConfig.MemoryReservation)
+	if swap := container.HostConfig.MemorySwap; swap == -1 || (swap > 0 && swap != 2*container.HostConfig.Memory) {
+		adopted.MemorySwap = formatSwap(swap)
+	}
+	if limit := container.HostConfig.PidsLimit; limit != nil && *limit > 0 {
+		adopted.PidsLimit = int(*limit)
+	}
+	adopted.CPUShares = int(container.HostConfig.CpuShares)
+	adopted.OOMKillDisable = container.HostConfig.OomKillDisable != nil && *container.HostConfig.OomKillDisable
+	adopted.CPUsetCPUs = container.HostConfig.CpusetCpus
+	adopted.CgroupParent = container.HostConfig.CgroupParent
+
+	// Environment variables set on the container, rather than inherited from
+	// the image, go into an env file next to the config
+	env, err := containerEnv(cfg, log, container)
+	if err != nil {
+		return adopted, err
+	}
+	if len(env) > 0 {
+		adopted.EnvFile = fmt.Sprintf(".env.%s", adopted.ContainerName)
+		if err := writeEnvFile(adopted.EnvFile, env); err != nil {
+			return adopted, fmt.Errorf("failed to write environment file: %v", err)
+		}
+		if err := log.Info(fmt.Sprintf("Wrote %d environment variables to %s", len(env), adopted.EnvFile)); err != nil {
+			return adopted, err
+		}
+	}
+
+	return adopted, nil
+}
+
+// containerEnv returns the environment variables of the container that are not
+// defined by its image
+func containerEnv(cfg *config.Config, log *logger.Logger, container containerInspect) ([]string, error) {
+	imageCmd := ssh.Command("docker", "image", "inspect", "--format", "{{json .Config.Env}}", container.Image)
+	result, err := ssh.Capture(cfg, log, imageCmd, "Inspecting image")
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect image: %v", err)
+	}
+
+	var imageEnv []string
+	if err := json.Unmarshal([]byte(result.Stdout), &imageEnv); err != nil {
+		return nil, fmt.Errorf("failed to parse image information: %v", err)
+	}
+
+	var env []string
+	for _, variable := range container.Config.Env {
+		if !slices.Contains(imageEnv, variable) {
+			env = append(env, variable)
+		}
+	}
+	return env, nil
+}
+
+// writeEnvFile writes environment variables to a new env file
+func writeEnvFile(path string, env []string) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.WriteString(strings.Join(env, "\n") + "\n")
+	return err
+}
+
+// formatMemory converts a memory limit in bytes to the --memory notation
+func formatMemory(bytes int64) string {
+	switch {
+	case bytes == 0:
+		return ""
+	case bytes%(1024*1024*1024) == 0:
+		return fmt.Sprintf("%dg", bytes/(1024*1024*1024))
+	case bytes%(1024*1024) == 0:
+		return fmt.Sprintf("%dm", bytes/(1024*1024))
+	default:
+		return strconv.FormatInt(bytes, 10)
+	}
+}
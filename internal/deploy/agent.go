@@ -0,0 +1,72 @@
+package deploy
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/docker"
+	"github.com/bjarneo/pipe/internal/logger"
+)
+
+// Agent installs, removes or shows the status of the optional agent that
+// watches the container on every host
+func Agent(cfg *config.Config, log *logger.Logger, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: pipe agent install|uninstall|status")
+	}
+
+	// Validate configuration
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "install":
+		pingURL, err := resolveSecret(log, make(map[string]string), "monitor.pingUrl", cfg.Monitor.PingURL)
+		if err != nil {
+			return err
+		}
+		cfg.Monitor.PingURL = pingURL
+
+		if err := forEachHost(cfg, log, docker.InstallAgent); err != nil {
+			return err
+		}
+		return log.Info(fmt.Sprintf("Agent installed, checking %s every %s", cfg.ContainerName, cfg.Agent.CheckInterval()))
+	case "uninstall":
+		if err := forEachHost(cfg, log, docker.RemoveAgent); err != nil {
+			return err
+		}
+		return log.Info("Agent removed")
+	case "status":
+		return forEachHost(cfg, log, func(cfg *config.Config, log *logger.Logger) error {
+			active, report, err := docker.AgentStatus(cfg, log)
+			if err != nil {
+				return err
+			}
+			log.Output(fmt.Sprintf("Agent:     %s", agentSummary(active, report)))
+			return nil
+		})
+	default:
+		return fmt.Errorf("unknown agent action %q: expected install, uninstall or status", args[0])
+	}
+}
+
+// agentSummary describes the agent state and its last report in one line
+func agentSummary(active bool, report *docker.AgentReport) string {
+	if !active {
+		return "not running"
+	}
+	if report == nil {
+		return "running, no report yet"
+	}
+
+	summary := fmt.Sprintf("running, container %s %s ago", report.Health, time.Since(report.CheckedAt).Round(time.Second))
+	if report.Restarts > 0 {
+		summary += fmt.Sprintf(", %d restart(s)", report.Restarts)
+	}
+	if report.RolledBack {
+		summary += ", rolled back"
+	}
+	return summary
+}
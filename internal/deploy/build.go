@@ -0,0 +1,154 @@
+package deploy
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/docker"
+	"github.com/bjarneo/pipe/internal/logger"
+)
+
+// buildServices runs the preBuild hooks and builds the images of the
+// services of a stack before any of them is deployed, at most
+// cfg.BuildParallel at a time. Services building the same image share a
+// single build. On failure it returns the index of the first failed service
+// in deployment order, after running the onFailure hooks of every failed
+// service.
+func buildServices(cfg *config.Config, log *logger.Logger, services []config.Config) (int, error) {
+	defer log.Group("Build images")()
+
+	needsDocker := false
+	for i := range services {
+		needsDocker = needsDocker || services[i].PrebuiltImage == ""
+	}
+	if needsDocker {
+		if err := docker.CheckLocal(cfg, log); err != nil {
+			return 0, err
+		}
+	}
+
+	for i := range services {
+		if err := resolvePlatform(&services[i], serviceLogger(log, &services[i])); err != nil {
+			return i, err
+		}
+	}
+
+	// The first service of every distinct build builds it, the others wait
+	// for its result
+	builds := make(map[string]*sharedBuild)
+	errs := make([]error, len(services))
+	slots := make(chan struct{}, cfg.BuildParallel)
+	var wg sync.WaitGroup
+
+	for i := range services {
+		service := &services[i]
+		key := buildKey(service)
+		build, shared := builds[key]
+		if !shared {
+			build = &sharedBuild{done: make(chan struct{})}
+			builds[key] = build
+		}
+
+		wg.Add(1)
+		go func(i int, service *config.Config, build *sharedBuild, shared bool) {
+			defer wg.Done()
+			log := serviceLogger(log, service)
+
+			slots <- struct{}{}
+			err := runPreBuildHooks(service, log)
+			if !shared {
+				if err == nil {
+					err = timeStep(log, "build", func(log *logger.Logger) error {
+						return buildImage(service, log)
+					})
+				}
+				build.err = err
+				close(build.done)
+			}
+			<-slots
+
+			if err == nil && shared {
+				<-build.done
+				if build.err != nil {
+					err = fmt.Errorf("image %s was not built: %v", service.ImageRef(), build.err)
+				} else if service.PrebuiltImage == "" {
+					err = log.Info(fmt.Sprintf("Image %s is built by another service", service.ImageRef()))
+				}
+			}
+			errs[i] = err
+		}(i, service, build, shared)
+	}
+	wg.Wait()
+
+	first := -1
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+		if first < 0 {
+			first = i
+		}
+		service := &services[i]
+		forEachHost(service, serviceLogger(log, service), func(cfg *config.Config, log *logger.Logger) error {
+			runFailureHooks(cfg, log, err)
+			return nil
+		})
+	}
+	if first >= 0 {
+		return first, errs[first]
+	}
+	return -1, nil
+}
+
+// sharedBuild is the result of a build shared by services building the same
+// image
+type sharedBuild struct {
+	done chan struct{}
+	err  error
+}
+
+// buildKey identifies the image a service builds, so services building the
+// same image from the same inputs build it once
+func buildKey(cfg *config.Config) string {
+	args := make([]string, 0, len(cfg.BuildArgs))
+	for key, value := range cfg.BuildArgs {
+		args = append(args, key+"="+value)
+	}
+	sort.Strings(args)
+	return strings.Join([]string{cfg.ImageRef(), cfg.Dockerfile, cfg.Platform, fmt.Sprint(cfg.SkipBuild),
+		strings.Join(args, "\x00"), strings.Join(cfg.BuildSecrets, "\x00"), strings.Join(cfg.BuildSSH, "\x00")}, "\x00")
+}
+
+// resolvePlatform replaces the auto platform with the platforms of the
+// hosts, detected on every host, so the image is built for each of them
+func resolvePlatform(cfg *config.Config, log *logger.Logger) error {
+	if cfg.Platform != config.PlatformAuto || cfg.PrebuiltImage != "" {
+		return nil
+	}
+
+	var mu sync.Mutex
+	var platforms []string
+	err := forEachHost(cfg, log, func(cfg *config.Config, log *logger.Logger) error {
+		platform, err := docker.HostPlatform(cfg, log)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if !slices.Contains(platforms, platform) {
+			platforms = append(platforms, platform)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.Strings(platforms)
+	cfg.Platform = strings.Join(platforms, ",")
+	return log.Info(fmt.Sprintf("Building for the platforms of the hosts: %s", cfg.Platform))
+}
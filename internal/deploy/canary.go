@@ -0,0 +1,105 @@
+package deploy
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/docker"
+	"github.com/bjarneo/pipe/internal/logger"
+	"github.com/bjarneo/pipe/internal/ssh"
+)
+
+// canaryPollInterval is how often the canary hosts are checked while baking
+const canaryPollInterval = 15 * time.Second
+
+// deployHosts deploys to every host of the app, the canary hosts first when
+// the canary strategy is used, or in batches in rolling deployments
+func deployHosts(cfg *config.Config, log *logger.Logger) error {
+	switch {
+	case cfg.Strategy == config.StrategyCanary:
+		return deployCanary(cfg, log)
+	case cfg.Rolling && len(cfg.Hosts) > 1:
+		return deployRolling(cfg, log)
+	}
+	return forEachHost(cfg, log, deployHost)
+}
+
+// deployCanary deploys the new version to the canary hosts and keeps checking
+// them for the bake time. The remaining hosts are only deployed once the
+// canary stayed healthy; otherwise the canary hosts are rolled back.
+func deployCanary(cfg *config.Config, log *logger.Logger) error {
+	canaries, rest := cfg.CanaryHosts()
+	bake, err := cfg.CanaryBakeDuration()
+	if err != nil {
+		return err
+	}
+
+	canaryCfg := *cfg
+	canaryCfg.Hosts = canaries
+	canaryCfg.Host = canaries[0]
+
+	if err := log.Info(fmt.Sprintf("Deploying canary to %d of %d hosts (%s)",
+		len(canaries), len(cfg.Hosts), strings.Join(canaries, ", "))); err != nil {
+		return err
+	}
+
+	err = forEachHost(&canaryCfg, log, deployHost)
+	if err == nil {
+		err = bakeCanary(&canaryCfg, log, bake)
+	}
+	if err != nil {
+		log.Warn(fmt.Sprintf("Canary failed, rolling back %s", strings.Join(canaries, ", ")))
+		if rollbackErr := forEachHost(&canaryCfg, log, rollbackHost); rollbackErr != nil {
+			log.Warn(fmt.Sprintf("Failed to roll back the canary: %v", rollbackErr))
+		}
+		return fmt.Errorf("canary failed, %s kept the previous version: %v", strings.Join(rest, ", "), err)
+	}
+
+	if err := log.Info(fmt.Sprintf("Canary healthy for %s, deploying to the remaining %d hosts", bake, len(rest))); err != nil {
+		return err
+	}
+
+	restCfg := *cfg
+	restCfg.Hosts = rest
+	restCfg.Host = rest[0]
+	return forEachHost(&restCfg, log, deployHost)
+}
+
+// bakeCanary checks that the container keeps running and passing its health
+// check on every canary host until the bake time has passed
+func bakeCanary(cfg *config.Config, log *logger.Logger, bake time.Duration) error {
+	if err := log.Info(fmt.Sprintf("Baking canary for %s", bake)); err != nil {
+		return err
+	}
+
+	check := func(cfg *config.Config, log *logger.Logger) error {
+		if err := docker.Verify(cfg, log); err != nil {
+			return err
+		}
+		return docker.CheckHealth(cfg, log, cfg.ContainerName, cfg.HostPort)
+	}
+
+	// A dry run does not start the canary, so it is only checked once
+	if ssh.DryRun() {
+		return forEachHost(cfg, log, check)
+	}
+
+	deadline := time.Now().Add(bake)
+	for {
+		if err := forEachHost(cfg, log, check); err != nil {
+			return err
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return nil
+		}
+		select {
+		case <-cfg.Context().Done():
+			return fmt.Errorf("canary interrupted: %v", cfg.Context().Err())
+		case <-time.After(min(canaryPollInterval, remaining)):
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package deploy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/logger"
+	"github.com/bjarneo/pipe/internal/ssh"
+)
+
+// hostState is the comparable state of the container on a single host
+type hostState struct {
+	Image     string
+	ImageID   string
+	EnvHash   string
+	FlagsHash string
+}
+
+// containerInspect holds the parts of `docker inspect` used to compare
+// containers across hosts and with the configuration
+type containerInspect struct {
+	ID           string `json:"Id"`
+	Name         string `json:"Name"`
+	Image        string `json:"Image"`
+	RestartCount int    `json:"RestartCount"`
+	State        struct {
+		Status    string    `json:"Status"`
+		Running   bool      `json:"Running"`
+		StartedAt time.Time `json:"StartedAt"`
+		Health    *struct {
+			Status string `json:"Status"`
+		} `json:"Health"`
+	} `json:"State"`
+	Config struct {
+		Image string   `json:"Image"`
+		Env   []string `json:"Env"`
+	} `json:"Config"`
+	HostConfig struct {
+		Binds             []string        `json:"Binds"`
+		PortBindings      json.RawMessage `json:"PortBindings"`
+		NetworkMode       string          `json:"NetworkMode"`
+		RestartPolicy     json.RawMessage `json:"RestartPolicy"`
+		NanoCpus          int64           `json:"NanoCpus"`
+		Memory            int64           `json:"Memory"`
+		MemoryReservation int64           `json:"MemoryReservation"`
+		MemorySwap        int64           `json:"MemorySwap"`
+		PidsLimit         *int64          `json:"PidsLimit"`
+		CpuShares         int64           `json:"CpuShares"`
+		OomKillDisable    *bool           `json:"OomKillDisable"`
+		CpusetCpus        string          `json:"CpusetCpus"`
+		CgroupParent      string          `json:"CgroupParent"`
+	} `json:"HostConfig"`
+}
+
+// Compare compares the deployed container across all configured hosts and
+// reports any host that is out of sync with the rest of the fleet
+func Compare(cfg *config.Config, log *logger.Logger, args []string) error {
+	if len(args) != 1 || args[0] != "hosts" {
+		return fmt.Errorf("usage: pipe compare hosts")
+	}
+
+	// Validate configuration
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	states := make(map[string]hostState)
+	var mu sync.Mutex
+
+	err := forEachHost(cfg, log, func(cfg *config.Config, log *logger.Logger) error {
+		state, err := inspectHost(cfg, log)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		states[cfg.Host] = state
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Find the most common value of every attribute across the fleet
+	expected := hostState{
+		ImageID:   majority(states, func(s hostState) string { return s.ImageID }),
+		EnvHash:   majority(states, func(s hostState) string { return s.EnvHash }),
+		FlagsHash: majority(states, func(s hostState) string { return s.FlagsHash }),
+	}
+
+	fmt.Printf("%-30s  %-30s  %-12s  %-12s  %-12s  %s\n", "HOST", "IMAGE", "IMAGE ID", "ENV", "FLAGS", "STATUS")
+	outOfSync := 0
+	for _, host := range cfg.Hosts {
+		state := states[host]
+
+		var diffs []string
+		if state.ImageID != expected.ImageID {
+			diffs = append(diffs, "image")
+		}
+		if state.EnvHash != expected.EnvHash {
+			diffs = append(diffs, "env")
+		}
+		if state.FlagsHash != expected.FlagsHash {
+			diffs = append(diffs, "flags")
+		}
+
+		status := "in sync"
+		if len(diffs) > 0 {
+			status = "OUT OF SYNC (" + strings.Join(diffs, ", ") + ")"
+			outOfSync++
+		}
+
+		fmt.Printf("%-30s  %-30s  %-12s  %-12s  %-12s  %s\n",
+			host, state.Image, shortID(state.ImageID), state.EnvHash, state.FlagsHash, status)
+	}
+
+	if outOfSync > 0 {
+		return fmt.Errorf("%d of %d hosts are out of sync", outOfSync, len(cfg.Hosts))
+	}
+
+	return log.Info("All hosts are in sync")
+}
+
+// inspectHost collects the comparable container state from a single host
+func inspectHost(cfg *config.Config, log *logger.Logger) (hostState, error) {
+	container, err := inspectContainer(cfg, log, cfg.ContainerName)
+	if err != nil {
+		return hostState{}, err
+	}
+
+	env := append([]string(nil), container.Config.Env...)
+	sort.Strings(env)
+
+	flags, err := json.Marshal(container.HostConfig)
+	if err != nil {
+		return hostState{}, err
+	}
+
+	return hostState{
+		Image:     container.Config.Image,
+		ImageID:   container.Image,
+		EnvHash:   hash(strings.Join(env, "\n")),
+		FlagsHash: hash(string(flags)),
+	}, nil
+}
+
+// inspectContainer returns the docker inspect information of a container
+func inspectContainer(cfg *config.Config, log *logger.Logger, name string) (containerInspect, error) {
+	result, err := ssh.Capture(cfg, log, ssh.Command("docker", "inspect", name), "Inspecting container")
+	if err != nil {
+		return containerInspect{}, fmt.Errorf("failed to inspect container: %v", err)
+	}
+
+	var containers []containerInspect
+	if err := json.Unmarshal([]byte(result.Stdout), &containers); err != nil || len(containers) == 0 {
+		return containerInspect{}, fmt.Errorf("failed to parse container information: %v", err)
+	}
+	return containers[0], nil
+}
+
+// majority returns the most common value of an attribute across hosts
+func majority(states map[string]hostState, value func(hostState) string) string {
+	counts := make(map[string]int)
+	best, bestCount := "", 0
+	for _, state := range states {
+		v := value(state)
+		counts[v]++
+		if counts[v] > bestCount || (counts[v] == bestCount && v < best) {
+			best, bestCount = v, counts[v]
+		}
+	}
+	return best
+}
+
+// hash returns a short SHA-256 hash of value
+func hash(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// shortID shortens a docker image ID for display
+func shortID(id string) string {
+	id = strings.TrimPrefix(id, "sha256:")
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
@@ -0,0 +1,115 @@
+package deploy
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/docker"
+	"github.com/bjarneo/pipe/internal/history"
+	"github.com/bjarneo/pipe/internal/logger"
+)
+
+// confirmProtected asks to confirm a deployment or rollback of protected
+// hosts, or of every host with --confirm, by typing each host name after a
+// summary of what changes on it. --yes skips the question, and without a
+// terminal to ask on the command fails unless --yes is set.
+func confirmProtected(cfg *config.Config, log *logger.Logger, action string, services []config.Config) error {
+	if cfg.Yes || cfg.Target != "" {
+		return nil
+	}
+
+	var hosts []string
+	var summary strings.Builder
+	for i := range services {
+		service := &services[i]
+		for _, host := range service.Hosts {
+			if !cfg.Confirm && !slices.Contains(service.Protected, host) {
+				continue
+			}
+			if !slices.Contains(hosts, host) {
+				hosts = append(hosts, host)
+			}
+			if !isTerminal() {
+				continue
+			}
+
+			hostCfg := *service
+			hostCfg.Host = host
+			if err := summarizeHost(&hostCfg, log.WithPrefix(host), action, &summary); err != nil {
+				return err
+			}
+		}
+	}
+	if len(hosts) == 0 {
+		return nil
+	}
+
+	if !isTerminal() {
+		return fmt.Errorf("%s needs confirmation, pass --yes to confirm the %s without a terminal", strings.Join(hosts, ", "), action)
+	}
+
+	fmt.Print(summary.String())
+	reader := bufio.NewReader(os.Stdin)
+	for _, host := range hosts {
+		fmt.Printf("\nType the host name %s to confirm the %s: ", host, action)
+		answer, _ := reader.ReadString('\n')
+		if strings.TrimSpace(answer) != host {
+			return fmt.Errorf("%s cancelled, %q does not match %s", action, strings.TrimSpace(answer), host)
+		}
+	}
+	return nil
+}
+
+// summarizeHost writes the container, the image change and, for a
+// deployment, the settings changed since the last deployment on a host
+func summarizeHost(cfg *config.Config, log *logger.Logger, action string, summary *strings.Builder) error {
+	fmt.Fprintf(summary, "\n%s of protected host %s:\n", strings.ToUpper(action[:1])+action[1:], cfg.Host)
+	fmt.Fprintf(summary, "  container  %s\n", cfg.ContainerName)
+
+	current := "(not deployed)"
+	exists, err := docker.Exists(cfg, log, cfg.ContainerName)
+	if err != nil {
+		return err
+	}
+	if exists {
+		container, err := inspectContainer(cfg, log, cfg.ContainerName)
+		if err != nil {
+			return err
+		}
+		current = container.Config.Image
+	}
+
+	target := cfg.ImageRef()
+	if action == "rollback" {
+		target = "the previous version"
+		if cfg.RollbackTo != "" {
+			target = cfg.Image + ":" + cfg.RollbackTo
+		}
+	}
+	fmt.Fprintf(summary, "  image      %s -> %s\n", current, target)
+
+	if action == "rollback" || !exists {
+		return nil
+	}
+
+	records, err := history.LoadSuccessful(cfg, log, 1, "deploy", "adopt")
+	if err != nil {
+		return err
+	}
+	_, changed := settingsChanges(cfg, records)
+	if len(changed) == 0 {
+		fmt.Fprintf(summary, "  settings   unchanged since the last recorded deployment\n")
+	}
+	for i, change := range changed {
+		label := ""
+		if i == 0 {
+			label = "settings"
+		}
+		fmt.Fprintf(summary, "  %-9s  %s\n", label, change)
+	}
+	return nil
+}
@@ -0,0 +1,212 @@
+package deploy
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/docker"
+	"github.com/bjarneo/pipe/internal/history"
+	"github.com/bjarneo/pipe/internal/logger"
+)
+
+// conflict describes how a running container differs from its last
+// recorded deployment
+type conflict struct {
+	// changes describes each difference, naming variables but not values
+	changes []string
+
+	// adoptable are the variables set or changed on the container by hand,
+	// with their values
+	adoptable map[string]string
+}
+
+// resolveConflicts looks for containers that were changed outside pipe
+// since their last deployment, such as a hotfix image or an environment
+// variable changed during an incident, and decides with --on-conflict, or by
+// asking, whether to overwrite the changes, adopt the changed variables into
+// the deployment or abort it. Without a terminal to ask on the changes are
+// overwritten with a warning.
+func resolveConflicts(cfg *config.Config, log *logger.Logger, services []config.Config) error {
+	if cfg.Target != "" {
+		return nil
+	}
+
+	for i := range services {
+		service := &services[i]
+		serviceLog := serviceLogger(log, service)
+		for _, host := range service.Hosts {
+			hostCfg := *service
+			hostCfg.Host = host
+			hostLog := serviceLog.WithPrefix(host)
+
+			found, err := findConflict(&hostCfg, hostLog)
+			if err != nil {
+				return err
+			}
+			if found == nil {
+				continue
+			}
+
+			hostLog.Warn(fmt.Sprintf("%s on %s was changed outside pipe since its last deployment: %s",
+				service.ContainerName, host, strings.Join(found.changes, "; ")))
+			if err := resolveConflict(service, hostLog, host, found); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// resolveConflict applies the answer to a single conflict
+func resolveConflict(cfg *config.Config, log *logger.Logger, host string, found *conflict) error {
+	answer := cfg.OnConflict
+	if answer == "" || answer == config.ConflictAsk {
+		if !isTerminal() {
+			log.Warn("Overwriting the changes, pass --on-conflict abort to stop the deployment instead")
+			return nil
+		}
+		answers := []string{config.ConflictOverwrite, config.ConflictAbort}
+		if len(found.adoptable) > 0 {
+			answers = []string{config.ConflictOverwrite, config.ConflictAdopt, config.ConflictAbort}
+		}
+		p := prompter{reader: bufio.NewReader(os.Stdin)}
+		answer = p.ask(strings.Join(answers, ", ")+"?", config.ConflictAbort, func(answer string) error {
+			if !slices.Contains(answers, answer) {
+				return fmt.Errorf("please answer %s", strings.Join(answers, ", "))
+			}
+			return nil
+		})
+	}
+
+	switch answer {
+	case config.ConflictAbort:
+		return fmt.Errorf("deployment aborted, %s on %s was changed outside pipe", cfg.ContainerName, host)
+	case config.ConflictAdopt:
+		return adoptChanges(cfg, log, found)
+	default:
+		log.Warn(fmt.Sprintf("Overwriting the changes to %s on %s", cfg.ContainerName, host))
+		return nil
+	}
+}
+
+// adoptChanges deploys the variables changed by hand along with the
+// configured environment, and writes them to an env file so they can be kept
+// in the configuration
+func adoptChanges(cfg *config.Config, log *logger.Logger, found *conflict) error {
+	if len(found.adoptable) == 0 {
+		log.Warn("No environment variables to adopt, overwriting the changes")
+		return nil
+	}
+
+	if cfg.Env == nil {
+		cfg.Env = make(map[string]string)
+	}
+	names := make([]string, 0, len(found.adoptable))
+	for name, value := range found.adoptable {
+		if cfg.Env[name] != value {
+			cfg.Env[name] = value
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	if len(names) == 0 {
+		return nil
+	}
+
+	env := make([]string, 0, len(names))
+	for _, name := range names {
+		env = append(env, name+"="+cfg.Env[name])
+	}
+	path := fmt.Sprintf(".env.%s.adopted", cfg.ContainerName)
+	if err := writeEnvFile(path, env); err != nil {
+		log.Warn(fmt.Sprintf("failed to write the adopted variables to %s: %v", path, err))
+		return log.Info(fmt.Sprintf("Adopted %s into this deployment", strings.Join(names, ", ")))
+	}
+	return log.Info(fmt.Sprintf("Adopted %s into this deployment and wrote them to %s, add it to envFiles in the config file to keep them",
+		strings.Join(names, ", "), path))
+}
+
+// findConflict compares the running container on a host with the last
+// successful deployment recorded there. It returns nil when the container
+// is missing, nothing was recorded or nothing changed.
+func findConflict(cfg *config.Config, log *logger.Logger) (*conflict, error) {
+	exists, err := docker.Exists(cfg, log, cfg.ContainerName)
+	if err != nil || !exists {
+		return nil, err
+	}
+
+	records, err := history.LoadSuccessful(cfg, log, 1, "deploy", "rollback", "adopt")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read deployment history: %v", err)
+	}
+	last := history.LastSuccessful(records, "deploy", "rollback", "adopt")
+	if last == nil {
+		return nil, nil
+	}
+
+	container, err := inspectContainer(cfg, log, cfg.ContainerName)
+	if err != nil {
+		return nil, err
+	}
+
+	found := manualChanges(last, container)
+	if len(found.changes) == 0 {
+		return nil, nil
+	}
+	return &found, nil
+}
+
+// manualChanges returns the differences between a recorded deployment and
+// the container running now. The environment is only compared when the
+// record has it.
+func manualChanges(last *history.Record, container containerInspect) conflict {
+	found := conflict{adoptable: make(map[string]string)}
+
+	switch {
+	case container.Config.Image != last.Ref():
+		found.changes = append(found.changes, fmt.Sprintf("runs image %s instead of %s", container.Config.Image, last.Ref()))
+	case last.ImageID != "" && container.Image != last.ImageID:
+		found.changes = append(found.changes, fmt.Sprintf("image %s was replaced since deployment %s", last.Ref(), last.ID))
+	}
+
+	if last.ContainerEnv == nil {
+		return found
+	}
+
+	current := history.EnvHashes(container.Config.Env)
+	var added, changed, removed []string
+	for _, variable := range container.Config.Env {
+		name, value, _ := strings.Cut(variable, "=")
+		recorded, ok := last.ContainerEnv[name]
+		switch {
+		case !ok:
+			added = append(added, name)
+		case recorded != current[name]:
+			changed = append(changed, name)
+		default:
+			continue
+		}
+		found.adoptable[name] = value
+	}
+	for name := range last.ContainerEnv {
+		if _, ok := current[name]; !ok {
+			removed = append(removed, name)
+		}
+	}
+	sort.Strings(removed)
+
+	for _, group := range []struct {
+		label string
+		names []string
+	}{{"added variables", added}, {"changed variables", changed}, {"removed variables", removed}} {
+		if len(group.names) > 0 {
+			found.changes = append(found.changes, fmt.Sprintf("%s %s", group.label, strings.Join(group.names, ", ")))
+		}
+	}
+	return found
+}
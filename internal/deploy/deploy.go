@@ -2,15 +2,21 @@ package deploy
 
 import (
 	"fmt"
+	"slices"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/bjarneo/pipe/internal/config"
 	"github.com/bjarneo/pipe/internal/docker"
+	"github.com/bjarneo/pipe/internal/history"
 	"github.com/bjarneo/pipe/internal/logger"
+	"github.com/bjarneo/pipe/internal/notify"
 	"github.com/bjarneo/pipe/internal/ssh"
 )
 
-// Deploy performs the main deployment process
+// Deploy performs the main deployment process. A stack is deployed one
+// service at a time, after the services it depends on.
 func Deploy(cfg *config.Config, log *logger.Logger) error {
 	// Log start of deployment
 	if err := log.Info("Starting deployment process"); err != nil {
@@ -18,142 +24,730 @@ func Deploy(cfg *config.Config, log *logger.Logger) error {
 	}
 
 	// Validate configuration
-	if err := cfg.Validate(); err != nil {
+	services, err := services(cfg, log)
+	if err != nil {
 		return err
 	}
+	if cfg.FailAt != "" {
+		log.Warn(fmt.Sprintf("--fail-at %s: the deployment will fail on purpose at this step", cfg.FailAt))
+	}
+
+	// Stand in for the hosts with local containers, which are removed again
+	// once the deployment is done. Built images are transferred to them
+	// rather than pushed to the registry.
+	if cfg.Target == config.TargetLocalDocker && !cfg.DryRun {
+		for i := range services {
+			services[i].Registry = ""
+		}
+		if err := log.Info("Deploying to local Docker-in-Docker targets instead of the configured hosts"); err != nil {
+			return err
+		}
+		target := docker.NewLocalTarget(cfg, log)
+		defer target.Close()
+		ctx := ssh.WithExecutor(cfg.Context(), target)
+		cfg = cfg.WithContext(ctx)
+		for i := range services {
+			services[i] = *services[i].WithContext(ctx)
+		}
+	}
 
+	// Print the commands instead of running them in a dry run, otherwise show
+	// the plan and ask for confirmation in interactive sessions. Secrets are
+	// only resolved for real deployments.
+	if cfg.DryRun {
+		ssh.SetDryRun(dryRunMasks(services)...)
+	} else if err := approvePlan(cfg, log, services); err != nil {
+		return err
+	} else if err := confirmProtected(cfg, log, "deployment", services); err != nil {
+		return err
+	} else if err := resolveConflicts(cfg, log, services); err != nil {
+		return err
+	} else if err := resolveSecrets(cfg, log, services); err != nil {
+		return err
+	}
+
+	if cfg.DryRun {
+		if err := deployServices(cfg, log, services); err != nil {
+			return err
+		}
+		return log.Info("Dry run completed, nothing was changed")
+	}
+
+	// Nobody is notified of a deployment to local targets
+	if cfg.Target != "" {
+		if err := deployServices(cfg, log, services); err != nil {
+			return err
+		}
+		return log.Info("Deployment to local targets completed, the configured hosts were not touched")
+	}
+
+	started := time.Now()
+	sendNotification(cfg, log, "deployment", notify.Started, services, started, nil)
+	err = deployServices(cfg, log, services)
+	if err != nil {
+		sendNotification(cfg, log, "deployment", notify.Failed, services, started, err)
+		sendReport(cfg, log, "deployment", services, started, err)
+		return err
+	}
+	sendNotification(cfg, log, "deployment", notify.Succeeded, services, started, nil)
+	sendReport(cfg, log, "deployment", services, started, nil)
+	pingMonitors(log, services)
+
+	return log.Info("Deployment completed successfully! 🚀")
+}
+
+// deployServices creates the missing accessories and deploys every service
+// in order, stopping at the first service that fails
+func deployServices(cfg *config.Config, log *logger.Logger, services []config.Config) error {
+	defer removeSBOMs(services)
+
+	// Create missing accessories before the apps that use them
+	if err := bootAccessories(cfg, log); err != nil {
+		return err
+	}
+
+	// Build every image of a stack before deploying, so builds run in
+	// parallel and a failing build leaves all services untouched
+	built := len(services) > 1
+	if built {
+		if i, err := buildServices(cfg, log, services); err != nil {
+			return stackError(services, max(i, 0), err)
+		}
+	}
+
+	for i := range services {
+		if err := deployApp(&services[i], serviceLogger(log, &services[i]), built); err != nil {
+			return stackError(services, i, err)
+		}
+	}
+
+	return nil
+}
+
+// deployApp builds and deploys a single app to all of its hosts. The build
+// is skipped when the image was already built.
+func deployApp(cfg *config.Config, log *logger.Logger, built bool) error {
 	// Preliminary checks
-	if err := docker.Check(cfg, log); err != nil {
+	if cfg.PrebuiltImage == "" && !built {
+		if err := docker.CheckLocal(cfg, log); err != nil {
+			return err
+		}
+	}
+
+	if err := forEachHost(cfg, log, checkHost); err != nil {
 		return err
 	}
 
-	if err := ssh.Check(cfg, log); err != nil {
+	if !built {
+		endGroup := log.Group("Build " + cfg.ImageRef())
+		err := resolvePlatform(cfg, log)
+		if err == nil {
+			err = runPreBuildHooks(cfg, log)
+		}
+		if err == nil {
+			err = timeStep(log, "build", func(log *logger.Logger) error {
+				return buildImage(cfg, log)
+			})
+		}
+		endGroup()
+		if err != nil {
+			forEachHost(cfg, log, func(cfg *config.Config, log *logger.Logger) error {
+				runFailureHooks(cfg, log, err)
+				return nil
+			})
+			return err
+		}
+	}
+
+	// Transfer and start the container on every host, once the migration
+	// succeeded
+	defer log.Group(fmt.Sprintf("Deploy %s to %s", cfg.ImageRef(), strings.Join(cfg.Hosts, ", ")))()
+	if err := migrate(cfg, log); err != nil {
 		return err
 	}
+	return deployHosts(cfg, log)
+}
+
+// buildImage builds the image, scans it when a scanner is configured,
+// generates its SBOM, pushes it to the registry and signs it, unless an
+// existing image is being deployed
+func buildImage(cfg *config.Config, log *logger.Logger) error {
+	if cfg.PrebuiltImage != "" {
+		if err := log.Info(fmt.Sprintf("Using pre-built image %s, skipping build", cfg.PrebuiltImage)); err != nil {
+			return err
+		}
+		if err := docker.Scan(cfg, log); err != nil {
+			return err
+		}
+		return generateSBOM(cfg, log)
+	}
 
 	// Build Docker image
-	if err := docker.Build(cfg, log); err != nil {
+	if cfg.SkipBuild {
+		if err := log.Info(fmt.Sprintf("Skipping build, using existing local image %s", cfg.ImageRef())); err != nil {
+			return err
+		}
+	} else if err := cfg.InjectFailure("build"); err != nil {
+		return err
+	} else if err := docker.Build(cfg, log); err != nil {
+		return err
+	}
+
+	// Scan the image before it leaves this machine
+	if err := docker.Scan(cfg, log); err != nil {
+		return err
+	}
+	if err := generateSBOM(cfg, log); err != nil {
 		return err
 	}
 
+	// Push the image once so every host can pull it. A multi-platform image
+	// was pushed by the build.
+	if cfg.Registry != "" && !cfg.MultiPlatform() {
+		if err := cfg.InjectFailure("push"); err != nil {
+			return err
+		}
+		if err := docker.Push(cfg, log); err != nil {
+			return err
+		}
+	}
+
+	// Sign the pushed image, the hosts verify the signature before starting it
+	return docker.Sign(cfg, log)
+}
+
+// checkHost checks SSH, Docker, the cgroup settings and the required ports
+// on a single host
+func checkHost(cfg *config.Config, log *logger.Logger) error {
+	if err := docker.CheckRemote(cfg, log); err != nil {
+		return err
+	}
+
+	if err := docker.CheckPlatform(cfg, log); err != nil {
+		return err
+	}
+
+	if err := docker.CheckCgroups(cfg, log); err != nil {
+		return err
+	}
+
+	if err := docker.CheckGPUs(cfg, log); err != nil {
+		return err
+	}
+
+	if err := ssh.Check(cfg, log); err != nil {
+		return err
+	}
+
+	return docker.CheckPorts(cfg, log)
+}
+
+// deployHost deploys to a single host and records the outcome in its history
+func deployHost(cfg *config.Config, log *logger.Logger) error {
+	unlock, err := docker.Lock(cfg, log, "deploy")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	err = deployContainer(cfg, log)
+	if err != nil {
+		runFailureHooks(cfg, log, err)
+	} else if err := docker.StoreSBOM(cfg, log); err != nil {
+		log.Warn(err.Error())
+	}
+	recordHistory(cfg, log, "deploy", err)
+	return err
+}
+
+// deployContainer transfers the image and deploys the container on a single host
+func deployContainer(cfg *config.Config, log *logger.Logger) error {
+	// A host without the container is a first deployment
+	deployed, err := docker.DeployedContainer(cfg, log)
+	if err != nil {
+		return err
+	}
+	exists := deployed != ""
+
+	// Warn if the previous deployment used different settings
+	if exists {
+		checkConfigDrift(cfg, log)
+	}
+
+	// Keep the agent from acting on the container while it is replaced, and
+	// remember the image it can roll back to
+	var previousImage string
+	if cfg.Agent.Enabled {
+		if exists {
+			container, err := inspectContainer(cfg, log, cfg.ContainerName)
+			if err != nil {
+				return err
+			}
+			previousImage = container.Config.Image
+		}
+
+		resume, err := docker.PauseAgent(cfg, log)
+		if err != nil {
+			return err
+		}
+		defer resume()
+	}
+
 	// Transfer Docker image
-	if err := docker.Transfer(cfg, log); err != nil {
+	if err := cfg.InjectFailure("transfer"); err != nil {
+		return err
+	}
+	if err := timeStep(log, "transfer", func(log *logger.Logger) error {
+		return docker.Transfer(cfg, log)
+	}); err != nil {
+		return err
+	}
+
+	// Refuse to start an image that is not signed as configured
+	if err := docker.VerifySignature(cfg, log); err != nil {
+		return err
+	}
+
+	if exists && cfg.SkipUnchanged {
+		same, err := unchanged(cfg, log)
+		if err != nil {
+			return err
+		}
+		if same {
+			return log.Info(fmt.Sprintf("%s already runs %s with the same settings on %s, leaving it running",
+				cfg.ContainerName, cfg.ImageRef(), cfg.Host))
+		}
+	}
+
+	if err := docker.PrepareState(cfg, log, cfg.EnvFile); err != nil {
+		return err
+	}
+
+	// Copy the environment files if there are any. Decrypted variables are
+	// only kept on the host until the container has started.
+	if envFiles := cfg.EnvFilePaths(); len(envFiles) > 0 {
+		if err := docker.CopyEnvFile(cfg, log, envFiles); err != nil {
+			return err
+		}
+		defer docker.RemoveEnvFile(cfg, log, envFiles)
+	}
+
+	// Place the copied files before the container mounts them
+	if err := docker.CopyFiles(cfg, log); err != nil {
 		return err
 	}
 
-	// Copy environment file if it exists
-	if cfg.EnvFile != "" {
-		if err := copyEnvFile(cfg, log); err != nil {
+	// Prepare the host the first time the app is deployed
+	if !exists {
+		if err := docker.Bootstrap(cfg, log); err != nil {
+			return err
+		}
+	}
+
+	// Install the system packages bind-mounted tooling needs on the host
+	if err := docker.InstallPackages(cfg, log); err != nil {
+		return err
+	}
+
+	// Run migrations and other preparation before the container is replaced
+	if err := cfg.InjectFailure("preDeploy"); err != nil {
+		return err
+	}
+	if err := timeStep(log, "preDeploy", func(log *logger.Logger) error {
+		return runHooks(cfg, log, "preDeploy", cfg.Hooks.PreDeploy, nil)
+	}); err != nil {
+		return err
+	}
+
+	// The proxy routes the domain to the container as soon as it starts
+	if cfg.Proxy.Enabled() {
+		if err := docker.RunProxy(cfg, log); err != nil {
 			return err
 		}
 	}
 
 	// Deploy container
-	if err := docker.Deploy(cfg, log); err != nil {
+	if err := timeStep(log, "start", func(log *logger.Logger) error {
+		return docker.Deploy(cfg, log)
+	}); err != nil {
 		return err
 	}
 
-	return log.Info("Deployment completed successfully! 🚀")
+	if err := cfg.InjectFailure("postDeploy"); err != nil {
+		return err
+	}
+	if err := timeStep(log, "postDeploy", func(log *logger.Logger) error {
+		return runHooks(cfg, log, "postDeploy", cfg.Hooks.PostDeploy, nil)
+	}); err != nil {
+		return err
+	}
+
+	if cfg.LogShipping.Enabled() {
+		if err := docker.ShipLogs(cfg, log); err != nil {
+			return err
+		}
+	}
+
+	if cfg.Metrics.Enabled {
+		if err := docker.RunMetrics(cfg, log); err != nil {
+			return err
+		}
+	}
+
+	if cfg.Agent.Enabled {
+		if err := docker.InstallAgent(cfg, log); err != nil {
+			return err
+		}
+		if err := docker.WriteAgentRollback(cfg, log, previousImage); err != nil {
+			return err
+		}
+	}
+
+	warnReboot(cfg, log)
+	return nil
+}
+
+// unchanged reports whether the running container on the host was started
+// from the same image, by ID, with the same settings and env file as the
+// deployment would start it
+func unchanged(cfg *config.Config, log *logger.Logger) (bool, error) {
+	// A deployment changing the number of replicas replaces the containers
+	if deployed, err := docker.DeployedContainer(cfg, log); err != nil || deployed != cfg.Containers()[0] {
+		return false, err
+	}
+	container, err := inspectContainer(cfg, log, cfg.Containers()[0])
+	if err != nil {
+		return false, err
+	}
+	if !container.State.Running || container.Config.Image != cfg.ImageRef() {
+		return false, nil
+	}
+
+	id, err := docker.ImageID(cfg, log, cfg.ImageRef())
+	if err != nil || id != container.Image {
+		return false, err
+	}
+
+	records, err := history.LoadSuccessful(cfg, log, 1, "deploy", "adopt")
+	if err != nil {
+		return false, fmt.Errorf("failed to read deployment history: %v", err)
+	}
+	last := history.LastSuccessful(records, "deploy", "adopt")
+	return last != nil && last.ConfigHash == cfg.Hash() && last.EnvFileChecksum == history.EnvFileChecksum(cfg.EnvFilePaths()), nil
 }
 
-// Rollback performs a rollback to the previous version
+// Rollback performs a rollback to the previous version. A stack is rolled
+// back in reverse deployment order.
 func Rollback(cfg *config.Config, log *logger.Logger) error {
+	if cfg.DryRun {
+		return fmt.Errorf("--dry-run is only supported for deployments")
+	}
+
 	if err := log.Info("Starting rollback process..."); err != nil {
 		return err
 	}
 
 	// Validate configuration
-	if err := cfg.Validate(); err != nil {
+	services, err := services(cfg, log)
+	if err != nil {
+		return err
+	}
+	slices.Reverse(services)
+
+	if cfg.RollbackTo != "" && len(services) > 1 {
+		return fmt.Errorf("--to needs a single service of the stack, choose one with --service")
+	}
+	if err := confirmProtected(cfg, log, "rollback", services); err != nil {
+		return err
+	}
+
+	started := time.Now()
+	sendNotification(cfg, log, "rollback", notify.Started, services, started, nil)
+	for i := range services {
+		endGroup := log.Group(fmt.Sprintf("Roll back %s on %s", services[i].ContainerName, strings.Join(services[i].Hosts, ", ")))
+		err := forEachHost(&services[i], serviceLogger(log, &services[i]), rollbackHost)
+		endGroup()
+		if err != nil {
+			err = stackError(services, i, err)
+			sendNotification(cfg, log, "rollback", notify.Failed, services, started, err)
+			sendReport(cfg, log, "rollback", services, started, err)
+			return err
+		}
+	}
+	sendNotification(cfg, log, "rollback", notify.Succeeded, services, started, nil)
+	sendReport(cfg, log, "rollback", services, started, nil)
+
+	return log.Info("Rollback completed successfully! 🔄")
+}
+
+// rollbackHost rolls back a single host and records the outcome in its history
+func rollbackHost(cfg *config.Config, log *logger.Logger) error {
+	unlock, err := docker.Lock(cfg, log, "rollback")
+	if err != nil {
 		return err
 	}
+	defer unlock()
+
+	target, err := rollbackContainer(cfg, log)
+
+	record := history.NewRecord(cfg, log, "rollback", err)
+	if target != "" {
+		record.ImageRef = target
+		record.Tag = strings.TrimPrefix(target, record.Image+":")
+	}
+	appendHistory(cfg, log, record)
+
+	return err
+}
 
+// rollbackContainer rolls back the container on a single host and returns
+// the image it rolled back to
+func rollbackContainer(cfg *config.Config, log *logger.Logger) (string, error) {
 	// Check SSH connection
 	if err := ssh.Check(cfg, log); err != nil {
-		return err
+		return "", err
+	}
+
+	// Make sure there is a container to roll back
+	deployed, err := docker.DeployedContainer(cfg, log)
+	if err != nil {
+		return "", err
+	}
+	if deployed == "" {
+		return "", fmt.Errorf("container %s not found on %s, nothing to roll back", cfg.ContainerName, cfg.Host)
+	}
+
+	if err := docker.PrepareState(cfg, log, cfg.EnvFile); err != nil {
+		return "", err
 	}
 
 	// Get current container image
-	getCurrentImageCmd := fmt.Sprintf("%s \"docker inspect --format='{{.Config.Image}}' %s\"",
-		ssh.GetCommand(cfg), cfg.ContainerName)
-	result, err := ssh.ExecuteCommand(log, getCurrentImageCmd, "Getting current container information")
+	getCurrentImageCmd := ssh.Command("docker", "inspect", "--format", "{{.Config.Image}}", deployed)
+	result, err := ssh.Run(cfg, log, getCurrentImageCmd, "Getting current container information")
 	if err != nil {
-		return fmt.Errorf("failed to get current container information: %v", err)
+		return "", fmt.Errorf("failed to get current container information: %v", err)
 	}
 	currentImage := strings.TrimSpace(result.Stdout)
 
-	// Get image history sorted by creation time
-	getImagesCmd := fmt.Sprintf("%s \"docker images %s --format '{{.Repository}}:{{.Tag}}___{{.CreatedAt}}' | sort -k2 -r\"",
-		ssh.GetCommand(cfg), cfg.Image)
-	history, err := ssh.ExecuteCommand(log, getImagesCmd, "Getting image history")
+	targetImage, err := rollbackTarget(cfg, log, currentImage)
 	if err != nil {
-		return fmt.Errorf("failed to get image history: %v", err)
+		return "", err
 	}
 
-	// Parse and sort images by creation time
-	images := strings.Split(strings.TrimSpace(history.Stdout), "\n")
-	if len(images) < 2 {
-		return fmt.Errorf("no previous version found to rollback to")
+	// Log the versions involved
+	if err := log.Info(fmt.Sprintf("Rolling back from %s to %s", currentImage, targetImage)); err != nil {
+		return "", err
 	}
 
-	// Find current image and get the next one
-	var previousImage string
-	for i, img := range images {
-		imageName := strings.Split(img, "___")[0]
-		if imageName == currentImage && i+1 < len(images) {
-			previousImage = strings.Split(images[i+1], "___")[0]
-			break
+	hookCfg := rollbackHookConfig(cfg, targetImage)
+	if err := timeStep(log, StepPreRollback, func(log *logger.Logger) error {
+		return runHooks(hookCfg, log, StepPreRollback, rollbackHooks(cfg.Hooks.PreRollback, currentImage), nil)
+	}); err != nil {
+		return "", err
+	}
+
+	if err := timeStep(log, "start", func(log *logger.Logger) error {
+		return performRollback(cfg, log, targetImage)
+	}); err != nil {
+		return targetImage, err
+	}
+
+	// Clean up backup container
+	if err := docker.Remove(cfg, log, backupName(cfg)); err != nil {
+		log.Warn(fmt.Sprintf("failed to clean up backup container: %v", err))
+	}
+
+	if err := timeStep(log, StepPostRollback, func(log *logger.Logger) error {
+		return runHooks(hookCfg, log, StepPostRollback, rollbackHooks(cfg.Hooks.PostRollback, currentImage), nil)
+	}); err != nil {
+		return targetImage, err
+	}
+
+	return targetImage, nil
+}
+
+// rollbackTarget returns the image to roll back to, based on the deployment
+// history of the app on the host: the version given with --to, or otherwise
+// the version deployed before the current one
+func rollbackTarget(cfg *config.Config, log *logger.Logger, currentImage string) (string, error) {
+	records, err := history.LoadSuccessful(cfg, log, 0, "deploy", "adopt", "rollback")
+	if err != nil {
+		return "", fmt.Errorf("failed to read deployment history: %v", err)
+	}
+
+	var target string
+	if cfg.RollbackTo != "" {
+		target = fmt.Sprintf("%s:%s", cfg.Repository(), cfg.RollbackTo)
+		for _, record := range records {
+			if record.Status == "success" && record.Tag == cfg.RollbackTo {
+				target = record.Ref()
+			}
+		}
+		if target == currentImage {
+			return "", fmt.Errorf("%s is already running on %s", target, cfg.Host)
+		}
+	} else {
+		target = history.Previous(records, currentImage)
+		if target == "" {
+			return "", fmt.Errorf("no previous version of %s recorded on %s, choose a version with --to (see 'pipe releases')",
+				currentImage, cfg.Host)
 		}
 	}
 
-	if previousImage == "" {
-		return fmt.Errorf("could not find previous version to rollback to")
+	if err := checkProvenance(cfg, log, records, target); err != nil {
+		return "", err
 	}
 
-	// Log the versions involved
-	if err := log.Info(fmt.Sprintf("Found previous version: %s", previousImage)); err != nil {
+	return target, nil
+}
+
+// checkProvenance checks that the rollback target is still on the host and is
+// the same image that was deployed under its reference, so a rollback never
+// starts an image that was replaced or tampered with since
+func checkProvenance(cfg *config.Config, log *logger.Logger, records []history.Record, target string) error {
+	id, err := docker.ImageID(cfg, log, target)
+	if err != nil {
 		return err
 	}
 
-	if err := performRollback(cfg, log, previousImage); err != nil {
-		return err
+	// Old images are cleaned up after a few releases
+	if id == "" && !ssh.DryRun() {
+		return fmt.Errorf("version %s is no longer available on %s, see 'pipe releases' for the versions kept on the host",
+			target, cfg.Host)
 	}
 
-	// Clean up backup container
-	cleanupCmd := fmt.Sprintf("%s \"docker rm %s_backup\"", ssh.GetCommand(cfg), cfg.ContainerName)
-	_, _ = ssh.ExecuteCommand(log, cleanupCmd, "Cleaning up backup container")
+	recorded := history.ImageID(records, target)
+	switch {
+	case ssh.DryRun():
+		return nil
+	case recorded == "":
+		log.Warn(fmt.Sprintf("No image ID recorded for %s, its provenance cannot be checked", target))
+		return nil
+	case recorded != id:
+		return fmt.Errorf("image %s on %s is %s, but %s was deployed, refusing to roll back to an image replaced since",
+			target, cfg.Host, shortID(id), shortID(recorded))
+	}
+	return log.Info(fmt.Sprintf("Image %s matches the recorded deployment", target))
+}
 
-	return log.Info("Rollback completed successfully! 🔄")
+// checkConfigDrift warns when the last successful deployment on the host was
+// done with materially different settings than the current configuration
+func checkConfigDrift(cfg *config.Config, log *logger.Logger) {
+	records, err := history.LoadSuccessful(cfg, log, 1, "deploy", "adopt")
+	if err != nil {
+		log.Warn(fmt.Sprintf("failed to read deployment history: %v", err))
+		return
+	}
+
+	id, changed := settingsChanges(cfg, records)
+	if len(changed) == 0 {
+		return
+	}
+
+	log.Warn(fmt.Sprintf("configuration drift detected, last deployment %s used different settings: %s",
+		id, strings.Join(changed, ", ")))
 }
 
-// copyEnvFile copies the environment file to the remote host
-func copyEnvFile(cfg *config.Config, log *logger.Logger) error {
-	copyEnvCmd := fmt.Sprintf("scp %s %s %s@%s:~/%s",
-		ssh.GetKeyFlag(cfg), cfg.EnvFile, cfg.User, cfg.Host, cfg.EnvFile)
-	_, err := ssh.ExecuteCommand(log, copyEnvCmd, "Copying environment file to server")
-	return err
+// settingsChanges returns the ID of the last successful deployment in the
+// records and the settings of the configuration that differ from it
+func settingsChanges(cfg *config.Config, records []history.Record) (string, []string) {
+	last := history.LastSuccessful(records, "deploy", "adopt")
+	if last == nil || last.ConfigHash == "" || last.ConfigHash == cfg.Hash() {
+		return "", nil
+	}
+
+	var changed []string
+	for key, value := range cfg.Settings() {
+		if last.Settings[key] != value {
+			changed = append(changed, fmt.Sprintf("%s (%q -> %q)", key, last.Settings[key], value))
+		}
+	}
+	sort.Strings(changed)
+	return last.ID, changed
+}
+
+// recordHistory stores the transcript of the run on the remote host. Failing
+// to record history does not fail the run.
+func recordHistory(cfg *config.Config, log *logger.Logger, action string, runErr error) {
+	appendHistory(cfg, log, history.NewRecord(cfg, log, action, runErr))
+}
+
+// appendHistory stores a record on the remote host, logging failures, and
+// adds it to the result document. The record is also stored when the run
+// was cancelled.
+func appendHistory(cfg *config.Config, log *logger.Logger, record history.Record) {
+	// The image ID lets a later rollback check the image was not replaced and
+	// tells the audit trail which image a failed run tried, and the
+	// environment lets a later deployment notice changes made by hand
+	var containerID string
+	if !ssh.DryRun() {
+		if id, err := docker.ImageID(cfg.Detached(), log, record.Ref()); err == nil {
+			record.ImageID = id
+		}
+	}
+	if record.Status == "success" && !ssh.DryRun() {
+		if container, err := inspectContainer(cfg.Detached(), log, cfg.Containers()[0]); err == nil {
+			record.ContainerEnv = history.EnvHashes(container.Config.Env)
+			containerID = container.ID
+		}
+	}
+	addHostResult(cfg, log, record, containerID)
+
+	if err := history.Append(cfg.Detached(), log, record); err != nil {
+		log.Warn(fmt.Sprintf("failed to record deployment history: %v", err))
+	}
 }
 
-// performRollback executes the rollback operation
+// backupName returns the name of the backup container used during rollbacks
+func backupName(cfg *config.Config) string {
+	return cfg.ContainerName + "_backup"
+}
+
+// performRollback executes the rollback operation. Replicas are rolled back
+// one at a time like a deployment.
 func performRollback(cfg *config.Config, log *logger.Logger, previousImage string) error {
-	envFileFlag := ""
-	if cfg.EnvFile != "" {
-		envFileFlag = fmt.Sprintf("--env-file ~/%s", cfg.EnvFile)
+	if cfg.Replicated() {
+		if envFiles := cfg.EnvFilePaths(); docker.EnvFileEncrypted(envFiles) {
+			if err := docker.CopyEnvFile(cfg, log, envFiles); err != nil {
+				return err
+			}
+			defer docker.RemoveEnvFile(cfg, log, envFiles)
+		}
+		return docker.RollReplicas(cfg, log, previousImage)
+	}
+
+	// Encrypted env files are not kept on the host after a deployment. They
+	// are decrypted before the current container is stopped, so a missing
+	// key does not leave the host without the app.
+	if envFiles := cfg.EnvFilePaths(); docker.EnvFileEncrypted(envFiles) {
+		if err := docker.CopyEnvFile(cfg, log, envFiles); err != nil {
+			return err
+		}
+		defer docker.RemoveEnvFile(cfg, log, envFiles)
+	}
+
+	// Remove a backup left behind by an earlier failed rollback
+	if err := docker.Remove(cfg, log, backupName(cfg)); err != nil {
+		return err
+	}
+
+	// Stop and rename current container (for backup)
+	if err := docker.Stop(cfg, log, cfg.ContainerName); err != nil {
+		return err
 	}
 
-	rollbackCommands := strings.Join([]string{
-		// Stop and rename current container (for backup)
-		fmt.Sprintf("docker stop %s", cfg.ContainerName),
-		fmt.Sprintf("docker rename %s %s_backup", cfg.ContainerName, cfg.ContainerName),
+	if err := docker.Rename(cfg, log, cfg.ContainerName, backupName(cfg)); err != nil {
+		if startErr := docker.Start(cfg, log, cfg.ContainerName); startErr != nil {
+			return fmt.Errorf("%v (restarting current version also failed: %v)", err, startErr)
+		}
+		return err
+	}
 
-		// Start container with previous version
-		fmt.Sprintf("docker run -d --name %s --restart unless-stopped -p %s:%s %s %s",
-			cfg.ContainerName, cfg.HostPort, cfg.ContainerPort,
-			envFileFlag, previousImage),
-	}, " && ")
+	// Start the previous version with the same options as a deployment
+	runCmd := docker.RunCommand(cfg, previousImage)
 
 	// Execute rollback
-	rollbackCmd := fmt.Sprintf("%s \"%s\"", ssh.GetCommand(cfg), rollbackCommands)
-	if _, err := ssh.ExecuteCommand(log, rollbackCmd, "Rolling back to previous version"); err != nil {
+	if _, err := ssh.Run(cfg, log, runCmd, "Rolling back to previous version"); err != nil {
 		// If rollback fails, attempt to restore the backup
 		if restoreErr := restoreBackup(cfg, log); restoreErr != nil {
 			return fmt.Errorf("rollback failed and restore failed: %v (original error: %v)", restoreErr, err)
@@ -162,14 +756,7 @@ func performRollback(cfg *config.Config, log *logger.Logger, previousImage strin
 	}
 
 	// Verify new container is running
-	verifyCmd := fmt.Sprintf("%s \"docker ps --filter name=%s --format '{{.Status}}'\"",
-		ssh.GetCommand(cfg), cfg.ContainerName)
-	result, err := ssh.ExecuteCommand(log, verifyCmd, "Verifying rollback container status")
-	if err != nil {
-		return err
-	}
-
-	if !strings.Contains(result.Stdout, "Up") {
+	if err := docker.Verify(cfg, log); err != nil {
 		// If verification fails, attempt to restore the backup
 		if restoreErr := restoreBackup(cfg, log); restoreErr != nil {
 			return fmt.Errorf("rollback verification failed and restore failed: %v", restoreErr)
@@ -180,11 +767,21 @@ func performRollback(cfg *config.Config, log *logger.Logger, previousImage strin
 	return nil
 }
 
-// restoreBackup attempts to restore the backup container
+// restoreBackup attempts to restore the backup container, also when the
+// rollback was cancelled
 func restoreBackup(cfg *config.Config, log *logger.Logger) error {
-	restoreCmd := fmt.Sprintf("%s \"docker stop %s || true && docker rm %s || true && docker rename %s_backup %s && docker start %s\"",
-		ssh.GetCommand(cfg), cfg.ContainerName, cfg.ContainerName,
-		cfg.ContainerName, cfg.ContainerName, cfg.ContainerName)
-	_, err := ssh.ExecuteCommand(log, restoreCmd, "Restoring previous version after failed rollback")
-	return err
-} 
\ No newline at end of file
+	cfg = cfg.Detached()
+	if err := log.Info("Restoring previous version after failed rollback"); err != nil {
+		return err
+	}
+
+	if err := docker.Remove(cfg, log, cfg.ContainerName); err != nil {
+		return err
+	}
+
+	if err := docker.Rename(cfg, log, backupName(cfg), cfg.ContainerName); err != nil {
+		return err
+	}
+
+	return docker.Start(cfg, log, cfg.ContainerName)
+}
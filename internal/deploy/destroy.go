@@ -0,0 +1,112 @@
+package deploy
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/docker"
+	"github.com/bjarneo/pipe/internal/logger"
+	"github.com/bjarneo/pipe/internal/ssh"
+)
+
+// Destroy removes the app from every host: its containers, release images,
+// scheduled jobs, agent and state directory with the env file and history,
+// and with --volumes its named volumes and network. It asks to confirm by
+// typing the container name, unless --yes is set.
+func Destroy(cfg *config.Config, log *logger.Logger) error {
+	// Validate configuration
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	if err := confirmDestroy(cfg); err != nil {
+		return err
+	}
+
+	if err := forEachHost(cfg, log, destroyHost); err != nil {
+		return err
+	}
+	return log.Info(fmt.Sprintf("Destroyed %s on %s", cfg.ContainerName, strings.Join(cfg.Hosts, ", ")))
+}
+
+// confirmDestroy lists what is removed and asks to type the container name.
+// Without a terminal to ask on, the command fails unless --yes is set.
+func confirmDestroy(cfg *config.Config) error {
+	if cfg.Yes {
+		return nil
+	}
+	if !isTerminal() {
+		return fmt.Errorf("destroy needs confirmation, pass --yes to destroy %s without a terminal", cfg.ContainerName)
+	}
+
+	fmt.Printf("\nDestroy %s on %s:\n", cfg.ContainerName, strings.Join(cfg.Hosts, ", "))
+	fmt.Printf("  - stop and remove its containers, replicas and scheduled jobs\n")
+	fmt.Printf("  - remove all release images of %s\n", cfg.Repository())
+	fmt.Printf("  - remove %s with the env file, history and backups\n", cfg.StateDir())
+	if cfg.Agent.Enabled {
+		fmt.Printf("  - remove the agent service\n")
+	}
+	if cfg.DestroyStorage {
+		fmt.Printf("  - remove its named volumes and network, with the data in them\n")
+	}
+
+	fmt.Printf("\nType the container name %s to confirm: ", cfg.ContainerName)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	if strings.TrimSpace(answer) != cfg.ContainerName {
+		return fmt.Errorf("destroy cancelled, %q does not match %s", strings.TrimSpace(answer), cfg.ContainerName)
+	}
+	return nil
+}
+
+// destroyHost removes the app from a single host. The deploy lock keeps a
+// deployment from starting the app again halfway, and goes with the state
+// directory.
+func destroyHost(cfg *config.Config, log *logger.Logger) error {
+	if err := ssh.Check(cfg, log); err != nil {
+		return err
+	}
+
+	unlock, err := docker.Lock(cfg, log, "destroy")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// Scheduled jobs would start new containers from the removed image
+	removeCmd := fmt.Sprintf("if command -v crontab >/dev/null; then crontab -l 2>/dev/null | %s | crontab -; fi",
+		ssh.Command("awk", "-v", "m= "+cronMarker(cfg, ""), "index($0, m) == 0"))
+	if _, err := ssh.Run(cfg, log, removeCmd, "Removing scheduled jobs"); err != nil {
+		return fmt.Errorf("failed to remove scheduled jobs: %v", err)
+	}
+
+	if cfg.Agent.Enabled {
+		if err := docker.RemoveAgent(cfg, log); err != nil {
+			return err
+		}
+	}
+
+	containers, err := docker.AppContainers(cfg, log)
+	if err != nil {
+		return err
+	}
+	for _, name := range containers {
+		if err := docker.Remove(cfg, log, name); err != nil {
+			return err
+		}
+	}
+
+	if err := docker.RemoveReleases(cfg, log); err != nil {
+		return err
+	}
+
+	if cfg.DestroyStorage {
+		if err := docker.RemoveStorage(cfg, log); err != nil {
+			return err
+		}
+	}
+
+	return docker.RemoveState(cfg, log)
+}
@@ -0,0 +1,282 @@
+package deploy
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/docker"
+	"github.com/bjarneo/pipe/internal/logger"
+	"github.com/bjarneo/pipe/internal/ssh"
+)
+
+// hostInventory is everything docker runs or keeps on a host
+type hostInventory struct {
+	Host       string                `json:"host"`
+	Containers []discoveredContainer `json:"containers"`
+	Images     []discoveredImage     `json:"images"`
+	Networks   []discoveredNetwork   `json:"networks"`
+	Volumes    []discoveredVolume    `json:"volumes"`
+	Error      string                `json:"error,omitempty"`
+}
+
+// discoveredContainer is a container found on a host
+type discoveredContainer struct {
+	Name     string   `json:"name"`
+	Image    string   `json:"image"`
+	Status   string   `json:"status"`
+	Ports    string   `json:"ports,omitempty"`
+	Networks []string `json:"networks,omitempty"`
+	Mounts   []string `json:"mounts,omitempty"`
+	Managed  bool     `json:"managed"`
+}
+
+// discoveredImage is an image found on a host
+type discoveredImage struct {
+	Image   string `json:"image"`
+	ID      string `json:"id"`
+	Size    string `json:"size"`
+	Created string `json:"created"`
+	Managed bool   `json:"managed"`
+}
+
+// discoveredNetwork is a docker network found on a host
+type discoveredNetwork struct {
+	Name       string   `json:"name"`
+	Driver     string   `json:"driver"`
+	Containers []string `json:"containers,omitempty"`
+	Managed    bool     `json:"managed"`
+}
+
+// discoveredVolume is a volume found on a host
+type discoveredVolume struct {
+	Name       string   `json:"name"`
+	Driver     string   `json:"driver"`
+	Containers []string `json:"containers,omitempty"`
+	Managed    bool     `json:"managed"`
+}
+
+// Discover reports the containers, images, networks and volumes on every
+// configured host, flagging the ones pipe manages
+func Discover(cfg *config.Config, log *logger.Logger) error {
+	// Validate configuration
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	// Keep stdout clean for scripts
+	if cfg.JSON {
+		log.SetQuiet(true)
+	}
+
+	inventories := make([]hostInventory, len(cfg.Hosts))
+	err := forEachHost(cfg, log, func(hostCfg *config.Config, hostLog *logger.Logger) error {
+		inventory, err := discoverHost(hostCfg, hostLog)
+		if err != nil {
+			inventory.Error = err.Error()
+		}
+		inventories[slices.Index(cfg.Hosts, hostCfg.Host)] = inventory
+		return err
+	})
+
+	if cfg.JSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if encodeErr := encoder.Encode(inventories); encodeErr != nil {
+			return encodeErr
+		}
+		return err
+	}
+
+	for i, inventory := range inventories {
+		if i > 0 {
+			fmt.Println()
+		}
+		printInventory(inventory)
+	}
+	return err
+}
+
+// discoverHost lists what docker runs or keeps on a single host. Containers
+// with a state directory, their blue-green candidates and the sidecars are
+// managed by pipe, and so are the images, networks and volumes they use.
+func discoverHost(cfg *config.Config, log *logger.Logger) (hostInventory, error) {
+	inventory := hostInventory{Host: cfg.Host}
+
+	// Every app and accessory pipe deployed has a state directory named after it
+	stateCmd := "ls -1 " + config.StateRoot + " 2>/dev/null || true"
+	stateDirs, err := captureLines(cfg, log, stateCmd, "Listing pipe state")
+	if err != nil {
+		return inventory, err
+	}
+
+	containerCmd := ssh.Command("docker", "ps", "-a", "--no-trunc", "--format",
+		"{{.Names}}\t{{.Image}}\t{{.Status}}\t{{.Ports}}\t{{.Networks}}\t{{.Mounts}}\t{{.Label \""+docker.SidecarLabel+"\"}}")
+	lines, err := captureLines(cfg, log, containerCmd, "Listing containers")
+	if err != nil {
+		return inventory, err
+	}
+
+	managedRepositories := make(map[string]bool)
+	for _, line := range lines {
+		fields := strings.Split(line, "\t")
+		if len(fields) != 7 {
+			continue
+		}
+		container := discoveredContainer{
+			Name:     fields[0],
+			Image:    fields[1],
+			Status:   fields[2],
+			Ports:    fields[3],
+			Networks: splitList(fields[4]),
+			Mounts:   splitList(fields[5]),
+		}
+		container.Managed = fields[6] != "" ||
+			slices.Contains(stateDirs, container.Name) ||
+			slices.Contains(stateDirs, strings.TrimSuffix(container.Name, "_next"))
+		if container.Managed {
+			managedRepositories[imageRepository(container.Image)] = true
+		}
+		inventory.Containers = append(inventory.Containers, container)
+	}
+
+	imageCmd := ssh.Command("docker", "images", "--format", "{{.Repository}}\t{{.Tag}}\t{{.ID}}\t{{.Size}}\t{{.CreatedSince}}")
+	lines, err = captureLines(cfg, log, imageCmd, "Listing images")
+	if err != nil {
+		return inventory, err
+	}
+	for _, line := range lines {
+		fields := strings.Split(line, "\t")
+		if len(fields) != 5 {
+			continue
+		}
+		inventory.Images = append(inventory.Images, discoveredImage{
+			Image:   fields[0] + ":" + fields[1],
+			ID:      fields[2],
+			Size:    fields[3],
+			Created: fields[4],
+			Managed: managedRepositories[fields[0]],
+		})
+	}
+
+	networkCmd := ssh.Command("docker", "network", "ls", "--format", "{{.Name}}\t{{.Driver}}")
+	lines, err = captureLines(cfg, log, networkCmd, "Listing networks")
+	if err != nil {
+		return inventory, err
+	}
+	for _, line := range lines {
+		name, driver, _ := strings.Cut(line, "\t")
+		network := discoveredNetwork{Name: name, Driver: driver}
+		for _, container := range inventory.Containers {
+			if slices.Contains(container.Networks, name) {
+				network.Containers = append(network.Containers, container.Name)
+				// The networks docker creates itself are shared by everything
+				network.Managed = network.Managed || (container.Managed && !slices.Contains([]string{"bridge", "host", "none"}, name))
+			}
+		}
+		inventory.Networks = append(inventory.Networks, network)
+	}
+
+	volumeCmd := ssh.Command("docker", "volume", "ls", "--format", "{{.Name}}\t{{.Driver}}")
+	lines, err = captureLines(cfg, log, volumeCmd, "Listing volumes")
+	if err != nil {
+		return inventory, err
+	}
+	for _, line := range lines {
+		name, driver, _ := strings.Cut(line, "\t")
+		volume := discoveredVolume{Name: name, Driver: driver}
+		for _, container := range inventory.Containers {
+			if slices.Contains(container.Mounts, name) {
+				volume.Containers = append(volume.Containers, container.Name)
+				volume.Managed = volume.Managed || container.Managed
+			}
+		}
+		inventory.Volumes = append(inventory.Volumes, volume)
+	}
+
+	return inventory, nil
+}
+
+// captureLines runs a read-only command on the host and returns the lines of
+// its output
+func captureLines(cfg *config.Config, log *logger.Logger, command string, description string) ([]string, error) {
+	result, err := ssh.Capture(cfg, log, command, description)
+	if err != nil {
+		return nil, fmt.Errorf("%s failed: %v", strings.ToLower(description[:1])+description[1:], err)
+	}
+
+	var lines []string
+	for _, line := range strings.Split(result.Stdout, "\n") {
+		// Trailing tabs separate empty fields, so only blank lines are dropped
+		if strings.TrimSpace(line) != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, nil
+}
+
+// splitList splits a comma-separated list printed by docker
+func splitList(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, ",")
+}
+
+// imageRepository returns the repository of an image reference without its
+// tag or digest
+func imageRepository(image string) string {
+	image, _, _ = strings.Cut(image, "@")
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		image = image[:i]
+	}
+	return image
+}
+
+// printInventory prints the inventory of a host as tables, marking what pipe
+// manages with an asterisk
+func printInventory(inventory hostInventory) {
+	fmt.Println(inventory.Host)
+	if inventory.Error != "" {
+		fmt.Printf("  error: %s\n", inventory.Error)
+		return
+	}
+
+	mark := func(managed bool) string {
+		if managed {
+			return "*"
+		}
+		return " "
+	}
+	users := func(containers []string) string {
+		if len(containers) == 0 {
+			return "unused"
+		}
+		return strings.Join(containers, ", ")
+	}
+
+	fmt.Printf("\n  %-1s %-24s  %-36s  %-28s  %s\n", "", "CONTAINER", "IMAGE", "STATUS", "PORTS")
+	for _, container := range inventory.Containers {
+		fmt.Printf("  %s %-24s  %-36s  %-28s  %s\n", mark(container.Managed), container.Name, container.Image, container.Status, container.Ports)
+	}
+
+	fmt.Printf("\n  %-1s %-50s  %-12s  %-10s  %s\n", "", "IMAGE", "ID", "SIZE", "CREATED")
+	for _, image := range inventory.Images {
+		fmt.Printf("  %s %-50s  %-12s  %-10s  %s\n", mark(image.Managed), image.Image, image.ID, image.Size, image.Created)
+	}
+
+	fmt.Printf("\n  %-1s %-24s  %-10s  %s\n", "", "NETWORK", "DRIVER", "CONTAINERS")
+	for _, network := range inventory.Networks {
+		fmt.Printf("  %s %-24s  %-10s  %s\n", mark(network.Managed), network.Name, network.Driver, users(network.Containers))
+	}
+
+	fmt.Printf("\n  %-1s %-36s  %-10s  %s\n", "", "VOLUME", "DRIVER", "CONTAINERS")
+	for _, volume := range inventory.Volumes {
+		fmt.Printf("  %s %-36s  %-10s  %s\n", mark(volume.Managed), volume.Name, volume.Driver, users(volume.Containers))
+	}
+
+	fmt.Println("\n  * managed by pipe")
+}
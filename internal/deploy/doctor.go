@@ -0,0 +1,129 @@
+package deploy
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/docker"
+	"github.com/bjarneo/pipe/internal/logger"
+	"github.com/bjarneo/pipe/internal/ssh"
+)
+
+// doctorCheck is a single item checked by the doctor command. It returns the
+// problems found, and can optionally fix them.
+type doctorCheck struct {
+	name  string
+	check func(cfg *config.Config, log *logger.Logger) ([]string, error)
+	fix   func(cfg *config.Config, log *logger.Logger) error
+}
+
+// doctorChecks are run in order, stopping at the first check that errors
+var doctorChecks = []doctorCheck{
+	{
+		name: "SSH connection",
+		check: func(cfg *config.Config, log *logger.Logger) ([]string, error) {
+			return nil, ssh.Check(cfg, log)
+		},
+	},
+	{
+		name: "Docker installation",
+		check: func(cfg *config.Config, log *logger.Logger) ([]string, error) {
+			return nil, docker.CheckRemote(cfg, log)
+		},
+	},
+	{
+		name: "Host platform",
+		check: func(cfg *config.Config, log *logger.Logger) ([]string, error) {
+			return nil, docker.CheckPlatform(cfg, log)
+		},
+	},
+	{
+		name: "GPU support",
+		check: func(cfg *config.Config, log *logger.Logger) ([]string, error) {
+			return nil, docker.CheckGPUs(cfg, log)
+		},
+	},
+	{
+		name:  "Restart on reboot",
+		check: docker.RebootProblems,
+		fix:   docker.FixReboot,
+	},
+	{
+		name:  "System packages",
+		check: docker.PackageProblems,
+		fix:   docker.InstallPackages,
+	},
+	{
+		name:  "Unattended upgrades",
+		check: docker.UpdateProblems,
+		fix:   docker.ConfigureUpdates,
+	},
+}
+
+// Doctor checks that every host is set up to run the app, and fixes the
+// problems it can when --fix is set
+func Doctor(cfg *config.Config, log *logger.Logger) error {
+	// Validate configuration
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	return forEachHost(cfg, log, doctorHost)
+}
+
+// doctorHost runs every check against a single host
+func doctorHost(cfg *config.Config, log *logger.Logger) error {
+	failed := 0
+	for _, item := range doctorChecks {
+		problems, err := item.check(cfg, log)
+		if err != nil {
+			log.Output(fmt.Sprintf("✗ %s: %v", item.name, err))
+			return fmt.Errorf("%s check failed", strings.ToLower(item.name))
+		}
+
+		if len(problems) > 0 && cfg.Fix && item.fix != nil {
+			if err := item.fix(cfg, log); err != nil {
+				log.Output(fmt.Sprintf("✗ %s: fix failed: %v", item.name, err))
+				failed++
+				continue
+			}
+			if problems, err = item.check(cfg, log); err != nil {
+				return err
+			}
+		}
+
+		if len(problems) == 0 {
+			log.Output(fmt.Sprintf("✓ %s", item.name))
+			continue
+		}
+
+		failed++
+		for _, problem := range problems {
+			log.Output(fmt.Sprintf("✗ %s: %s", item.name, problem))
+		}
+		if item.fix != nil && !cfg.Fix {
+			log.Output("  run 'pipe doctor --fix' to fix this")
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d checks failed", failed, len(doctorChecks))
+	}
+
+	return nil
+}
+
+// warnReboot warns when the deployed container would not come back after the
+// host reboots. Failing to check does not fail the deployment.
+func warnReboot(cfg *config.Config, log *logger.Logger) {
+	problems, err := docker.RebootProblems(cfg, log)
+	if err != nil {
+		log.Warn(fmt.Sprintf("failed to check restart on reboot: %v", err))
+		return
+	}
+
+	for _, problem := range problems {
+		log.Warn(fmt.Sprintf("%s, run 'pipe doctor --fix' to fix this", problem))
+	}
+}
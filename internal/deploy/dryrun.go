@@ -0,0 +1,32 @@
+package deploy
+
+import (
+	"regexp"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/ssh"
+)
+
+// secretName matches names of build arguments that hold secrets
+var secretName = regexp.MustCompile(`(?i)pass|secret|token|key|credential|auth`)
+
+// maskedValue replaces secrets in the commands printed by a dry run
+const maskedValue = "****"
+
+// dryRunMasks returns the replacement pairs hiding the secrets of every
+// service in dry run output: the values of environment variables and of build
+// arguments with secret-looking names
+func dryRunMasks(services []config.Config) []string {
+	var masks []string
+	for _, service := range services {
+		for key, value := range service.Env {
+			masks = append(masks, "-e "+ssh.Command(key+"="+value), "-e "+ssh.Command(key+"="+maskedValue))
+		}
+		for key, value := range service.BuildArgs {
+			if secretName.MatchString(key) {
+				masks = append(masks, ssh.Command("--build-arg", key+"="+value), ssh.Command("--build-arg", key+"="+maskedValue))
+			}
+		}
+	}
+	return masks
+}
@@ -0,0 +1,35 @@
+package deploy
+
+import (
+	"fmt"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/logger"
+	"github.com/bjarneo/pipe/internal/ssh"
+)
+
+// Exec runs a command inside the running container, or its first replica.
+// From an interactive terminal the command gets a TTY, so a shell can be
+// opened in the container.
+func Exec(cfg *config.Config, log *logger.Logger, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: pipe exec [options] -- <command> [args...]")
+	}
+
+	// Validate configuration
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	if cfg.TTY && isTerminal() {
+		if len(cfg.Hosts) > 1 {
+			return fmt.Errorf("an interactive exec needs a single host, choose one with --host or pass --tty=false")
+		}
+		return ssh.Interactive(cfg, log, ssh.Command(append([]string{"docker", "exec", "-it", cfg.Containers()[0]}, args...)...))
+	}
+
+	execCmd := ssh.Command(append([]string{"docker", "exec", cfg.Containers()[0]}, args...)...)
+	return forEachHost(cfg, log, func(cfg *config.Config, log *logger.Logger) error {
+		return ssh.Stream(cfg, log, execCmd, fmt.Sprintf("Running command in %s", cfg.Containers()[0]))
+	})
+}
@@ -0,0 +1,87 @@
+package deploy
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/logger"
+	"github.com/bjarneo/pipe/internal/ssh"
+)
+
+// Fleet runs operational commands across every host of the inventory: the
+// hosts of the app, the services of its stack and its accessories
+func Fleet(cfg *config.Config, log *logger.Logger, args []string) error {
+	if len(args) < 2 || args[0] != "exec" {
+		return fmt.Errorf("usage: pipe fleet exec [options] -- <command>")
+	}
+	if cfg.Parallel < 1 {
+		return fmt.Errorf("invalid --parallel %d: expected at least 1", cfg.Parallel)
+	}
+
+	inventory, err := cfg.Inventory()
+	if err != nil {
+		return err
+	}
+	for i := range inventory {
+		if err := inventory[i].Validate(); err != nil {
+			return err
+		}
+	}
+
+	return fleetExec(inventory, log, strings.Join(args[1:], " "), cfg.Parallel)
+}
+
+// fleetExec runs a shell command on every host, at most parallel hosts at a
+// time. The output of each host is printed as one block once it finishes, so
+// the output of different hosts does not interleave.
+func fleetExec(inventory []config.Config, log *logger.Logger, command string, parallel int) error {
+	remoteCmd := ssh.Command("sh", "-c", command) + " 2>&1"
+
+	failed := make([]bool, len(inventory))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, parallel)
+
+	for i := range inventory {
+		wg.Add(1)
+		go func(i int, cfg *config.Config) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			var output strings.Builder
+			result, err := ssh.RunWithOutput(cfg, log.WithPrefix(cfg.Host), remoteCmd,
+				fmt.Sprintf("Running command on %s", cfg.Host), &output)
+
+			mu.Lock()
+			defer mu.Unlock()
+			var status string
+			if err != nil {
+				status = fmt.Sprintf("FAILED: %v", err)
+				failed[i] = true
+			} else {
+				status = fmt.Sprintf("ok in %s", result.Duration.Round(time.Millisecond))
+			}
+			fmt.Printf("\n==> %s (%s)\n", cfg.Host, status)
+			if text := strings.TrimRight(output.String(), "\n"); text != "" {
+				fmt.Println(text)
+			}
+		}(i, &inventory[i])
+	}
+	wg.Wait()
+
+	var failures []string
+	for i := range inventory {
+		if failed[i] {
+			failures = append(failures, inventory[i].Host)
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("command failed on %d of %d hosts: %s", len(failures), len(inventory), strings.Join(failures, ", "))
+	}
+
+	return log.Info(fmt.Sprintf("Command completed on all %d hosts", len(inventory)))
+}
@@ -0,0 +1,77 @@
+package deploy
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/history"
+	"github.com/bjarneo/pipe/internal/logger"
+	"github.com/bjarneo/pipe/internal/ssh"
+)
+
+// History prints the audit trail of the deployments and rollbacks on each
+// host, newest first
+func History(cfg *config.Config, log *logger.Logger) error {
+	// Validate configuration
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	if cfg.HistoryLimit < 0 {
+		return fmt.Errorf("invalid limit %d: expected 0 or more", cfg.HistoryLimit)
+	}
+
+	return forEachHost(cfg, log, showHistory)
+}
+
+// showHistory prints the most recent records of the deployment history of a
+// host with who ran them, from where, the image and the result
+func showHistory(cfg *config.Config, log *logger.Logger) error {
+	if err := ssh.Check(cfg, log); err != nil {
+		return err
+	}
+
+	records, err := history.LoadRecent(cfg, log, cfg.HistoryLimit)
+	if err != nil {
+		return err
+	}
+
+	if cfg.JSON {
+		if records == nil {
+			records = []history.Record{}
+		}
+		data, err := json.MarshalIndent(records, "", "  ")
+		if err != nil {
+			return err
+		}
+		log.Output(string(data))
+		return nil
+	}
+
+	if len(records) == 0 {
+		log.Output("No deployments recorded yet")
+		return nil
+	}
+
+	log.Output(fmt.Sprintf("%-23s  %-8s  %-7s  %-8s  %-24s  %-12s  %s",
+		"TIME", "ACTION", "STATUS", "DURATION", "DEPLOYER", "IMAGE ID", "IMAGE"))
+	for i := len(records) - 1; i >= 0; i-- {
+		record := records[i]
+		deployer, imageID := record.Deployer, shortID(record.ImageID)
+		if deployer == "" {
+			deployer = "unknown"
+		}
+		if imageID == "" {
+			imageID = "-"
+		}
+		log.Output(fmt.Sprintf("%-23s  %-8s  %-7s  %-8s  %-24s  %-12s  %s",
+			record.Timestamp.Format("2006-01-02 15:04:05 MST"), record.Action, record.Status,
+			record.Duration.Round(time.Second), deployer, imageID, record.Ref()))
+		if record.Error != "" {
+			log.Output("  " + record.Error)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,115 @@
+package deploy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/logger"
+	"github.com/bjarneo/pipe/internal/ssh"
+)
+
+// hookInput is the JSON hook executables receive on stdin: the hook point,
+// the host and outcome so far, the timings of the steps that ran and the
+// configuration, with secrets redacted
+type hookInput struct {
+	Hook   string          `json:"hook"`
+	Host   string          `json:"host,omitempty"`
+	Image  string          `json:"image"`
+	Error  string          `json:"error,omitempty"`
+	Steps  []logger.Timing `json:"steps"`
+	Config json.RawMessage `json:"config"`
+}
+
+// hookExecutables returns the executables of a hook point in the hooks
+// directory: the file named after the point, followed by the files in the
+// directory of the same name with a .d suffix, in name order. Files that
+// are not executable are skipped with a warning.
+func hookExecutables(cfg *config.Config, log *logger.Logger, point string) []string {
+	dir := cfg.HookPath()
+	candidates := []string{filepath.Join(dir, point)}
+	if entries, err := os.ReadDir(filepath.Join(dir, point+".d")); err == nil {
+		names := make([]string, 0, len(entries))
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			candidates = append(candidates, filepath.Join(dir, point+".d", name))
+		}
+	}
+
+	var executables []string
+	for _, path := range candidates {
+		info, err := os.Stat(path)
+		if err != nil || info.IsDir() {
+			continue
+		}
+		if !config.IsLocalExecutable(path, info) {
+			if runtime.GOOS == "windows" {
+				log.Warn(fmt.Sprintf("skipping hook %s, expected a .exe, .cmd, .bat or .ps1 file, or sh on the PATH", path))
+			} else {
+				log.Warn(fmt.Sprintf("skipping hook %s, it is not executable (chmod +x %s)", path, path))
+			}
+			continue
+		}
+		executables = append(executables, path)
+	}
+	return executables
+}
+
+// runHookExecutables runs the executables of a hook point on this machine,
+// stopping at the first one that fails. Like local hooks they get the PIPE_*
+// variables, and the hook input as JSON on stdin.
+func runHookExecutables(cfg *config.Config, log *logger.Logger, point string, runErr error) error {
+	executables := hookExecutables(cfg, log, point)
+	if len(executables) == 0 {
+		return nil
+	}
+
+	settings, err := json.Marshal(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to encode the configuration for %s hooks: %v", point, err)
+	}
+	input := hookInput{
+		Hook:   point,
+		Host:   cfg.Host,
+		Image:  cfg.ImageRef(),
+		Steps:  log.Timings(log.Started()),
+		Config: json.RawMessage(log.Redact(string(settings))),
+	}
+	if input.Steps == nil {
+		input.Steps = []logger.Timing{}
+	}
+	if !json.Valid(input.Config) {
+		input.Config = json.RawMessage("null")
+	}
+	if runErr != nil {
+		input.Error = log.Redact(runErr.Error())
+	}
+	data, err := json.Marshal(input)
+	if err != nil {
+		return fmt.Errorf("failed to encode the input of %s hooks: %v", point, err)
+	}
+
+	for _, path := range executables {
+		if err := runHookExecutable(cfg, log, point, path, runErr, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runHookExecutable runs a single hook executable with the input on stdin
+func runHookExecutable(cfg *config.Config, log *logger.Logger, point string, path string, runErr error, input []byte) error {
+	name := fmt.Sprintf("%s hook %s", point, filepath.Base(path))
+	if _, err := ssh.ExecuteFile(cfg.Context(), log, path, hookVariables(cfg, runErr), "Running "+name, bytes.NewReader(input)); err != nil {
+		return fmt.Errorf("%s failed: %v", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package deploy
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/logger"
+	"github.com/bjarneo/pipe/internal/ssh"
+)
+
+// runHooks runs the hooks of a hook point for a single host, followed by the
+// custom steps and the hook executables of the same position, stopping at
+// the first one that fails
+func runHooks(cfg *config.Config, log *logger.Logger, point string, hooks []config.Hook, runErr error) error {
+	for i, hook := range hooks {
+		if err := runHook(cfg, log, fmt.Sprintf("%s hook %d/%d", point, i+1, len(hooks)), hook, runErr); err != nil {
+			return err
+		}
+	}
+	if err := runSteps(cfg, log, point, runErr); err != nil {
+		return err
+	}
+	return runHookExecutables(cfg, log, point, runErr)
+}
+
+// runPreBuildHooks runs the preBuild hooks once before building, local hooks
+// on this machine and remote hooks on every host, followed by the preBuild
+// steps and hook executables
+func runPreBuildHooks(cfg *config.Config, log *logger.Logger) error {
+	if err := cfg.InjectFailure("preBuild"); err != nil {
+		return err
+	}
+
+	hooks := cfg.Hooks.PreBuild
+	for i, hook := range hooks {
+		name := fmt.Sprintf("preBuild hook %d/%d", i+1, len(hooks))
+		if hook.Local != "" {
+			if err := runHook(cfg, log, name, hook, nil); err != nil {
+				return err
+			}
+			continue
+		}
+
+		err := forEachHost(cfg, log, func(cfg *config.Config, log *logger.Logger) error {
+			return runHook(cfg, log, name, hook, nil)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if err := runSteps(cfg, log, StepPreBuild, nil); err != nil {
+		return err
+	}
+	return runHookExecutables(cfg, log, StepPreBuild, nil)
+}
+
+// runHook runs a single hook, exposing the deployment in PIPE_* variables
+func runHook(cfg *config.Config, log *logger.Logger, name string, hook config.Hook, runErr error) error {
+	variables := append(hookVariables(cfg, runErr), hook.Env...)
+
+	var err error
+	if hook.Local != "" {
+		_, err = ssh.ExecuteShell(cfg.Context(), log, hook.Local, variables, "Running "+name, nil)
+	} else {
+		_, err = ssh.Run(cfg, log, ssh.UserCommand(cfg, hookEnv(variables)+hook.Remote), "Running "+name)
+	}
+	if err != nil {
+		return fmt.Errorf("%s failed: %v", name, err)
+	}
+	return nil
+}
+
+// runFailureHooks runs the onFailure hooks, logging instead of returning
+// their errors so the original failure is reported. They also run when the
+// deployment was cancelled.
+func runFailureHooks(cfg *config.Config, log *logger.Logger, runErr error) {
+	if err := runHooks(cfg.Detached(), log, "onFailure", cfg.Hooks.OnFailure, runErr); err != nil {
+		log.Info(err.Error())
+	}
+}
+
+// rollbackHookConfig returns the configuration rollback hooks run with, whose
+// image and tag are those of the version rolled back to
+func rollbackHookConfig(cfg *config.Config, targetImage string) *config.Config {
+	hookCfg := *cfg
+	hookCfg.PrebuiltImage = targetImage
+	hookCfg.Tag = strings.TrimPrefix(targetImage, cfg.Repository()+":")
+	return &hookCfg
+}
+
+// rollbackHooks returns the rollback hooks with the image being rolled back
+// from exported as PIPE_ROLLBACK_FROM, for reverse migrations
+func rollbackHooks(hooks []config.Hook, currentImage string) []config.Hook {
+	exported := make([]config.Hook, len(hooks))
+	for i, hook := range hooks {
+		hook.Env = append(hook.Env[:len(hook.Env):len(hook.Env)], "PIPE_ROLLBACK_FROM="+currentImage)
+		exported[i] = hook
+	}
+	return exported
+}
+
+// hookVariables returns the PIPE_* variables for hooks as KEY=VALUE pairs
+func hookVariables(cfg *config.Config, runErr error) []string {
+	variables := []string{
+		"PIPE_HOST=" + cfg.Host,
+		"PIPE_CONTAINER=" + cfg.ContainerName,
+		"PIPE_IMAGE=" + cfg.ImageRef(),
+		"PIPE_TAG=" + cfg.Tag,
+	}
+	if runErr != nil {
+		variables = append(variables, "PIPE_ERROR="+runErr.Error())
+	}
+	return variables
+}
+
+// hookEnv returns the shell prefix exporting the hook variables for remote
+// hooks
+func hookEnv(variables []string) string {
+	quoted := make([]string, len(variables))
+	for i, variable := range variables {
+		name, value, _ := strings.Cut(variable, "=")
+		quoted[i] = name + "=" + ssh.Quote(value)
+	}
+	return fmt.Sprintf("export %s; ", strings.Join(quoted, " "))
+}
@@ -0,0 +1,119 @@
+package deploy
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/docker"
+	"github.com/bjarneo/pipe/internal/logger"
+	"github.com/bjarneo/pipe/internal/ssh"
+)
+
+const (
+	// rebootDelay is how long the remote host waits before rebooting, so the
+	// reboot command can return
+	rebootDelay = 2 * time.Second
+	// rebootPollInterval is the delay between attempts to reconnect
+	rebootPollInterval = 5 * time.Second
+)
+
+// bootIDCmd prints an ID that changes on every boot
+const bootIDCmd = "cat /proc/sys/kernel/random/boot_id"
+
+// Host runs maintenance operations on the hosts themselves
+func Host(cfg *config.Config, log *logger.Logger, args []string) error {
+	if len(args) != 1 || args[0] != "reboot" {
+		return fmt.Errorf("usage: pipe host reboot")
+	}
+
+	// Validate configuration
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	timeout, err := time.ParseDuration(cfg.RebootTimeout)
+	if err != nil {
+		return fmt.Errorf("invalid reboot timeout %q: %v", cfg.RebootTimeout, err)
+	}
+
+	// Reboot one host at a time so the other hosts keep serving
+	for _, host := range cfg.Hosts {
+		hostCfg := *cfg
+		hostCfg.Host = host
+
+		hostLog := log
+		if len(cfg.Hosts) > 1 {
+			hostLog = log.WithPrefix(host)
+		}
+
+		if err := rebootHost(&hostCfg, hostLog, timeout); err != nil {
+			return fmt.Errorf("%s: %v", host, err)
+		}
+	}
+
+	return log.Info("Reboot completed successfully!")
+}
+
+// rebootHost reboots a single host, waits for it to come back and verifies
+// the container is running again
+func rebootHost(cfg *config.Config, log *logger.Logger, timeout time.Duration) error {
+	result, err := ssh.Capture(cfg, log, bootIDCmd, "Reading boot ID")
+	if err != nil {
+		return err
+	}
+	bootID := strings.TrimSpace(result.Stdout)
+
+	// Fail early instead of waiting for a reboot that will never happen
+	if _, err := ssh.Run(cfg, log, `[ "$(id -u)" = 0 ] || sudo -n true`, "Checking reboot permission"); err != nil {
+		return fmt.Errorf("rebooting needs root or passwordless sudo for %s: %v", cfg.User, err)
+	}
+
+	rebootCmd := fmt.Sprintf(`nohup sh -c 'sleep %d; if [ "$(id -u)" = 0 ]; then reboot; else sudo -n reboot; fi' >/dev/null 2>&1 &`,
+		int(rebootDelay.Seconds()))
+	if _, err := ssh.Run(cfg, log, rebootCmd, fmt.Sprintf("Rebooting %s", cfg.Host)); err != nil {
+		return err
+	}
+	ssh.Disconnect(cfg)
+
+	if err := log.Info(fmt.Sprintf("Waiting up to %s for %s to come back", timeout, cfg.Host)); err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		time.Sleep(rebootPollInterval)
+
+		result, err := ssh.Capture(cfg, log, bootIDCmd, "Reading boot ID")
+		if err == nil && strings.TrimSpace(result.Stdout) != bootID {
+			break
+		}
+		if cfg.Context().Err() != nil {
+			return err
+		}
+		ssh.Disconnect(cfg)
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("host did not come back within %s", timeout)
+		}
+	}
+
+	if err := log.Info(fmt.Sprintf("%s is back up", cfg.Host)); err != nil {
+		return err
+	}
+
+	exists, err := docker.Exists(cfg, log, cfg.ContainerName)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return log.Info(fmt.Sprintf("Container %s is not deployed on %s, nothing to verify", cfg.ContainerName, cfg.Host))
+	}
+
+	if err := docker.WaitStarted(cfg, log); err != nil {
+		return fmt.Errorf("container did not come back after the reboot: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,56 @@
+package deploy
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/logger"
+)
+
+// hostFunc is an operation performed against a single host
+type hostFunc func(cfg *config.Config, log *logger.Logger) error
+
+// forEachHost runs fn against every configured host. Multiple hosts are
+// handled concurrently, each with its own log prefix and its console output
+// grouped, and all failures are collected into a single summary error.
+func forEachHost(cfg *config.Config, log *logger.Logger, fn hostFunc) error {
+	if len(cfg.Hosts) <= 1 {
+		return fn(cfg, log)
+	}
+
+	errs := make([]error, len(cfg.Hosts))
+	var wg sync.WaitGroup
+
+	for i, host := range cfg.Hosts {
+		hostCfg := *cfg
+		hostCfg.Host = host
+
+		wg.Add(1)
+		go func(i int, hostCfg config.Config) {
+			defer wg.Done()
+			// Group the output of each host so parallel hosts stay readable
+			hostLog := log.WithPrefix(hostCfg.Host).Grouped()
+			defer hostLog.Flush()
+			errs[i] = fn(&hostCfg, hostLog)
+		}(i, hostCfg)
+	}
+
+	wg.Wait()
+
+	// Summarize failures across all hosts
+	var failures []string
+	for i, err := range errs {
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", cfg.Hosts[i], err))
+		}
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("failed on %d of %d hosts:\n  %s",
+			len(failures), len(cfg.Hosts), strings.Join(failures, "\n  "))
+	}
+
+	return nil
+}
@@ -0,0 +1,211 @@
+package deploy
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/docker"
+	"github.com/bjarneo/pipe/internal/logger"
+	"github.com/bjarneo/pipe/internal/ssh"
+)
+
+// workflowTemplate is the GitHub Actions workflow written by init, deploying
+// every push to main with the pipe action
+const workflowTemplate = `name: Deploy
+
+on:
+  push:
+    branches:
+      - main
+
+jobs:
+  deploy:
+    runs-on: ubuntu-latest
+    steps:
+      - name: Checkout code
+        uses: actions/checkout@v4
+
+      # Add the private SSH key as the PRIVATE_SSH_KEY secret of the repository
+      - name: Deploy
+        uses: bjarneo/pipe@main
+        with:
+          host: %s
+          user: %s
+          ssh_key: ${{ secrets.PRIVATE_SSH_KEY }}
+          image: %s
+          tag: ${{ github.sha }}
+          container_name: %s
+          container_port: %s
+          host_port: %s
+`
+
+// imageNameChars are the characters not allowed in the default image name
+var imageNameChars = regexp.MustCompile(`[^a-z0-9_.-]+`)
+
+// Init asks for the host, SSH user and key, image and ports of a new
+// project, checking the SSH connection and Docker on the host as it goes,
+// and writes a starter config file and a GitHub Actions workflow
+func Init(cfg *config.Config, log *logger.Logger) error {
+	if !isTerminal() {
+		return fmt.Errorf("init asks its questions on a terminal, run it interactively or write %s by hand", cfg.Output)
+	}
+	for _, path := range []string{cfg.Output, cfg.Workflow} {
+		if _, err := os.Stat(path); path != "" && err == nil {
+			return fmt.Errorf("%s already exists, choose another file with --output or --workflow", path)
+		}
+	}
+
+	p := prompter{reader: bufio.NewReader(os.Stdin)}
+
+	target := *cfg
+	for {
+		target.Host = p.ask("Host to deploy to", cfg.Host, required)
+		target.Hosts = []string{target.Host}
+		target.User = p.ask("SSH user", cfg.User, required)
+		target.SSHKey = p.ask("SSH key (empty for the ssh-agent and ~/.ssh keys)", cfg.SSHKey, nil)
+
+		err := target.Validate()
+		if err == nil {
+			err = ssh.Check(&target, log)
+		}
+		if err == nil {
+			err = docker.CheckRemote(&target, log)
+		}
+		if err == nil {
+			break
+		}
+		log.Warn(fmt.Sprintf("%s is not ready to deploy to: %v", target.Host, err))
+		if !p.confirm("Try again?") {
+			log.Warn("Writing the config anyway, fix the connection before the first deployment")
+			break
+		}
+	}
+
+	image := p.ask("Image name", defaultImageName(cfg), required)
+	containerName := p.ask("Container name", image, required)
+	containerPort := p.ask("Port the app listens on in the container", cfg.ContainerPort, port)
+	hostPort := p.ask("Port on the host", containerPort, port)
+
+	written := config.Config{
+		Hosts:         []string{target.Host},
+		User:          target.User,
+		SSHKey:        target.SSHKey,
+		Image:         image,
+		ContainerName: containerName,
+		ContainerPort: containerPort,
+		HostPort:      hostPort,
+	}
+	if err := config.WriteFile(cfg.Output, written); err != nil {
+		return fmt.Errorf("failed to write config file: %v", err)
+	}
+	if err := log.Info(fmt.Sprintf("Configuration written to %s", cfg.Output)); err != nil {
+		return err
+	}
+
+	if cfg.Workflow == "" {
+		return nil
+	}
+	if err := writeWorkflow(cfg.Workflow, fmt.Sprintf(workflowTemplate,
+		target.Host, target.User, image, containerName, containerPort, hostPort)); err != nil {
+		return fmt.Errorf("failed to write workflow: %v", err)
+	}
+	return log.Info(fmt.Sprintf("GitHub Actions workflow written to %s, add the private SSH key as the PRIVATE_SSH_KEY secret of the repository", cfg.Workflow))
+}
+
+// writeWorkflow writes the workflow file, creating its directory
+func writeWorkflow(path string, workflow string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.WriteString(workflow)
+	return err
+}
+
+// defaultImageName returns the configured image, or the name of the current
+// directory when the image is the default
+func defaultImageName(cfg *config.Config) string {
+	if cfg.Image != config.Defaults().Image {
+		return cfg.Image
+	}
+	dir, err := os.Getwd()
+	if err != nil {
+		return cfg.Image
+	}
+	name := strings.Trim(imageNameChars.ReplaceAllString(strings.ToLower(filepath.Base(dir)), "-"), "-.")
+	if name == "" {
+		return cfg.Image
+	}
+	return name
+}
+
+// prompter asks questions on the terminal
+type prompter struct {
+	reader *bufio.Reader
+}
+
+// ask asks a question until the answer, or the default for an empty
+// answer, passes the check
+func (p prompter) ask(question string, value string, check func(string) error) string {
+	for {
+		if value != "" {
+			fmt.Printf("%s [%s]: ", question, value)
+		} else {
+			fmt.Printf("%s: ", question)
+		}
+
+		answer, err := p.reader.ReadString('\n')
+		if err != nil && answer == "" {
+			fmt.Println()
+			os.Exit(1)
+		}
+		if answer = strings.TrimSpace(answer); answer == "" {
+			answer = value
+		}
+		if check == nil {
+			return answer
+		}
+		if err := check(answer); err != nil {
+			fmt.Println(err)
+			continue
+		}
+		return answer
+	}
+}
+
+// confirm asks a yes or no question, defaulting to yes
+func (p prompter) confirm(question string) bool {
+	answer := p.ask(question+" (yes/no)", "yes", func(answer string) error {
+		if answer != "yes" && answer != "no" {
+			return fmt.Errorf("please answer yes or no")
+		}
+		return nil
+	})
+	return answer == "yes"
+}
+
+// required checks that an answer was given
+func required(answer string) error {
+	if answer == "" {
+		return fmt.Errorf("an answer is required")
+	}
+	return nil
+}
+
+// port checks that an answer is a port number
+func port(answer string) error {
+	if n, err := strconv.Atoi(answer); err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("%q is not a port number", answer)
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package deploy
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/history"
+	"github.com/bjarneo/pipe/internal/logger"
+	"github.com/bjarneo/pipe/internal/ssh"
+)
+
+// Jobs runs one-off jobs in the container and shows the jobs that ran. The
+// host records the output and exit code of every job in the state
+// directory, so failures stay visible after the connection is gone. Jobs
+// scheduled with pipe run --schedule are listed and removed here too.
+func Jobs(cfg *config.Config, log *logger.Logger, args []string) error {
+	// Validate configuration
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	switch {
+	case len(args) > 1 && args[0] == "run":
+		return forEachHost(cfg, log, func(cfg *config.Config, log *logger.Logger) error {
+			return runJob(cfg, log, args[1:])
+		})
+	case len(args) == 1 && args[0] == "history":
+		return forEachHost(cfg, log, listJobs)
+	case len(args) == 2 && args[0] == "logs":
+		return forEachHost(cfg, log, func(cfg *config.Config, log *logger.Logger) error {
+			return ssh.Stream(cfg, log, ssh.Command("cat", cfg.JobLog(args[1])), fmt.Sprintf("Reading output of job %s", args[1]))
+		})
+	case len(args) == 1 && args[0] == "scheduled":
+		return forEachHost(cfg, log, listScheduledJobs)
+	case len(args) == 2 && args[0] == "unschedule":
+		return forEachHost(cfg, log, func(cfg *config.Config, log *logger.Logger) error {
+			return unscheduleJob(cfg, log, args[1])
+		})
+	default:
+		return fmt.Errorf("usage: pipe jobs run -- <command> [args...] | history | logs <id> | scheduled | unschedule <name>")
+	}
+}
+
+// runJob runs a command in the container on a host, recording its output
+// and exit code
+func runJob(cfg *config.Config, log *logger.Logger, args []string) error {
+	id := log.Started().Format("20060102-150405")
+	execCmd := ssh.Command(append([]string{"docker", "exec", cfg.Containers()[0]}, args...)...)
+	jobCmd := history.JobCommand(cfg, id, strings.Join(args, " "), execCmd)
+	if err := ssh.Stream(cfg, log, jobCmd, fmt.Sprintf("Running job %s in %s", id, cfg.Containers()[0])); err != nil {
+		return fmt.Errorf("job %s failed, see pipe jobs logs %s: %v", id, id, err)
+	}
+	return nil
+}
+
+// listJobs prints the jobs recorded on a host, latest first
+func listJobs(cfg *config.Config, log *logger.Logger) error {
+	if err := ssh.Check(cfg, log); err != nil {
+		return err
+	}
+
+	jobs, err := history.LoadJobs(cfg, log)
+	if err != nil {
+		return err
+	}
+
+	if cfg.JSON {
+		if jobs == nil {
+			jobs = []history.Job{}
+		}
+		data, err := json.MarshalIndent(jobs, "", "  ")
+		if err != nil {
+			return err
+		}
+		log.Output(string(data))
+		return nil
+	}
+
+	if len(jobs) == 0 {
+		log.Output("No jobs recorded yet")
+	}
+
+	for i := len(jobs) - 1; i >= 0; i-- {
+		job := jobs[i]
+		status := "ok"
+		if job.ExitCode != 0 {
+			status = fmt.Sprintf("exit %d", job.ExitCode)
+		}
+		log.Output(fmt.Sprintf("%-16s  %-8s  %-8s  %s  %s",
+			job.ID, status, job.Finished.Sub(job.Started).String(),
+			job.Started.Format("2006-01-02 15:04:05 MST"), job.Command))
+	}
+	return nil
+}
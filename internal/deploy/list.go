@@ -0,0 +1,158 @@
+package deploy
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/logger"
+	"github.com/bjarneo/pipe/internal/ssh"
+)
+
+// appListing is the state of a container of an app on a single host
+type appListing struct {
+	App       string `json:"app"`
+	Host      string `json:"host"`
+	Container string `json:"container"`
+	Port      string `json:"port"`
+	Domain    string `json:"domain,omitempty"`
+	Image     string `json:"image,omitempty"`
+	Status    string `json:"status"`
+}
+
+// hostContainer is a container found on a host
+type hostContainer struct {
+	image  string
+	status string
+}
+
+// List gives an overview of the apps of the workspace and the state of their
+// containers on every host
+func List(cfg *config.Config, log *logger.Logger) error {
+	apps, err := cfg.AppConfigs()
+	if err != nil {
+		return err
+	}
+	for i := range apps {
+		if err := apps[i].Validate(); err != nil {
+			return fmt.Errorf("app %s: %v", appLabel(apps[i]), err)
+		}
+	}
+
+	// Keep stdout clean for scripts
+	if cfg.JSON {
+		log.SetQuiet(true)
+	}
+
+	// Apps share hosts, so the containers of every host are listed once
+	var listings []appListing
+	hosts := make(map[string]*config.Config)
+	for i := range apps {
+		services, err := apps[i].Services()
+		if err != nil {
+			return err
+		}
+		for _, service := range services {
+			for _, host := range service.Hosts {
+				listings = append(listings, appListing{
+					App:       appLabel(apps[i]),
+					Host:      host,
+					Container: service.ContainerName,
+					Port:      service.HostPort,
+					Domain:    service.Domain,
+				})
+				if hosts[host] == nil {
+					hostConfig := service
+					hostConfig.Host, hostConfig.Hosts = host, []string{host}
+					hosts[host] = &hostConfig
+				}
+			}
+		}
+	}
+
+	containers := make(map[string]map[string]hostContainer)
+	failures := make(map[string]error)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for host, hostConfig := range hosts {
+		wg.Add(1)
+		go func(host string, hostConfig *config.Config) {
+			defer wg.Done()
+			found, err := listContainers(hostConfig, log.WithPrefix(host))
+			mu.Lock()
+			defer mu.Unlock()
+			containers[host], failures[host] = found, err
+		}(host, hostConfig)
+	}
+	wg.Wait()
+
+	for i := range listings {
+		listing := &listings[i]
+		container, found := containers[listing.Host][listing.Container]
+		switch {
+		case failures[listing.Host] != nil:
+			listing.Status = fmt.Sprintf("unknown (%v)", failures[listing.Host])
+		case !found:
+			listing.Status = "not deployed"
+		default:
+			listing.Image, listing.Status = container.image, container.status
+		}
+	}
+
+	if cfg.JSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(listings); err != nil {
+			return err
+		}
+	} else {
+		fmt.Printf("%-16s  %-24s  %-20s  %-6s  %-24s  %-30s  %s\n", "APP", "HOST", "CONTAINER", "PORT", "DOMAIN", "IMAGE", "STATUS")
+		for _, listing := range listings {
+			fmt.Printf("%-16s  %-24s  %-20s  %-6s  %-24s  %-30s  %s\n", listing.App, listing.Host, listing.Container,
+				listing.Port, listing.Domain, listing.Image, listing.Status)
+		}
+	}
+
+	var unreachable []string
+	for host, err := range failures {
+		if err != nil {
+			unreachable = append(unreachable, host)
+		}
+	}
+	if len(unreachable) > 0 {
+		sort.Strings(unreachable)
+		return fmt.Errorf("failed to list the containers on %s", strings.Join(unreachable, ", "))
+	}
+	return nil
+}
+
+// appLabel returns the name an app is listed under
+func appLabel(app config.Config) string {
+	if app.AppName != "" {
+		return app.AppName
+	}
+	return app.ContainerName
+}
+
+// listContainers returns the image and status of every container on the host
+// by name
+func listContainers(cfg *config.Config, log *logger.Logger) (map[string]hostContainer, error) {
+	listCmd := ssh.Command("docker", "ps", "-a", "--format", "{{.Names}}\t{{.Image}}\t{{.Status}}")
+	result, err := ssh.Capture(cfg, log, listCmd, fmt.Sprintf("Listing containers on %s", cfg.Host))
+	if err != nil {
+		return nil, err
+	}
+
+	containers := make(map[string]hostContainer)
+	for _, line := range strings.Split(strings.TrimSpace(result.Stdout), "\n") {
+		fields := strings.SplitN(line, "\t", 3)
+		if len(fields) == 3 {
+			containers[fields[0]] = hostContainer{image: fields[1], status: fields[2]}
+		}
+	}
+	return containers, nil
+}
@@ -0,0 +1,55 @@
+package deploy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/logger"
+	"github.com/bjarneo/pipe/internal/ssh"
+)
+
+// Logs streams the container logs from every configured host. The lines of
+// replicas are prefixed with the name of their replica.
+func Logs(cfg *config.Config, log *logger.Logger) error {
+	// Validate configuration
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	logsCmd, err := logsCommand(cfg, cfg.ContainerName)
+	if err != nil {
+		return err
+	}
+	if cfg.Replicated() {
+		var replicaCmds []string
+		for _, name := range cfg.Containers() {
+			replicaCmd, _ := logsCommand(cfg, name)
+			prefix := ssh.Command("awk", fmt.Sprintf(`{ print "[%s] " $0; fflush() }`, name))
+			replicaCmds = append(replicaCmds, fmt.Sprintf("(%s 2>&1 | %s) &", replicaCmd, prefix))
+		}
+		logsCmd = strings.Join(replicaCmds, " ") + " wait"
+	}
+
+	return forEachHost(cfg, log, func(cfg *config.Config, log *logger.Logger) error {
+		return ssh.Stream(cfg, log, logsCmd, fmt.Sprintf("Streaming logs of %s", cfg.ContainerName))
+	})
+}
+
+// logsCommand returns the docker logs command for a container, using the
+// --tail, --since and --follow flags
+func logsCommand(cfg *config.Config, name string) (string, error) {
+	if _, err := strconv.Atoi(cfg.Tail); err != nil && cfg.Tail != "all" {
+		return "", fmt.Errorf("invalid --tail %q: expected a number or 'all'", cfg.Tail)
+	}
+
+	logsArgs := []string{"docker", "logs", "--tail", cfg.Tail}
+	if cfg.Since != "" {
+		logsArgs = append(logsArgs, "--since", cfg.Since)
+	}
+	if cfg.Follow {
+		logsArgs = append(logsArgs, "-f")
+	}
+	return ssh.Command(append(logsArgs, name)...), nil
+}
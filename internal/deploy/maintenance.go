@@ -0,0 +1,111 @@
+package deploy
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/docker"
+	"github.com/bjarneo/pipe/internal/logger"
+	"github.com/bjarneo/pipe/internal/ssh"
+)
+
+// Maintenance switches maintenance mode on or off for the configured app.
+// During maintenance a static page answers on the ports of the app.
+func Maintenance(cfg *config.Config, log *logger.Logger, mode string) error {
+	if mode != "on" && mode != "off" {
+		return fmt.Errorf("invalid maintenance mode %q: expected 'on' or 'off'", mode)
+	}
+
+	// Validate configuration
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	if mode == "on" {
+		page := docker.DefaultMaintenancePage
+		if cfg.MaintenancePage != "" {
+			content, err := os.ReadFile(cfg.MaintenancePage)
+			if err != nil {
+				return fmt.Errorf("failed to read maintenance page: %v", err)
+			}
+			page = string(content)
+		}
+		return forEachHost(cfg, log, func(cfg *config.Config, log *logger.Logger) error {
+			return enableMaintenance(cfg, log, page)
+		})
+	}
+	return forEachHost(cfg, log, disableMaintenance)
+}
+
+// enableMaintenance stops the container, or all of its replicas, starts the
+// maintenance page in its place and records the maintenance state. The app
+// is started again when the page fails to start.
+func enableMaintenance(cfg *config.Config, log *logger.Logger, page string) error {
+	if err := ssh.Check(cfg, log); err != nil {
+		return err
+	}
+
+	for _, name := range cfg.Containers() {
+		exists, err := docker.Exists(cfg, log, name)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return fmt.Errorf("container %s not found on %s, deploy it first", name, cfg.Host)
+		}
+	}
+
+	for _, name := range cfg.Containers() {
+		if err := docker.Stop(cfg, log, name); err != nil {
+			return err
+		}
+	}
+
+	if err := docker.StartMaintenancePage(cfg, log, page); err != nil {
+		docker.RemoveMaintenancePage(cfg, log)
+		for _, name := range cfg.Containers() {
+			if startErr := docker.Start(cfg, log, name); startErr != nil {
+				log.Warn(startErr.Error())
+			}
+		}
+		return err
+	}
+
+	recordCmd := ssh.Command("mkdir", "-p", cfg.StateDir()) + " && date -u +%Y-%m-%dT%H:%M:%SZ > " +
+		ssh.Command(cfg.StateDir()+"/maintenance")
+	if _, err := ssh.Run(cfg, log, recordCmd, "Recording maintenance state"); err != nil {
+		return fmt.Errorf("failed to record maintenance state: %v", err)
+	}
+
+	return log.Info("Maintenance mode enabled 🚧")
+}
+
+// disableMaintenance removes the maintenance page, starts the container, or
+// all of its replicas, again and clears the maintenance state
+func disableMaintenance(cfg *config.Config, log *logger.Logger) error {
+	if err := ssh.Check(cfg, log); err != nil {
+		return err
+	}
+
+	if err := docker.RemoveMaintenancePage(cfg, log); err != nil {
+		return err
+	}
+
+	for _, name := range cfg.Containers() {
+		if err := docker.Start(cfg, log, name); err != nil {
+			return err
+		}
+	}
+
+	if err := docker.Verify(cfg, log); err != nil {
+		return err
+	}
+
+	clearCmd := ssh.Command("rm", "-f", cfg.StateDir()+"/maintenance")
+	if _, err := ssh.Run(cfg, log, clearCmd, "Clearing maintenance state"); err != nil {
+		return fmt.Errorf("failed to clear maintenance state: %v", err)
+	}
+
+	return log.Info("Maintenance mode disabled ✅")
+}
@@ -0,0 +1,86 @@
+package deploy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/docker"
+	"github.com/bjarneo/pipe/internal/logger"
+)
+
+// scrapeTargets is a Prometheus file-based service discovery entry
+type scrapeTargets struct {
+	Targets []string          `json:"targets"`
+	Labels  map[string]string `json:"labels"`
+}
+
+// Metrics installs or removes node-exporter and cAdvisor on every host of the
+// inventory, or prints the Prometheus scrape targets for them
+func Metrics(cfg *config.Config, log *logger.Logger, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: pipe metrics install|uninstall|targets")
+	}
+
+	inventory, err := cfg.Inventory()
+	if err != nil {
+		return err
+	}
+	for i := range inventory {
+		if err := inventory[i].Validate(); err != nil {
+			return err
+		}
+	}
+
+	switch args[0] {
+	case "install":
+		if err := eachInventoryHost(inventory, log, docker.RunMetrics); err != nil {
+			return err
+		}
+		return log.Info(fmt.Sprintf("Metrics exporters running on %d hosts, run 'pipe metrics targets' for the Prometheus scrape targets", len(inventory)))
+	case "uninstall":
+		if err := eachInventoryHost(inventory, log, docker.RemoveMetrics); err != nil {
+			return err
+		}
+		return log.Info("Metrics exporters removed")
+	case "targets":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(metricsTargets(inventory))
+	default:
+		return fmt.Errorf("unknown metrics action %q: expected install, uninstall or targets", args[0])
+	}
+}
+
+// eachInventoryHost runs fn on every host of the inventory in turn
+func eachInventoryHost(inventory []config.Config, log *logger.Logger, fn func(*config.Config, *logger.Logger) error) error {
+	for i := range inventory {
+		if err := fn(&inventory[i], log.WithPrefix(inventory[i].Host)); err != nil {
+			return fmt.Errorf("%s: %v", inventory[i].Host, err)
+		}
+	}
+	return nil
+}
+
+// metricsTargets returns the node-exporter and cAdvisor scrape targets of the
+// inventory, in the file format of Prometheus' file_sd_configs
+func metricsTargets(inventory []config.Config) []scrapeTargets {
+	node := scrapeTargets{Targets: []string{}, Labels: map[string]string{"job": "node"}}
+	cadvisor := scrapeTargets{Targets: []string{}, Labels: map[string]string{"job": "cadvisor"}}
+
+	for _, cfg := range inventory {
+		// The SSH port of a host is not the metrics port
+		host := cfg.Host
+		if h, _, err := net.SplitHostPort(cfg.Host); err == nil {
+			host = h
+		}
+
+		nodePort, cadvisorPort := cfg.Metrics.Ports()
+		node.Targets = append(node.Targets, net.JoinHostPort(host, nodePort))
+		cadvisor.Targets = append(cadvisor.Targets, net.JoinHostPort(host, cadvisorPort))
+	}
+
+	return []scrapeTargets{node, cadvisor}
+}
@@ -0,0 +1,79 @@
+package deploy
+
+import (
+	"fmt"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/docker"
+	"github.com/bjarneo/pipe/internal/logger"
+)
+
+// migrate runs the configured migration once per deployment, on the first
+// host, before any container is replaced. A failing migration fails the
+// deployment and leaves the running containers untouched on every host.
+func migrate(cfg *config.Config, log *logger.Logger) error {
+	if !cfg.Migrate.Enabled() {
+		return nil
+	}
+
+	hostCfg := *cfg
+	hostCfg.Host = cfg.Hosts[0]
+	hostCfg.Hosts = cfg.Hosts[:1]
+	hostLog := log
+	if len(cfg.Hosts) > 1 {
+		hostLog = log.WithPrefix(hostCfg.Host)
+	}
+
+	err := timeStep(hostLog, "migrate", func(log *logger.Logger) error {
+		return migrateHost(&hostCfg, log)
+	})
+	if err == nil {
+		return nil
+	}
+
+	forEachHost(cfg, log, func(cfg *config.Config, log *logger.Logger) error {
+		runFailureHooks(cfg, log, err)
+		return nil
+	})
+	recordHistory(&hostCfg, hostLog, "deploy", err)
+	return fmt.Errorf("%v, the running containers were left untouched", err)
+}
+
+// migrateHost transfers the image to a single host and runs the migration
+// there with the environment files of the app
+func migrateHost(cfg *config.Config, log *logger.Logger) error {
+	unlock, err := docker.Lock(cfg, log, "migrate")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := cfg.InjectFailure("migrate"); err != nil {
+		return err
+	}
+
+	// A dedicated image is pulled by docker when the migration starts
+	if cfg.Migrate.Image == "" {
+		if err := docker.Transfer(cfg, log); err != nil {
+			return err
+		}
+		if err := docker.VerifySignature(cfg, log); err != nil {
+			return err
+		}
+	}
+
+	if err := docker.PrepareState(cfg, log, cfg.EnvFile); err != nil {
+		return err
+	}
+	if envFiles := cfg.EnvFilePaths(); len(envFiles) > 0 {
+		if err := docker.CopyEnvFile(cfg, log, envFiles); err != nil {
+			return err
+		}
+		defer docker.RemoveEnvFile(cfg, log, envFiles)
+	}
+	if err := docker.CopyFiles(cfg, log); err != nil {
+		return err
+	}
+
+	return docker.Migrate(cfg, log)
+}
@@ -0,0 +1,93 @@
+package deploy
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/docker"
+	"github.com/bjarneo/pipe/internal/logger"
+	"github.com/bjarneo/pipe/internal/ssh"
+)
+
+// Mirror copies the image deployed on one host directly to another host. The
+// source host pipes docker save over SSH into docker load on the target,
+// using the forwarded local credentials, so the image never passes through
+// this machine.
+func Mirror(cfg *config.Config, log *logger.Logger) error {
+	if cfg.MirrorFrom == "" || cfg.MirrorTo == "" {
+		return fmt.Errorf("usage: pipe mirror --from <host> --to <host>")
+	}
+	if cfg.MirrorFrom == cfg.MirrorTo {
+		return fmt.Errorf("--from and --to are the same host")
+	}
+
+	from, to := *cfg, *cfg
+	from.Host, from.Hosts = cfg.MirrorFrom, []string{cfg.MirrorFrom}
+	to.Host, to.Hosts = cfg.MirrorTo, []string{cfg.MirrorTo}
+
+	// Validate configuration
+	if err := from.Validate(); err != nil {
+		return err
+	}
+
+	fromLog, toLog := log.WithPrefix(from.Host), log.WithPrefix(to.Host)
+	if err := docker.CheckRemote(&from, fromLog); err != nil {
+		return err
+	}
+	if err := docker.CheckRemote(&to, toLog); err != nil {
+		return err
+	}
+
+	exists, err := docker.Exists(&from, fromLog, cfg.ContainerName)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("container %s is not deployed on %s", cfg.ContainerName, from.Host)
+	}
+
+	container, err := inspectContainer(&from, fromLog, cfg.ContainerName)
+	if err != nil {
+		return err
+	}
+	image := container.Config.Image
+
+	// Skip the copy when the target already has the image
+	inspectCmd := ssh.Command("docker", "image", "inspect", image) + " >/dev/null 2>&1 && echo exists || echo missing"
+	result, err := ssh.Capture(&to, toLog, inspectCmd, fmt.Sprintf("Checking image %s", image))
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(result.Stdout) == "exists" {
+		return log.Info(fmt.Sprintf("%s already has image %s, nothing to copy", to.Host, image))
+	}
+
+	if _, err := ssh.RunForwarded(&from, fromLog, mirrorCommand(&to, image),
+		fmt.Sprintf("Copying image %s to %s", image, to.Host)); err != nil {
+		return fmt.Errorf("failed to copy image %s from %s to %s: %v", image, from.Host, to.Host, err)
+	}
+
+	return log.Info(fmt.Sprintf("Image %s copied from %s to %s, deploy it there with --image-ref %s --host %s",
+		image, from.Host, to.Host, image, to.Host))
+}
+
+// mirrorCommand returns the command run on the source host that streams the
+// image into docker load on the target host, through the jump host if one is
+// configured. The target's host key is accepted on first use, as the source
+// host may never have connected to it.
+func mirrorCommand(to *config.Config, image string) string {
+	host, port := to.Host, to.SSHPort
+	if h, p, err := net.SplitHostPort(to.Host); err == nil {
+		host, port = h, p
+	}
+
+	sshArgs := []string{"ssh", "-o", "BatchMode=yes", "-o", "StrictHostKeyChecking=accept-new", "-p", port}
+	if jumpUser, jumpHost := to.Jump(); jumpHost != "" {
+		sshArgs = append(sshArgs, "-J", jumpUser+"@"+jumpHost)
+	}
+	sshArgs = append(sshArgs, to.User+"@"+host, ssh.AsDockerUser(to, "gunzip | docker load"))
+
+	return ssh.Command("docker", "save", image) + " | gzip | " + ssh.Command(sshArgs...)
+}
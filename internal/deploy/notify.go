@@ -0,0 +1,63 @@
+package deploy
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/history"
+	"github.com/bjarneo/pipe/internal/logger"
+	"github.com/bjarneo/pipe/internal/notify"
+)
+
+// sendNotification tells the configured webhooks, the status page and the
+// registered notifiers about a deployment or rollback of the services.
+// Failures include the output of the last failed command.
+func sendNotification(cfg *config.Config, log *logger.Logger, action string, status string,
+	services []config.Config, started time.Time, runErr error) {
+	if len(cfg.Notifications) == 0 && !cfg.StatusPage.Enabled() && !notify.Registered(cfg.Context()) {
+		return
+	}
+
+	event := notify.Event{
+		ID:       log.Started().Format("20060102-150405"),
+		Action:   action,
+		Status:   status,
+		Deployer: history.Deployer(),
+		Started:  started,
+	}
+	event.Apps, event.Hosts = appsAndHosts(action, services)
+	if status != notify.Started {
+		event.Duration = time.Since(started).Round(time.Second).String()
+	}
+	if runErr != nil {
+		event.Error = log.Redact(runErr.Error())
+		if step, ok := log.LastFailure(); ok {
+			event.Excerpt = fmt.Sprintf("$ %s\n%s", step.Command, step.Output)
+		}
+	}
+
+	notify.Send(cfg.Context(), log, cfg.Notifications, event)
+	if cfg.StatusPage.Enabled() {
+		if err := notify.UpdateStatusPage(log, cfg.StatusPage, event); err != nil {
+			log.Warn(fmt.Sprintf("failed to update status page: %v", err))
+		}
+	}
+}
+
+// pingMonitors pings the uptime monitors of the deployed services, once
+// each
+func pingMonitors(log *logger.Logger, services []config.Config) {
+	pinged := make(map[string]bool)
+	for _, service := range services {
+		if service.Monitor.PingURL == "" || pinged[service.Monitor.PingURL] {
+			continue
+		}
+		pinged[service.Monitor.PingURL] = true
+
+		message := fmt.Sprintf("deployed %s by %s", service.ImageRef(), history.Deployer())
+		if err := notify.Ping(log, service.Monitor, message); err != nil {
+			log.Warn(fmt.Sprintf("failed to ping uptime monitor: %v", err))
+		}
+	}
+}
@@ -0,0 +1,394 @@
+package deploy
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/term"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/docker"
+	"github.com/bjarneo/pipe/internal/logger"
+	"github.com/bjarneo/pipe/internal/ssh"
+)
+
+// Plan prints the actions a deployment would perform on every host without
+// changing anything
+func Plan(cfg *config.Config, log *logger.Logger) error {
+	// Validate configuration
+	services, err := services(cfg, log)
+	if err != nil {
+		return err
+	}
+
+	plan, err := buildStackPlan(cfg, log, services)
+	if err != nil {
+		return err
+	}
+
+	fmt.Print(plan)
+	return nil
+}
+
+// approvePlan shows the plan and asks for confirmation when running in an
+// interactive terminal, unless --auto-approve is set
+func approvePlan(cfg *config.Config, log *logger.Logger, services []config.Config) error {
+	if cfg.AutoApprove || !isTerminal() {
+		return nil
+	}
+
+	plan, err := buildStackPlan(cfg, log, services)
+	if err != nil {
+		return err
+	}
+
+	fmt.Print(plan)
+	fmt.Print("\nDo you want to perform these actions? Only 'yes' will be accepted: ")
+
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	if strings.TrimSpace(answer) != "yes" {
+		return fmt.Errorf("deployment cancelled")
+	}
+
+	return nil
+}
+
+// buildStackPlan returns the plans of the accessories and of all services in
+// deployment order
+func buildStackPlan(cfg *config.Config, log *logger.Logger, services []config.Config) (string, error) {
+	accessoriesPlan, err := planAccessories(cfg, log)
+	if err != nil {
+		return "", err
+	}
+
+	var plan strings.Builder
+	plan.WriteString(accessoriesPlan)
+	for i := range services {
+		servicePlan, err := buildPlan(&services[i], serviceLogger(log, &services[i]))
+		if err != nil {
+			return "", err
+		}
+		plan.WriteString(servicePlan)
+	}
+	return plan.String(), nil
+}
+
+// isTerminal reports whether stdin is an interactive terminal
+func isTerminal() bool {
+	return term.IsTerminal(int(os.Stdin.Fd()))
+}
+
+// buildPlan returns the human-readable plan for all hosts
+func buildPlan(cfg *config.Config, log *logger.Logger) (string, error) {
+	var plan strings.Builder
+	if cfg.ServiceName != "" {
+		fmt.Fprintf(&plan, "\nService %s:", cfg.ServiceName)
+	}
+	fmt.Fprintf(&plan, "\nPlan for deploying %s as container %s:\n\n", cfg.ImageRef(), cfg.ContainerName)
+
+	if len(cfg.Hooks.PreBuild) > 0 {
+		fmt.Fprintf(&plan, "  + run %d preBuild hook(s)\n", len(cfg.Hooks.PreBuild))
+	}
+	for _, step := range stepsOf(cfg, StepPreBuild) {
+		fmt.Fprintf(&plan, "  + run preBuild step %s\n", step.Name())
+	}
+	for _, path := range hookExecutables(cfg, log, StepPreBuild) {
+		fmt.Fprintf(&plan, "  + run preBuild hook %s\n", path)
+	}
+
+	switch {
+	case cfg.PrebuiltImage != "":
+		fmt.Fprintf(&plan, "  = use pre-built image %s\n", cfg.PrebuiltImage)
+	case cfg.SkipBuild:
+		fmt.Fprintf(&plan, "  = use existing local image %s\n", cfg.ImageRef())
+	default:
+		fmt.Fprintf(&plan, "  + build image %s from %s (platform %s)\n", cfg.ImageRef(), cfg.Dockerfile, cfg.Platform)
+	}
+
+	if cfg.Scan.Scanner != "" {
+		fmt.Fprintf(&plan, "  + scan image with %s, failing on %s or higher\n", cfg.Scan.Scanner, cfg.Scan.Threshold())
+	}
+	if cfg.SBOM.Enabled {
+		fmt.Fprintf(&plan, "  + generate %s SBOM with syft and store it on every host\n", cfg.SBOM.OutputFormat())
+	}
+	if cfg.SBOM.Upload != "" {
+		fmt.Fprintf(&plan, "  + upload SBOM to the artifact endpoint\n")
+	}
+	if cfg.Registry != "" && cfg.PrebuiltImage == "" {
+		fmt.Fprintf(&plan, "  + push image to %s\n", cfg.Registry)
+	}
+	if (cfg.Signing.Key != "" || cfg.Signing.Keyless()) && cfg.PrebuiltImage == "" {
+		fmt.Fprintf(&plan, "  + sign image with cosign\n")
+	}
+	if cfg.Signing.Enabled() {
+		fmt.Fprintf(&plan, "  + verify the image signature on every host before starting it\n")
+	}
+	if cfg.Migrate.Enabled() {
+		fmt.Fprintf(&plan, "  + run migration %s in %s on %s before replacing any container\n",
+			strings.Join(cfg.Migrate.Command, " "), cfg.Migrate.ImageRef(cfg.ImageRef()), cfg.Hosts[0])
+	}
+
+	hostPlans := make(map[string][]string)
+	var mu sync.Mutex
+
+	err := forEachHost(cfg, log, func(cfg *config.Config, log *logger.Logger) error {
+		actions, err := planHost(cfg, log)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		hostPlans[cfg.Host] = actions
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	for _, host := range cfg.Hosts {
+		fmt.Fprintf(&plan, "\n  %s:\n", host)
+		for _, action := range hostPlans[host] {
+			fmt.Fprintf(&plan, "    %s\n", action)
+		}
+	}
+
+	if len(cfg.Hooks.PreDeploy) > 0 || len(cfg.Hooks.PostDeploy) > 0 {
+		fmt.Fprintf(&plan, "\n  + run %d preDeploy and %d postDeploy hook(s) on every host\n",
+			len(cfg.Hooks.PreDeploy), len(cfg.Hooks.PostDeploy))
+	}
+	for _, position := range []string{StepPreDeploy, StepPostDeploy} {
+		for _, step := range stepsOf(cfg, position) {
+			fmt.Fprintf(&plan, "  + run %s step %s on every host\n", position, step.Name())
+		}
+		for _, path := range hookExecutables(cfg, log, position) {
+			fmt.Fprintf(&plan, "  + run %s hook %s for every host\n", position, path)
+		}
+	}
+
+	return plan.String(), nil
+}
+
+// planHost compares the remote state of a single host with the configuration
+// and returns the actions a deployment would take
+func planHost(cfg *config.Config, log *logger.Logger) ([]string, error) {
+	var actions []string
+
+	// Image transfer
+	switch {
+	case cfg.Registry != "" || cfg.PrebuiltImage != "":
+		actions = append(actions, fmt.Sprintf("~ pull image %s on the host", cfg.ImageRef()))
+	default:
+		actions = append(actions, fmt.Sprintf("~ transfer image %s over SSH (%s)", cfg.ImageRef(), localImageSize(cfg, log)))
+	}
+
+	if envFiles := cfg.EnvFilePaths(); docker.EnvFileEncrypted(envFiles) {
+		actions = append(actions, fmt.Sprintf("~ decrypt and merge environment files %s, removed after the container starts", strings.Join(envFiles, ", ")))
+	} else if len(envFiles) > 0 {
+		actions = append(actions, fmt.Sprintf("~ merge environment files %s", strings.Join(envFiles, ", ")))
+	}
+	if copies, err := cfg.Copies(); err == nil {
+		for _, file := range copies {
+			actions = append(actions, fmt.Sprintf("~ copy %s to %s", file.Local, file.Remote))
+		}
+	}
+
+	if cfg.LogShipping.Enabled() {
+		actions = append(actions, fmt.Sprintf("~ ship logs to %s at %s", cfg.LogShipping.Type, cfg.LogShipping.URL))
+	}
+	if cfg.Metrics.Enabled {
+		nodeExporterPort, cadvisorPort := cfg.Metrics.Ports()
+		actions = append(actions, fmt.Sprintf("~ run node-exporter on port %s and cAdvisor on port %s", nodeExporterPort, cadvisorPort))
+	}
+
+	deployed, err := docker.DeployedContainer(cfg, log)
+	if err != nil {
+		return nil, err
+	}
+	exists := deployed != ""
+
+	if !exists {
+		actions = append(actions, "+ bootstrap host (state directory, initial commands)")
+	}
+
+	missing, err := docker.MissingStorage(cfg, log)
+	if err != nil {
+		return nil, err
+	}
+	for _, storage := range missing {
+		actions = append(actions, "+ create "+storage)
+	}
+
+	target := "container " + cfg.ContainerName
+	if cfg.Replicated() {
+		target = fmt.Sprintf("replicas %s to %s", cfg.ReplicaName(1), cfg.ReplicaName(cfg.Replicas))
+	}
+	if !exists {
+		return append(actions, fmt.Sprintf("+ create %s (first deployment)", target)), nil
+	}
+
+	changes, err := containerChanges(cfg, log, deployed)
+	if err != nil {
+		return nil, err
+	}
+
+	verb := "recreate"
+	if cfg.ReplacesAlongside() {
+		verb = fmt.Sprintf("replace (%s)", cfg.Strategy)
+	} else if cfg.Replicated() {
+		verb = "recreate one at a time"
+	}
+
+	if len(changes) == 0 {
+		return append(actions, fmt.Sprintf("~ %s %s (no configuration changes)", verb, target)), nil
+	}
+
+	actions = append(actions, fmt.Sprintf("~ %s %s because:", verb, target))
+	for _, change := range changes {
+		actions = append(actions, "    - "+change)
+	}
+
+	return actions, nil
+}
+
+// containerChanges lists the differences between the deployed container and
+// the configuration
+func containerChanges(cfg *config.Config, log *logger.Logger, name string) ([]string, error) {
+	current, err := inspectContainer(cfg, log, name)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []string
+	addChange := func(name, from, to string) {
+		if from != to {
+			changes = append(changes, fmt.Sprintf("%s: %q -> %q", name, from, to))
+		}
+	}
+
+	addChange("image", current.Config.Image, cfg.ImageRef())
+	if cfg.ReplicaPorts != config.ReplicaPortsDynamic || !cfg.Replicated() {
+		addChange("ports", currentPorts(current), fmt.Sprintf("%s:%s", cfg.HostPort, cfg.ContainerPort))
+	}
+	if replicated := name != cfg.ContainerName; replicated != cfg.Replicated() {
+		from, to := "1", strconv.Itoa(cfg.Replicas)
+		if replicated {
+			from, to = "several", "1"
+		}
+		addChange("replicas", from, to)
+	}
+	network := current.HostConfig.NetworkMode
+	if network == "default" || network == "bridge" {
+		network = ""
+	}
+	addChange("network", network, cfg.Network)
+
+	binds := append([]string(nil), current.HostConfig.Binds...)
+	volumes := append([]string(nil), cfg.Volumes...)
+	sort.Strings(binds)
+	sort.Strings(volumes)
+	addChange("volumes", strings.Join(binds, ","), strings.Join(volumes, ","))
+
+	addChange("cpus", formatCPUs(current.HostConfig.NanoCpus), normalizeCPUs(cfg.CPUs))
+	addChange("memory", formatMemory(current.HostConfig.Memory), formatMemory(memoryBytes(cfg.Memory)))
+	addChange("memory reservation", formatMemory(current.HostConfig.MemoryReservation), formatMemory(memoryBytes(cfg.MemoryReservation)))
+
+	// Docker defaults the swap limit to twice the memory limit
+	if cfg.MemorySwap != "" {
+		swap := cfg.MemorySwap
+		if swap != "-1" {
+			swap = formatMemory(memoryBytes(swap))
+		}
+		addChange("memory swap", formatSwap(current.HostConfig.MemorySwap), swap)
+	}
+
+	var pidsLimit int64
+	if current.HostConfig.PidsLimit != nil {
+		pidsLimit = *current.HostConfig.PidsLimit
+	}
+	addChange("pids limit", formatPidsLimit(pidsLimit), formatPidsLimit(int64(cfg.PidsLimit)))
+	addChange("cpu shares", strconv.FormatInt(current.HostConfig.CpuShares, 10), strconv.Itoa(cfg.CPUShares))
+	addChange("oom kill disable", strconv.FormatBool(current.HostConfig.OomKillDisable != nil && *current.HostConfig.OomKillDisable),
+		strconv.FormatBool(cfg.OOMKillDisable))
+	addChange("cpuset cpus", current.HostConfig.CpusetCpus, cfg.CPUsetCPUs)
+	addChange("cgroup parent", current.HostConfig.CgroupParent, cfg.CgroupParent)
+
+	return changes, nil
+}
+
+// currentPorts formats the port bindings of a container as host:container
+func currentPorts(container containerInspect) string {
+	var bindings map[string][]struct {
+		HostPort string `json:"HostPort"`
+	}
+	json.Unmarshal(container.HostConfig.PortBindings, &bindings)
+
+	var ports []string
+	for containerPort, hostPorts := range bindings {
+		for _, hostPort := range hostPorts {
+			ports = append(ports, fmt.Sprintf("%s:%s", hostPort.HostPort, strings.TrimSuffix(containerPort, "/tcp")))
+		}
+	}
+	sort.Strings(ports)
+	return strings.Join(ports, ",")
+}
+
+// localImageSize returns the size of the local image, if it exists yet
+func localImageSize(cfg *config.Config, log *logger.Logger) string {
+	sizeArgs := []string{"docker", "image", "inspect", "--format", "{{.Size}}", cfg.ImageRef()}
+	result, err := ssh.ExecuteCommand(cfg.Context(), log, sizeArgs, "Checking local image size")
+	if err != nil {
+		return "size known after build"
+	}
+
+	size, err := strconv.ParseFloat(strings.TrimSpace(result.Stdout), 64)
+	if err != nil {
+		return "size unknown"
+	}
+	return fmt.Sprintf("%.1f MB uncompressed", size/1024/1024)
+}
+
+// formatCPUs converts docker's NanoCpus to the --cpus notation
+func formatCPUs(nanoCPUs int64) string {
+	if nanoCPUs == 0 {
+		return ""
+	}
+	return strconv.FormatFloat(float64(nanoCPUs)/1e9, 'f', -1, 64)
+}
+
+// normalizeCPUs formats a --cpus value the same way as formatCPUs
+func normalizeCPUs(cpus string) string {
+	value, err := strconv.ParseFloat(cpus, 64)
+	if err != nil {
+		return cpus
+	}
+	return formatCPUs(int64(value * 1e9))
+}
+
+// memoryBytes converts a validated docker memory size to bytes
+func memoryBytes(memory string) int64 {
+	bytes, _ := config.ParseMemory(memory)
+	return bytes
+}
+
+// formatSwap formats docker's MemorySwap, where -1 means unlimited swap
+func formatSwap(bytes int64) string {
+	if bytes == -1 {
+		return "-1"
+	}
+	return formatMemory(bytes)
+}
+
+// formatPidsLimit formats docker's PidsLimit, which is zero or -1 when the
+// number of processes is unlimited
+func formatPidsLimit(limit int64) string {
+	if limit <= 0 {
+		return ""
+	}
+	return strconv.FormatInt(limit, 10)
+}
@@ -0,0 +1,56 @@
+package deploy
+
+import (
+	"fmt"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/docker"
+	"github.com/bjarneo/pipe/internal/logger"
+)
+
+// PullRemote downloads the image of the running container from the host to
+// the local docker daemon, to reproduce issues with the exact deployed image
+func PullRemote(cfg *config.Config, log *logger.Logger) error {
+	// Validate configuration
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	if len(cfg.Hosts) > 1 {
+		return fmt.Errorf("pull-remote works on a single host, choose one with --host")
+	}
+
+	if err := docker.CheckLocal(cfg, log); err != nil {
+		return err
+	}
+
+	exists, err := docker.Exists(cfg, log, cfg.ContainerName)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("container %s not found on %s", cfg.ContainerName, cfg.Host)
+	}
+
+	container, err := inspectContainer(cfg, log, cfg.ContainerName)
+	if err != nil {
+		return err
+	}
+	image := container.Config.Image
+
+	// Skip the download when the exact image is already available locally
+	if docker.LocalImageID(cfg, log, image) == container.Image {
+		return log.Info(fmt.Sprintf("Image %s (%s) is already available locally", image, shortID(container.Image)))
+	}
+
+	if err := docker.Download(cfg, log, image); err != nil {
+		return fmt.Errorf("failed to download image %s: %v", image, err)
+	}
+
+	// The tag may have moved on the host since the container was started
+	if id := docker.LocalImageID(cfg, log, image); id != container.Image {
+		return fmt.Errorf("downloaded %s is %s, but the container runs %s; the tag was changed on %s after the container started",
+			image, shortID(id), shortID(container.Image), cfg.Host)
+	}
+
+	return log.Info(fmt.Sprintf("Image %s (%s) pulled from %s, run it with: docker run %s", image, shortID(container.Image), cfg.Host, image))
+}
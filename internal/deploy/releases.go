@@ -0,0 +1,136 @@
+package deploy
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/docker"
+	"github.com/bjarneo/pipe/internal/history"
+	"github.com/bjarneo/pipe/internal/logger"
+	"github.com/bjarneo/pipe/internal/ssh"
+)
+
+// Releases lists past deployments and the versions kept on the host, shows
+// a single deployment when args is "show <id>", or lists the releases whose
+// SBOM contains a library when args is "contains <library>"
+func Releases(cfg *config.Config, log *logger.Logger, args []string) error {
+	// Validate configuration
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	switch {
+	case len(args) == 0:
+		return forEachHost(cfg, log, listReleases)
+	case len(args) == 2 && args[0] == "show":
+		return forEachHost(cfg, log, func(cfg *config.Config, log *logger.Logger) error {
+			return showRelease(cfg, log, args[1])
+		})
+	case len(args) == 2 && args[0] == "contains":
+		return forEachHost(cfg, log, func(cfg *config.Config, log *logger.Logger) error {
+			return releasesContaining(cfg, log, args[1])
+		})
+	default:
+		return fmt.Errorf("usage: pipe releases [show <id> | contains <library>]")
+	}
+}
+
+// listReleases prints a summary of every recorded deployment on a host
+func listReleases(cfg *config.Config, log *logger.Logger) error {
+	if err := ssh.Check(cfg, log); err != nil {
+		return err
+	}
+
+	records, err := history.Load(cfg, log)
+	if err != nil {
+		return err
+	}
+
+	if len(records) == 0 {
+		log.Output("No deployments recorded yet")
+	}
+
+	for i := len(records) - 1; i >= 0; i-- {
+		record := records[i]
+		log.Output(fmt.Sprintf("%-16s  %-8s  %-8s  %s:%s  %s",
+			record.ID, record.Action, record.Status, record.Image, record.Tag,
+			record.Timestamp.Format("2006-01-02 15:04:05 MST")))
+	}
+
+	return listVersions(cfg, log)
+}
+
+// listVersions prints the versions kept on a host that can be rolled back to
+func listVersions(cfg *config.Config, log *logger.Logger) error {
+	releases, err := docker.Releases(cfg, log)
+	if err != nil {
+		return err
+	}
+
+	currentCmd := ssh.Command("docker", "inspect", "--format", "{{.Config.Image}}", cfg.ContainerName) + " 2>/dev/null || true"
+	result, err := ssh.Capture(cfg, log, currentCmd, "Getting current container information")
+	if err != nil {
+		return err
+	}
+	current := strings.TrimSpace(result.Stdout)
+
+	log.Output("")
+	log.Output("Versions on the host (roll back with 'pipe rollback --to <tag>'):")
+	if len(releases) == 0 {
+		log.Output("  none")
+	}
+	for _, release := range releases {
+		marker := " "
+		if release.Ref == current {
+			marker = "*"
+		}
+		log.Output(fmt.Sprintf("%s %s", marker, release.Tag))
+	}
+
+	return nil
+}
+
+// showRelease prints the full transcript of a recorded deployment
+func showRelease(cfg *config.Config, log *logger.Logger, id string) error {
+	if err := ssh.Check(cfg, log); err != nil {
+		return err
+	}
+
+	records, err := history.Load(cfg, log)
+	if err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		if record.ID != id {
+			continue
+		}
+
+		log.Output(fmt.Sprintf("Release:  %s (%s)", record.ID, record.Action))
+		log.Output(fmt.Sprintf("Image:    %s:%s", record.Image, record.Tag))
+		log.Output(fmt.Sprintf("Started:  %s", record.Timestamp.Format("2006-01-02 15:04:05 MST")))
+		log.Output(fmt.Sprintf("Duration: %s", record.Duration.Round(time.Millisecond)))
+		log.Output(fmt.Sprintf("Status:   %s", record.Status))
+		if record.Error != "" {
+			log.Output(fmt.Sprintf("Error:    %s", record.Error))
+		}
+
+		for i, step := range record.Steps {
+			log.Output("")
+			log.Output(fmt.Sprintf("%d. %s (%s, exit code %d)",
+				i+1, step.Description, step.Duration.Round(time.Millisecond), step.ExitCode))
+			log.Output(fmt.Sprintf("   $ %s", step.Command))
+			if step.Output != "" {
+				for _, line := range strings.Split(step.Output, "\n") {
+					log.Output("   " + line)
+				}
+			}
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("release %s not found", id)
+}
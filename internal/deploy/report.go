@@ -0,0 +1,131 @@
+package deploy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/history"
+	"github.com/bjarneo/pipe/internal/logger"
+	"github.com/bjarneo/pipe/internal/notify"
+	"github.com/bjarneo/pipe/internal/ssh"
+)
+
+// Report is the outcome of a deployment or rollback with the timing of every
+// step on every host, handed to the report hooks and handlers at the end of
+// the run for SLO tracking
+type Report struct {
+	ID       string          `json:"id"`
+	Action   string          `json:"action"`
+	Status   string          `json:"status"`
+	Error    string          `json:"error,omitempty"`
+	Deployer string          `json:"deployer"`
+	Apps     []string        `json:"apps"`
+	Hosts    []string        `json:"hosts"`
+	Started  time.Time       `json:"started"`
+	Duration time.Duration   `json:"duration"`
+	Steps    []logger.Timing `json:"steps"`
+}
+
+// reportHandlersKey is the context key of the report handlers
+type reportHandlersKey struct{}
+
+// WithReportHandlers returns a context handing the report of every
+// deployment and rollback of the configurations using it to the given
+// functions at the end
+func WithReportHandlers(ctx context.Context, handlers []func(Report)) context.Context {
+	return context.WithValue(ctx, reportHandlersKey{}, handlers)
+}
+
+// timeStep runs a step of the pipeline with its name in the log and records
+// its timing for the run report
+func timeStep(log *logger.Logger, step string, fn func(log *logger.Logger) error) error {
+	log = log.WithStep(step)
+	return log.Time(func() error {
+		return fn(log)
+	})
+}
+
+// sendReport hands the report of a deployment or rollback to the registered
+// handlers and, as JSON on their stdin, to the report hooks and executables,
+// and exports its timings to the telemetry endpoints. Failing hooks and
+// exports are only logged.
+func sendReport(cfg *config.Config, log *logger.Logger, action string, services []config.Config, started time.Time, runErr error) {
+	executables := hookExecutables(cfg, log, "report")
+	reportHandlers, _ := cfg.Context().Value(reportHandlersKey{}).([]func(Report))
+	if len(cfg.Hooks.Report) == 0 && len(executables) == 0 && len(reportHandlers) == 0 && !cfg.Telemetry.Enabled() {
+		return
+	}
+
+	report := Report{
+		ID:       log.Started().Format("20060102-150405"),
+		Action:   action,
+		Status:   notify.Succeeded,
+		Deployer: history.Deployer(),
+		Started:  started,
+		Duration: time.Since(started),
+		Steps:    log.Timings(started),
+	}
+	report.Apps, report.Hosts = appsAndHosts(action, services)
+	if runErr != nil {
+		report.Status = notify.Failed
+		report.Error = log.Redact(runErr.Error())
+	}
+
+	for _, handler := range reportHandlers {
+		handler(report)
+	}
+
+	if cfg.Telemetry.Enabled() {
+		notify.ExportTelemetry(log, cfg.Telemetry, notify.Run{
+			Action:   report.Action,
+			Status:   report.Status,
+			Error:    report.Error,
+			Started:  report.Started,
+			Duration: report.Duration,
+			Steps:    report.Steps,
+		})
+	}
+
+	data, err := json.Marshal(report)
+	if err != nil {
+		log.Warn(fmt.Sprintf("failed to encode the run report: %v", err))
+		return
+	}
+	hooks := cfg.Hooks.Report
+	for i, hook := range hooks {
+		name := fmt.Sprintf("report hook %d/%d", i+1, len(hooks))
+		if _, err := ssh.ExecuteShell(cfg.Detached().Context(), log, hook.Local, nil,
+			"Running "+name, bytes.NewReader(data)); err != nil {
+			log.Warn(fmt.Sprintf("%s failed: %v", name, err))
+		}
+	}
+	for _, path := range executables {
+		if err := runHookExecutable(cfg.Detached(), log, "report", path, runErr, data); err != nil {
+			log.Warn(err.Error())
+		}
+	}
+}
+
+// appsAndHosts returns the images, or the containers for a rollback, and
+// the distinct hosts of the services
+func appsAndHosts(action string, services []config.Config) ([]string, []string) {
+	var apps, hosts []string
+	for _, service := range services {
+		app := service.ImageRef()
+		if action == "rollback" {
+			app = service.ContainerName
+		}
+		apps = append(apps, app)
+		for _, host := range service.Hosts {
+			if !slices.Contains(hosts, host) {
+				hosts = append(hosts, host)
+			}
+		}
+	}
+	return apps, hosts
+}
@@ -0,0 +1,120 @@
+package deploy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/docker"
+	"github.com/bjarneo/pipe/internal/history"
+	"github.com/bjarneo/pipe/internal/logger"
+	"github.com/bjarneo/pipe/internal/notify"
+	"github.com/bjarneo/pipe/internal/ssh"
+)
+
+// Result is the document printed on stdout with --output json once a
+// deployment or rollback is done, for CI pipelines to read instead of the
+// log
+type Result struct {
+	ID       string          `json:"id"`
+	Action   string          `json:"action"`
+	Status   string          `json:"status"`
+	ExitCode int             `json:"exitCode"`
+	Error    string          `json:"error,omitempty"`
+	DryRun   bool            `json:"dryRun,omitempty"`
+	Started  time.Time       `json:"started"`
+	Duration time.Duration   `json:"duration"`
+	Steps    []logger.Timing `json:"steps"`
+	Hosts    []HostResult    `json:"hosts"`
+}
+
+// HostResult is the outcome of a deployment or rollback of a container on a
+// single host
+type HostResult struct {
+	Host        string `json:"host"`
+	Container   string `json:"container"`
+	Image       string `json:"image"`
+	ImageID     string `json:"imageId,omitempty"`
+	Digest      string `json:"digest,omitempty"`
+	ContainerID string `json:"containerId,omitempty"`
+	Status      string `json:"status"`
+	Error       string `json:"error,omitempty"`
+}
+
+// hostResults collects the outcome on every host during the run
+var hostResults struct {
+	mu      sync.Mutex
+	results []HostResult
+}
+
+// addHostResult adds the outcome on a host, recorded in its history, to the
+// result document. The registry digest of the image is only read when the
+// document is printed.
+func addHostResult(cfg *config.Config, log *logger.Logger, record history.Record, containerID string) {
+	if cfg.OutputFormat != config.OutputJSON {
+		return
+	}
+
+	result := HostResult{
+		Host:        cfg.Host,
+		Container:   cfg.ContainerName,
+		Image:       record.Ref(),
+		ImageID:     record.ImageID,
+		ContainerID: containerID,
+		Status:      record.Status,
+		Error:       log.Redact(record.Error),
+	}
+	if record.Status == "success" && !ssh.DryRun() && (cfg.Registry != "" || cfg.PrebuiltImage != "") {
+		if digest, err := docker.PulledDigest(cfg.Detached(), log); err == nil {
+			result.Digest = digest
+		}
+	}
+
+	hostResults.mu.Lock()
+	defer hostResults.mu.Unlock()
+	hostResults.results = append(hostResults.results, result)
+}
+
+// PrintResult writes the result document of the deployment or rollback to
+// the writer when --output json is set
+func PrintResult(cfg *config.Config, log *logger.Logger, out io.Writer, runErr error) error {
+	if cfg.OutputFormat != config.OutputJSON {
+		return nil
+	}
+
+	action := "deployment"
+	if cfg.Command == "rollback" || cfg.Rollback {
+		action = "rollback"
+	}
+	result := Result{
+		ID:       log.Started().Format("20060102-150405"),
+		Action:   action,
+		Status:   notify.Succeeded,
+		DryRun:   cfg.DryRun,
+		Started:  log.Started(),
+		Duration: time.Since(log.Started()),
+		Steps:    log.Timings(log.Started()),
+	}
+	if result.Steps == nil {
+		result.Steps = []logger.Timing{}
+	}
+	if runErr != nil {
+		result.Status = notify.Failed
+		result.ExitCode = ssh.ExitCode(runErr)
+		result.Error = log.Redact(runErr.Error())
+	}
+
+	hostResults.mu.Lock()
+	result.Hosts = append([]HostResult{}, hostResults.results...)
+	hostResults.mu.Unlock()
+
+	data, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode the result: %v", err)
+	}
+	_, err = fmt.Fprintln(out, string(data))
+	return err
+}
@@ -0,0 +1,78 @@
+package deploy
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/logger"
+)
+
+// deployRolling deploys the hosts in batches of at most the maximum number of
+// unavailable hosts. Every host is verified by its deployment, so a batch is
+// only followed by the next once all of its hosts are healthy. The rollout
+// stops at the first failing batch, leaving the hosts after it on the
+// previous version, and optionally rolls back the hosts already deployed.
+func deployRolling(cfg *config.Config, log *logger.Logger) error {
+	batches := cfg.RollingBatches()
+
+	var mu sync.Mutex
+	var deployed []string
+	deployAndTrack := func(cfg *config.Config, log *logger.Logger) error {
+		if err := deployHost(cfg, log); err != nil {
+			return err
+		}
+		mu.Lock()
+		deployed = append(deployed, cfg.Host)
+		mu.Unlock()
+		return nil
+	}
+
+	for i, batch := range batches {
+		if err := log.Info(fmt.Sprintf("Deploying batch %d of %d (%s)", i+1, len(batches), strings.Join(batch, ", "))); err != nil {
+			return err
+		}
+
+		batchCfg := *cfg
+		batchCfg.Hosts = batch
+		batchCfg.Host = batch[0]
+		err := forEachHost(&batchCfg, log, deployAndTrack)
+		if err == nil {
+			err = cfg.Context().Err()
+		}
+		if err == nil {
+			continue
+		}
+
+		var untouched []string
+		for _, rest := range batches[i+1:] {
+			untouched = append(untouched, rest...)
+		}
+		if len(untouched) > 0 {
+			err = fmt.Errorf("rolling deployment stopped at batch %d of %d, %s kept the previous version: %v",
+				i+1, len(batches), strings.Join(untouched, ", "), err)
+		} else {
+			err = fmt.Errorf("rolling deployment failed at the last batch: %v", err)
+		}
+		if len(deployed) == 0 {
+			return err
+		}
+
+		if !cfg.RollingRollback {
+			log.Warn(fmt.Sprintf("%s run the new version, roll them back with pipe rollback or pass --rolling-rollback", strings.Join(deployed, ", ")))
+			return err
+		}
+
+		log.Warn(fmt.Sprintf("Rolling deployment failed, rolling back %s", strings.Join(deployed, ", ")))
+		rollbackCfg := *cfg
+		rollbackCfg.Hosts = deployed
+		rollbackCfg.Host = deployed[0]
+		if rollbackErr := forEachHost(&rollbackCfg, log, rollbackHost); rollbackErr != nil {
+			log.Warn(fmt.Sprintf("Failed to roll back the deployed hosts: %v", rollbackErr))
+		}
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,255 @@
+package deploy
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/docker"
+	"github.com/bjarneo/pipe/internal/history"
+	"github.com/bjarneo/pipe/internal/logger"
+	"github.com/bjarneo/pipe/internal/ssh"
+)
+
+// jobName matches the names of scheduled jobs
+var jobName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// cronField matches a single field of a cron schedule
+var cronField = regexp.MustCompile(`^[0-9A-Za-z*,/-]+$`)
+
+// cronMacros are the schedules cron accepts in place of the five fields
+var cronMacros = []string{"@reboot", "@yearly", "@annually", "@monthly", "@weekly", "@daily", "@midnight", "@hourly"}
+
+// Run builds and transfers the image like a deployment, then runs a command
+// to completion in a new container from it on the host, streaming its output
+// and exiting with its exit code. With a schedule the command is installed
+// as a cron job on the host instead. The running container is not touched.
+func Run(cfg *config.Config, log *logger.Logger, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: pipe run [options] -- <command> [args...]")
+	}
+
+	// Validate configuration
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	if len(cfg.Hosts) > 1 {
+		return fmt.Errorf("a job runs on a single host, choose one with --host")
+	}
+	if cfg.Schedule != "" {
+		if err := validateSchedule(cfg); err != nil {
+			return err
+		}
+	}
+
+	// Preliminary checks
+	if cfg.PrebuiltImage == "" {
+		if err := docker.CheckLocal(cfg, log); err != nil {
+			return err
+		}
+	}
+	if err := docker.CheckRemote(cfg, log); err != nil {
+		return err
+	}
+	if err := docker.CheckPlatform(cfg, log); err != nil {
+		return err
+	}
+	if err := ssh.Check(cfg, log); err != nil {
+		return err
+	}
+
+	defer removeSBOMs([]config.Config{*cfg})
+	endGroup := log.Group("Build " + cfg.ImageRef())
+	err := resolvePlatform(cfg, log)
+	if err == nil {
+		err = runPreBuildHooks(cfg, log)
+	}
+	if err == nil {
+		err = timeStep(log, "build", func(log *logger.Logger) error {
+			return buildImage(cfg, log)
+		})
+	}
+	endGroup()
+	if err != nil {
+		return err
+	}
+
+	if err := timeStep(log, "transfer", func(log *logger.Logger) error {
+		return docker.Transfer(cfg, log)
+	}); err != nil {
+		return err
+	}
+
+	// Refuse to run an image that is not signed as configured
+	if err := docker.VerifySignature(cfg, log); err != nil {
+		return err
+	}
+
+	if err := docker.PrepareState(cfg, log, cfg.EnvFile); err != nil {
+		return err
+	}
+
+	// The scheduled job keeps reading the env file, decrypted variables are
+	// only kept on the host while a job runs
+	if envFiles := cfg.EnvFilePaths(); len(envFiles) > 0 {
+		if err := docker.CopyEnvFile(cfg, log, envFiles); err != nil {
+			return err
+		}
+		if cfg.Schedule == "" {
+			defer docker.RemoveEnvFile(cfg, log, envFiles)
+		}
+	}
+	if err := docker.CopyFiles(cfg, log); err != nil {
+		return err
+	}
+
+	if cfg.Schedule != "" {
+		return scheduleJob(cfg, log, args)
+	}
+	return runContainerJob(cfg, log, args)
+}
+
+// validateSchedule checks the schedule and name of a job to schedule, and
+// that the host can keep running it
+func validateSchedule(cfg *config.Config) error {
+	if !jobName.MatchString(cfg.JobName) {
+		return fmt.Errorf("invalid job name %q: expected letters, digits, '.', '_' and '-'", cfg.JobName)
+	}
+
+	fields := strings.Fields(cfg.Schedule)
+	switch {
+	case len(fields) == 1 && slices.Contains(cronMacros, fields[0]):
+	case len(fields) == 5 && !slices.ContainsFunc(fields, func(field string) bool { return !cronField.MatchString(field) }):
+	default:
+		return fmt.Errorf("invalid schedule %q: expected five cron fields such as '0 3 * * *', or one of %s",
+			cfg.Schedule, strings.Join(cronMacros, ", "))
+	}
+
+	if cfg.DockerTLS() {
+		return fmt.Errorf("--schedule installs a cron job over SSH, which --transport %s does not use", config.TransportDockerTLS)
+	}
+	if docker.EnvFileEncrypted(cfg.EnvFilePaths()) {
+		return fmt.Errorf("--schedule keeps the env file on the host, which cannot hold the decrypted variables of encrypted env files")
+	}
+	return nil
+}
+
+// runContainerJob runs a command to completion in a new container on the
+// host, recording its output and exit code like pipe jobs run. A failing
+// command makes pipe exit with its exit code.
+func runContainerJob(cfg *config.Config, log *logger.Logger, args []string) error {
+	id := log.Started().Format("20060102-150405")
+	name := fmt.Sprintf("%s-run-%s", cfg.ContainerName, id)
+	runCmd := ssh.Command(append([]string{"docker", "run"}, docker.JobArgs(cfg, name, args)...)...)
+	jobCmd := history.JobCommand(cfg, id, strings.Join(args, " "), runCmd)
+
+	err := timeStep(log, "run", func(log *logger.Logger) error {
+		return ssh.Stream(cfg, log, jobCmd, fmt.Sprintf("Running job %s in a new container from %s", id, cfg.ImageRef()))
+	})
+	var exitErr *ssh.ExitError
+	switch {
+	case errors.As(err, &exitErr):
+		return &ssh.DockerError{
+			Message: fmt.Sprintf("job %s exited with code %d, see pipe jobs logs %s", id, exitErr.Code, id),
+			Code:    exitErr.Code,
+			Err:     err,
+		}
+	case err != nil:
+		return fmt.Errorf("job %s failed, see pipe jobs logs %s: %v", id, id, err)
+	case cfg.Context().Err() != nil:
+		return fmt.Errorf("job %s was cancelled, its container %s may still be running", id, name)
+	}
+	return log.Info(fmt.Sprintf("Job %s completed", id))
+}
+
+// scheduleJob installs a command as a cron job of the SSH user on the host.
+// Cron runs a script in the state directory, which runs the command in a new
+// container and records every run in the job history. A run still going when
+// the next one is due makes the next one fail, as the container name is
+// taken.
+func scheduleJob(cfg *config.Config, log *logger.Logger, args []string) error {
+	result, err := ssh.Capture(cfg, log, "command -v crontab >/dev/null && echo yes || echo no", "Checking for cron on server")
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(result.Stdout) != "yes" {
+		return fmt.Errorf("crontab is not installed on %s, install cron to schedule jobs", cfg.Host)
+	}
+
+	name := fmt.Sprintf("%s-job-%s", cfg.ContainerName, cfg.JobName)
+	runCmd := ssh.Command(append([]string{"docker", "run"}, docker.JobArgs(cfg, name, args)...)...)
+	script := fmt.Sprintf("#!/bin/sh\n# Job %s of %s, installed by pipe run --schedule\n%s\n",
+		cfg.JobName, cfg.ContainerName, history.ScheduledJobCommand(cfg, cfg.JobName, ssh.AsDockerUser(cfg, runCmd)))
+	if err := ssh.WriteFile(cfg, log, []byte(script), cfg.CronScript(cfg.JobName), "Writing job script"); err != nil {
+		return fmt.Errorf("failed to write job script: %v", err)
+	}
+
+	entry := fmt.Sprintf("%s sh %s >/dev/null 2>&1 %s", cfg.Schedule, ssh.Command(cfg.CronScript(cfg.JobName)), cronMarker(cfg, cfg.JobName))
+	installCmd := fmt.Sprintf("(%s; echo %s) | crontab -", otherCronEntries(cfg, cfg.JobName), ssh.Quote(entry))
+	if _, err := ssh.Run(cfg, log, installCmd, fmt.Sprintf("Scheduling job %s", cfg.JobName)); err != nil {
+		return fmt.Errorf("failed to install cron job: %v", err)
+	}
+
+	return log.Info(fmt.Sprintf("Scheduled job %s to run %s on %s, see pipe jobs history for its runs", cfg.JobName, cfg.Schedule, cfg.Host))
+}
+
+// cronMarker returns the comment marking the crontab entry of a scheduled
+// job of the app
+func cronMarker(cfg *config.Config, name string) string {
+	return fmt.Sprintf("# pipe:%s:%s", cfg.ContainerName, name)
+}
+
+// otherCronEntries returns the shell command printing the crontab of the
+// host without the entry of a scheduled job
+func otherCronEntries(cfg *config.Config, name string) string {
+	return "crontab -l 2>/dev/null | " + ssh.Command("awk", "-v", "m= "+cronMarker(cfg, name),
+		"length($0) < length(m) || substr($0, length($0) - length(m) + 1) != m")
+}
+
+// listScheduledJobs prints the jobs of the app scheduled with cron on a host
+func listScheduledJobs(cfg *config.Config, log *logger.Logger) error {
+	if err := ssh.Check(cfg, log); err != nil {
+		return err
+	}
+
+	result, err := ssh.Capture(cfg, log, "crontab -l 2>/dev/null || true", "Reading crontab")
+	if err != nil {
+		return err
+	}
+
+	prefix := cronMarker(cfg, "")
+	found := false
+	for _, line := range strings.Split(result.Stdout, "\n") {
+		schedule, name, ok := strings.Cut(line, " sh ")
+		if !ok {
+			continue
+		}
+		i := strings.LastIndex(name, " "+prefix)
+		if i < 0 {
+			continue
+		}
+		found = true
+		log.Output(fmt.Sprintf("%-16s  %s", name[i+len(prefix)+1:], schedule))
+	}
+	if !found {
+		log.Output("No jobs scheduled")
+	}
+	return nil
+}
+
+// unscheduleJob removes a scheduled job of the app and its script from a
+// host. The job history is kept.
+func unscheduleJob(cfg *config.Config, log *logger.Logger, name string) error {
+	if err := ssh.Check(cfg, log); err != nil {
+		return err
+	}
+
+	removeCmd := fmt.Sprintf("%s | crontab - && %s", otherCronEntries(cfg, name), ssh.Command("rm", "-f", cfg.CronScript(name)))
+	if _, err := ssh.Run(cfg, log, removeCmd, fmt.Sprintf("Removing scheduled job %s", name)); err != nil {
+		return fmt.Errorf("failed to remove scheduled job %s: %v", name, err)
+	}
+	return log.Info(fmt.Sprintf("Removed scheduled job %s", name))
+}
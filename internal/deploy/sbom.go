@@ -0,0 +1,77 @@
+package deploy
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/docker"
+	"github.com/bjarneo/pipe/internal/logger"
+	"github.com/bjarneo/pipe/internal/notify"
+	"github.com/bjarneo/pipe/internal/ssh"
+)
+
+// generateSBOM generates the SBOM of the image and uploads it to the
+// artifact endpoint. A failed upload only logs a warning.
+func generateSBOM(cfg *config.Config, log *logger.Logger) error {
+	if err := docker.GenerateSBOM(cfg, log); err != nil {
+		return err
+	}
+	if !cfg.SBOM.Enabled || cfg.SBOM.Upload == "" || ssh.DryRun() || cfg.Target != "" {
+		return nil
+	}
+	if err := notify.UploadSBOM(cfg, log, docker.LocalSBOM(cfg)); err != nil {
+		log.Warn(fmt.Sprintf("failed to upload SBOM: %v", err))
+		return nil
+	}
+	return log.Info(fmt.Sprintf("Uploaded SBOM of %s", cfg.ImageRef()))
+}
+
+// removeSBOMs removes the SBOMs generated on this machine once they are
+// stored on the hosts
+func removeSBOMs(services []config.Config) {
+	for i := range services {
+		if services[i].SBOM.Enabled {
+			os.Remove(docker.LocalSBOM(&services[i]))
+		}
+	}
+}
+
+// releasesContaining prints the releases on a host whose SBOM lists a
+// component with the library in its name, marking the running release
+func releasesContaining(cfg *config.Config, log *logger.Logger, library string) error {
+	if err := ssh.Check(cfg, log); err != nil {
+		return err
+	}
+
+	found, err := docker.FindInSBOMs(cfg, log, library)
+	if err != nil {
+		return err
+	}
+	if len(found) == 0 {
+		log.Output(fmt.Sprintf("No stored SBOM lists %s", library))
+		return nil
+	}
+
+	running := ""
+	if container, err := inspectContainer(cfg, log, cfg.ContainerName); err == nil {
+		running = container.Config.Image
+	}
+
+	tags := make([]string, 0, len(found))
+	for tag := range found {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	for _, tag := range tags {
+		marker := " "
+		if running == cfg.Repository()+":"+tag {
+			marker = "*"
+		}
+		for _, component := range found[tag] {
+			log.Output(fmt.Sprintf("%s %-20s  %s %s", marker, tag, component.Name, component.Version))
+		}
+	}
+	return nil
+}
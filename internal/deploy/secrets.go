@@ -0,0 +1,111 @@
+package deploy
+
+import (
+	"fmt"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/logger"
+	"github.com/bjarneo/pipe/internal/secrets"
+)
+
+// resolveSecrets replaces secret references in the environment variables and
+// build arguments of every service, in the environment of the accessories
+// and in the monitor ping URL and log shipping password with their values. Resolved values are masked
+// in all log output.
+func resolveSecrets(cfg *config.Config, log *logger.Logger, services []config.Config) error {
+	resolved := make(map[string]string)
+	resolve := func(values map[string]string) error {
+		for key, value := range values {
+			secret, err := resolveSecret(log, resolved, key, value)
+			if err != nil {
+				return err
+			}
+			values[key] = secret
+		}
+		return nil
+	}
+
+	for i := range services {
+		if err := resolve(services[i].Env); err != nil {
+			return err
+		}
+		if err := resolve(services[i].BuildArgs); err != nil {
+			return err
+		}
+
+		pingURL, err := resolveSecret(log, resolved, "monitor.pingUrl", services[i].Monitor.PingURL)
+		if err != nil {
+			return err
+		}
+		services[i].Monitor.PingURL = pingURL
+
+		password, err := resolveSecret(log, resolved, "logShipping.password", services[i].LogShipping.Password)
+		if err != nil {
+			return err
+		}
+		services[i].LogShipping.Password = password
+	}
+	for i := range cfg.Accessories {
+		if err := resolve(cfg.Accessories[i].Env); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// minSecretLength is the length below which values are not treated as secrets
+// on their own
+const minSecretLength = 8
+
+// MaskSecrets hides the environment variables and build arguments of every
+// service and accessory, as they are passed to docker, and the registry
+// password in all log output. Values of secret-looking names are hidden
+// wherever they appear, unless they are too short to be secrets, such as
+// AUTH_ENABLED=true.
+func MaskSecrets(cfg *config.Config, log *logger.Logger) {
+	log.Mask(cfg.RegistryPass)
+
+	services, err := cfg.Services()
+	if err != nil {
+		services = []config.Config{*cfg}
+	}
+
+	mask := func(values map[string]string) {
+		log.MaskAssignments(values)
+		for key, value := range values {
+			if secretName.MatchString(key) && len(value) >= minSecretLength && !secrets.IsReference(value) {
+				log.Mask(value)
+			}
+		}
+	}
+	for _, service := range services {
+		mask(service.Env)
+		mask(service.BuildArgs)
+	}
+	for _, accessory := range cfg.Accessories {
+		mask(accessory.Env)
+	}
+}
+
+// resolveSecret returns the value of a secret reference, using and filling
+// the cache of resolved references. Other values are returned unchanged.
+func resolveSecret(log *logger.Logger, resolved map[string]string, name string, value string) (string, error) {
+	if !secrets.IsReference(value) {
+		return value, nil
+	}
+	if secret, ok := resolved[value]; ok {
+		return secret, nil
+	}
+
+	if err := log.Info(fmt.Sprintf("Resolving secret %s for %s", value, name)); err != nil {
+		return "", err
+	}
+	secret, err := secrets.Resolve(value)
+	if err != nil {
+		return "", err
+	}
+	resolved[value] = secret
+	log.Mask(secret)
+	return secret, nil
+}
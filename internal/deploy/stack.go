@@ -0,0 +1,85 @@
+package deploy
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/logger"
+)
+
+// services returns the validated configuration of every service to deploy,
+// in deployment order, without its cordoned hosts. Without a stack this is
+// the configuration itself.
+func services(cfg *config.Config, log *logger.Logger) ([]config.Config, error) {
+	services, err := cfg.Services()
+	if err != nil {
+		return nil, err
+	}
+	for _, value := range config.ResolvedValues() {
+		log.Info("Resolved " + value)
+	}
+
+	for i := range services {
+		err := services[i].Validate()
+		if err == nil {
+			err = skipCordoned(&services[i], serviceLogger(log, &services[i]))
+		}
+		if err != nil {
+			if services[i].ServiceName != "" {
+				return nil, fmt.Errorf("service %s: %v", services[i].ServiceName, err)
+			}
+			return nil, err
+		}
+	}
+
+	return services, nil
+}
+
+// skipCordoned removes the cordoned hosts from the configuration with a
+// warning, so deployments leave hosts being debugged or migrated alone
+func skipCordoned(cfg *config.Config, log *logger.Logger) error {
+	var hosts []string
+	for _, host := range cfg.Hosts {
+		if slices.Contains(cfg.Cordoned, host) {
+			log.Warn(fmt.Sprintf("skipping cordoned host %s", host))
+			continue
+		}
+		hosts = append(hosts, host)
+	}
+
+	if len(hosts) == 0 {
+		return fmt.Errorf("every host is cordoned, remove %s from cordoned to deploy to it", strings.Join(cfg.Hosts, ", "))
+	}
+
+	cfg.Hosts, cfg.Host = hosts, hosts[0]
+	return nil
+}
+
+// serviceLogger returns a logger prefixed with the service name when the
+// configuration is a service of a stack
+func serviceLogger(log *logger.Logger, cfg *config.Config) *logger.Logger {
+	if cfg.ServiceName == "" {
+		return log
+	}
+	return log.WithService(cfg.ServiceName)
+}
+
+// stackError describes the failure of the i-th service and the services that
+// were skipped because of it
+func stackError(services []config.Config, i int, err error) error {
+	if services[i].ServiceName == "" {
+		return err
+	}
+
+	var skipped []string
+	for _, service := range services[i+1:] {
+		skipped = append(skipped, service.ServiceName)
+	}
+	if len(skipped) == 0 {
+		return fmt.Errorf("service %s: %v", services[i].ServiceName, err)
+	}
+
+	return fmt.Errorf("service %s: %v (skipped: %s)", services[i].ServiceName, err, strings.Join(skipped, ", "))
+}
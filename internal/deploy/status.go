@@ -0,0 +1,247 @@
+package deploy
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/docker"
+	"github.com/bjarneo/pipe/internal/logger"
+	"github.com/bjarneo/pipe/internal/notify"
+	"github.com/bjarneo/pipe/internal/ssh"
+)
+
+// hostStatus is the state of the app on a single host
+type hostStatus struct {
+	Host           string         `json:"host"`
+	Container      string         `json:"container"`
+	State          string         `json:"state"`
+	Health         string         `json:"health,omitempty"`
+	StartedAt      *time.Time     `json:"startedAt,omitempty"`
+	Uptime         string         `json:"uptime,omitempty"`
+	Image          string         `json:"image,omitempty"`
+	Restarts       int            `json:"restarts"`
+	RebootRequired bool           `json:"rebootRequired"`
+	RebootPackages []string       `json:"rebootPackages,omitempty"`
+	Agent          string         `json:"agent,omitempty"`
+	Monitor        string         `json:"monitor,omitempty"`
+	Usage          *resourceUsage `json:"usage,omitempty"`
+	Releases       []release      `json:"releases"`
+	Error          string         `json:"error,omitempty"`
+}
+
+// release is an image of the app kept on the host
+type release struct {
+	Image   string `json:"image"`
+	ID      string `json:"id"`
+	Created string `json:"created"`
+	Current bool   `json:"current"`
+}
+
+// Status reports the state of the container and the releases kept on every
+// configured host
+func Status(cfg *config.Config, log *logger.Logger) error {
+	// Validate configuration
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	// Keep stdout clean for scripts
+	if cfg.JSON {
+		log.SetQuiet(true)
+	}
+
+	statuses := make([]hostStatus, len(cfg.Hosts))
+	var mu sync.Mutex
+
+	err := forEachHost(cfg, log, func(cfg *config.Config, log *logger.Logger) error {
+		status, err := hostStatusOf(cfg, log)
+		if err != nil {
+			status = hostStatus{Host: cfg.Host, Container: cfg.ContainerName, State: "unknown", Releases: []release{}, Error: err.Error()}
+		}
+		mu.Lock()
+		for i, host := range cfg.Hosts {
+			if host == cfg.Host {
+				statuses[i] = status
+			}
+		}
+		mu.Unlock()
+		return err
+	})
+
+	// The uptime monitor watches the app as a whole
+	if cfg.Monitor.PingURL != "" || cfg.Monitor.StatusURL != "" {
+		monitor, monitorErr := notify.MonitorStatus(log, cfg.Monitor)
+		if monitorErr != nil {
+			monitor = fmt.Sprintf("unknown (%v)", monitorErr)
+		}
+		for i := range statuses {
+			statuses[i].Monitor = monitor
+		}
+	}
+
+	if cfg.JSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if encodeErr := encoder.Encode(statuses); encodeErr != nil {
+			return encodeErr
+		}
+	} else {
+		for _, status := range statuses {
+			printStatus(log, status)
+		}
+		printRebootSummary(log, statuses)
+	}
+
+	return err
+}
+
+// hostStatusOf collects the status of the app on a single host
+func hostStatusOf(cfg *config.Config, log *logger.Logger) (hostStatus, error) {
+	status := hostStatus{Host: cfg.Host, Container: cfg.Containers()[0], State: "not deployed", Releases: []release{}}
+
+	if err := ssh.Check(cfg, log); err != nil {
+		return status, err
+	}
+
+	// Replicas share their image and settings, the first one stands for all
+	exists, err := docker.Exists(cfg, log, status.Container)
+	if err != nil {
+		return status, err
+	}
+
+	var container *containerInspect
+	if exists {
+		inspected, err := inspectContainer(cfg, log, status.Container)
+		if err != nil {
+			return status, err
+		}
+		container = &inspected
+
+		status.State = container.State.Status
+		status.Image = container.Config.Image
+		status.Restarts = container.RestartCount
+		if container.State.Health != nil {
+			status.Health = container.State.Health.Status
+		}
+		if container.State.Running {
+			startedAt := container.State.StartedAt
+			status.StartedAt = &startedAt
+			status.Uptime = time.Since(startedAt).Round(time.Second).String()
+		}
+	}
+
+	status.RebootRequired, status.RebootPackages, err = docker.RebootRequired(cfg, log)
+	if err != nil {
+		return status, err
+	}
+
+	if cfg.Agent.Enabled {
+		active, report, err := docker.AgentStatus(cfg, log)
+		if err != nil {
+			return status, err
+		}
+		status.Agent = agentSummary(active, report)
+	}
+
+	// Resource usage against the limits, to help right-size the host
+	if cfg.Wide {
+		status.Usage, err = resourceUsageOf(cfg, log, container)
+		if err != nil {
+			return status, err
+		}
+	}
+
+	releases, err := docker.Releases(cfg, log)
+	if err != nil {
+		return status, err
+	}
+	for _, image := range releases {
+		status.Releases = append(status.Releases, release{
+			Image:   image.Ref,
+			ID:      shortID(image.ID),
+			Created: image.Created.Local().Format("2006-01-02 15:04:05 MST"),
+			Current: image.Ref == status.Image,
+		})
+	}
+
+	return status, nil
+}
+
+// printStatus prints the status of a host in human-readable form
+func printStatus(log *logger.Logger, status hostStatus) {
+	log.Output(fmt.Sprintf("Host:      %s", status.Host))
+	log.Output(fmt.Sprintf("Container: %s", status.Container))
+
+	state := status.State
+	if status.Health != "" {
+		state += fmt.Sprintf(" (%s)", status.Health)
+	}
+	if status.Uptime != "" {
+		state += fmt.Sprintf(", up %s", status.Uptime)
+	}
+	log.Output(fmt.Sprintf("State:     %s", state))
+
+	if status.Error != "" {
+		log.Output(fmt.Sprintf("Error:     %s", status.Error))
+		log.Output("")
+		return
+	}
+
+	if status.Image != "" {
+		log.Output(fmt.Sprintf("Image:     %s", status.Image))
+		log.Output(fmt.Sprintf("Restarts:  %d", status.Restarts))
+	}
+
+	if status.RebootRequired {
+		reboot := "required"
+		if len(status.RebootPackages) > 0 {
+			reboot += fmt.Sprintf(" by %s", strings.Join(status.RebootPackages, ", "))
+		}
+		log.Output(fmt.Sprintf("Reboot:    %s", reboot))
+	}
+
+	if status.Agent != "" {
+		log.Output(fmt.Sprintf("Agent:     %s", status.Agent))
+	}
+	if status.Monitor != "" {
+		log.Output(fmt.Sprintf("Monitor:   %s", status.Monitor))
+	}
+	if status.Usage != nil {
+		printUsage(log, status.Usage)
+	}
+
+	log.Output("Releases:")
+	if len(status.Releases) == 0 {
+		log.Output("  none")
+	}
+	for _, release := range status.Releases {
+		marker := " "
+		if release.Current {
+			marker = "*"
+		}
+		log.Output(fmt.Sprintf("%s %-40s  %s  %s", marker, release.Image, release.ID, release.Created))
+	}
+	log.Output("")
+}
+
+// printRebootSummary prints the hosts that need a reboot to finish installing
+// updates
+func printRebootSummary(log *logger.Logger, statuses []hostStatus) {
+	var hosts []string
+	for _, status := range statuses {
+		if status.RebootRequired {
+			hosts = append(hosts, status.Host)
+		}
+	}
+	if len(hosts) == 0 {
+		return
+	}
+
+	log.Output(fmt.Sprintf("%d of %d hosts require a reboot: %s", len(hosts), len(statuses), strings.Join(hosts, ", ")))
+	log.Output("Run 'pipe host reboot' to reboot them one at a time.")
+}
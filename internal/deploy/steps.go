@@ -0,0 +1,85 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/logger"
+	"github.com/bjarneo/pipe/internal/ssh"
+)
+
+// Positions in the pipeline where custom steps run, each right after the
+// hooks of the same name
+const (
+	StepPreBuild     = "preBuild"
+	StepPreDeploy    = "preDeploy"
+	StepPostDeploy   = "postDeploy"
+	StepOnFailure    = "onFailure"
+	StepPreRollback  = "preRollback"
+	StepPostRollback = "postRollback"
+)
+
+// stepPositions lists the valid positions in pipeline order, followed by
+// those of rollbacks
+var stepPositions = []string{StepPreBuild, StepPreDeploy, StepPostDeploy, StepOnFailure, StepPreRollback, StepPostRollback}
+
+// Step is a custom step that programs embedding pipe insert into the
+// pipeline, such as an update of an internal inventory
+type Step interface {
+	// Name identifies the step in the log and in errors
+	Name() string
+
+	// Run runs the step. preBuild steps run once for the whole deployment,
+	// the others for every host. runErr is the failure of the deployment
+	// in onFailure steps and nil elsewhere.
+	Run(cfg *config.Config, log *logger.Logger, runErr error) error
+}
+
+// stepsKey is the context key of the custom steps
+type stepsKey struct{}
+
+// CheckStepPosition returns an error for a position custom steps cannot run
+// at
+func CheckStepPosition(position string) error {
+	if !slices.Contains(stepPositions, position) {
+		return fmt.Errorf("invalid step position %q: expected one of %s", position, strings.Join(stepPositions, ", "))
+	}
+	return nil
+}
+
+// WithSteps returns a context running the given custom steps, keyed by
+// position, in the pipelines of the configurations using it
+func WithSteps(ctx context.Context, positions map[string][]Step) context.Context {
+	return context.WithValue(ctx, stepsKey{}, positions)
+}
+
+// stepsOf returns the custom steps of a position in the pipeline of the
+// configuration
+func stepsOf(cfg *config.Config, position string) []Step {
+	positions, _ := cfg.Context().Value(stepsKey{}).(map[string][]Step)
+	return positions[position]
+}
+
+// runSteps runs the custom steps of a position, stopping at the first step
+// that fails. A dry run only names them, as their effects are unknown.
+func runSteps(cfg *config.Config, log *logger.Logger, position string, runErr error) error {
+	for _, step := range stepsOf(cfg, position) {
+		if ssh.DryRun() {
+			if err := log.Info(fmt.Sprintf("[dry-run] %s step %s", position, step.Name())); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := log.Info(fmt.Sprintf("Running %s step %s...", position, step.Name())); err != nil {
+			return err
+		}
+		if err := step.Run(cfg, log, runErr); err != nil {
+			return fmt.Errorf("%s step %s failed: %v", position, step.Name(), err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,19 @@
+package deploy
+
+import (
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/docker"
+	"github.com/bjarneo/pipe/internal/logger"
+)
+
+// Unlock releases the deploy lock of the app on every host. Deployments and
+// rollbacks release it themselves, even when they fail or are cancelled, so
+// this is only needed after a run was killed.
+func Unlock(cfg *config.Config, log *logger.Logger) error {
+	// Validate configuration
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	return forEachHost(cfg, log, docker.Unlock)
+}
@@ -0,0 +1,130 @@
+package deploy
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/logger"
+	"github.com/bjarneo/pipe/internal/ssh"
+)
+
+// resourceUsage is the resource usage of the container against its limits,
+// and the image storage and disk space of the host
+type resourceUsage struct {
+	CPUPercent        string `json:"cpuPercent,omitempty"`
+	CPULimit          string `json:"cpuLimit,omitempty"`
+	MemoryUsage       string `json:"memoryUsage,omitempty"`
+	MemoryLimit       string `json:"memoryLimit,omitempty"`
+	MemoryPercent     string `json:"memoryPercent,omitempty"`
+	PIDs              string `json:"pids,omitempty"`
+	PidsLimit         string `json:"pidsLimit,omitempty"`
+	ImagesSize        string `json:"imagesSize"`
+	ImagesReclaimable string `json:"imagesReclaimable"`
+	DiskSize          string `json:"diskSize"`
+	DiskUsed          string `json:"diskUsed"`
+	DiskAvailable     string `json:"diskAvailable"`
+	DiskUsedPercent   string `json:"diskUsedPercent"`
+}
+
+// containerStats holds the parts of `docker stats` used in the status
+type containerStats struct {
+	CPUPerc  string `json:"CPUPerc"`
+	MemUsage string `json:"MemUsage"`
+	MemPerc  string `json:"MemPerc"`
+	PIDs     string `json:"PIDs"`
+}
+
+// diskUsage holds the parts of `docker system df` used in the status
+type diskUsage struct {
+	Type        string `json:"Type"`
+	Size        string `json:"Size"`
+	Reclaimable string `json:"Reclaimable"`
+}
+
+// resourceUsageOf collects the resource usage of the container, when it is
+// running, and the image storage and disk headroom of the host
+func resourceUsageOf(cfg *config.Config, log *logger.Logger, container *containerInspect) (*resourceUsage, error) {
+	usage := &resourceUsage{}
+
+	if container != nil && container.State.Running {
+		statsCmd := ssh.Command("docker", "stats", "--no-stream", "--format", "{{json .}}", cfg.ContainerName)
+		result, err := ssh.Capture(cfg, log, statsCmd, "Reading container resource usage")
+		if err != nil {
+			return nil, fmt.Errorf("failed to read container resource usage: %v", err)
+		}
+		var stats containerStats
+		if err := json.Unmarshal([]byte(strings.TrimSpace(result.Stdout)), &stats); err != nil {
+			return nil, fmt.Errorf("failed to parse container resource usage: %v", err)
+		}
+
+		// Without a memory limit docker reports the memory of the host
+		memoryUsage, memoryLimit, _ := strings.Cut(stats.MemUsage, " / ")
+		usage.CPUPercent = stats.CPUPerc
+		usage.CPULimit = formatCPUs(container.HostConfig.NanoCpus)
+		usage.MemoryUsage = memoryUsage
+		usage.MemoryLimit = memoryLimit
+		usage.MemoryPercent = stats.MemPerc
+		usage.PIDs = stats.PIDs
+		if container.HostConfig.PidsLimit != nil {
+			usage.PidsLimit = formatPidsLimit(*container.HostConfig.PidsLimit)
+		}
+		if container.HostConfig.Memory == 0 {
+			usage.MemoryLimit = ""
+		}
+	}
+
+	dfCmd := ssh.Command("docker", "system", "df", "--format", "{{json .}}")
+	result, err := ssh.Capture(cfg, log, dfCmd, "Reading image storage")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image storage: %v", err)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(result.Stdout), "\n") {
+		var row diskUsage
+		if json.Unmarshal([]byte(line), &row) == nil && row.Type == "Images" {
+			usage.ImagesSize = row.Size
+			usage.ImagesReclaimable = row.Reclaimable
+		}
+	}
+
+	// Report the file system docker keeps its images and volumes on
+	diskCmd := fmt.Sprintf(`df -Ph "$(%s)" | tail -n 1`, ssh.Command("docker", "info", "--format", "{{.DockerRootDir}}"))
+	result, err = ssh.Capture(cfg, log, diskCmd, "Reading disk space")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read disk space: %v", err)
+	}
+	fields := strings.Fields(result.Stdout)
+	if len(fields) < 5 {
+		return nil, fmt.Errorf("failed to parse disk space: %q", strings.TrimSpace(result.Stdout))
+	}
+	usage.DiskSize, usage.DiskUsed, usage.DiskAvailable, usage.DiskUsedPercent = fields[1], fields[2], fields[3], fields[4]
+
+	return usage, nil
+}
+
+// printUsage prints the resource usage of a host in human-readable form
+func printUsage(log *logger.Logger, usage *resourceUsage) {
+	if usage.CPUPercent != "" {
+		cpuLimit := "no limit"
+		if usage.CPULimit != "" {
+			cpuLimit = "limit " + usage.CPULimit + " CPUs"
+		}
+		log.Output(fmt.Sprintf("CPU:       %s of one CPU (%s)", usage.CPUPercent, cpuLimit))
+
+		memory := fmt.Sprintf("%s (no limit)", usage.MemoryUsage)
+		if usage.MemoryLimit != "" {
+			memory = fmt.Sprintf("%s of %s (%s)", usage.MemoryUsage, usage.MemoryLimit, usage.MemoryPercent)
+		}
+		log.Output(fmt.Sprintf("Memory:    %s", memory))
+
+		pids := usage.PIDs + " (no limit)"
+		if usage.PidsLimit != "" {
+			pids = fmt.Sprintf("%s of %s", usage.PIDs, usage.PidsLimit)
+		}
+		log.Output(fmt.Sprintf("PIDs:      %s", pids))
+	}
+
+	log.Output(fmt.Sprintf("Images:    %s, %s reclaimable", usage.ImagesSize, usage.ImagesReclaimable))
+	log.Output(fmt.Sprintf("Disk:      %s of %s used (%s), %s free", usage.DiskUsed, usage.DiskSize, usage.DiskUsedPercent, usage.DiskAvailable))
+}
@@ -0,0 +1,195 @@
+package deploy
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/docker"
+	"github.com/bjarneo/pipe/internal/logger"
+	"github.com/bjarneo/pipe/internal/ssh"
+)
+
+// volumeName matches the names docker accepts for named volumes
+var volumeName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// volumeOptions are the options docker accepts after the target of a volume
+var volumeOptions = []string{"ro", "rw", "z", "Z", "nocopy", "consistent", "cached", "delegated",
+	"shared", "slave", "private", "rshared", "rslave", "rprivate"}
+
+// validateCheck is a single item checked by the validate command on this
+// machine. It returns the problems found.
+type validateCheck struct {
+	name  string
+	check func(cfg *config.Config) []string
+}
+
+// validateChecks are run in order, each reporting all of its problems
+var validateChecks = []validateCheck{
+	{name: "Configuration", check: configProblems},
+	{name: "Dockerfile", check: dockerfileProblems},
+	{name: "Environment files", check: func(cfg *config.Config) []string {
+		return docker.EnvFileProblems(cfg.Context(), cfg.EnvFilePaths())
+	}},
+	{name: "Volumes", check: volumeProblems},
+	{name: "Ports", check: portProblems},
+	{name: "SSH key", check: sshKeyProblems},
+}
+
+// Validate checks the configuration of every service without deploying:
+// the settings, the Dockerfile, env files, volumes, ports and SSH key, and
+// with --remote that every host is reachable and runs Docker. Every problem
+// is reported instead of stopping at the first.
+func Validate(cfg *config.Config, log *logger.Logger) error {
+	services, err := cfg.Services()
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for i := range services {
+		service := &services[i]
+		serviceLog := serviceLogger(log, service)
+		for _, item := range validateChecks {
+			problems := item.check(service)
+			if len(problems) == 0 {
+				serviceLog.Output(fmt.Sprintf("✓ %s", item.name))
+				continue
+			}
+			failed++
+			for _, problem := range problems {
+				serviceLog.Output(fmt.Sprintf("✗ %s: %s", item.name, problem))
+			}
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d checks failed", failed)
+	}
+
+	if !cfg.Remote {
+		return log.Info("Configuration is valid, run 'pipe validate --remote' to also check the hosts")
+	}
+	for i := range services {
+		if err := forEachHost(&services[i], serviceLogger(log, &services[i]), validateHost); err != nil {
+			return err
+		}
+	}
+	return log.Info("Configuration is valid and the hosts are reachable")
+}
+
+// validateHost checks that a host is reachable over SSH and runs Docker
+func validateHost(cfg *config.Config, log *logger.Logger) error {
+	if err := ssh.Check(cfg, log); err != nil {
+		log.Output(fmt.Sprintf("✗ SSH connection: %v", err))
+		return fmt.Errorf("%s is not reachable", cfg.Host)
+	}
+	log.Output("✓ SSH connection")
+
+	if err := docker.CheckRemote(cfg, log); err != nil {
+		log.Output(fmt.Sprintf("✗ Docker installation: %v", err))
+		return fmt.Errorf("docker is not available on %s", cfg.Host)
+	}
+	log.Output("✓ Docker installation")
+	return nil
+}
+
+// configProblems returns the problems of the settings, such as the memory
+// and CPU syntax
+func configProblems(cfg *config.Config) []string {
+	var problems []string
+	for _, err := range cfg.Problems() {
+		problems = append(problems, err.Error())
+	}
+	return problems
+}
+
+// dockerfileProblems checks that the Dockerfile exists, unless an existing
+// image is deployed
+func dockerfileProblems(cfg *config.Config) []string {
+	if cfg.PrebuiltImage != "" {
+		return nil
+	}
+	info, err := os.Stat(cfg.Dockerfile)
+	if err != nil {
+		return []string{fmt.Sprintf("%s not found, set --dockerfile or deploy an existing image with --image-ref", cfg.Dockerfile)}
+	}
+	if info.IsDir() {
+		return []string{fmt.Sprintf("%s is a directory, not a Dockerfile", cfg.Dockerfile)}
+	}
+	return nil
+}
+
+// volumeProblems checks that every volume is a named volume or a host path
+// followed by an absolute path in the container and known options
+func volumeProblems(cfg *config.Config) []string {
+	var problems []string
+	for _, volume := range cfg.Volumes {
+		parts := strings.Split(volume, ":")
+		if len(parts) < 2 || len(parts) > 3 {
+			problems = append(problems, fmt.Sprintf("%q: expected source:target or source:target:options", volume))
+			continue
+		}
+
+		source, target := parts[0], parts[1]
+		bindMount := strings.HasPrefix(source, "/") || strings.HasPrefix(source, ".") || strings.HasPrefix(source, "~")
+		if !bindMount && !volumeName.MatchString(source) {
+			problems = append(problems, fmt.Sprintf("%q: %q is neither a host path nor a volume name", volume, source))
+		}
+		if !strings.HasPrefix(target, "/") {
+			problems = append(problems, fmt.Sprintf("%q: the path in the container %q is not absolute", volume, target))
+		}
+		if len(parts) == 3 {
+			for _, option := range strings.Split(parts[2], ",") {
+				if !slices.Contains(volumeOptions, option) {
+					problems = append(problems, fmt.Sprintf("%q: unknown option %q", volume, option))
+				}
+			}
+		}
+	}
+	return problems
+}
+
+// portProblems checks that the ports are numbers between 1 and 65535. The
+// host port may be bound to an address, as in 127.0.0.1:3000.
+func portProblems(cfg *config.Config) []string {
+	var problems []string
+	check := func(name string, value string) {
+		if port, err := strconv.Atoi(value); err != nil || port < 1 || port > 65535 {
+			problems = append(problems, fmt.Sprintf("invalid %s %q: expected a number between 1 and 65535", name, value))
+		}
+	}
+
+	check("container port", cfg.ContainerPort)
+	hostPort := cfg.HostPort
+	if i := strings.LastIndex(hostPort, ":"); i >= 0 {
+		hostPort = hostPort[i+1:]
+	}
+	check("host port", hostPort)
+	if cfg.AlternatePort != "" {
+		check("alternate port", cfg.AlternatePort)
+	}
+	return problems
+}
+
+// sshKeyProblems checks that the SSH key exists and is only readable by its
+// owner, as ssh refuses keys others can read
+func sshKeyProblems(cfg *config.Config) []string {
+	if cfg.SSHKey == "" {
+		return nil
+	}
+	info, err := os.Stat(cfg.SSHKey)
+	if err != nil {
+		return []string{err.Error()}
+	}
+	if info.IsDir() {
+		return []string{fmt.Sprintf("%s is a directory, not a key", cfg.SSHKey)}
+	}
+	if mode := info.Mode().Perm(); mode&0077 != 0 {
+		return []string{fmt.Sprintf("%s is readable by others (%04o), run 'chmod 600 %s'", cfg.SSHKey, mode, cfg.SSHKey)}
+	}
+	return nil
+}
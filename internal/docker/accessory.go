@@ -0,0 +1,72 @@
+package docker
+
+import (
+	"fmt"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/logger"
+	"github.com/bjarneo/pipe/internal/ssh"
+)
+
+// RunAccessory creates the network and volumes of an accessory, copies its
+// environment file and starts its container. cfg is the accessory's configuration from AccessoryConfig.
+func RunAccessory(cfg *config.Config, log *logger.Logger, accessory config.Accessory) error {
+	if err := ensureStorage(cfg, log); err != nil {
+		return err
+	}
+
+	if err := PrepareState(cfg, log, accessory.EnvFile); err != nil {
+		return err
+	}
+
+	if accessory.EnvFile != "" {
+		if err := CopyEnvFile(cfg, log, []string{accessory.EnvFile}); err != nil {
+			return err
+		}
+		defer RemoveEnvFile(cfg, log, []string{accessory.EnvFile})
+	}
+
+	if _, err := ssh.Run(cfg, log, ssh.Command("docker", "pull", accessory.Image),
+		fmt.Sprintf("Pulling image %s", accessory.Image)); err != nil {
+		return fmt.Errorf("failed to pull image %s: %v", accessory.Image, err)
+	}
+
+	runCmd := ssh.Command(append([]string{"docker", "run"}, accessoryArgs(cfg, accessory)...)...)
+	if _, err := ssh.Run(cfg, log, runCmd, fmt.Sprintf("Starting accessory %s", accessory.Name)); err != nil {
+		return fmt.Errorf("failed to start accessory %s: %v", accessory.Name, err)
+	}
+
+	return nil
+}
+
+// accessoryArgs returns the docker run arguments for an accessory
+func accessoryArgs(cfg *config.Config, accessory config.Accessory) []string {
+	args := []string{
+		"-d",
+		"--name", accessory.Name,
+		"--restart", restartPolicy,
+	}
+
+	if accessory.Port != "" {
+		args = append(args, "-p", accessory.Port)
+	}
+
+	if cfg.Network != "" {
+		args = append(args, "--network", cfg.Network)
+	}
+
+	for _, volume := range accessory.Volumes {
+		args = append(args, "-v", volume)
+	}
+
+	for _, key := range sortedKeys(accessory.Env) {
+		args = append(args, "-e", key+"="+accessory.Env[key])
+	}
+
+	if accessory.EnvFile != "" {
+		args = append(args, "--env-file", cfg.RemoteEnvFile())
+	}
+
+	args = append(args, accessory.Image)
+	return append(args, accessory.Cmd...)
+}
@@ -0,0 +1,246 @@
+package docker
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/logger"
+	"github.com/bjarneo/pipe/internal/secrets"
+	"github.com/bjarneo/pipe/internal/ssh"
+)
+
+// AgentReport is the state the agent last reported for the container
+type AgentReport struct {
+	Container  string    `json:"container"`
+	Health     string    `json:"health"`
+	Failures   int       `json:"failures"`
+	Restarts   int       `json:"restarts"`
+	RolledBack bool      `json:"rolledBack"`
+	LastAction string    `json:"lastAction,omitempty"`
+	CheckedAt  time.Time `json:"checkedAt"`
+}
+
+// agentUnit returns the name of the systemd unit running the agent
+func agentUnit(cfg *config.Config) string {
+	return fmt.Sprintf("pipe-agent-%s.service", cfg.ContainerName)
+}
+
+// agentScript returns the agent, a shell loop that checks the container and
+// restarts it, or rolls it back, after repeated failed checks. A stopped
+// container is left alone, as it was stopped on purpose, and nothing is done
+// while a deployment holds the pause file. Healthy checks ping the uptime
+// monitor as a heartbeat.
+func agentScript(cfg *config.Config) string {
+	check := `[ "$running" = true ] && [ "$health" != unhealthy ]`
+	if cfg.HealthURL != "" {
+		_, healthCmd := healthCommand(cfg.HealthURL, cfg.HostPort)
+		check += fmt.Sprintf(` && case "$(%s)" in 200|open) true ;; *) false ;; esac`, healthCmd)
+	}
+
+	rollback := "false"
+	if cfg.Agent.Rollback {
+		rollback = "true"
+	}
+
+	// Secret references are resolved before the agent is installed
+	heartbeatURL := cfg.Monitor.PingURL
+	if secrets.IsReference(heartbeatURL) {
+		heartbeatURL = ""
+	}
+
+	return fmt.Sprintf(`#!/bin/sh
+# Agent watching the %[1]s container, installed by pipe
+%[10]scontainer=%[2]s
+state="$HOME/%[3]s"
+interval=%[4]d
+threshold=%[5]d
+rollback=%[6]s
+report_url=%[7]s
+heartbeat_url=%[9]s
+
+failures=0
+restarts=0
+restarted=false
+rolled_back=false
+while true; do
+	action=""
+	if [ -e "$state/agent.pause" ]; then
+		health=paused
+		failures=0
+	else
+		inspect=$(docker inspect -f '{{.State.Running}} {{if .State.Health}}{{.State.Health.Status}}{{end}}' "$container" 2>/dev/null)
+		running=${inspect%%%% *}
+		health=${inspect#* }
+		if [ "$running" != true ]; then
+			health=stopped
+			failures=0
+		elif %[8]s; then
+			health=healthy
+			failures=0
+			restarted=false
+		else
+			health=unhealthy
+			failures=$((failures + 1))
+		fi
+	fi
+
+	if [ "$failures" -ge "$threshold" ]; then
+		failures=0
+		if [ "$restarted" = true ] && [ "$rollback" = true ] && [ -f "$state/rollback.sh" ]; then
+			action=rollback
+			sh "$state/rollback.sh" >>"$state/agent.log" 2>&1 && mv "$state/rollback.sh" "$state/rollback.done"
+			rolled_back=true
+			restarted=false
+		else
+			action=restart
+			docker restart "$container" >/dev/null 2>&1
+			restarts=$((restarts + 1))
+			restarted=true
+		fi
+	fi
+
+	report=$(printf '{"container":"%%s","health":"%%s","failures":%%d,"restarts":%%d,"rolledBack":%%s,"lastAction":"%%s","checkedAt":"%%s"}' \
+		"$container" "$health" "$failures" "$restarts" "$rolled_back" "$action" "$(date -u +%%Y-%%m-%%dT%%H:%%M:%%SZ)")
+	printf '%%s\n' "$report" >"$state/agent.json.tmp" && mv "$state/agent.json.tmp" "$state/agent.json"
+	if [ -n "$action" ]; then
+		printf '%%s\n' "$report" >>"$state/agent.log"
+	fi
+	if [ -n "$heartbeat_url" ] && [ "$health" = healthy ]; then
+		curl -fsS -m 5 "$heartbeat_url" >/dev/null 2>&1 || true
+	fi
+	if [ -n "$report_url" ]; then
+		curl -fsS -m 5 -H 'Content-Type: application/json' -d "$report" "$report_url" >/dev/null 2>&1 || true
+	fi
+
+	sleep "$interval"
+done
+`, cfg.ContainerName, ssh.Quote(cfg.ContainerName), strings.TrimPrefix(cfg.StateDir(), "~/"),
+		int(cfg.Agent.CheckInterval().Seconds()), cfg.Agent.FailureThreshold(), rollback,
+		ssh.Quote(cfg.Agent.ReportURL), check, ssh.Quote(heartbeatURL), dockerFunctionLine(cfg))
+}
+
+// dockerFunctionLine returns the line of a script defining the docker
+// function of the configured Docker command or user, if there is one
+func dockerFunctionLine(cfg *config.Config) string {
+	if function := ssh.DockerFunction(cfg); function != "" {
+		return strings.TrimSuffix(function, " ") + "\n"
+	}
+	return ""
+}
+
+// agentService returns the systemd unit running the agent as the SSH user.
+// systemd turns $$ into a literal $, leaving HOME to the shell.
+func agentService(cfg *config.Config) string {
+	return fmt.Sprintf(`[Unit]
+Description=pipe agent for %[1]s
+After=docker.service
+Wants=docker.service
+
+[Service]
+User=%[2]s
+ExecStart=/bin/sh -c 'exec /bin/sh "$$HOME/%[3]s/agent.sh"'
+Restart=always
+RestartSec=5
+
+[Install]
+WantedBy=multi-user.target
+`, cfg.ContainerName, cfg.User, strings.TrimPrefix(cfg.StateDir(), "~/"))
+}
+
+// InstallAgent writes the agent to the host and (re)starts its systemd
+// service. Installing the service needs root or passwordless sudo.
+func InstallAgent(cfg *config.Config, log *logger.Logger) error {
+	if _, err := ssh.Run(cfg, log, ssh.Command("mkdir", "-p", cfg.StateDir()), "Creating state directory"); err != nil {
+		return fmt.Errorf("failed to create state directory: %v", err)
+	}
+
+	if err := ssh.WriteFile(cfg, log, []byte(agentScript(cfg)), cfg.StateDir()+"/agent.sh", "Writing agent"); err != nil {
+		return fmt.Errorf("failed to write agent: %v", err)
+	}
+
+	unitPath := "/etc/systemd/system/" + agentUnit(cfg)
+	installCmd := asRoot(ssh.Command("tee", unitPath)+" >/dev/null") + " && " +
+		asRoot(ssh.Command("systemctl", "daemon-reload")) + " && " +
+		asRoot(ssh.Command("systemctl", "enable", agentUnit(cfg))) + " && " +
+		asRoot(ssh.Command("systemctl", "restart", agentUnit(cfg)))
+	if _, err := ssh.RunWithInput(cfg, log, installCmd, "Installing agent service",
+		strings.NewReader(agentService(cfg))); err != nil {
+		return fmt.Errorf("failed to install agent service (needs root or passwordless sudo): %v", err)
+	}
+
+	return nil
+}
+
+// RemoveAgent stops and removes the agent service and its files
+func RemoveAgent(cfg *config.Config, log *logger.Logger) error {
+	unitPath := "/etc/systemd/system/" + agentUnit(cfg)
+	removeCmd := "(" + asRoot(ssh.Command("systemctl", "disable", "--now", agentUnit(cfg))) + " || true) && " +
+		asRoot(ssh.Command("rm", "-f", unitPath)) + " && " +
+		asRoot(ssh.Command("systemctl", "daemon-reload")) + " && " +
+		ssh.Command("rm", "-f", cfg.StateDir()+"/agent.sh", cfg.StateDir()+"/agent.json", cfg.StateDir()+"/agent.pause",
+			cfg.StateDir()+"/rollback.sh", cfg.StateDir()+"/rollback.done")
+	if _, err := ssh.Run(cfg, log, removeCmd, "Removing agent service"); err != nil {
+		return fmt.Errorf("failed to remove agent: %v", err)
+	}
+	return nil
+}
+
+// AgentStatus returns whether the agent service is active and its last
+// report. The report is nil when the agent has not reported yet.
+func AgentStatus(cfg *config.Config, log *logger.Logger) (bool, *AgentReport, error) {
+	statusCmd := fmt.Sprintf(`printf '%%s\n' "$(%s 2>/dev/null)"; %s 2>/dev/null || true`,
+		ssh.Command("systemctl", "is-active", agentUnit(cfg)), ssh.Command("cat", cfg.StateDir()+"/agent.json"))
+	result, err := ssh.Capture(cfg, log, statusCmd, "Reading agent status")
+	if err != nil {
+		return false, nil, fmt.Errorf("failed to read agent status: %v", err)
+	}
+
+	state, report, _ := strings.Cut(result.Stdout, "\n")
+	active := strings.TrimSpace(state) == "active"
+	if strings.TrimSpace(report) == "" {
+		return active, nil, nil
+	}
+
+	var agentReport AgentReport
+	if err := json.Unmarshal([]byte(report), &agentReport); err != nil {
+		return active, nil, fmt.Errorf("failed to parse agent report: %v", err)
+	}
+	return active, &agentReport, nil
+}
+
+// PauseAgent stops the agent from acting on the container while a
+// deployment replaces it. The returned function resumes the agent.
+func PauseAgent(cfg *config.Config, log *logger.Logger) (func(), error) {
+	pauseFile := cfg.StateDir() + "/agent.pause"
+	pauseCmd := ssh.Command("mkdir", "-p", cfg.StateDir()) + " && " + ssh.Command("touch", pauseFile)
+	if _, err := ssh.Run(cfg, log, pauseCmd, "Pausing agent"); err != nil {
+		return nil, fmt.Errorf("failed to pause agent: %v", err)
+	}
+
+	return func() {
+		if _, err := ssh.Run(cfg, log, ssh.Command("rm", "-f", pauseFile), "Resuming agent"); err != nil {
+			log.Warn(fmt.Sprintf("failed to resume agent: %v", err))
+		}
+	}, nil
+}
+
+// WriteAgentRollback writes the command the agent runs to roll the container
+// back to the previous image. Without a previous image, or when the env file
+// is encrypted and therefore not kept on the host, any old rollback is
+// removed instead.
+func WriteAgentRollback(cfg *config.Config, log *logger.Logger, previousImage string) error {
+	rollbackFile := cfg.StateDir() + "/rollback.sh"
+	if previousImage == "" || previousImage == cfg.ImageRef() || EnvFileEncrypted(cfg.EnvFilePaths()) {
+		_, err := ssh.Run(cfg, log, ssh.Command("rm", "-f", rollbackFile), "Clearing agent rollback")
+		return err
+	}
+
+	script := ssh.Command("docker", "rm", "-f", cfg.ContainerName) + " && " +
+		RunCommand(cfg, previousImage) + "\n"
+
+	return ssh.WriteFile(cfg, log, []byte(script), rollbackFile,
+		fmt.Sprintf("Writing agent rollback to %s", previousImage))
+}
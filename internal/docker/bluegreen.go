@@ -0,0 +1,168 @@
+package docker
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/logger"
+	"github.com/bjarneo/pipe/internal/ssh"
+)
+
+const (
+	// healthInterval is the delay between health polls
+	healthInterval = 2 * time.Second
+	// stableChecks is the number of consecutive "running" polls required for
+	// containers without a HEALTHCHECK
+	stableChecks = 3
+)
+
+// deployBlueGreen starts the new version as a candidate on the alternate
+// port next to the running container, and only hands traffic over once the
+// candidate is healthy. If the candidate fails, the running container is left
+// untouched. The handover stops the old container and starts the new version
+// on the host port, where it is verified before the old container is removed.
+// Behind the proxy the candidate is routed to as well and serves alone in
+// between, so no request goes unanswered; without it the host port is
+// unbound from stopping the old container until the new one runs. If the new
+// container fails its checks, the old container is put back.
+func deployBlueGreen(cfg *config.Config, log *logger.Logger) error {
+	alternatePort, err := alternatePort(cfg)
+	if err != nil {
+		return err
+	}
+
+	candidate := cfg.ContainerName + "_next"
+
+	// Start the candidate on the alternate port, removing any leftover candidate
+	if err := Remove(cfg, log, candidate); err != nil {
+		return err
+	}
+
+	startCandidate := ssh.Command(append([]string{"docker", "run"}, runArgs(cfg, candidate, alternatePort)...)...)
+	if _, err := ssh.Run(cfg, log, startCandidate, fmt.Sprintf("Starting new version on port %s", alternatePort)); err != nil {
+		removeContainer(cfg, log, candidate)
+		return err
+	}
+
+	verifyLog := log.WithStep("verify")
+	if err := verifyLog.Time(func() error {
+		if err := waitHealthy(cfg, verifyLog, candidate); err != nil {
+			return err
+		}
+		return CheckHealth(cfg, verifyLog, candidate, alternatePort)
+	}); err != nil {
+		Quarantine(cfg, log, candidate)
+		removeContainer(cfg, log, candidate)
+		return fmt.Errorf("new version failed health check, previous version left running: %v", err)
+	}
+
+	// The candidate serves until the new container on the host port passed
+	// its checks, or the old container is back, and is then drained
+	defer func() {
+		if err := Stop(cfg.Detached(), log, candidate); err != nil {
+			log.Info(err.Error())
+		}
+		removeContainer(cfg, log, candidate)
+	}()
+
+	// Move the new version onto the host port, keeping the old container
+	// aside until it passed its checks
+	switchCmd := ssh.Command(append([]string{"docker", "run"}, runArgs(cfg, cfg.ContainerName, cfg.HostPort)...)...)
+	if err := cutover(cfg, log, func() error {
+		if _, err := ssh.Run(cfg, log, switchCmd, fmt.Sprintf("Switching traffic to new version on port %s", cfg.HostPort)); err != nil {
+			return err
+		}
+		return verifyContainer(cfg, log, cfg.ContainerName, cfg.HostPort)
+	}); err != nil {
+		return err
+	}
+
+	// Clean up old releases
+	if err := cleanupOldReleases(cfg, log); err != nil {
+		log.Warn(fmt.Sprintf("failed to cleanup old releases: %v", err))
+	}
+
+	return nil
+}
+
+// WaitStarted waits for the container to be running, or healthy if it has a
+// HEALTHCHECK, and then for the configured health check to pass
+func WaitStarted(cfg *config.Config, log *logger.Logger) error {
+	if err := waitHealthy(cfg, log, cfg.ContainerName); err != nil {
+		return err
+	}
+	return CheckHealth(cfg, log, cfg.ContainerName, cfg.HostPort)
+}
+
+// alternatePort returns the configured alternate port, defaulting to the
+// host port plus one
+func alternatePort(cfg *config.Config) (string, error) {
+	if cfg.AlternatePort != "" {
+		return cfg.AlternatePort, nil
+	}
+
+	port, err := strconv.Atoi(cfg.HostPort)
+	if err != nil {
+		return "", fmt.Errorf("cannot derive alternate port from host port %q, set --alternate-port", cfg.HostPort)
+	}
+
+	return strconv.Itoa(port + 1), nil
+}
+
+// waitHealthy polls the container until it reports healthy, or until it has
+// been running for several consecutive checks if it has no HEALTHCHECK
+func waitHealthy(cfg *config.Config, log *logger.Logger, name string) error {
+	inspectCmd := ssh.Command("docker", "inspect", "--format", "{{.State.Status}} {{if .State.Health}}{{.State.Health.Status}}{{end}}", name)
+	if ssh.DryRun() {
+		return nil
+	}
+
+	wait := cfg.HealthStatusWait()
+	deadline := time.Now().Add(wait)
+	running := 0
+
+	for time.Now().Before(deadline) {
+		result, err := ssh.Capture(cfg, log, inspectCmd, fmt.Sprintf("Checking health of %s", name))
+		if err != nil {
+			return err
+		}
+
+		fields := strings.Fields(result.Stdout)
+		status, health := "", ""
+		if len(fields) > 0 {
+			status = fields[0]
+		}
+		if len(fields) > 1 {
+			health = fields[1]
+		}
+
+		switch {
+		case status != "running" && status != "created":
+			return fmt.Errorf("container %s is %s", name, status)
+		case health == "healthy":
+			return log.Info(fmt.Sprintf("Container %s is healthy", name))
+		case health == "unhealthy":
+			return fmt.Errorf("container %s is unhealthy", name)
+		case health == "" && status == "running":
+			running++
+			if running >= stableChecks {
+				return log.Info(fmt.Sprintf("Container %s is running", name))
+			}
+		}
+
+		time.Sleep(healthInterval)
+	}
+
+	return fmt.Errorf("container %s did not become healthy within %s", name, wait)
+}
+
+// removeContainer removes a container, logging instead of failing on errors.
+// It also runs when the command was cancelled, to clean up after it.
+func removeContainer(cfg *config.Config, log *logger.Logger, name string) {
+	if err := Remove(cfg.Detached(), log, name); err != nil {
+		log.Info(err.Error())
+	}
+}
@@ -0,0 +1,117 @@
+package docker
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/logger"
+	"github.com/bjarneo/pipe/internal/ssh"
+)
+
+// Bootstrap prepares a host for its first deployment: it creates the state
+// directory and runs the configured initial commands
+func Bootstrap(cfg *config.Config, log *logger.Logger) error {
+	if err := log.Info("Bootstrapping host for first deployment"); err != nil {
+		return err
+	}
+
+	if _, err := ssh.Run(cfg, log, ssh.Command("mkdir", "-p", cfg.StateDir()), "Creating state directory"); err != nil {
+		return fmt.Errorf("failed to create state directory: %v", err)
+	}
+
+	if err := ConfigureUpdates(cfg, log); err != nil {
+		return err
+	}
+
+	for _, command := range cfg.Initial {
+		if _, err := ssh.Run(cfg, log, ssh.UserCommand(cfg, command), "Running initial command"); err != nil {
+			return fmt.Errorf("initial command failed: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// ensureStorage creates the configured network, named volumes and bind mount
+// directories that do not exist yet. Networks and volumes are created with
+// the configured driver and options; existing ones are left as they are.
+func ensureStorage(cfg *config.Config, log *logger.Logger) error {
+	if cfg.Network != "" {
+		networkCmd := ssh.Command("docker", "network", "inspect", cfg.Network) + " >/dev/null 2>&1 || " +
+			ssh.Command(createArgs("network", cfg.Network, cfg.NetworkDriver, cfg.NetworkOpts)...)
+		if _, err := ssh.Run(cfg, log, networkCmd, fmt.Sprintf("Ensuring network %s exists", cfg.Network)); err != nil {
+			return fmt.Errorf("failed to create network %s: %v", cfg.Network, err)
+		}
+	}
+
+	for _, volume := range cfg.Volumes {
+		source := strings.SplitN(volume, ":", 2)[0]
+
+		if isBindMount(source) {
+			if _, err := ssh.Run(cfg, log, ssh.Command("mkdir", "-p", source),
+				fmt.Sprintf("Ensuring directory %s exists", source)); err != nil {
+				return fmt.Errorf("failed to create directory %s: %v", source, err)
+			}
+			continue
+		}
+
+		volumeCmd := ssh.Command("docker", "volume", "inspect", source) + " >/dev/null 2>&1 || " +
+			ssh.Command(createArgs("volume", source, cfg.VolumeDriver, cfg.VolumeOpts)...)
+		if _, err := ssh.Run(cfg, log, volumeCmd, fmt.Sprintf("Ensuring volume %s exists", source)); err != nil {
+			return fmt.Errorf("failed to create volume %s: %v", source, err)
+		}
+	}
+
+	return nil
+}
+
+// MissingStorage returns the configured network and named volumes that do
+// not exist on the host yet, such as "network app" or "volume data"
+func MissingStorage(cfg *config.Config, log *logger.Logger) ([]string, error) {
+	var checks []string
+	if cfg.Network != "" {
+		checks = append(checks, ssh.Command("docker", "network", "inspect", cfg.Network)+" >/dev/null 2>&1 || "+
+			ssh.Command("echo", "network "+cfg.Network))
+	}
+	for _, volume := range cfg.Volumes {
+		if source := strings.SplitN(volume, ":", 2)[0]; !isBindMount(source) {
+			checks = append(checks, ssh.Command("docker", "volume", "inspect", source)+" >/dev/null 2>&1 || "+
+				ssh.Command("echo", "volume "+source))
+		}
+	}
+	if len(checks) == 0 {
+		return nil, nil
+	}
+
+	result, err := ssh.Capture(cfg, log, strings.Join(checks, "; "), "Checking network and volumes")
+	if err != nil {
+		return nil, err
+	}
+	var missing []string
+	for _, line := range strings.Split(result.Stdout, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			missing = append(missing, line)
+		}
+	}
+	return missing, nil
+}
+
+// createArgs returns the command creating a docker network or volume with
+// a driver and driver options
+func createArgs(kind string, name string, driver string, opts map[string]string) []string {
+	args := []string{"docker", kind, "create"}
+	if driver != "" {
+		args = append(args, "--driver", driver)
+	}
+	for _, key := range sortedKeys(opts) {
+		args = append(args, "--opt", key+"="+opts[key])
+	}
+	return append(args, name)
+}
+
+// isBindMount reports whether a volume source is a host path rather than a
+// named volume
+func isBindMount(source string) bool {
+	return strings.HasPrefix(source, "/") || strings.HasPrefix(source, ".") || strings.HasPrefix(source, "~")
+}
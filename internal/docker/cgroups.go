@@ -0,0 +1,58 @@
+package docker
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/logger"
+	"github.com/bjarneo/pipe/internal/ssh"
+)
+
+// CheckCgroups checks that the cpuset and cgroup parent fit the host: the
+// pinned CPUs must exist, and the cgroup parent must match docker's cgroup
+// driver, which with systemd (the default on cgroup v2) only accepts slices
+func CheckCgroups(cfg *config.Config, log *logger.Logger) error {
+	if cfg.CPUsetCPUs == "" && cfg.CgroupParent == "" {
+		return nil
+	}
+
+	infoCmd := ssh.Command("docker", "info", "--format", "{{.CgroupDriver}} {{.CgroupVersion}} {{.NCPU}}")
+	result, err := ssh.Capture(cfg, log, infoCmd, "Checking cgroup driver")
+	if err != nil {
+		return fmt.Errorf("failed to check cgroup driver: %v", err)
+	}
+
+	fields := strings.Fields(result.Stdout)
+	if len(fields) != 3 {
+		return fmt.Errorf("unexpected docker info output %q", strings.TrimSpace(result.Stdout))
+	}
+	driver, version := fields[0], fields[1]
+	cpus, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return fmt.Errorf("unexpected CPU count %q: %v", fields[2], err)
+	}
+
+	highest, err := config.CPUsetMax(cfg.CPUsetCPUs)
+	if err != nil {
+		return err
+	}
+	if highest >= cpus {
+		return fmt.Errorf("cpuset %s uses CPU %d, but %s only has CPUs 0-%d", cfg.CPUsetCPUs, highest, cfg.Host, cpus-1)
+	}
+
+	if cfg.CgroupParent != "" {
+		isSlice := strings.HasSuffix(cfg.CgroupParent, ".slice") && !strings.Contains(cfg.CgroupParent, "/")
+		if driver == "systemd" && !isSlice {
+			return fmt.Errorf("%s uses the systemd cgroup driver (cgroup v%s), set the cgroup parent to a slice such as %s.slice",
+				cfg.Host, version, strings.ReplaceAll(strings.Trim(cfg.CgroupParent, "/"), "/", "-"))
+		}
+		if driver == "cgroupfs" && strings.HasSuffix(cfg.CgroupParent, ".slice") {
+			return fmt.Errorf("%s uses the cgroupfs cgroup driver, set the cgroup parent to a path such as /%s",
+				cfg.Host, strings.TrimSuffix(cfg.CgroupParent, ".slice"))
+		}
+	}
+
+	return nil
+}
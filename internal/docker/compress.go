@@ -0,0 +1,100 @@
+package docker
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/logger"
+	"github.com/bjarneo/pipe/internal/ssh"
+)
+
+// compression returns the algorithm the image is compressed with on its way
+// to the host. zstd needs the zstd command on both ends and falls back to
+// gzip when either lacks it.
+func compression(cfg *config.Config, log *logger.Logger) (string, error) {
+	if cfg.Compress != config.CompressZstd {
+		return cfg.Compress, nil
+	}
+
+	if err := ssh.LookPath(cfg.Context(), "zstd"); err != nil {
+		log.Warn("zstd is not installed locally, compressing the image with gzip")
+		return config.CompressGzip, nil
+	}
+
+	result, err := ssh.Capture(cfg, log, "command -v zstd >/dev/null && echo yes || echo no", "Checking for zstd on server")
+	if err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(result.Stdout) != "yes" {
+		log.Warn(fmt.Sprintf("zstd is not installed on %s, compressing the image with gzip", cfg.Host))
+		return config.CompressGzip, nil
+	}
+	return config.CompressZstd, nil
+}
+
+// compressor is a running compression of the saved image
+type compressor struct {
+	output io.Reader
+	stop   func()
+	wait   func() error
+}
+
+// compress starts compressing input with the algorithm, with gzip in
+// process and with zstd using the local zstd command
+func compress(cfg *config.Config, algorithm string, input io.Reader) (*compressor, error) {
+	switch algorithm {
+	case config.CompressZstd:
+		level := "-3"
+		if cfg.CompressLevel > 0 {
+			level = "-" + strconv.Itoa(cfg.CompressLevel)
+		}
+		process, err := ssh.StartLocal(cfg.Context(), []string{"zstd", "-q", "-c", "-T0", level}, input)
+		if err != nil {
+			return nil, fmt.Errorf("failed to start zstd: %v", err)
+		}
+		return &compressor{
+			output: process.Stdout,
+			stop:   process.Stop,
+			wait:   process.Wait,
+		}, nil
+
+	case config.CompressGzip:
+		level := gzip.DefaultCompression
+		if cfg.CompressLevel > 0 {
+			level = cfg.CompressLevel
+		}
+		reader, writer := io.Pipe()
+		done := make(chan error, 1)
+		go func() {
+			gz, err := gzip.NewWriterLevel(writer, level)
+			if err == nil {
+				_, err = io.Copy(gz, input)
+			}
+			if err == nil {
+				err = gz.Close()
+			}
+			writer.CloseWithError(err)
+			done <- err
+		}()
+		return &compressor{
+			output: reader,
+			stop:   func() { reader.Close() },
+			wait:   func() error { return <-done },
+		}, nil
+	}
+
+	return &compressor{output: input, stop: func() {}, wait: func() error { return nil }}, nil
+}
+
+// loadCommand returns the remote command decompressing and loading an image
+// compressed with the algorithm. docker load reads gzip itself.
+func loadCommand(algorithm string) string {
+	if algorithm == config.CompressZstd {
+		return "zstd -d -q -c | docker load"
+	}
+	return "docker load"
+}
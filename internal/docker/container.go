@@ -0,0 +1,137 @@
+package docker
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/logger"
+	"github.com/bjarneo/pipe/internal/ssh"
+)
+
+// Exists reports whether a container with the given name exists on the
+// remote host. An error is only returned if the check itself failed.
+func Exists(cfg *config.Config, log *logger.Logger, name string) (bool, error) {
+	checkCmd := ssh.Command("docker", "ps", "-a", "--filter", "name=^/?"+name+"$", "--format", "{{.Names}}")
+	result, err := ssh.Capture(cfg, log, checkCmd, fmt.Sprintf("Checking for container %s", name))
+	if err != nil {
+		return false, fmt.Errorf("failed to check for container %s: %v", name, err)
+	}
+
+	for _, line := range strings.Split(result.Stdout, "\n") {
+		if strings.TrimPrefix(strings.TrimSpace(line), "/") == name {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Stop stops a container, skipping containers that do not exist
+func Stop(cfg *config.Config, log *logger.Logger, name string) error {
+	exists, err := Exists(cfg, log, name)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return log.Info(fmt.Sprintf("Container %s does not exist, nothing to stop", name))
+	}
+
+	if _, err := ssh.Run(cfg, log, ssh.Command("docker", "stop", name), fmt.Sprintf("Stopping container %s", name)); err != nil {
+		return fmt.Errorf("failed to stop container %s: %v", name, err)
+	}
+	return nil
+}
+
+// Remove force-removes a container, skipping containers that do not exist
+func Remove(cfg *config.Config, log *logger.Logger, name string) error {
+	exists, err := Exists(cfg, log, name)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return nil
+	}
+
+	if _, err := ssh.Run(cfg, log, ssh.Command("docker", "rm", "-f", name), fmt.Sprintf("Removing container %s", name)); err != nil {
+		return fmt.Errorf("failed to remove container %s: %v", name, err)
+	}
+	return nil
+}
+
+// Rename renames an existing container
+func Rename(cfg *config.Config, log *logger.Logger, from string, to string) error {
+	renameCmd := ssh.Command("docker", "rename", from, to)
+	if _, err := ssh.Run(cfg, log, renameCmd, fmt.Sprintf("Renaming container %s to %s", from, to)); err != nil {
+		return fmt.Errorf("failed to rename container %s to %s: %v", from, to, err)
+	}
+	return nil
+}
+
+// Start starts an existing container
+func Start(cfg *config.Config, log *logger.Logger, name string) error {
+	if _, err := ssh.Run(cfg, log, ssh.Command("docker", "start", name), fmt.Sprintf("Starting container %s", name)); err != nil {
+		return fmt.Errorf("failed to start container %s: %v", name, err)
+	}
+	return nil
+}
+
+// cutover replaces the running container with the one started by start.
+// The old container is stopped and kept aside until the new one runs, and
+// is put back when starting the new one fails or the command is cancelled
+// in between, so a failed deployment does not leave the host without the
+// app. A missing container is treated as a first deployment.
+func cutover(cfg *config.Config, log *logger.Logger, start func() error) error {
+	name := cfg.ContainerName
+	previous := name + "_previous"
+
+	exists, err := Exists(cfg, log, name)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		if err := log.Info(fmt.Sprintf("No existing container %s found, performing first deployment", name)); err != nil {
+			return err
+		}
+		return start()
+	}
+
+	// Remove a container left aside by an interrupted run
+	if err := Remove(cfg, log, previous); err != nil {
+		return err
+	}
+	err = Stop(cfg, log, name)
+	if err == nil {
+		err = Rename(cfg, log, name, previous)
+	}
+	if err == nil {
+		err = start()
+	}
+	if err != nil {
+		return restorePrevious(cfg.Detached(), log, name, previous, err)
+	}
+
+	removeContainer(cfg, log, previous)
+	return nil
+}
+
+// restorePrevious puts the container kept aside as previous back in place
+// of the container name and starts it, after the cutover was cancelled at
+// any step or its replacement failed
+func restorePrevious(cfg *config.Config, log *logger.Logger, name string, previous string, cause error) error {
+	log.Warn(fmt.Sprintf("Cutover of %s failed (%v), restoring the previous container", name, cause))
+
+	exists, err := Exists(cfg, log, previous)
+	if err == nil && exists {
+		err = Remove(cfg, log, name)
+		if err == nil {
+			err = Rename(cfg, log, previous, name)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("%v, and failed to restore the previous container: %v", cause, err)
+	}
+	if err := Start(cfg, log, name); err != nil {
+		return fmt.Errorf("%v, and failed to restore the previous container: %v", cause, err)
+	}
+	return fmt.Errorf("%v, previous container restored", cause)
+}
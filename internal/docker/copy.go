@@ -0,0 +1,47 @@
+package docker
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"path/filepath"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/logger"
+	"github.com/bjarneo/pipe/internal/ssh"
+)
+
+// CopyFiles copies the configured files and directories to the remote host,
+// so bind mounts find them when the container starts. Directories are copied
+// with their contents, and existing files are overwritten.
+func CopyFiles(cfg *config.Config, log *logger.Logger) error {
+	copies, err := cfg.Copies()
+	if err != nil {
+		return err
+	}
+
+	for _, file := range copies {
+		err := filepath.WalkDir(file.Local, func(localPath string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if entry.IsDir() {
+				return nil
+			}
+
+			remotePath := file.Remote
+			if localPath != file.Local {
+				rel, err := filepath.Rel(file.Local, localPath)
+				if err != nil {
+					return err
+				}
+				remotePath = path.Join(file.Remote, filepath.ToSlash(rel))
+			}
+			return ssh.CopyFile(cfg, log, localPath, remotePath, file.Mode, fmt.Sprintf("Copying %s to server", localPath))
+		})
+		if err != nil {
+			return fmt.Errorf("failed to copy %s: %v", file.Local, err)
+		}
+	}
+	return nil
+}
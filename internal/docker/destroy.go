@@ -0,0 +1,100 @@
+package docker
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/logger"
+	"github.com/bjarneo/pipe/internal/ssh"
+)
+
+// AppContainers returns the containers of the app on the host: the container
+// or its replicas, the ones kept aside during deployments, the maintenance
+// page, the log shipper, the migration and the jobs
+func AppContainers(cfg *config.Config, log *logger.Logger) ([]string, error) {
+	result, err := ssh.Capture(cfg, log, ssh.Command("docker", "ps", "-a", "--format", "{{.Names}}"), "Listing containers")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containers: %v", err)
+	}
+
+	name := regexp.QuoteMeta(cfg.ContainerName)
+	owned := regexp.MustCompile("^" + name + "(-[0-9]+)?(_next|_backup|_previous)?$|" +
+		"^" + name + "(_maintenance|-logs|-migrate|-run-[0-9]{8}-[0-9]{6}|-job-[A-Za-z0-9][A-Za-z0-9_.-]*)$")
+
+	var containers []string
+	for _, line := range strings.Split(result.Stdout, "\n") {
+		if line = strings.TrimSpace(line); owned.MatchString(line) {
+			containers = append(containers, line)
+		}
+	}
+	return containers, nil
+}
+
+// RemoveReleases removes every image of the app from the host, including the
+// images of quarantined containers
+func RemoveReleases(cfg *config.Config, log *logger.Logger) error {
+	releases, err := Releases(cfg, log)
+	if err != nil {
+		return err
+	}
+
+	refs := []string{}
+	for _, release := range releases {
+		refs = append(refs, release.Ref)
+	}
+	if len(refs) > 0 {
+		removeCmd := ssh.Command(append([]string{"docker", "rmi", "-f"}, refs...)...)
+		if _, err := ssh.Run(cfg, log, removeCmd, fmt.Sprintf("Removing %d release image(s)", len(refs))); err != nil {
+			return fmt.Errorf("failed to remove release images: %v", err)
+		}
+	}
+
+	quarantineCmd := fmt.Sprintf("images=$(%s) && if [ -n \"$images\" ]; then docker rmi -f $images; fi",
+		ssh.Command("docker", "images", "-q", strings.ToLower(cfg.ContainerName)+"-quarantine"))
+	if _, err := ssh.Run(cfg, log, quarantineCmd, "Removing quarantine images"); err != nil {
+		return fmt.Errorf("failed to remove quarantine images: %v", err)
+	}
+	return nil
+}
+
+// RemoveStorage removes the named volumes and the network of the app. A
+// network other containers are still connected to is kept with a warning.
+func RemoveStorage(cfg *config.Config, log *logger.Logger) error {
+	for _, volume := range cfg.Volumes {
+		source := strings.SplitN(volume, ":", 2)[0]
+		if isBindMount(source) {
+			continue
+		}
+		removeCmd := ssh.Command("docker", "volume", "inspect", source) + " >/dev/null 2>&1 || exit 0; " +
+			ssh.Command("docker", "volume", "rm", source)
+		if _, err := ssh.Run(cfg, log, removeCmd, fmt.Sprintf("Removing volume %s", source)); err != nil {
+			return fmt.Errorf("failed to remove volume %s: %v", source, err)
+		}
+	}
+
+	if cfg.Network == "" {
+		return nil
+	}
+	removeCmd := ssh.Command("docker", "network", "inspect", cfg.Network) + " >/dev/null 2>&1 || exit 0; " +
+		ssh.Command("docker", "network", "rm", cfg.Network)
+	if _, err := ssh.Run(cfg, log, removeCmd, fmt.Sprintf("Removing network %s", cfg.Network)); err != nil {
+		log.Warn(fmt.Sprintf("Kept network %s, which other containers may still use: %v", cfg.Network, err))
+	}
+	return nil
+}
+
+// RemoveState removes the state directory of the app from the host, with
+// its env file, history, jobs, SBOMs and backups, and an env file left in
+// the home directory by earlier versions
+func RemoveState(cfg *config.Config, log *logger.Logger) error {
+	paths := []string{cfg.StateDir()}
+	if cfg.EnvFile != "" {
+		paths = append(paths, "~/"+cfg.EnvFile)
+	}
+	if _, err := ssh.Run(cfg, log, ssh.Command(append([]string{"rm", "-rf"}, paths...)...), "Removing state directory"); err != nil {
+		return fmt.Errorf("failed to remove state directory %s: %v", cfg.StateDir(), err)
+	}
+	return nil
+}
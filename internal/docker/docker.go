@@ -2,28 +2,61 @@ package docker
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/bjarneo/pipe/internal/config"
 	"github.com/bjarneo/pipe/internal/logger"
 	"github.com/bjarneo/pipe/internal/ssh"
 )
 
-// Check checks if Docker is installed and running locally and remotely
-func Check(cfg *config.Config, log *logger.Logger) error {
-	// Check local Docker
-	if _, err := ssh.ExecuteCommand(log, "docker info", "Checking local Docker installation"); err != nil {
+// CheckLocal checks if Docker is installed and running locally
+func CheckLocal(cfg *config.Config, log *logger.Logger) error {
+	if _, err := ssh.ExecuteCommand(cfg.Context(), log, []string{"docker", "info"}, "Checking local Docker installation"); err != nil {
 		return fmt.Errorf("local Docker check failed: %v", err)
 	}
+	return nil
+}
+
+// CheckRemote checks if Docker is installed and running on the remote host.
+// When it is not, the SSH user's access to docker is checked to explain why,
+// and with --bootstrap Docker is installed and the user added to the docker
+// group.
+func CheckRemote(cfg *config.Config, log *logger.Logger) error {
+	_, err := ssh.Run(cfg, log, "docker info", "Checking remote Docker installation")
+	if err == nil {
+		return nil
+	}
+	if cfg.DockerUser != "" {
+		return fmt.Errorf("remote Docker check failed - please ensure Docker is installed on %s and %s may run it as %s with passwordless sudo: %v",
+			cfg.Host, cfg.User, cfg.DockerUser, err)
+	}
+	if cfg.DockerCommand != "" {
+		return fmt.Errorf("remote Docker check failed - please ensure '%s info' works for %s on %s without a password prompt: %v",
+			cfg.DockerCommand, cfg.User, cfg.Host, err)
+	}
+	if cfg.DockerTLS() {
+		return fmt.Errorf("remote Docker check failed - please ensure the Docker API of %s is reachable: %v", cfg.Host, err)
+	}
 
-	// Check remote Docker
-	remoteCmd := fmt.Sprintf("%s \"docker info\"", ssh.GetCommand(cfg))
-	if _, err := ssh.ExecuteCommand(log, remoteCmd, "Checking remote Docker installation"); err != nil {
+	access, accessErr := dockerAccess(cfg, log)
+	if accessErr != nil {
 		return fmt.Errorf("remote Docker check failed - please ensure Docker is installed on %s: %v", cfg.Host, err)
 	}
+	if !cfg.BootstrapDocker || (access != dockerMissing && access != dockerNoGroup) {
+		return dockerAccessError(cfg, access, err)
+	}
 
+	if err := InstallDocker(cfg, log); err != nil {
+		return err
+	}
+	if _, err := ssh.Run(cfg, log, "docker info", "Checking remote Docker installation"); err != nil {
+		return fmt.Errorf("Docker was installed on %s but is not usable yet: %v", cfg.Host, err)
+	}
 	return nil
 }
 
@@ -34,130 +67,617 @@ func Build(cfg *config.Config, log *logger.Logger) error {
 		return fmt.Errorf("%s not found", cfg.Dockerfile)
 	}
 
-	// Build Docker image with build arguments
-	buildCmd := fmt.Sprintf("docker build --platform %s", cfg.Platform)
+	if cfg.Reproducible {
+		pinned, err := reproducible(cfg, log)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(pinned.Dockerfile)
+		cfg = pinned
+	}
 
-	// Add build arguments to the command
-	for key, value := range cfg.BuildArgs {
-		buildCmd += fmt.Sprintf(" --build-arg %s=%s", key, value)
+	if cfg.MultiPlatform() {
+		return buildMultiPlatform(cfg, log)
 	}
 
-	buildCmd += fmt.Sprintf(" -t %s:%s .", cfg.Image, cfg.Tag)
+	// Build Docker image with build arguments. Reproducible builds need
+	// buildx for the timestamp rewriting of its outputs.
+	buildArgs := append([]string{"docker", "build", "--platform", cfg.Platform}, buildOptions(cfg)...)
+	buildArgs = append(buildArgs, "-t", cfg.ImageRef(), ".")
+	if cfg.Reproducible {
+		buildArgs = append([]string{"docker", "buildx", "build", "--platform", cfg.Platform}, buildOptions(cfg)...)
+		buildArgs = append(append(buildArgs, imageOutput(cfg, cfg.ImageRef(), false)...), ".")
+	}
 
-	_, err := ssh.ExecuteCommand(log, buildCmd, "Building Docker image")
+	// BuildKit shares the work on common base layers between concurrent builds
+	_, err := ssh.ExecuteCommandWithEnv(cfg.Context(), log, buildArgs, []string{"DOCKER_BUILDKIT=1"}, "Building Docker image")
 	return err
 }
 
-// Transfer transfers the Docker image to the remote host
+// buildMultiPlatform builds the image for several platforms with buildx.
+// With a registry a single multi-platform image is pushed, from which every
+// host pulls its own platform. Otherwise every platform is built into its
+// own local image, and each host is sent the one matching its platform.
+func buildMultiPlatform(cfg *config.Config, log *logger.Logger) error {
+	if cfg.Registry != "" {
+		if err := login(cfg, log); err != nil {
+			return err
+		}
+		buildArgs := append([]string{"docker", "buildx", "build", "--platform", strings.Join(cfg.Platforms(), ",")}, buildOptions(cfg)...)
+		buildArgs = append(append(buildArgs, imageOutput(cfg, cfg.ImageRef(), true)...), ".")
+		if _, err := ssh.ExecuteCommand(cfg.Context(), log, buildArgs, "Building and pushing multi-platform Docker image"); err != nil {
+			return err
+		}
+		return nil
+	}
+
+	for _, platform := range cfg.Platforms() {
+		buildArgs := append([]string{"docker", "buildx", "build", "--platform", platform}, buildOptions(cfg)...)
+		buildArgs = append(append(buildArgs, imageOutput(cfg, cfg.PlatformRef(platform), false)...), ".")
+		if _, err := ssh.ExecuteCommand(cfg.Context(), log, buildArgs, fmt.Sprintf("Building Docker image for %s", platform)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// buildOptions returns the Dockerfile, build argument, secret and SSH options
+// of a build
+func buildOptions(cfg *config.Config) []string {
+	options := []string{"-f", cfg.Dockerfile}
+	for _, key := range sortedKeys(cfg.BuildArgs) {
+		options = append(options, "--build-arg", key+"="+cfg.BuildArgs[key])
+	}
+	for _, secret := range cfg.BuildSecrets {
+		options = append(options, "--secret", secret)
+	}
+	for _, forward := range cfg.BuildSSH {
+		options = append(options, "--ssh", forward)
+	}
+	return options
+}
+
+// HostPlatform returns the docker platform of the host, detected with
+// uname -m
+func HostPlatform(cfg *config.Config, log *logger.Logger) (string, error) {
+	result, err := ssh.Capture(cfg, log, "uname -m", "Detecting host platform")
+	if err != nil {
+		return "", fmt.Errorf("failed to detect the platform of %s: %v", cfg.Host, err)
+	}
+	return config.MachinePlatform(result.Stdout)
+}
+
+// Transfer transfers the Docker image to the remote host, either by piping
+// it over SSH or by pulling it from a registry. A transfer interrupted by a
+// network error starts over.
 func Transfer(cfg *config.Config, log *logger.Logger) error {
-	deployCmd := fmt.Sprintf("docker save %s:%s | gzip | %s docker load",
-		cfg.Image, cfg.Tag, ssh.GetCommand(cfg))
-	_, err := ssh.ExecuteCommand(log, deployCmd, "Transferring Docker image to server")
+	if cfg.Registry != "" || cfg.PrebuiltImage != "" {
+		if cfg.PrebuiltImage == "" {
+			if err := checkDiskSpace(cfg, log, cfg.ImageRef()); err != nil {
+				return err
+			}
+		}
+		return pull(cfg, log)
+	}
+
+	// A multi-platform build made an image for every platform, of which the
+	// host gets its own under the deployed reference
+	image := cfg.ImageRef()
+	if cfg.MultiPlatform() {
+		platform, err := HostPlatform(cfg, log)
+		if err != nil {
+			return err
+		}
+		if !slices.Contains(cfg.Platforms(), platform) {
+			return fmt.Errorf("the image was not built for %s, the platform of %s: add it to --platform", platform, cfg.Host)
+		}
+		image = cfg.PlatformRef(platform)
+	}
+
+	// A redeploy of the same build would send the same bytes again, so an
+	// image the host already has is only tagged
+	if !ssh.DryRun() {
+		id := LocalImageID(cfg, log, image)
+		remoteID, err := ImageID(cfg, log, id)
+		if err != nil {
+			return err
+		}
+		if id != "" && remoteID == id {
+			if _, err := ssh.Run(cfg, log, ssh.Command("docker", "tag", id, cfg.ImageRef()), fmt.Sprintf("Tagging image %s", cfg.ImageRef())); err != nil {
+				return err
+			}
+			return log.Info(fmt.Sprintf("Image %s is already on %s, skipping transfer", image, cfg.Host))
+		}
+	}
+
+	if err := checkDiskSpace(cfg, log, image); err != nil {
+		return err
+	}
+
+	algorithm, err := compression(cfg, log)
+	if err != nil {
+		return err
+	}
+
+	// Only print the two ends of the transfer in a dry run
+	if ssh.DryRun() {
+		if _, err := ssh.ExecuteCommand(cfg.Context(), log, []string{"docker", "save", image}, "Saving Docker image"); err != nil {
+			return err
+		}
+		if _, err := ssh.Run(cfg, log, loadCommand(algorithm), "Transferring Docker image to server"); err != nil {
+			return err
+		}
+	} else if err := ssh.Retry(cfg, log, "Transferring Docker image", func() error {
+		return transfer(cfg, log, image, algorithm)
+	}); err != nil {
+		return err
+	}
+
+	if image == cfg.ImageRef() {
+		return nil
+	}
+	tagCmd := ssh.Command("docker", "tag", image, cfg.ImageRef()) + " && " + ssh.Command("docker", "rmi", image)
+	_, err = ssh.Run(cfg, log, tagCmd, fmt.Sprintf("Tagging image %s as %s", image, cfg.ImageRef()))
 	return err
 }
 
-// Deploy deploys the container on the remote host
+// LocalImageID returns the ID of an image in the local docker daemon, or an
+// empty string if it does not exist
+func LocalImageID(cfg *config.Config, log *logger.Logger, image string) string {
+	result, err := ssh.ExecuteCommand(cfg.Context(), log, []string{"docker", "image", "inspect", "--format", "{{.Id}}", image}, "Checking local image")
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(result.Stdout)
+}
+
+// ImageID returns the ID of an image on the remote host, or an empty string
+// if it does not exist there
+func ImageID(cfg *config.Config, log *logger.Logger, image string) (string, error) {
+	if image == "" {
+		return "", nil
+	}
+	inspectCmd := ssh.Command("docker", "image", "inspect", "--format", "{{.Id}}", image) + " 2>/dev/null || true"
+	result, err := ssh.Capture(cfg, log, inspectCmd, fmt.Sprintf("Checking image %s on %s", image, cfg.Host))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(result.Stdout), nil
+}
+
+// transfer pipes the saved image, compressed, into docker load on the
+// remote host at no more than the bandwidth limit. It shows the progress
+// while it runs and logs how much was sent and how long it took.
+func transfer(cfg *config.Config, log *logger.Logger, image string, algorithm string) error {
+	limit, err := cfg.BandwidthLimit()
+	if err != nil {
+		return err
+	}
+	size := imageSize(cfg, log, image)
+
+	pipeline := ssh.Command("docker", "save", image)
+	if algorithm != config.CompressNone {
+		pipeline += " | " + algorithm
+	}
+	if limit > 0 {
+		pipeline += fmt.Sprintf(" (limited to %s/s)", formatSize(limit))
+	}
+	if err := log.Info("Executing: " + pipeline); err != nil {
+		return err
+	}
+
+	save, err := ssh.StartLocal(cfg.Context(), []string{"docker", "save", image}, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start docker save: %v", err)
+	}
+
+	// Compress the saved image while streaming it to the remote docker load
+	saved := &countingReader{reader: save.Stdout}
+	compressed, err := compress(cfg, algorithm, saved)
+	if err != nil {
+		save.Stop()
+		return err
+	}
+
+	started := time.Now()
+	upload := compressed.output
+	if limit > 0 {
+		upload = &limitedReader{reader: upload, limit: limit, started: started}
+	}
+	sent := &countingReader{reader: upload}
+
+	stopProgress := showProgress(log, saved, sent, size, started)
+	_, err = ssh.RunWithInput(cfg, log, loadCommand(algorithm), "Transferring Docker image to server", sent)
+	stopProgress()
+	if err != nil {
+		// Stop docker save so it doesn't block on a transfer that is no longer read
+		compressed.stop()
+		save.Stop()
+		return err
+	}
+
+	if err := compressed.wait(); err != nil {
+		return fmt.Errorf("%s compression failed: %v", algorithm, err)
+	}
+	if err := save.Wait(); err != nil {
+		return fmt.Errorf("docker save failed: %v", err)
+	}
+
+	elapsed := time.Since(started)
+	return log.Info(fmt.Sprintf("Transferred %s (%s) in %s, %s/s", formatSize(sent.count.Load()), algorithm,
+		elapsed.Round(time.Second), formatSize(int64(float64(sent.count.Load())/max(elapsed.Seconds(), 1)))))
+}
+
+// imageSize returns the size of a local image, or zero if it is unknown
+func imageSize(cfg *config.Config, log *logger.Logger, image string) int64 {
+	result, err := ssh.ExecuteCommand(cfg.Context(), log, []string{"docker", "image", "inspect", "--format", "{{.Size}}", image}, "Checking image size")
+	if err != nil {
+		return 0
+	}
+	size, _ := strconv.ParseInt(strings.TrimSpace(result.Stdout), 10, 64)
+	return size
+}
+
+// Download streams an image from the remote host into the local docker daemon,
+// compressing it on the host while it is saved
+func Download(cfg *config.Config, log *logger.Logger, image string) error {
+	input, writer := io.Pipe()
+	load, err := ssh.StartLocal(cfg.Context(), []string{"docker", "load"}, input)
+	if err != nil {
+		return fmt.Errorf("failed to start docker load: %v", err)
+	}
+	output := make(chan string, 1)
+	go func() {
+		data, _ := io.ReadAll(load.Stdout)
+		output <- strings.TrimSpace(string(data))
+	}()
+
+	saveCmd := ssh.Command("docker", "save", image) + " | gzip"
+	_, err = ssh.RunWithOutput(cfg, log, saveCmd, fmt.Sprintf("Downloading image %s from %s", image, cfg.Host), writer)
+	writer.Close()
+
+	loaded := <-output
+	if loadErr := load.Wait(); loadErr != nil && err == nil {
+		err = fmt.Errorf("docker load failed: %v", loadErr)
+	}
+	if err != nil {
+		return err
+	}
+
+	return log.Info(loaded)
+}
+
+// Deploy deploys the container on the remote host using the configured
+// strategy, creating its network and named volumes first when they are
+// missing. Replicas are replaced one at a time.
 func Deploy(cfg *config.Config, log *logger.Logger) error {
+	if err := ensureStorage(cfg, log); err != nil {
+		return err
+	}
+
+	if cfg.Replicated() {
+		if err := RollReplicas(cfg, log, cfg.ImageRef()); err != nil {
+			return err
+		}
+		if err := cleanupOldReleases(cfg, log); err != nil {
+			log.Warn(fmt.Sprintf("failed to cleanup old releases: %v", err))
+		}
+		return nil
+	}
+	if err := collapseReplicas(cfg, log); err != nil {
+		return err
+	}
+
+	if cfg.ReplacesAlongside() {
+		exists, err := Exists(cfg, log, cfg.ContainerName)
+		if err != nil {
+			return err
+		}
+
+		// Without a running version there is nothing to keep serving traffic
+		if exists {
+			return deployBlueGreen(cfg, log)
+		}
+	}
+
+	containerConfig := runArgs(cfg, cfg.ContainerName, cfg.HostPort)
+
+	// Replace the existing container, if any
+	runCmd := ssh.Command(append([]string{"docker", "run"}, containerConfig...)...)
+	if err := cutover(cfg, log, func() error {
+		_, err := ssh.Run(cfg, log, runCmd, "Starting container on server")
+		return err
+	}); err != nil {
+		return err
+	}
+
+	// Clean up old releases
+	if err := cleanupOldReleases(cfg, log); err != nil {
+		log.Warn(fmt.Sprintf("failed to cleanup old releases: %v", err))
+	}
+
+	log = log.WithStep("verify")
+	return log.Time(func() error {
+		return Verify(cfg, log)
+	})
+}
+
+// runArgs returns the docker run arguments for the configured container,
+// using the given container name and host port. Without a host port docker
+// picks one.
+func runArgs(cfg *config.Config, name string, hostPort string) []string {
+	publish := cfg.ContainerPort
+	if hostPort != "" {
+		publish = hostPort + ":" + cfg.ContainerPort
+	}
 	containerConfig := []string{
 		"-d",
-		"--name", cfg.ContainerName,
-		"--restart", "unless-stopped",
-		"-p", fmt.Sprintf("%s:%s", cfg.HostPort, cfg.ContainerPort),
+		"--name", name,
+		"--restart", restartPolicy,
+		"-p", publish,
 	}
 
 	if cfg.Network != "" {
 		containerConfig = append(containerConfig, "--network", cfg.Network)
 	}
 
+	for _, label := range proxyLabels(cfg, hostPort) {
+		containerConfig = append(containerConfig, "--label", label)
+	}
+
+	containerConfig = append(containerConfig, resourceArgs(cfg)...)
+
+	if cfg.HealthCmd != "" {
+		containerConfig = append(containerConfig, "--health-cmd", cfg.HealthCmd)
+	}
+
+	if cfg.HealthInterval != "" {
+		containerConfig = append(containerConfig, "--health-interval", cfg.HealthInterval)
+	}
+
+	if cfg.HealthStartPeriod != "" {
+		containerConfig = append(containerConfig, "--health-start-period", cfg.HealthStartPeriod)
+	}
+
+	if cfg.HealthCmdRetries != 0 {
+		containerConfig = append(containerConfig, "--health-retries", strconv.Itoa(cfg.HealthCmdRetries))
+	}
+
+	containerConfig = append(containerConfig, settingsArgs(cfg)...)
+
+	return append(containerConfig, cfg.ImageRef())
+}
+
+// JobArgs returns the docker run arguments for running a command to
+// completion in a new container from the configured image, using the given
+// container name. The container gets the resources, volumes and environment
+// of the app, but no ports, restart policy or health check, and is removed
+// when the command exits.
+func JobArgs(cfg *config.Config, name string, command []string) []string {
+	return jobArgs(cfg, name, cfg.ImageRef(), command)
+}
+
+// MigrateArgs returns the docker run arguments for running the migration
+// command of the app to completion, like a job, in the configured image
+func MigrateArgs(cfg *config.Config) []string {
+	return jobArgs(cfg, cfg.ContainerName+"-migrate", cfg.Migrate.ImageRef(cfg.ImageRef()), cfg.Migrate.Command)
+}
+
+// jobArgs returns the docker run arguments for running a command to
+// completion in a new container from the image
+func jobArgs(cfg *config.Config, name string, image string, command []string) []string {
+	args := []string{"--rm", "--name", name}
+	if cfg.Network != "" {
+		args = append(args, "--network", cfg.Network)
+	}
+	args = append(args, resourceArgs(cfg)...)
+	args = append(args, settingsArgs(cfg)...)
+	args = append(args, image)
+	return append(args, command...)
+}
+
+// resourceArgs returns the docker run arguments of the resource limits
+func resourceArgs(cfg *config.Config) []string {
+	var args []string
+
 	if cfg.CPUs != "" {
-		containerConfig = append(containerConfig, "--cpus", cfg.CPUs)
+		args = append(args, "--cpus", cfg.CPUs)
 	}
 
 	if cfg.Memory != "" {
-		containerConfig = append(containerConfig, "--memory", cfg.Memory)
+		args = append(args, "--memory", cfg.Memory)
 	}
 
-	for _, volume := range cfg.Volumes {
-		containerConfig = append(containerConfig, "-v", volume)
+	if cfg.MemoryReservation != "" {
+		args = append(args, "--memory-reservation", cfg.MemoryReservation)
 	}
 
-	if cfg.EnvFile != "" {
-		containerConfig = append(containerConfig, fmt.Sprintf("--env-file ~/%s", cfg.EnvFile))
+	if cfg.MemorySwap != "" {
+		args = append(args, "--memory-swap", cfg.MemorySwap)
 	}
 
-	containerConfig = append(containerConfig, fmt.Sprintf("%s:%s", cfg.Image, cfg.Tag))
+	if cfg.PidsLimit != 0 {
+		args = append(args, "--pids-limit", strconv.Itoa(cfg.PidsLimit))
+	}
 
-	remoteCommands := strings.Join([]string{
-		fmt.Sprintf("docker stop %s || true", cfg.ContainerName),
-		fmt.Sprintf("docker rm %s || true", cfg.ContainerName),
-		fmt.Sprintf("docker run %s", strings.Join(containerConfig, " ")),
-	}, " && ")
+	if cfg.CPUShares != 0 {
+		args = append(args, "--cpu-shares", strconv.Itoa(cfg.CPUShares))
+	}
 
-	// Execute remote commands
-	restartCmd := fmt.Sprintf("%s \"%s\"", ssh.GetCommand(cfg), remoteCommands)
-	if _, err := ssh.ExecuteCommand(log, restartCmd, "Restarting container on server"); err != nil {
-		return err
+	if cfg.OOMKillDisable {
+		args = append(args, "--oom-kill-disable")
 	}
 
-	// Clean up old releases
-	if err := cleanupOldReleases(cfg, log); err != nil {
-		log.Info(fmt.Sprintf("failed to cleanup old releases: %v", err))
+	if cfg.CPUsetCPUs != "" {
+		args = append(args, "--cpuset-cpus", cfg.CPUsetCPUs)
+	}
+
+	if cfg.CgroupParent != "" {
+		args = append(args, "--cgroup-parent", cfg.CgroupParent)
 	}
 
-	return verifyContainer(cfg, log)
+	if cfg.GPUs != "" {
+		args = append(args, "--gpus", cfg.GPUs)
+	}
+
+	return args
 }
 
-// cleanupOldReleases ensures only the last 5 releases are kept
-func cleanupOldReleases(cfg *config.Config, log *logger.Logger) error {
-	// Get all images for the current application
-	listCmd := fmt.Sprintf("%s \"docker images '%s' --format '{{.Tag}}'\"",
-		ssh.GetCommand(cfg), cfg.Image)
+// settingsArgs returns the docker run arguments of the volumes, the further
+// runtime options and the environment
+func settingsArgs(cfg *config.Config) []string {
+	var args []string
 
-	result, err := ssh.ExecuteCommand(log, listCmd, "Listing existing releases")
-	if err != nil {
-		return err
+	for _, volume := range cfg.Volumes {
+		args = append(args, "-v", volume)
+	}
+
+	args = append(args, runtimeArgs(cfg.Runtime)...)
+
+	for _, key := range sortedKeys(cfg.Env) {
+		args = append(args, "-e", key+"="+cfg.Env[key])
+	}
+
+	if len(cfg.EnvFilePaths()) > 0 {
+		args = append(args, "--env-file", cfg.RemoteEnvFile())
+	}
+
+	return args
+}
+
+// runtimeArgs returns the docker run arguments of the further runtime options
+func runtimeArgs(runtime config.Runtime) []string {
+	var args []string
+	for _, key := range sortedKeys(runtime.Labels) {
+		args = append(args, "--label", key+"="+runtime.Labels[key])
+	}
+	if runtime.User != "" {
+		args = append(args, "--user", runtime.User)
+	}
+	for _, ulimit := range runtime.Ulimits {
+		args = append(args, "--ulimit", ulimit)
+	}
+	for _, capability := range runtime.CapAdd {
+		args = append(args, "--cap-add", capability)
+	}
+	for _, capability := range runtime.CapDrop {
+		args = append(args, "--cap-drop", capability)
+	}
+	if runtime.ReadOnly {
+		args = append(args, "--read-only")
+	}
+	for _, option := range runtime.SecurityOpt {
+		args = append(args, "--security-opt", option)
+	}
+	if runtime.LogDriver != "" {
+		args = append(args, "--log-driver", runtime.LogDriver)
+	}
+	for _, key := range sortedKeys(runtime.LogOpts) {
+		args = append(args, "--log-opt", key+"="+runtime.LogOpts[key])
 	}
+	for _, server := range runtime.DNS {
+		args = append(args, "--dns", server)
+	}
+	for _, extraHost := range runtime.ExtraHosts {
+		args = append(args, "--add-host", extraHost)
+	}
+	for _, mount := range runtime.Tmpfs {
+		args = append(args, "--tmpfs", mount)
+	}
+	for _, device := range runtime.Devices {
+		args = append(args, "--device", device)
+	}
+	return args
+}
 
-	// Split tags into slice and reverse the order
-	tags := strings.Split(strings.TrimSpace(result.Stdout), "\n")
+// RunCommand returns the docker run command starting the app container from
+// the given image with all of its configured options
+func RunCommand(cfg *config.Config, image string) string {
+	args := runArgs(cfg, cfg.ContainerName, cfg.HostPort)
+	args[len(args)-1] = image
+	return ssh.Command(append([]string{"docker", "run"}, args...)...)
+}
 
-	if len(tags) <= 5 {
-		return nil // No cleanup needed
+// sortedKeys returns the keys of a map in sorted order, so commands built
+// from it are stable between runs
+func sortedKeys(values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
 	}
+	slices.Sort(keys)
+	return keys
+}
 
-	slices.Reverse(tags)
+// cleanupOldReleases removes all but the configured number of most recent
+// release images, and dangling layers when pruning is enabled
+func cleanupOldReleases(cfg *config.Config, log *logger.Logger) error {
+	releases, err := Releases(cfg, log)
+	if err != nil {
+		return err
+	}
 
-	// Remove all but the latest 5 tags
-	for _, tag := range tags[5:] {
-		if tag == "" {
+	// Remove all but the latest tags, never the one being deployed
+	for i, release := range releases {
+		tag := release.Tag
+		if i < cfg.KeepReleases || tag == cfg.Tag {
 			continue
 		}
-		removeCmd := fmt.Sprintf("%s \"docker rmi %s:%s\"",
-			ssh.GetCommand(cfg), cfg.Image, tag)
+		removeCmd := ssh.Command("docker", "rmi", release.Ref)
 
-		if _, err := ssh.ExecuteCommand(log, removeCmd,
+		if _, err := ssh.Run(cfg, log, removeCmd,
 			fmt.Sprintf("Removing old release %s", tag)); err != nil {
-			log.Info(fmt.Sprintf("Failed to remove old release %s: %v", tag, err))
+			log.Warn(fmt.Sprintf("Failed to remove old release %s: %v", tag, err))
 			// Continue with other deletions even if one fails
 		}
 	}
 
+	if cfg.Prune {
+		if _, err := ssh.Run(cfg, log, ssh.Command("docker", "image", "prune", "-f"), "Removing dangling image layers"); err != nil {
+			return fmt.Errorf("failed to prune images: %v", err)
+		}
+	}
+
 	return nil
 }
 
-// verifyContainer verifies that the container is running
-func verifyContainer(cfg *config.Config, log *logger.Logger) error {
-	verifyCmd := fmt.Sprintf("%s \"docker ps --filter name=%s --format '{{.Status}}'\"",
-		ssh.GetCommand(cfg), cfg.ContainerName)
-	result, err := ssh.ExecuteCommand(log, verifyCmd, "Verifying container status")
-	if err != nil {
+// Verify waits for the container to stay running, or for docker to report
+// it healthy if it has a health command, then for the configured health
+// check to pass, and watches its logs when configured. A failing container
+// is quarantined when enabled. Every replica is verified.
+func Verify(cfg *config.Config, log *logger.Logger) error {
+	if !cfg.Replicated() {
+		return verifyContainer(cfg, log, cfg.ContainerName, cfg.HostPort)
+	}
+	for replica := 1; replica <= cfg.Replicas; replica++ {
+		if err := verifyReplica(cfg, log, replica); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// verifyContainer verifies a single container published on the host port
+func verifyContainer(cfg *config.Config, log *logger.Logger, name string, hostPort string) error {
+	if err := waitHealthy(cfg, log, name); err != nil {
+		err := fmt.Errorf("container failed to start properly: %v", err)
+		oomCmd := ssh.Command("docker", "inspect", "--format", "{{.State.OOMKilled}}", name)
+		if state, inspectErr := ssh.Capture(cfg, log, oomCmd, "Checking why the container stopped"); inspectErr == nil &&
+			strings.TrimSpace(state.Stdout) == "true" {
+			err = ssh.OutOfMemory(err)
+		}
+		Quarantine(cfg, log, name)
 		return err
 	}
 
-	if !strings.Contains(result.Stdout, "Up") {
-		return fmt.Errorf("container failed to start properly")
+	if err := CheckHealth(cfg, log, name, hostPort); err != nil {
+		Quarantine(cfg, log, name)
+		return err
 	}
 
+	if err := WatchLogs(cfg, log, name); err != nil {
+		Quarantine(cfg, log, name)
+		return err
+	}
 	return nil
 }
-
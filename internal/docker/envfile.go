@@ -0,0 +1,217 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/logger"
+	"github.com/bjarneo/pipe/internal/ssh"
+)
+
+// sopsMarker matches the metadata SOPS adds to encrypted dotenv, YAML and
+// JSON files, capturing the marker that identifies the format
+var sopsMarker = regexp.MustCompile(`(?m)^(sops_version=|sops:|\s*"sops":)`)
+
+// envKey matches the variable names docker accepts in env files
+var envKey = regexp.MustCompile(`^[^\s=]+$`)
+
+// Encryption formats of env files
+const (
+	encryptionNone = ""
+	encryptionSOPS = "sops"
+	encryptionAge  = "age"
+)
+
+// CopyEnvFile merges environment files into the app's env file in the state
+// directory on the remote host, readable only by the SSH user. Later files
+// override the variables of earlier ones, and ${NAME} in plain files is
+// replaced with the variable from the local environment. SOPS and age
+// encrypted files are decrypted in memory; remove the env file with
+// RemoveEnvFile once the container started if any of them is encrypted.
+func CopyEnvFile(cfg *config.Config, log *logger.Logger, paths []string) error {
+	merged, err := mergeEnvFiles(cfg, log, paths)
+	if err != nil {
+		return err
+	}
+	return ssh.WriteFile(cfg, log, merged, cfg.RemoteEnvFile(), "Writing environment file to server")
+}
+
+// RemoveEnvFile removes the env file from the remote host when it holds
+// decrypted variables. Env files of plain files only are kept.
+func RemoveEnvFile(cfg *config.Config, log *logger.Logger, paths []string) {
+	if !EnvFileEncrypted(paths) {
+		return
+	}
+
+	if _, err := ssh.Run(cfg, log, ssh.Command("rm", "-f", cfg.RemoteEnvFile()), "Removing decrypted environment file"); err != nil {
+		log.Warn(fmt.Sprintf("failed to remove decrypted environment file %s: %v", cfg.RemoteEnvFile(), err))
+	}
+}
+
+// EnvFileEncrypted reports whether any of the environment files is SOPS or
+// age encrypted
+func EnvFileEncrypted(paths []string) bool {
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err == nil && envFileEncryption(data) != encryptionNone {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeEnvFiles reads, decrypts and interpolates environment files and
+// merges their variables, keeping the position a variable first appeared at
+// and the value of the last file setting it. Nothing is decrypted in a dry
+// run.
+func mergeEnvFiles(cfg *config.Config, log *logger.Logger, paths []string) ([]byte, error) {
+	var keys []string
+	values := make(map[string]string)
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read environment file: %v", err)
+		}
+
+		encryption := envFileEncryption(data)
+		if encryption != encryptionNone {
+			if ssh.DryRun() {
+				continue
+			}
+			if err := log.Info(fmt.Sprintf("Decrypting %s encrypted environment file %s", encryption, path)); err != nil {
+				return nil, err
+			}
+			if data, err = decryptEnvFile(cfg.Context(), path, encryption, data); err != nil {
+				return nil, err
+			}
+		}
+
+		for number, line := range strings.Split(string(data), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+
+			// A variable without a value is taken from the host's environment
+			key, value, hasValue := strings.Cut(line, "=")
+			if hasValue && encryption == encryptionNone {
+				if value, err = config.Interpolate(value); err != nil {
+					return nil, fmt.Errorf("%s line %d: %v", path, number+1, err)
+				}
+			}
+			if _, seen := values[key]; !seen {
+				keys = append(keys, key)
+			}
+			values[key] = line
+			if hasValue {
+				values[key] = key + "=" + value
+			}
+		}
+	}
+
+	var merged bytes.Buffer
+	for _, key := range keys {
+		merged.WriteString(values[key] + "\n")
+	}
+	return merged.Bytes(), nil
+}
+
+// EnvFileProblems reads the environment files and returns the problems
+// found: missing files, lines that are not KEY=VALUE and variables that
+// are not set in the local environment. Encrypted files are only checked
+// for the command decrypting them.
+func EnvFileProblems(ctx context.Context, paths []string) []string {
+	var problems []string
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+
+		if encryption := envFileEncryption(data); encryption != encryptionNone {
+			if err := ssh.LookPath(ctx, encryption); err != nil {
+				problems = append(problems, fmt.Sprintf("%s is %s encrypted, but %s is not installed", path, encryption, encryption))
+			}
+			continue
+		}
+
+		for number, line := range strings.Split(string(data), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+
+			key, value, hasValue := strings.Cut(line, "=")
+			if !envKey.MatchString(key) {
+				problems = append(problems, fmt.Sprintf("%s line %d: %q is not a variable name", path, number+1, key))
+				continue
+			}
+			if hasValue {
+				if _, err := config.Interpolate(value); err != nil {
+					problems = append(problems, fmt.Sprintf("%s line %d: %v", path, number+1, err))
+				}
+			}
+		}
+	}
+	return problems
+}
+
+// envFileEncryption detects how an environment file is encrypted
+func envFileEncryption(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte("age-encryption.org/")),
+		bytes.HasPrefix(bytes.TrimSpace(data), []byte("-----BEGIN AGE ENCRYPTED FILE-----")):
+		return encryptionAge
+	case sopsMarker.Match(data):
+		return encryptionSOPS
+	default:
+		return encryptionNone
+	}
+}
+
+// decryptEnvFile decrypts an environment file with the sops or age command.
+// The plaintext only exists in memory and is never logged. age uses the same
+// identity as SOPS: SOPS_AGE_KEY_FILE or ~/.config/sops/age/keys.txt. The
+// command is stopped when ctx is cancelled.
+func decryptEnvFile(ctx context.Context, path string, encryption string, data []byte) ([]byte, error) {
+	var args []string
+	switch encryption {
+	case encryptionSOPS:
+		inputType := "dotenv"
+		switch strings.TrimSpace(sopsMarker.FindString(string(data))) {
+		case "sops:":
+			inputType = "yaml"
+		case `"sops":`:
+			inputType = "json"
+		}
+		args = []string{"sops", "--decrypt", "--input-type", inputType, "--output-type", "dotenv", path}
+	case encryptionAge:
+		identity := os.Getenv("SOPS_AGE_KEY_FILE")
+		if identity == "" {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return nil, err
+			}
+			identity = filepath.Join(home, ".config", "sops", "age", "keys.txt")
+		}
+		args = []string{"age", "--decrypt", "-i", identity, path}
+	}
+
+	process, err := ssh.StartLocal(ctx, args, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt %s with %s: %v", path, args[0], err)
+	}
+	decrypted, _ := io.ReadAll(process.Stdout)
+	if err := process.Wait(); err != nil {
+		return nil, fmt.Errorf("failed to decrypt %s with %s: %v", path, args[0], err)
+	}
+	return decrypted, nil
+}
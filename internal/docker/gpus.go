@@ -0,0 +1,30 @@
+package docker
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/logger"
+	"github.com/bjarneo/pipe/internal/ssh"
+)
+
+// CheckGPUs checks that the NVIDIA Container Toolkit, which docker needs to
+// hand GPUs to a container, is installed on the host
+func CheckGPUs(cfg *config.Config, log *logger.Logger) error {
+	if cfg.GPUs == "" {
+		return nil
+	}
+
+	checkCmd := "if command -v nvidia-container-cli >/dev/null 2>&1 || command -v nvidia-ctk >/dev/null 2>&1; " +
+		"then echo present; else echo missing; fi"
+	result, err := ssh.Capture(cfg, log, checkCmd, "Checking NVIDIA Container Toolkit")
+	if err != nil {
+		return fmt.Errorf("failed to check the NVIDIA Container Toolkit: %v", err)
+	}
+	if strings.TrimSpace(result.Stdout) != "present" {
+		return fmt.Errorf("--gpus needs the NVIDIA Container Toolkit on %s, see "+
+			"https://docs.nvidia.com/datacenter/cloud-native/container-toolkit/latest/install-guide.html", cfg.Host)
+	}
+	return nil
+}
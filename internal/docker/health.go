@@ -0,0 +1,152 @@
+package docker
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/logger"
+	"github.com/bjarneo/pipe/internal/ssh"
+)
+
+// healthRequestTimeout is the timeout of a single health check attempt, in seconds
+const healthRequestTimeout = 5
+
+// timelineLogLines is the number of log lines kept in the health timeline
+// for every attempt
+const timelineLogLines = 20
+
+// CheckHealth polls the configured health check from the remote host until
+// it passes or the health timeout expires. A path is requested from the given
+// host port, and "tcp" waits for the port to accept connections. Without a
+// health check URL nothing is checked. The attempts are spread further apart
+// as they go, so slow starting apps get more time, and a failure reports
+// every attempt with the logs the container wrote in between.
+func CheckHealth(cfg *config.Config, log *logger.Logger, container string, hostPort string) error {
+	if err := cfg.InjectFailure("healthcheck"); err != nil {
+		return err
+	}
+	if cfg.HealthURL == "" {
+		return nil
+	}
+
+	timeout, err := time.ParseDuration(cfg.HealthTimeout)
+	if err != nil {
+		return fmt.Errorf("invalid health timeout %q: %v", cfg.HealthTimeout, err)
+	}
+
+	target, checkCmd := healthCommand(cfg.HealthURL, hostPort)
+
+	// A dry run does not start the new version, so there is nothing to poll
+	if ssh.DryRun() {
+		_, err := ssh.Run(cfg, log, checkCmd, fmt.Sprintf("Checking health of %s", target))
+		return err
+	}
+
+	deadline := time.Now().Add(timeout)
+	timeline := healthTimeline{started: time.Now()}
+
+	var last string
+	for attempt := 1; attempt <= cfg.HealthRetries; attempt++ {
+		result, err := ssh.Capture(cfg, log, checkCmd,
+			fmt.Sprintf("Checking health of %s (attempt %d/%d)", target, attempt, cfg.HealthRetries))
+		if err != nil {
+			return err
+		}
+
+		last = strings.TrimSpace(result.Stdout)
+		if last == "200" || last == "open" {
+			return log.Info(fmt.Sprintf("Health check %s passed", target))
+		}
+		if last == "" || last == "000" {
+			last = "no response"
+		}
+		timeline.attempt(attempt, cfg.HealthRetries, last)
+		timeline.captureLogs(cfg, log, container)
+
+		interval := healthWait(timeout, cfg.HealthRetries, attempt)
+		if attempt == cfg.HealthRetries || time.Now().Add(interval).After(deadline) {
+			break
+		}
+		if err := log.Debug(fmt.Sprintf("Health check %s returned %s, retrying in %s", target, last, interval.Round(time.Millisecond))); err != nil {
+			return err
+		}
+		time.Sleep(interval)
+	}
+
+	return fmt.Errorf("health check %s did not pass within %s (last result: %s)\n%s", target, timeout, last, timeline.String())
+}
+
+// healthWait returns the wait after the given attempt. The waits grow by
+// the same step with every attempt and leave time within the timeout for the
+// requests themselves.
+func healthWait(timeout time.Duration, retries int, attempt int) time.Duration {
+	step := timeout / time.Duration(retries*(retries+1)/2)
+	return step * time.Duration(attempt)
+}
+
+// healthCommand returns the checked target and the remote command printing
+// the HTTP status code, or "open" once a TCP port accepts connections
+func healthCommand(healthURL string, hostPort string) (string, string) {
+	if healthURL == "tcp" || strings.HasPrefix(healthURL, "tcp://") {
+		address := strings.TrimPrefix(healthURL, "tcp://")
+		if address == "tcp" {
+			address = "127.0.0.1:" + hostPort
+		}
+		host, port, _ := strings.Cut(address, ":")
+		timeout := strconv.Itoa(healthRequestTimeout)
+		return "tcp://" + address, fmt.Sprintf("(%s || %s) >/dev/null 2>&1 && echo open || echo closed",
+			ssh.Command("nc", "-z", "-w", timeout, host, port),
+			ssh.Command("timeout", timeout, "bash", "-c", "</dev/tcp/$0/$1", host, port))
+	}
+
+	url := healthURL
+	if strings.HasPrefix(url, "/") {
+		url = fmt.Sprintf("http://127.0.0.1:%s%s", hostPort, healthURL)
+	}
+	timeout := strconv.Itoa(healthRequestTimeout)
+	return url, fmt.Sprintf("if command -v curl >/dev/null; then %s; else %s && echo 200; fi || true",
+		ssh.Command("curl", "-s", "-o", "/dev/null", "-w", "%{http_code}", "--max-time", timeout, url),
+		ssh.Command("wget", "-q", "-O", "/dev/null", "-T", timeout, url))
+}
+
+// healthTimeline records the failed health check attempts and the logs the
+// container wrote after each of them
+type healthTimeline struct {
+	started time.Time
+	lastLog time.Time
+	entries []string
+}
+
+// attempt records the result of a failed attempt
+func (t *healthTimeline) attempt(attempt int, retries int, result string) {
+	t.entries = append(t.entries, fmt.Sprintf("+%-7s attempt %d/%d: %s",
+		time.Since(t.started).Round(100*time.Millisecond), attempt, retries, result))
+}
+
+// captureLogs records the last lines the container logged since the previous
+// attempt. Failing to read them does not fail the health check.
+func (t *healthTimeline) captureLogs(cfg *config.Config, log *logger.Logger, container string) {
+	logsCmd := ssh.Command("docker", "logs", "--timestamps", "--tail", strconv.Itoa(timelineLogLines), container) + " 2>&1"
+	result, err := ssh.Capture(cfg, log, logsCmd, fmt.Sprintf("Reading logs of %s", container))
+	if err != nil {
+		return
+	}
+
+	for _, line := range strings.Split(strings.TrimRight(result.Stdout, "\n"), "\n") {
+		stamp, message, _ := strings.Cut(line, " ")
+		logged, err := time.Parse(time.RFC3339Nano, stamp)
+		if err != nil || !logged.After(t.lastLog) {
+			continue
+		}
+		t.lastLog = logged
+		t.entries = append(t.entries, "          | "+message)
+	}
+}
+
+// String returns the timeline for the failure report
+func (t *healthTimeline) String() string {
+	return "timeline:\n  " + strings.Join(t.entries, "\n  ")
+}
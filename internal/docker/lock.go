@@ -0,0 +1,88 @@
+package docker
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strings"
+	"time"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/logger"
+	"github.com/bjarneo/pipe/internal/ssh"
+)
+
+// lockPollInterval is how often a held deploy lock is checked again
+const lockPollInterval = 5 * time.Second
+
+// Lock acquires the deploy lock of the app on the host, so deployments and
+// rollbacks from several machines cannot replace the container at the same
+// time. While another run holds it, Lock waits up to the lock timeout. The
+// returned function releases the lock, also after the command was cancelled.
+func Lock(cfg *config.Config, log *logger.Logger, action string) (func(), error) {
+	timeout, err := cfg.LockTimeoutDuration()
+	if err != nil {
+		return nil, err
+	}
+
+	// mkdir fails when the directory exists, so only one run gets the lock
+	lock := cfg.DeployLock()
+	lockCmd := fmt.Sprintf("mkdir -p %s && if mkdir %s 2>/dev/null; then printf '%%s\\n' %s > %s; echo acquired; else cat %s 2>/dev/null || echo 'an unknown run'; fi",
+		ssh.Command(cfg.LocksDir()), ssh.Command(lock), ssh.Quote(lockOwner(action)), ssh.Command(lock+"/owner"), ssh.Command(lock+"/owner"))
+	release := func() {
+		if err := Unlock(cfg.Detached(), log); err != nil {
+			log.Warn(err.Error())
+		}
+	}
+
+	if ssh.DryRun() {
+		if _, err := ssh.Run(cfg, log, lockCmd, "Acquiring deploy lock"); err != nil {
+			return nil, err
+		}
+		return release, nil
+	}
+
+	deadline := time.Now().Add(timeout)
+	for waited := false; ; waited = true {
+		result, err := ssh.Capture(cfg, log, lockCmd, "Acquiring deploy lock")
+		if err != nil {
+			return nil, fmt.Errorf("failed to acquire the deploy lock: %v", err)
+		}
+		holder := strings.TrimSpace(result.Stdout)
+		if holder == "acquired" {
+			return release, nil
+		}
+
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("%s on %s is locked by %s; wait for it to finish, or run 'pipe unlock' if that run was killed",
+				cfg.ContainerName, cfg.Host, holder)
+		}
+		if !waited {
+			log.Warn(fmt.Sprintf("%s on %s is locked by %s, waiting up to %s", cfg.ContainerName, cfg.Host, holder, timeout))
+		}
+
+		select {
+		case <-cfg.Context().Done():
+			return nil, fmt.Errorf("cancelled while waiting for the deploy lock held by %s", holder)
+		case <-time.After(lockPollInterval):
+		}
+	}
+}
+
+// Unlock releases the deploy lock of the app on the host, whoever holds it
+func Unlock(cfg *config.Config, log *logger.Logger) error {
+	if _, err := ssh.Run(cfg, log, ssh.Command("rm", "-rf", cfg.DeployLock()), "Releasing deploy lock"); err != nil {
+		return fmt.Errorf("failed to release the deploy lock %s on %s: %v", cfg.DeployLock(), cfg.Host, err)
+	}
+	return nil
+}
+
+// lockOwner describes this run in the deploy lock, for the runs waiting on it
+func lockOwner(action string) string {
+	name := "unknown"
+	if current, err := user.Current(); err == nil {
+		name = current.Username
+	}
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("%s by %s@%s (pid %d) since %s", action, name, hostname, os.Getpid(), time.Now().UTC().Format(time.RFC3339))
+}
@@ -0,0 +1,124 @@
+package docker
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/logger"
+	"github.com/bjarneo/pipe/internal/ssh"
+)
+
+// logShipperName returns the name of the log shipping sidecar of the app
+func logShipperName(cfg *config.Config) string {
+	return cfg.ContainerName + "-logs"
+}
+
+// logShipperConfig returns the Vector configuration that reads the logs of
+// the app container, including a blue-green candidate, from Docker and sends
+// them to the configured endpoint
+func logShipperConfig(cfg *config.Config) ([]byte, error) {
+	shipping := cfg.LogShipping
+
+	sink := map[string]any{
+		"inputs":   []string{"app"},
+		"encoding": map[string]any{"codec": "json"},
+	}
+	switch shipping.Type {
+	case config.LogsLoki:
+		labels := map[string]string{
+			"app":  cfg.ContainerName,
+			"host": "{{ host }}",
+		}
+		for key, value := range shipping.Labels {
+			labels[key] = value
+		}
+		sink["type"] = "loki"
+		sink["endpoint"] = shipping.URL
+		sink["labels"] = labels
+	case config.LogsElasticsearch:
+		sink["type"] = "elasticsearch"
+		sink["endpoints"] = []string{shipping.URL}
+		sink["bulk"] = map[string]any{"index": cfg.ContainerName + "-%Y.%m.%d"}
+		delete(sink, "encoding")
+	}
+	if shipping.User != "" {
+		sink["auth"] = map[string]any{
+			"strategy": "basic",
+			"user":     shipping.User,
+			"password": shipping.Password,
+		}
+	}
+
+	names := []string{cfg.ContainerName, cfg.ContainerName + "_next"}
+	return json.MarshalIndent(map[string]any{
+		"data_dir": "/var/lib/vector",
+		"sources": map[string]any{
+			"docker": map[string]any{
+				"type":               "docker_logs",
+				"include_containers": []string{cfg.ContainerName},
+			},
+		},
+		// include_containers matches name prefixes, which would also pick up
+		// other apps such as name-worker
+		"transforms": map[string]any{
+			"app": map[string]any{
+				"type":      "filter",
+				"inputs":    []string{"docker"},
+				"condition": fmt.Sprintf(`includes(%s, .container_name)`, vrlStrings(names)),
+			},
+		},
+		"sinks": map[string]any{
+			"endpoint": sink,
+		},
+	}, "", "  ")
+}
+
+// vrlStrings formats values as a VRL array of strings
+func vrlStrings(values []string) string {
+	quoted := make([]string, len(values))
+	for i, value := range values {
+		encoded, _ := json.Marshal(value)
+		quoted[i] = string(encoded)
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
+
+// ShipLogs writes the log shipping configuration to the host and starts the
+// sidecar shipping the app logs. A running sidecar is only recreated when its
+// configuration changed.
+func ShipLogs(cfg *config.Config, log *logger.Logger) error {
+	vectorConfig, err := logShipperConfig(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to create log shipping configuration: %v", err)
+	}
+
+	name := logShipperName(cfg)
+	configPath := cfg.StateDir() + "/vector.json"
+	args := []string{
+		"-v", "/var/run/docker.sock:/var/run/docker.sock:ro",
+		"-v", configPath + ":/etc/vector/vector.json:ro",
+		"-v", name + ":/var/lib/vector",
+		cfg.LogShipping.ShipperImage(),
+		"--config", "/etc/vector/vector.json",
+	}
+
+	hash := sidecarHash(vectorConfig, args)
+	current, err := sidecarCurrent(cfg, log, name, hash)
+	if err != nil {
+		return err
+	}
+	if current {
+		return log.Info(fmt.Sprintf("Log shipper %s is up to date", name))
+	}
+
+	if _, err := ssh.Run(cfg, log, ssh.Command("mkdir", "-p", cfg.StateDir()), "Creating state directory"); err != nil {
+		return fmt.Errorf("failed to create state directory: %v", err)
+	}
+	if err := ssh.WriteFile(cfg, log, vectorConfig, configPath, "Writing log shipping configuration"); err != nil {
+		return fmt.Errorf("failed to write log shipping configuration: %v", err)
+	}
+
+	return startSidecar(cfg, log, name, hash, args)
+}
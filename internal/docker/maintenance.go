@@ -0,0 +1,112 @@
+package docker
+
+import (
+	"fmt"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/logger"
+	"github.com/bjarneo/pipe/internal/ssh"
+)
+
+// DefaultMaintenanceImage serves the maintenance page unless another nginx
+// image is configured
+const DefaultMaintenanceImage = "nginx:alpine"
+
+// DefaultMaintenancePage is served during maintenance unless a page of the
+// app is configured
+const DefaultMaintenancePage = `<!doctype html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Down for maintenance</title>
+<style>body{font-family:system-ui,sans-serif;color:#333;text-align:center;padding:15vh 1em}</style>
+</head>
+<body>
+<h1>We'll be back soon</h1>
+<p>We are performing scheduled maintenance and will be back shortly.</p>
+</body>
+</html>
+`
+
+// maintenanceConfig is the nginx configuration answering every request with
+// the maintenance page and a 503 status, so clients and crawlers retry later
+const maintenanceConfig = `server {
+    listen %s;
+    root /usr/share/nginx/html;
+    add_header Retry-After 300 always;
+    error_page 503 /maintenance.html;
+    location = /maintenance.html { internal; }
+    location / { return 503; }
+}
+`
+
+// maintenanceScript writes the page and configuration handed over in the
+// environment and runs nginx in the foreground
+const maintenanceScript = `printf '%s' "$MAINTENANCE_PAGE" > /usr/share/nginx/html/maintenance.html && ` +
+	`printf '%s' "$MAINTENANCE_CONFIG" > /etc/nginx/conf.d/default.conf && exec nginx -g 'daemon off;'`
+
+// maintenanceName returns the name of the container serving the maintenance
+// page of the app
+func maintenanceName(cfg *config.Config) string {
+	return cfg.ContainerName + "_maintenance"
+}
+
+// maintenanceArgs returns the docker run arguments of the maintenance page.
+// It publishes the host ports of the app and carries its proxy labels, so
+// requests reach it the same way they reached the app.
+func maintenanceArgs(cfg *config.Config, page string) []string {
+	image := cfg.MaintenanceImage
+	if image == "" {
+		image = DefaultMaintenanceImage
+	}
+
+	hostPort := cfg.HostPort
+	args := []string{"-d", "--name", maintenanceName(cfg), "--restart", restartPolicy}
+	if cfg.Replicated() {
+		hostPort = cfg.ReplicaPort(1)
+		for replica := 1; replica <= cfg.Replicas; replica++ {
+			if port := cfg.ReplicaPort(replica); port != "" {
+				args = append(args, "-p", port+":"+cfg.ContainerPort)
+			}
+		}
+		if hostPort == "" {
+			args = append(args, "-p", cfg.ContainerPort)
+		}
+	} else {
+		args = append(args, "-p", hostPort+":"+cfg.ContainerPort)
+	}
+
+	if cfg.Network != "" {
+		args = append(args, "--network", cfg.Network)
+	}
+	for _, label := range proxyLabels(cfg, hostPort) {
+		args = append(args, "--label", label)
+	}
+
+	return append(args,
+		"-e", "MAINTENANCE_PAGE="+page,
+		"-e", "MAINTENANCE_CONFIG="+fmt.Sprintf(maintenanceConfig, cfg.ContainerPort),
+		image, "sh", "-c", maintenanceScript,
+	)
+}
+
+// StartMaintenancePage starts the container serving the maintenance page in
+// place of the stopped app, replacing one started before
+func StartMaintenancePage(cfg *config.Config, log *logger.Logger, page string) error {
+	if err := Remove(cfg, log, maintenanceName(cfg)); err != nil {
+		return err
+	}
+
+	runCmd := ssh.Command(append([]string{"docker", "run"}, maintenanceArgs(cfg, page)...)...)
+	if _, err := ssh.Run(cfg, log, runCmd, "Starting maintenance page"); err != nil {
+		return fmt.Errorf("failed to start maintenance page: %v", err)
+	}
+	return nil
+}
+
+// RemoveMaintenancePage removes the container serving the maintenance page,
+// freeing the ports of the app
+func RemoveMaintenancePage(cfg *config.Config, log *logger.Logger) error {
+	return Remove(cfg, log, maintenanceName(cfg))
+}
@@ -0,0 +1,86 @@
+package docker
+
+import (
+	"fmt"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/logger"
+	"github.com/bjarneo/pipe/internal/ssh"
+)
+
+// Names of the metrics containers, shared by every app on the host
+const (
+	nodeExporterName = "pipe-node-exporter"
+	cadvisorName     = "pipe-cadvisor"
+)
+
+// metricsArgs returns the docker run arguments of node-exporter and cAdvisor
+func metricsArgs(cfg *config.Config) (nodeExporter []string, cadvisor []string) {
+	nodeExporterPort, cadvisorPort := cfg.Metrics.Ports()
+	nodeExporterImage, cadvisorImage := cfg.Metrics.Images()
+
+	// node-exporter reads the host's network and processes directly
+	nodeExporter = []string{
+		"--network", "host",
+		"--pid", "host",
+		"-v", "/:/host:ro,rslave",
+		nodeExporterImage,
+		"--path.rootfs=/host",
+		"--web.listen-address=:" + nodeExporterPort,
+	}
+
+	cadvisor = []string{
+		"-p", cadvisorPort + ":8080",
+		"--privileged",
+		"--device", "/dev/kmsg",
+		"-v", "/:/rootfs:ro",
+		"-v", "/var/run:/var/run:ro",
+		"-v", "/sys:/sys:ro",
+		"-v", "/var/lib/docker/:/var/lib/docker:ro",
+		"-v", "/dev/disk/:/dev/disk:ro",
+		cadvisorImage,
+		"--docker_only=true",
+	}
+	return nodeExporter, cadvisor
+}
+
+// RunMetrics starts node-exporter and cAdvisor on the host, recreating them
+// only when their settings changed
+func RunMetrics(cfg *config.Config, log *logger.Logger) error {
+	nodeExporter, cadvisor := metricsArgs(cfg)
+	exporters := []struct {
+		name string
+		args []string
+	}{
+		{nodeExporterName, nodeExporter},
+		{cadvisorName, cadvisor},
+	}
+
+	for _, exporter := range exporters {
+		name, args := exporter.name, exporter.args
+		hash := sidecarHash(nil, args)
+		current, err := sidecarCurrent(cfg, log, name, hash)
+		if err != nil {
+			return err
+		}
+		if current {
+			if err := log.Info(fmt.Sprintf("%s is up to date", name)); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := startSidecar(cfg, log, name, hash, args); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RemoveMetrics removes node-exporter and cAdvisor from the host
+func RemoveMetrics(cfg *config.Config, log *logger.Logger) error {
+	removeCmd := ssh.Command("docker", "rm", "-f", nodeExporterName, cadvisorName) + " >/dev/null 2>&1 || true"
+	if _, err := ssh.Run(cfg, log, removeCmd, "Removing metrics exporters"); err != nil {
+		return fmt.Errorf("failed to remove metrics exporters: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package docker
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/logger"
+	"github.com/bjarneo/pipe/internal/ssh"
+)
+
+// Migrate runs the migration command of the app to completion in a one-off
+// container on the host, streaming its output. The network and volumes of
+// the app are created first, so the migration of a first deployment reaches
+// the database like the container will.
+func Migrate(cfg *config.Config, log *logger.Logger) error {
+	if err := ensureStorage(cfg, log); err != nil {
+		return err
+	}
+
+	runCmd := ssh.Command(append([]string{"docker", "run"}, MigrateArgs(cfg)...)...)
+	description := fmt.Sprintf("Running migration %s", strings.Join(cfg.Migrate.Command, " "))
+	if ssh.DryRun() {
+		_, err := ssh.Run(cfg, log, runCmd, description)
+		return err
+	}
+
+	err := ssh.Stream(cfg, log, runCmd, description)
+	var exitErr *ssh.ExitError
+	switch {
+	case errors.As(err, &exitErr):
+		return fmt.Errorf("migration exited with code %d", exitErr.Code)
+	case err != nil:
+		return fmt.Errorf("migration failed: %v", err)
+	case cfg.Context().Err() != nil:
+		return fmt.Errorf("migration was cancelled, its container %s-migrate may still be running", cfg.ContainerName)
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package docker
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/logger"
+	"github.com/bjarneo/pipe/internal/ssh"
+)
+
+// missingPackagesScript prints the package manager of the host, apt or dnf,
+// followed by the packages given as arguments that are not installed. A
+// version given as name=version is ignored for the check.
+const missingPackagesScript = `if command -v apt-get >/dev/null; then echo apt; installed() { dpkg -s "${1%%=*}" >/dev/null 2>&1; }
+elif command -v dnf >/dev/null; then echo dnf; installed() { rpm -q "${1%%=*}" >/dev/null 2>&1; }
+else echo unsupported; exit 0; fi
+for p in "$@"; do installed "$p" || echo "$p"; done`
+
+// missingPackages returns the package manager of the host and the configured
+// system packages that are not installed
+func missingPackages(cfg *config.Config, log *logger.Logger) (string, []string, error) {
+	checkCmd := ssh.Command(append([]string{"sh", "-c", missingPackagesScript, "sh"}, cfg.SystemPackages...)...)
+	result, err := ssh.Capture(cfg, log, checkCmd, "Checking system packages")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to check system packages: %v", err)
+	}
+
+	lines := strings.Fields(result.Stdout)
+	if len(lines) == 0 {
+		return "", nil, fmt.Errorf("failed to check system packages: no output")
+	}
+	return lines[0], lines[1:], nil
+}
+
+// PackageProblems returns the configured system packages missing on the host
+func PackageProblems(cfg *config.Config, log *logger.Logger) ([]string, error) {
+	if len(cfg.SystemPackages) == 0 {
+		return nil, nil
+	}
+
+	manager, missing, err := missingPackages(cfg, log)
+	if err != nil {
+		return nil, err
+	}
+	if manager == "unsupported" {
+		return []string{"system packages are only supported on hosts with apt-get or dnf"}, nil
+	}
+	if len(missing) > 0 {
+		return []string{"not installed: " + strings.Join(missing, ", ")}, nil
+	}
+	return nil, nil
+}
+
+// InstallPackages installs the configured system packages missing on the
+// host with apt-get or dnf and records them in the state directory. Packages
+// removed from the configuration since they were recorded are reported, and
+// left installed as other software on the host may need them.
+func InstallPackages(cfg *config.Config, log *logger.Logger) error {
+	result, err := ssh.Capture(cfg, log, ssh.Command("cat", cfg.PackagesFile())+" 2>/dev/null || true", "Reading installed system packages")
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", cfg.PackagesFile(), err)
+	}
+	recorded := strings.Fields(result.Stdout)
+	if len(recorded) == 0 && len(cfg.SystemPackages) == 0 {
+		return nil
+	}
+
+	var removed []string
+	for _, name := range recorded {
+		if !slices.Contains(cfg.SystemPackages, name) {
+			removed = append(removed, name)
+		}
+	}
+	if len(removed) > 0 {
+		log.Warn(fmt.Sprintf("system packages %s are no longer configured but stay installed on %s, remove them by hand if nothing else needs them",
+			strings.Join(removed, ", "), cfg.Host))
+	}
+
+	if len(cfg.SystemPackages) > 0 {
+		manager, missing, err := missingPackages(cfg, log)
+		if err != nil {
+			return err
+		}
+
+		var installCmd string
+		switch manager {
+		case "apt":
+			installCmd = asRoot("apt-get update -qq") + " && " +
+				asRoot(ssh.Command(append([]string{"env", "DEBIAN_FRONTEND=noninteractive", "apt-get", "install", "-y", "-qq"}, missing...)...))
+		case "dnf":
+			installCmd = asRoot(ssh.Command(append([]string{"dnf", "install", "-y", "-q"}, missing...)...))
+		default:
+			return fmt.Errorf("system packages are only supported on hosts with apt-get or dnf")
+		}
+		if len(missing) > 0 {
+			if _, err := ssh.Run(cfg, log, installCmd, fmt.Sprintf("Installing system packages %s", strings.Join(missing, ", "))); err != nil {
+				return fmt.Errorf("failed to install system packages, this needs root or passwordless sudo: %v", err)
+			}
+		}
+	}
+
+	if slices.Equal(recorded, cfg.SystemPackages) {
+		return nil
+	}
+	record := strings.Join(cfg.SystemPackages, "\n") + "\n"
+	if len(cfg.SystemPackages) == 0 {
+		record = ""
+	}
+	if _, err := ssh.RunWithInput(cfg, log, ssh.Command("tee", cfg.PackagesFile())+" >/dev/null", "Recording system packages",
+		strings.NewReader(record)); err != nil {
+		return fmt.Errorf("failed to record system packages: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package docker
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/logger"
+	"github.com/bjarneo/pipe/internal/ssh"
+)
+
+// publishedPort matches a published port in `docker ps` output, such as
+// 0.0.0.0:8000->80/tcp or :::8000-8001->8000-8001/tcp
+var publishedPort = regexp.MustCompile(`:(\d+)(?:-(\d+))?->`)
+
+// processName matches the process name in `ss -p` output
+var processName = regexp.MustCompile(`users:\(\("([^"]+)"`)
+
+// CheckPorts checks that the host ports the deployment needs are not bound by
+// other containers or host processes
+func CheckPorts(cfg *config.Config, log *logger.Logger) error {
+	ports := []string{cfg.HostPort}
+	if cfg.Replicated() {
+		ports = nil
+		for replica := 1; replica <= cfg.Replicas; replica++ {
+			if port := cfg.ReplicaPort(replica); port != "" {
+				ports = append(ports, port)
+			}
+		}
+	} else if cfg.ReplacesAlongside() {
+		port, err := alternatePort(cfg)
+		if err != nil {
+			return err
+		}
+		ports = append(ports, port)
+	}
+
+	// Containers of this app are replaced during the deployment
+	owned := map[string]bool{
+		cfg.ContainerName:               true,
+		cfg.ContainerName + "_next":     true,
+		cfg.ContainerName + "_backup":   true,
+		cfg.ContainerName + "_previous": true,
+	}
+	replicas, err := replicaContainers(cfg, log)
+	if err != nil {
+		return err
+	}
+	for _, replica := range replicas {
+		owned[cfg.ReplicaName(replica)] = true
+		owned[cfg.ReplicaName(replica)+"_previous"] = true
+	}
+
+	result, err := ssh.Capture(cfg, log, ssh.Command("docker", "ps", "--format", "{{.Names}}\t{{.Ports}}"), "Checking ports used by containers")
+	if err != nil {
+		return fmt.Errorf("failed to list containers: %v", err)
+	}
+
+	var conflicts []string
+	published := make(map[string]bool)
+	for _, line := range strings.Split(strings.TrimSpace(result.Stdout), "\n") {
+		name, publishedPorts, _ := strings.Cut(line, "\t")
+		for _, port := range ports {
+			if !publishesPort(publishedPorts, port) {
+				continue
+			}
+			published[port] = true
+			conflict := fmt.Sprintf("port %s is used by container %s", port, name)
+			if name == maintenanceName(cfg) {
+				conflict = fmt.Sprintf("port %s is used by the maintenance page, end maintenance with pipe maintenance off first", port)
+			}
+			if !owned[name] && !slices.Contains(conflicts, conflict) {
+				conflicts = append(conflicts, conflict)
+			}
+		}
+	}
+
+	// Ports published by containers are bound by docker itself, so only the
+	// remaining ports can be held by host processes. Process names are only
+	// shown when the SSH user may see them.
+	listening, err := ssh.Capture(cfg, log, "ss -Hltnp 2>/dev/null || true", "Checking ports used by host processes")
+	if err != nil {
+		return fmt.Errorf("failed to list listening ports: %v", err)
+	}
+
+	for _, line := range strings.Split(strings.TrimSpace(listening.Stdout), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 4 {
+			continue
+		}
+		for _, port := range ports {
+			if published[port] || !strings.HasSuffix(fields[3], ":"+port) {
+				continue
+			}
+			process := "a host process"
+			if match := processName.FindStringSubmatch(line); match != nil {
+				process = "process " + match[1]
+			}
+			conflict := fmt.Sprintf("port %s is used by %s", port, process)
+			if !slices.Contains(conflicts, conflict) {
+				conflicts = append(conflicts, conflict)
+			}
+		}
+	}
+
+	if len(conflicts) > 0 {
+		return fmt.Errorf("port conflict on %s: %s", cfg.Host, strings.Join(conflicts, ", "))
+	}
+
+	return nil
+}
+
+// publishesPort reports whether docker ps port output publishes the host port
+func publishesPort(published string, port string) bool {
+	target, err := strconv.Atoi(port)
+	if err != nil {
+		return false
+	}
+
+	for _, match := range publishedPort.FindAllStringSubmatch(published, -1) {
+		first, _ := strconv.Atoi(match[1])
+		last := first
+		if match[2] != "" {
+			last, _ = strconv.Atoi(match[2])
+		}
+		if target >= first && target <= last {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,138 @@
+package docker
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/logger"
+	"github.com/bjarneo/pipe/internal/ssh"
+)
+
+// Why the SSH user cannot run docker on a host
+const (
+	dockerMissing  = "missing"
+	dockerNoGroup  = "sudo"
+	dockerDenied   = "denied"
+	dockerNoDaemon = "member"
+)
+
+// dockerAccessCmd prints why the SSH user cannot run docker: it is not
+// installed, the user is not in the docker group but may use sudo, may use
+// neither, or is a member and the daemon is not running
+const dockerAccessCmd = `if ! command -v docker >/dev/null 2>&1; then echo missing; ` +
+	`elif [ "$(id -u)" = 0 ] || id -nG | tr ' ' '\n' | grep -qx docker; then echo member; ` +
+	`elif sudo -n true 2>/dev/null; then echo sudo; else echo denied; fi`
+
+// dockerAccess returns why the SSH user cannot run docker on the host
+func dockerAccess(cfg *config.Config, log *logger.Logger) (string, error) {
+	result, err := ssh.Capture(cfg, log, dockerAccessCmd, "Checking Docker access")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(result.Stdout), nil
+}
+
+// dockerAccessError explains why docker info failed on the host
+func dockerAccessError(cfg *config.Config, access string, err error) error {
+	switch access {
+	case dockerMissing:
+		return fmt.Errorf("Docker is not installed on %s: install it, or pass --bootstrap to install it on Ubuntu or Debian", cfg.Host)
+	case dockerNoGroup:
+		return fmt.Errorf("%s is not in the docker group on %s: add it with 'sudo usermod -aG docker %s', pass --bootstrap to add it, or set --docker-user or --docker-cmd 'sudo docker'",
+			cfg.User, cfg.Host, cfg.User)
+	case dockerDenied:
+		return fmt.Errorf("%s is not in the docker group on %s and may not use sudo: ask an administrator to run 'usermod -aG docker %s'",
+			cfg.User, cfg.Host, cfg.User)
+	case dockerNoDaemon:
+		return fmt.Errorf("Docker is installed on %s but its daemon is not reachable, start it with 'sudo systemctl start docker': %v", cfg.Host, err)
+	}
+	return fmt.Errorf("remote Docker check failed - please ensure Docker is installed on %s: %v", cfg.Host, err)
+}
+
+// InstallDocker installs Docker from the distribution's packages on an
+// Ubuntu or Debian host, starts it and adds the SSH user to the docker
+// group. The SSH connection is closed afterwards, so the next command logs
+// in with the new group.
+func InstallDocker(cfg *config.Config, log *logger.Logger) error {
+	result, err := ssh.Capture(cfg, log, `. /etc/os-release 2>/dev/null && echo "$ID $ID_LIKE"`, "Detecting host distribution")
+	if err != nil {
+		return fmt.Errorf("failed to detect the distribution of %s: %v", cfg.Host, err)
+	}
+	debian := func(id string) bool { return id == "ubuntu" || id == "debian" }
+	if !slices.ContainsFunc(strings.Fields(result.Stdout), debian) {
+		return fmt.Errorf("--bootstrap installs Docker on Ubuntu and Debian only, install it on %s yourself", cfg.Host)
+	}
+
+	if err := log.Info(fmt.Sprintf("Installing Docker on %s", cfg.Host)); err != nil {
+		return err
+	}
+	installCmd := asRoot("apt-get update -q") + " && " +
+		asRoot("env DEBIAN_FRONTEND=noninteractive apt-get install -y -q docker.io") + " && " +
+		asRoot(ssh.Command("systemctl", "enable", "--now", "docker")) + " && " +
+		`if [ "$(id -u)" != 0 ]; then ` + asRoot(`usermod -aG docker "$(id -un)"`) + "; fi"
+	if _, err := ssh.Run(cfg, log, installCmd, "Installing Docker"); err != nil {
+		return fmt.Errorf("failed to install Docker on %s (needs root or passwordless sudo): %v", cfg.Host, err)
+	}
+
+	ssh.Disconnect(cfg)
+	return nil
+}
+
+// CheckPlatform checks that the host can run the image built for the
+// configured platform. Multi-platform builds are checked when the image is
+// transferred, and the platform of pre-built images is unknown.
+func CheckPlatform(cfg *config.Config, log *logger.Logger) error {
+	if cfg.Platform == config.PlatformAuto || cfg.MultiPlatform() || cfg.PrebuiltImage != "" {
+		return nil
+	}
+
+	result, err := ssh.Capture(cfg, log, ssh.Command("docker", "info", "--format", "{{.Architecture}}"), "Checking host architecture")
+	if err != nil {
+		return fmt.Errorf("failed to check the architecture of %s: %v", cfg.Host, err)
+	}
+	if ssh.DryRun() {
+		return nil
+	}
+	platform, err := config.MachinePlatform(result.Stdout)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSuffix(platform, "/v8") != strings.TrimSuffix(cfg.Platform, "/v8") {
+		return fmt.Errorf("%s runs %s but the image is built for %s: set --platform %s, or --platform %s to build for every host",
+			cfg.Host, platform, cfg.Platform, platform, config.PlatformAuto)
+	}
+	return nil
+}
+
+// checkDiskSpace checks that the filesystem of docker's data on the host
+// has room for the image. Layers the host already has take no extra room,
+// so an image that just fits may need less. Images that are not local, and
+// hosts only reached through the Docker API, are not checked.
+func checkDiskSpace(cfg *config.Config, log *logger.Logger, image string) error {
+	if cfg.DockerTLS() || ssh.DryRun() {
+		return nil
+	}
+	size := imageSize(cfg, log, image)
+	if size == 0 {
+		return nil
+	}
+
+	dfCmd := `df -Pk "$(docker info --format '{{.DockerRootDir}}' 2>/dev/null || echo /var/lib/docker)" | awk 'NR == 2 { print $4 }'`
+	result, err := ssh.Capture(cfg, log, dfCmd, "Checking free disk space")
+	if err != nil {
+		return fmt.Errorf("failed to check free disk space on %s: %v", cfg.Host, err)
+	}
+	free, err := strconv.ParseInt(strings.TrimSpace(result.Stdout), 10, 64)
+	if err != nil {
+		log.Warn(fmt.Sprintf("Could not read free disk space on %s from %q", cfg.Host, strings.TrimSpace(result.Stdout)))
+		return nil
+	}
+	if free*1024 < size {
+		return fmt.Errorf("%s has %s free for docker but %s is %s: free up space, such as with 'docker system prune'",
+			cfg.Host, formatSize(free*1024), image, formatSize(size))
+	}
+	return nil
+}
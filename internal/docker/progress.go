@@ -0,0 +1,101 @@
+package docker
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/bjarneo/pipe/internal/logger"
+)
+
+// countingReader counts the bytes read through it, which may be read while
+// it is in use
+type countingReader struct {
+	reader io.Reader
+	count  atomic.Int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.count.Add(int64(n))
+	return n, err
+}
+
+// limitedReader limits the rate data is read through it to limit bytes per
+// second
+type limitedReader struct {
+	reader  io.Reader
+	limit   int64
+	started time.Time
+	count   int64
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	// Read a tenth of a second's worth at a time so the rate stays even
+	if chunk := max(r.limit/10, 1); int64(len(p)) > chunk {
+		p = p[:chunk]
+	}
+	n, err := r.reader.Read(p)
+	r.count += int64(n)
+
+	due := time.Duration(float64(r.count) / float64(r.limit) * float64(time.Second))
+	if wait := due - time.Since(r.started); wait > 0 {
+		time.Sleep(wait)
+	}
+	return n, err
+}
+
+// showProgress reports the progress of an image transfer every second until
+// the returned function is called. Progress is measured on the saved image,
+// before compression, against its size; sent is what went over the wire.
+func showProgress(log *logger.Logger, saved *countingReader, sent *countingReader, size int64, started time.Time) func() {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				log.Progress(progressLine(saved.count.Load(), sent.count.Load(), size, time.Since(started)))
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// progressLine describes how far a transfer got. The remaining time is
+// estimated from the rate the image has been read so far.
+func progressLine(read int64, sent int64, size int64, elapsed time.Duration) string {
+	rate := float64(read) / max(elapsed.Seconds(), 1)
+	if size <= 0 || read > size {
+		return fmt.Sprintf("Transferring image: %s read, %s sent, %s/s", formatSize(read), formatSize(sent), formatSize(int64(rate)))
+	}
+
+	line := fmt.Sprintf("Transferring image: %d%% of %s, %s sent, %s/s", read*100/size, formatSize(size), formatSize(sent), formatSize(int64(rate)))
+	if rate > 0 {
+		left := time.Duration(float64(size-read) / rate * float64(time.Second))
+		line += fmt.Sprintf(", %s left", left.Round(time.Second))
+	}
+	return line
+}
+
+// formatSize formats a number of bytes for the log
+func formatSize(bytes int64) string {
+	switch {
+	case bytes >= 1<<30:
+		return fmt.Sprintf("%.1f GB", float64(bytes)/(1<<30))
+	case bytes >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(bytes)/(1<<20))
+	default:
+		return fmt.Sprintf("%.1f KB", float64(bytes)/(1<<10))
+	}
+}
@@ -0,0 +1,96 @@
+package docker
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/logger"
+)
+
+// Name of the reverse proxy container and its certificate volume, shared by
+// every app on the host
+const (
+	proxyName   = "pipe-proxy"
+	proxyVolume = "pipe-proxy-data"
+)
+
+// proxyArgs returns the docker run arguments of the reverse proxy. It runs on
+// the host network, listening on ports 80 and 443, and finds the apps through
+// the labels of their containers.
+func proxyArgs(cfg *config.Config) []string {
+	args := []string{
+		"--network", "host",
+		"-v", "/var/run/docker.sock:/var/run/docker.sock:ro",
+	}
+
+	if cfg.Proxy.Type == config.ProxyCaddy {
+		args = append(args, "-v", proxyVolume+":/data")
+		if cfg.Proxy.Email != "" {
+			args = append(args, "--label", "caddy.email="+cfg.Proxy.Email)
+		}
+		return append(args, cfg.Proxy.ImageRef())
+	}
+
+	args = append(args,
+		"-v", proxyVolume+":/letsencrypt",
+		cfg.Proxy.ImageRef(),
+		"--providers.docker=true",
+		"--providers.docker.exposedbydefault=false",
+		"--entrypoints.web.address=:80",
+		"--entrypoints.web.http.redirections.entrypoint.to=websecure",
+		"--entrypoints.web.http.redirections.entrypoint.scheme=https",
+		"--entrypoints.websecure.address=:443",
+		"--certificatesresolvers.letsencrypt.acme.storage=/letsencrypt/acme.json",
+		"--certificatesresolvers.letsencrypt.acme.httpchallenge.entrypoint=web",
+	)
+	if cfg.Proxy.Email != "" {
+		args = append(args, "--certificatesresolvers.letsencrypt.acme.email="+cfg.Proxy.Email)
+	}
+	return args
+}
+
+// proxyLabels returns the labels routing the domain of the app to the
+// container published on the given host port. Traefik reaches the container
+// on its docker network and Caddy through the published port.
+func proxyLabels(cfg *config.Config, hostPort string) []string {
+	if !cfg.Proxy.Enabled() || cfg.Domain == "" {
+		return nil
+	}
+
+	if cfg.Proxy.Type == config.ProxyCaddy {
+		return []string{
+			"caddy=" + cfg.Domain,
+			"caddy.reverse_proxy=127.0.0.1:" + hostPort,
+		}
+	}
+
+	// Dots separate the parts of a label, so they cannot be in the router name
+	router := strings.ReplaceAll(cfg.ContainerName, ".", "-")
+	labels := []string{
+		"traefik.enable=true",
+		fmt.Sprintf("traefik.http.routers.%s.rule=Host(`%s`)", router, cfg.Domain),
+		fmt.Sprintf("traefik.http.routers.%s.entrypoints=websecure", router),
+		fmt.Sprintf("traefik.http.routers.%s.tls.certresolver=letsencrypt", router),
+		fmt.Sprintf("traefik.http.services.%s.loadbalancer.server.port=%s", router, cfg.ContainerPort),
+	}
+	if cfg.Network != "" {
+		labels = append(labels, "traefik.docker.network="+cfg.Network)
+	}
+	return labels
+}
+
+// RunProxy starts the reverse proxy on the host, recreating it only when its
+// settings changed
+func RunProxy(cfg *config.Config, log *logger.Logger) error {
+	args := proxyArgs(cfg)
+	hash := sidecarHash(nil, args)
+	current, err := sidecarCurrent(cfg, log, proxyName, hash)
+	if err != nil {
+		return err
+	}
+	if current {
+		return log.Info(fmt.Sprintf("%s is up to date", proxyName))
+	}
+	return startSidecar(cfg, log, proxyName, hash, args)
+}
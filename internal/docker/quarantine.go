@@ -0,0 +1,65 @@
+package docker
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/logger"
+	"github.com/bjarneo/pipe/internal/ssh"
+)
+
+// Quarantine keeps the evidence of a container that failed verification
+// when quarantine is enabled: the container is committed to a quarantine
+// image, and its logs, its inspect output and the configured quarantine
+// paths are exported to the state directory. Failures are only logged, so
+// the container is still replaced or restored afterwards.
+func Quarantine(cfg *config.Config, log *logger.Logger, container string) {
+	if !cfg.Quarantine.Enabled {
+		return
+	}
+	cfg = cfg.Detached()
+
+	stamp := time.Now().UTC().Format("20060102T150405Z")
+	image := quarantineImage(cfg, stamp)
+	dir := fmt.Sprintf("%s/%s", cfg.QuarantineDir(), stamp)
+
+	commitCmd := ssh.Command("docker", "commit", container, image)
+	if _, err := ssh.Run(cfg, log, commitCmd, fmt.Sprintf("Committing failed container %s to %s", container, image)); err != nil {
+		log.Warn(fmt.Sprintf("failed to commit failed container %s: %v", container, err))
+	}
+
+	commands := []string{
+		ssh.Command("mkdir", "-p", dir),
+		ssh.Command("docker", "logs", "--timestamps", container) + " > " + ssh.Command(dir+"/container.log") + " 2>&1",
+		ssh.Command("docker", "inspect", container) + " > " + ssh.Command(dir+"/inspect.json"),
+	}
+	for _, source := range cfg.Quarantine.Paths {
+		// The paths are copied below a directory named after their full
+		// path, so two paths ending in the same name do not collide
+		target := dir + "/files" + source
+		commands = append(commands, ssh.Command("mkdir", "-p", path.Dir(target))+" && "+
+			ssh.Command("docker", "cp", container+":"+source, target))
+	}
+
+	var failed []string
+	for _, command := range commands {
+		if _, err := ssh.Run(cfg, log, command, "Exporting evidence of the failed container"); err != nil {
+			failed = append(failed, err.Error())
+		}
+	}
+	if len(failed) > 0 {
+		log.Warn(fmt.Sprintf("failed to export some evidence of container %s: %s", container, strings.Join(failed, "; ")))
+	}
+
+	log.Warn(fmt.Sprintf("Failed container %s quarantined as %s, its logs and files are in %s on %s", container, image, dir, cfg.Host))
+}
+
+// quarantineImage returns the image a failed container is committed to. It
+// is kept apart from the release images, so rollbacks and the cleanup of old
+// releases never pick it up.
+func quarantineImage(cfg *config.Config, stamp string) string {
+	return fmt.Sprintf("%s-quarantine:%s", strings.ToLower(cfg.ContainerName), stamp)
+}
@@ -0,0 +1,100 @@
+package docker
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/logger"
+	"github.com/bjarneo/pipe/internal/ssh"
+)
+
+// restartPolicy is the policy used for deployed containers
+const restartPolicy = "unless-stopped"
+
+// rebootState describes what decides whether the container survives a reboot
+type rebootState struct {
+	restartPolicy string
+	dockerService string
+}
+
+// RebootProblems returns the reasons the container would not come back after
+// the host reboots. Hosts without systemd are not checked for the docker service.
+func RebootProblems(cfg *config.Config, log *logger.Logger) ([]string, error) {
+	state, err := checkReboot(cfg, log)
+	if err != nil {
+		return nil, err
+	}
+
+	var problems []string
+	if state.restartPolicy != "" && state.restartPolicy != "always" && state.restartPolicy != restartPolicy {
+		problems = append(problems, fmt.Sprintf("container %s has restart policy %q instead of %q",
+			cfg.ContainerName, state.restartPolicy, restartPolicy))
+	}
+	if state.dockerService != "" && state.dockerService != "enabled" {
+		problems = append(problems, fmt.Sprintf("docker service is %s in systemd, so docker does not start on boot",
+			state.dockerService))
+	}
+
+	return problems, nil
+}
+
+// FixReboot sets the container restart policy and enables the docker service
+// so the container comes back after the host reboots
+func FixReboot(cfg *config.Config, log *logger.Logger) error {
+	state, err := checkReboot(cfg, log)
+	if err != nil {
+		return err
+	}
+
+	if state.restartPolicy != "" && state.restartPolicy != "always" && state.restartPolicy != restartPolicy {
+		updateCmd := ssh.Command("docker", "update", "--restart", restartPolicy, cfg.ContainerName)
+		if _, err := ssh.Run(cfg, log, updateCmd, "Setting container restart policy"); err != nil {
+			return err
+		}
+	}
+
+	if state.dockerService != "" && state.dockerService != "enabled" {
+		enableCmd := "systemctl enable docker 2>/dev/null || sudo -n systemctl enable docker"
+		if _, err := ssh.Run(cfg, log, enableCmd, "Enabling docker service"); err != nil {
+			return fmt.Errorf("failed to enable docker service, run 'sudo systemctl enable docker' on %s: %v", cfg.Host, err)
+		}
+	}
+
+	return nil
+}
+
+// checkReboot reads the restart policy of the container, empty when it does
+// not exist, and the systemd state of docker, empty without systemd
+func checkReboot(cfg *config.Config, log *logger.Logger) (rebootState, error) {
+	var state rebootState
+
+	exists, err := Exists(cfg, log, cfg.ContainerName)
+	if err != nil {
+		return state, err
+	}
+
+	if exists {
+		policyCmd := ssh.Command("docker", "inspect", "--format", "{{.HostConfig.RestartPolicy.Name}}", cfg.ContainerName)
+		result, err := ssh.Capture(cfg, log, policyCmd, "Checking container restart policy")
+		if err != nil {
+			return state, fmt.Errorf("failed to check restart policy: %v", err)
+		}
+		state.restartPolicy = strings.TrimSpace(result.Stdout)
+		if state.restartPolicy == "" {
+			state.restartPolicy = "no"
+		}
+	}
+
+	serviceCmd := "command -v systemctl >/dev/null && systemctl is-enabled docker 2>/dev/null || true"
+	result, err := ssh.Capture(cfg, log, serviceCmd, "Checking docker service")
+	if err != nil {
+		return state, fmt.Errorf("failed to check docker service: %v", err)
+	}
+	state.dockerService = strings.TrimSpace(result.Stdout)
+	if state.dockerService == "not-found" {
+		state.dockerService = ""
+	}
+
+	return state, nil
+}
@@ -0,0 +1,63 @@
+package docker
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/logger"
+	"github.com/bjarneo/pipe/internal/ssh"
+)
+
+// Push logs in to the registry locally, if credentials are configured, and
+// pushes the built image, retrying when the push fails on a network error
+func Push(cfg *config.Config, log *logger.Logger) error {
+	if err := login(cfg, log); err != nil {
+		return err
+	}
+
+	if err := ssh.Retry(cfg, log, "Pushing Docker image", func() error {
+		_, err := ssh.ExecuteCommand(cfg.Context(), log, []string{"docker", "push", cfg.ImageRef()}, "Pushing Docker image to registry")
+		return err
+	}); err != nil {
+		return fmt.Errorf("failed to push image: %v", err)
+	}
+
+	return nil
+}
+
+// login logs in to the registry locally, if credentials are configured
+func login(cfg *config.Config, log *logger.Logger) error {
+	if cfg.RegistryUser == "" {
+		return nil
+	}
+	loginArgs := []string{"docker", "login", cfg.RegistryHost(), "-u", cfg.RegistryUser, "--password-stdin"}
+	if _, err := ssh.ExecuteCommandWithInput(cfg.Context(), log, loginArgs, "Logging in to registry locally",
+		strings.NewReader(cfg.RegistryPass)); err != nil {
+		return fmt.Errorf("local registry login failed: %v", err)
+	}
+	return nil
+}
+
+// pull logs in to the registry on the remote host, if credentials are
+// configured, and pulls the image, retrying when the pull fails on a network
+// error
+func pull(cfg *config.Config, log *logger.Logger) error {
+	if cfg.RegistryUser != "" {
+		loginCmd := ssh.Command("docker", "login", cfg.RegistryHost(), "-u", cfg.RegistryUser, "--password-stdin")
+		if _, err := ssh.RunWithInput(cfg, log, loginCmd, "Logging in to registry on server",
+			strings.NewReader(cfg.RegistryPass)); err != nil {
+			return fmt.Errorf("remote registry login failed: %v", err)
+		}
+	}
+
+	pullCmd := ssh.Command("docker", "pull", cfg.ImageRef())
+	if err := ssh.Retry(cfg, log, "Pulling Docker image", func() error {
+		_, err := ssh.Run(cfg, log, pullCmd, "Pulling Docker image on server")
+		return err
+	}); err != nil {
+		return fmt.Errorf("failed to pull image: %v", err)
+	}
+
+	return nil
+}
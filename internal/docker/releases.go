@@ -0,0 +1,79 @@
+package docker
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/logger"
+	"github.com/bjarneo/pipe/internal/ssh"
+)
+
+// Release is an image of the app kept on the host
+type Release struct {
+	Ref     string
+	Tag     string
+	ID      string
+	Created time.Time
+}
+
+// imageInspect holds the fields of docker image inspect used for releases
+type imageInspect struct {
+	ID       string    `json:"Id"`
+	RepoTags []string  `json:"RepoTags"`
+	Created  time.Time `json:"Created"`
+}
+
+// Releases returns the images of the app kept on the host, newest first. The
+// creation times are read from docker image inspect, which reports them in
+// UTC regardless of the locale and time zone of the host.
+func Releases(cfg *config.Config, log *logger.Logger) ([]Release, error) {
+	listCmd := ssh.Command("docker", "images", cfg.Repository(), "--format", "{{.Repository}}:{{.Tag}}")
+	result, err := ssh.Capture(cfg, log, listCmd, "Listing release images")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list release images: %v", err)
+	}
+
+	var refs []string
+	for _, ref := range strings.Split(strings.TrimSpace(result.Stdout), "\n") {
+		if ref != "" && !strings.HasSuffix(ref, ":<none>") {
+			refs = append(refs, ref)
+		}
+	}
+	if len(refs) == 0 {
+		return nil, nil
+	}
+
+	inspectCmd := ssh.Command(append([]string{"docker", "image", "inspect"}, refs...)...)
+	result, err = ssh.Capture(cfg, log, inspectCmd, "Inspecting release images")
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect release images: %v", err)
+	}
+	var images []imageInspect
+	if err := json.Unmarshal([]byte(result.Stdout), &images); err != nil {
+		return nil, fmt.Errorf("failed to parse release images: %v", err)
+	}
+
+	var releases []Release
+	for _, image := range images {
+		// An image may also be tagged for other repositories
+		for _, ref := range image.RepoTags {
+			tag, ok := strings.CutPrefix(ref, cfg.Repository()+":")
+			if !ok {
+				continue
+			}
+			releases = append(releases, Release{Ref: ref, Tag: tag, ID: image.ID, Created: image.Created})
+		}
+	}
+
+	sort.SliceStable(releases, func(i, j int) bool {
+		if !releases[i].Created.Equal(releases[j].Created) {
+			return releases[i].Created.After(releases[j].Created)
+		}
+		return releases[i].Ref > releases[j].Ref
+	})
+	return releases, nil
+}
@@ -0,0 +1,206 @@
+package docker
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/logger"
+	"github.com/bjarneo/pipe/internal/ssh"
+)
+
+// DeployedContainer returns the container of the app on the host, or its
+// first replica, as configured or as left by an earlier deployment with
+// another number of replicas. It is empty when the app is not deployed.
+func DeployedContainer(cfg *config.Config, log *logger.Logger) (string, error) {
+	names := []string{cfg.ContainerName, cfg.ReplicaName(1)}
+	if cfg.Replicated() {
+		slices.Reverse(names)
+	}
+	for _, name := range names {
+		exists, err := Exists(cfg, log, name)
+		if err != nil {
+			return "", err
+		}
+		if exists {
+			return name, nil
+		}
+	}
+	return "", nil
+}
+
+// RollReplicas replaces the replicas of the app on the host with containers
+// of the image one at a time, so the others keep serving while one restarts.
+// A replica failing verification gets its previous container back and the
+// rollout stops, leaving the replicas before it on the new version. A single
+// container of an earlier deployment becomes the first replica, and the
+// replicas beyond the configured number are removed at the end.
+func RollReplicas(cfg *config.Config, log *logger.Logger, image string) error {
+	// The single container publishes the host port of the first replica
+	exists, err := Exists(cfg, log, cfg.ContainerName)
+	if err != nil {
+		return err
+	}
+	if exists {
+		replicaExists, err := Exists(cfg, log, cfg.ReplicaName(1))
+		if err != nil {
+			return err
+		}
+		if !replicaExists {
+			if err := Rename(cfg, log, cfg.ContainerName, cfg.ReplicaName(1)); err != nil {
+				return err
+			}
+		}
+	}
+
+	for replica := 1; replica <= cfg.Replicas; replica++ {
+		if err := replaceReplica(cfg, log, replica, image); err != nil {
+			if replica > 1 {
+				return fmt.Errorf("replica %d of %d failed, replicas 1 to %d run %s: %v", replica, cfg.Replicas, replica-1, image, err)
+			}
+			return err
+		}
+	}
+
+	replicas, err := replicaContainers(cfg, log)
+	if err != nil {
+		return err
+	}
+	for _, replica := range replicas {
+		if replica > cfg.Replicas {
+			if err := Remove(cfg, log, cfg.ReplicaName(replica)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// replaceReplica replaces a single replica with a container of the image and
+// verifies it. The previous container is kept aside until the new one passed
+// verification, and put back when it fails or the command is cancelled.
+func replaceReplica(cfg *config.Config, log *logger.Logger, replica int, image string) error {
+	name := cfg.ReplicaName(replica)
+	previous := name + "_previous"
+
+	exists, err := Exists(cfg, log, name)
+	if err != nil {
+		return err
+	}
+	if exists {
+		// Remove a container left aside by an interrupted run
+		if err := Remove(cfg, log, previous); err != nil {
+			return err
+		}
+		err = Stop(cfg, log, name)
+		if err == nil {
+			err = Rename(cfg, log, name, previous)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	args := runArgs(cfg, name, cfg.ReplicaPort(replica))
+	args[len(args)-1] = image
+	runCmd := ssh.Command(append([]string{"docker", "run"}, args...)...)
+	_, err = ssh.Run(cfg, log, runCmd, fmt.Sprintf("Starting replica %d of %d", replica, cfg.Replicas))
+	if err == nil {
+		err = verifyReplica(cfg, log, replica)
+	}
+	if err != nil {
+		if !exists {
+			return err
+		}
+		removeContainer(cfg, log, name)
+		return restorePrevious(cfg.Detached(), log, name, previous, err)
+	}
+
+	removeContainer(cfg, log, previous)
+	return nil
+}
+
+// verifyReplica verifies a replica like a single container, on the host
+// port it was published on
+func verifyReplica(cfg *config.Config, log *logger.Logger, replica int) error {
+	port, err := replicaPort(cfg, log, replica)
+	if err != nil {
+		return err
+	}
+	return verifyContainer(cfg, log, cfg.ReplicaName(replica), port)
+}
+
+// replicaPort returns the host port a replica is published on, which docker
+// picked for dynamic ports
+func replicaPort(cfg *config.Config, log *logger.Logger, replica int) (string, error) {
+	if port := cfg.ReplicaPort(replica); port != "" {
+		return port, nil
+	}
+
+	name := cfg.ReplicaName(replica)
+	result, err := ssh.Capture(cfg, log, ssh.Command("docker", "port", name, cfg.ContainerPort), fmt.Sprintf("Reading host port of %s", name))
+	if err != nil {
+		return "", fmt.Errorf("failed to read host port of %s: %v", name, err)
+	}
+	if ssh.DryRun() {
+		return "<port>", nil
+	}
+	for _, binding := range strings.Fields(result.Stdout) {
+		if i := strings.LastIndex(binding, ":"); i >= 0 {
+			return binding[i+1:], nil
+		}
+	}
+	return "", fmt.Errorf("%s publishes no host port for container port %s", name, cfg.ContainerPort)
+}
+
+// replicaContainers returns the numbers of the replicas of the app on the
+// host, in order, whatever the configured number of replicas
+func replicaContainers(cfg *config.Config, log *logger.Logger) ([]int, error) {
+	listCmd := ssh.Command("docker", "ps", "-a", "--filter", "name="+cfg.ContainerName+"-", "--format", "{{.Names}}")
+	result, err := ssh.Capture(cfg, log, listCmd, "Listing replicas")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list replicas: %v", err)
+	}
+
+	replicaName := regexp.MustCompile("^" + regexp.QuoteMeta(cfg.ContainerName) + "-([0-9]+)$")
+	var replicas []int
+	for _, line := range strings.Split(result.Stdout, "\n") {
+		if match := replicaName.FindStringSubmatch(strings.TrimSpace(line)); match != nil {
+			replica, _ := strconv.Atoi(match[1])
+			replicas = append(replicas, replica)
+		}
+	}
+	slices.Sort(replicas)
+	return replicas, nil
+}
+
+// collapseReplicas turns the replicas of an earlier deployment back into a
+// single container before it is replaced: the first replica is renamed to
+// the container name and the others are removed
+func collapseReplicas(cfg *config.Config, log *logger.Logger) error {
+	replicas, err := replicaContainers(cfg, log)
+	if err != nil || len(replicas) == 0 {
+		return err
+	}
+
+	exists, err := Exists(cfg, log, cfg.ContainerName)
+	if err != nil {
+		return err
+	}
+	for i, replica := range replicas {
+		name := cfg.ReplicaName(replica)
+		if i > 0 || exists {
+			if err := Remove(cfg, log, name); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := Rename(cfg, log, name, cfg.ContainerName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package docker
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/logger"
+	"github.com/bjarneo/pipe/internal/ssh"
+)
+
+// fromLine matches a FROM instruction of a Dockerfile, capturing the
+// instruction with its flags, the image and the rest of the line
+var fromLine = regexp.MustCompile(`(?i)^(\s*FROM\s+(?:--\S+\s+)*)(\S+)(.*)$`)
+
+// stageName matches the name of a build stage at the end of a FROM line
+var stageName = regexp.MustCompile(`(?i)\s+AS\s+(\S+)\s*$`)
+
+// reproducible returns the configuration of a reproducible build: a copy of
+// the Dockerfile with the base images pinned by digest, and SOURCE_DATE_EPOCH
+// set to the time of the last commit. Base images that cannot be pinned are
+// reported as warnings. Remove the copy of the Dockerfile after the build.
+func reproducible(cfg *config.Config, log *logger.Logger) (*config.Config, error) {
+	dockerfile, err := pinBaseImages(cfg, log)
+	if err != nil {
+		return nil, err
+	}
+
+	epoch := "0"
+	result, err := ssh.ExecuteCommand(cfg.Context(), log, []string{"git", "log", "-1", "--format=%ct"}, "Reading commit time")
+	if err == nil && strings.TrimSpace(result.Stdout) != "" {
+		epoch = strings.TrimSpace(result.Stdout)
+	} else if !ssh.DryRun() {
+		log.Warn("failed to read the time of the last commit, using 0 as SOURCE_DATE_EPOCH")
+	}
+
+	// A SOURCE_DATE_EPOCH build argument of the configuration wins
+	pinned := *cfg
+	pinned.Dockerfile = dockerfile
+	pinned.BuildArgs = map[string]string{"SOURCE_DATE_EPOCH": epoch}
+	maps.Copy(pinned.BuildArgs, cfg.BuildArgs)
+	return &pinned, nil
+}
+
+// pinBaseImages writes a copy of the Dockerfile in which every base image is
+// pinned by the digest it currently resolves to, and returns its path.
+// Images already pinned, earlier build stages and scratch are left alone.
+func pinBaseImages(cfg *config.Config, log *logger.Logger) (string, error) {
+	data, err := os.ReadFile(cfg.Dockerfile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %v", cfg.Dockerfile, err)
+	}
+
+	stages := make(map[string]bool)
+	lines := strings.Split(string(data), "\n")
+	for i, line := range lines {
+		match := fromLine.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		image := match[2]
+		if name := stageName.FindStringSubmatch(match[3]); name != nil {
+			stages[strings.ToLower(name[1])] = true
+		}
+
+		switch {
+		case stages[strings.ToLower(image)], strings.EqualFold(image, "scratch"), strings.Contains(image, "@"):
+			continue
+		case strings.Contains(image, "$"):
+			log.Warn(fmt.Sprintf("base image %s is set by a build argument and cannot be pinned, the build may not be reproducible", image))
+			continue
+		}
+
+		digest, err := imageDigest(cfg, log, image)
+		if err != nil {
+			log.Warn(fmt.Sprintf("failed to pin base image %s, the build may not be reproducible: %v", image, err))
+			continue
+		}
+		if digest != "" {
+			lines[i] = match[1] + image + "@" + digest + match[3]
+		}
+	}
+
+	pinned, err := os.CreateTemp("", "Dockerfile.reproducible-")
+	if err != nil {
+		return "", err
+	}
+	defer pinned.Close()
+	if _, err := pinned.WriteString(strings.Join(lines, "\n")); err != nil {
+		os.Remove(pinned.Name())
+		return "", err
+	}
+	return pinned.Name(), nil
+}
+
+// imageDigest returns the digest an image reference resolves to in its
+// registry. Nothing is resolved in a dry run.
+func imageDigest(cfg *config.Config, log *logger.Logger, image string) (string, error) {
+	result, err := ssh.ExecuteCommand(cfg.Context(), log,
+		[]string{"docker", "buildx", "imagetools", "inspect", "--format", "{{.Manifest.Digest}}", image},
+		fmt.Sprintf("Resolving digest of %s", image))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(result.Stdout), nil
+}
+
+// imageOutput returns the options storing the built image under ref, loaded
+// into docker or, with push, pushed to the registry. Reproducible builds set
+// the file timestamps to SOURCE_DATE_EPOCH and leave out the provenance
+// attestation, which records the build time.
+func imageOutput(cfg *config.Config, ref string, push bool) []string {
+	if !cfg.Reproducible {
+		if push {
+			return []string{"--push", "-t", ref}
+		}
+		return []string{"--load", "-t", ref}
+	}
+
+	output := "type=docker,name=" + ref
+	if push {
+		output = "type=image,push=true,name=" + ref
+	}
+	return []string{"--provenance=false", "--output", output + ",rewrite-timestamp=true"}
+}
@@ -0,0 +1,135 @@
+package docker
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/logger"
+	"github.com/bjarneo/pipe/internal/ssh"
+)
+
+// Component is a package listed in an SBOM
+type Component struct {
+	Name    string
+	Version string
+}
+
+// sbomDocument holds the packages of CycloneDX and SPDX documents
+type sbomDocument struct {
+	Components []struct {
+		Name    string `json:"name"`
+		Version string `json:"version"`
+	} `json:"components"`
+	Packages []struct {
+		Name        string `json:"name"`
+		VersionInfo string `json:"versionInfo"`
+	} `json:"packages"`
+}
+
+// LocalSBOM returns the path the SBOM of the image is generated to on this
+// machine. Services deploying the same image share it.
+func LocalSBOM(cfg *config.Config) string {
+	sum := sha256.Sum256([]byte(cfg.ImageRef()))
+	return filepath.Join(os.TempDir(), "pipe-sbom-"+hex.EncodeToString(sum[:])[:12]+cfg.SBOM.Extension())
+}
+
+// GenerateSBOM generates the SBOM of the image with syft on this machine,
+// to be stored on the hosts once the image is deployed
+func GenerateSBOM(cfg *config.Config, log *logger.Logger) error {
+	if !cfg.SBOM.Enabled {
+		return nil
+	}
+	if err := ssh.LookPath(cfg.Context(), "syft"); err != nil && !ssh.DryRun() {
+		return fmt.Errorf("syft is not installed locally, install it to generate the SBOM or deploy without --sbom")
+	}
+
+	args := []string{"syft", "scan", cfg.ImageRef(), "--quiet", "--output", cfg.SBOM.OutputFormat() + "=" + LocalSBOM(cfg)}
+	if _, err := ssh.ExecuteCommand(cfg.Context(), log, args, fmt.Sprintf("Generating SBOM of %s", cfg.ImageRef())); err != nil {
+		return fmt.Errorf("failed to generate SBOM: %v", err)
+	}
+	return nil
+}
+
+// StoreSBOM copies the SBOM of the image to the state directory of the app
+// on the host, named after the tag
+func StoreSBOM(cfg *config.Config, log *logger.Logger) error {
+	if !cfg.SBOM.Enabled {
+		return nil
+	}
+
+	var input io.Reader
+	if !ssh.DryRun() {
+		file, err := os.Open(LocalSBOM(cfg))
+		if err != nil {
+			return fmt.Errorf("failed to read SBOM: %v", err)
+		}
+		defer file.Close()
+		input = file
+	}
+
+	storeCmd := ssh.Command("mkdir", "-p", cfg.SBOMDir()) + " && cat > " + ssh.Command(cfg.SBOMFile())
+	if _, err := ssh.RunWithInput(cfg, log, storeCmd, "Storing SBOM", input); err != nil {
+		return fmt.Errorf("failed to store SBOM: %v", err)
+	}
+	return nil
+}
+
+// FindInSBOMs returns the components whose name contains the library, by
+// the tag of every SBOM stored on the host that lists one
+func FindInSBOMs(cfg *config.Config, log *logger.Logger, library string) (map[string][]Component, error) {
+	searchCmd := "grep -l -i -F -- " + ssh.Command(library) + " " + ssh.Command(cfg.SBOMDir()) + "/*.json 2>/dev/null || true"
+	result, err := ssh.Capture(cfg, log, searchCmd, "Searching SBOMs")
+	if err != nil {
+		return nil, fmt.Errorf("failed to search SBOMs: %v", err)
+	}
+
+	found := make(map[string][]Component)
+	for _, file := range strings.Fields(result.Stdout) {
+		result, err := ssh.Capture(cfg, log, ssh.Command("cat", file), "Reading SBOM")
+		if err != nil {
+			return nil, fmt.Errorf("failed to read SBOM %s: %v", file, err)
+		}
+		components, err := ParseSBOM([]byte(result.Stdout))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse SBOM %s: %v", file, err)
+		}
+
+		tag := strings.TrimSuffix(strings.TrimSuffix(path.Base(file), ".cdx.json"), ".spdx.json")
+		for _, component := range components {
+			if strings.Contains(strings.ToLower(component.Name), strings.ToLower(library)) {
+				found[tag] = append(found[tag], component)
+			}
+		}
+	}
+	return found, nil
+}
+
+// ParseSBOM returns the components of a CycloneDX or SPDX JSON document,
+// sorted by name
+func ParseSBOM(data []byte) ([]Component, error) {
+	var document sbomDocument
+	if err := json.Unmarshal(data, &document); err != nil {
+		return nil, err
+	}
+
+	var components []Component
+	for _, component := range document.Components {
+		components = append(components, Component{Name: component.Name, Version: component.Version})
+	}
+	for _, pkg := range document.Packages {
+		components = append(components, Component{Name: pkg.Name, Version: pkg.VersionInfo})
+	}
+	sort.Slice(components, func(i, j int) bool {
+		return components[i].Name < components[j].Name
+	})
+	return components, nil
+}
@@ -0,0 +1,102 @@
+package docker
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/logger"
+	"github.com/bjarneo/pipe/internal/ssh"
+)
+
+// Scan scans the image for vulnerabilities with trivy or grype on this
+// machine and fails when it finds any of at least the configured severity
+// that are not accepted in the ignore file
+func Scan(cfg *config.Config, log *logger.Logger) error {
+	scanner := cfg.Scan.Scanner
+	if scanner == "" {
+		return nil
+	}
+	if err := ssh.LookPath(cfg.Context(), scanner); err != nil && !ssh.DryRun() {
+		return fmt.Errorf("%s is not installed locally, install it to scan the image or deploy without --scan", scanner)
+	}
+
+	threshold := cfg.Scan.Threshold()
+	var args []string
+	switch scanner {
+	case config.ScannerTrivy:
+		var severities []string
+		for _, severity := range config.ScanSeverities[slices.Index(config.ScanSeverities, threshold):] {
+			severities = append(severities, strings.ToUpper(severity))
+		}
+		args = []string{"trivy", "image", "--exit-code", "1", "--no-progress", "--severity", strings.Join(severities, ",")}
+		if cfg.Scan.IgnoreFile != "" {
+			args = append(args, "--ignorefile", cfg.Scan.IgnoreFile)
+		}
+		args = append(args, cfg.ImageRef())
+	case config.ScannerGrype:
+		args = []string{"grype", cfg.ImageRef(), "--fail-on", threshold}
+		if cfg.Scan.IgnoreFile != "" {
+			grypeConfig, err := grypeIgnoreConfig(cfg.Scan.IgnoreFile)
+			if err != nil {
+				return fmt.Errorf("failed to read scan ignore file: %v", err)
+			}
+			defer os.Remove(grypeConfig)
+			args = append(args, "--config", grypeConfig)
+		}
+	}
+
+	description := fmt.Sprintf("Scanning %s for vulnerabilities with %s", cfg.ImageRef(), scanner)
+	if _, err := ssh.ExecuteCommand(cfg.Context(), log, args, description); err != nil {
+		return fmt.Errorf("vulnerability scan of %s failed, fix the vulnerabilities of severity %s or higher or accept them with --scan-ignore-file: %v",
+			cfg.ImageRef(), threshold, err)
+	}
+	return log.Info(fmt.Sprintf("No vulnerabilities of severity %s or higher found in %s", threshold, cfg.ImageRef()))
+}
+
+// ignoredVulnerabilities returns the vulnerability IDs listed in an ignore
+// file, skipping empty lines and # comments
+func ignoredVulnerabilities(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		ids = append(ids, strings.Fields(line)[0])
+	}
+	return ids, nil
+}
+
+// grypeIgnoreConfig writes a temporary grype configuration ignoring the
+// vulnerabilities of the ignore file, in the format trivy reads it, and
+// returns its path
+func grypeIgnoreConfig(ignoreFile string) (string, error) {
+	ids, err := ignoredVulnerabilities(ignoreFile)
+	if err != nil {
+		return "", err
+	}
+
+	var grypeConfig strings.Builder
+	grypeConfig.WriteString("ignore:\n")
+	for _, id := range ids {
+		fmt.Fprintf(&grypeConfig, "  - vulnerability: %q\n", id)
+	}
+
+	file, err := os.CreateTemp("", "pipe-grype-*.yaml")
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	if _, err := file.WriteString(grypeConfig.String()); err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+	return file.Name(), nil
+}
@@ -0,0 +1,52 @@
+package docker
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/logger"
+	"github.com/bjarneo/pipe/internal/ssh"
+)
+
+// SidecarLabel is the label holding the hash of a sidecar's configuration,
+// set on every sidecar container pipe runs next to the apps
+const SidecarLabel = "pipe.sidecar.config"
+
+// sidecarHash returns a short hash of the run arguments and configuration
+// file of a sidecar container
+func sidecarHash(configData []byte, args []string) string {
+	sum := sha256.Sum256(append([]byte(strings.Join(args, "\x00")+"\n"), configData...))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// sidecarCurrent reports whether the sidecar container is running with the
+// configuration of the given hash
+func sidecarCurrent(cfg *config.Config, log *logger.Logger, name string, hash string) (bool, error) {
+	inspectCmd := ssh.Command("docker", "inspect", "-f", `{{.State.Running}} {{index .Config.Labels "`+SidecarLabel+`"}}`, name) + " 2>/dev/null || true"
+	result, err := ssh.Capture(cfg, log, inspectCmd, fmt.Sprintf("Checking %s", name))
+	if err != nil {
+		return false, fmt.Errorf("failed to check %s: %v", name, err)
+	}
+	return strings.TrimSpace(result.Stdout) == "true "+hash, nil
+}
+
+// startSidecar replaces the sidecar container with one started from the
+// given docker run arguments, labeled with the hash of its configuration
+func startSidecar(cfg *config.Config, log *logger.Logger, name string, hash string, args []string) error {
+	if err := Remove(cfg, log, name); err != nil {
+		return err
+	}
+
+	runArgs := append([]string{"docker", "run", "-d",
+		"--name", name,
+		"--restart", restartPolicy,
+		"--label", SidecarLabel + "=" + hash,
+	}, args...)
+	if _, err := ssh.Run(cfg, log, ssh.Command(runArgs...), fmt.Sprintf("Starting %s", name)); err != nil {
+		return fmt.Errorf("failed to start %s: %v", name, err)
+	}
+	return nil
+}
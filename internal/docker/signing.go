@@ -0,0 +1,111 @@
+package docker
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/logger"
+	"github.com/bjarneo/pipe/internal/ssh"
+)
+
+// Sign signs the pushed image with cosign on this machine, with the private
+// key of the configuration or keyless. Signatures are stored in the registry
+// next to the image. Nothing is signed when the image is only verified, or
+// when deploying to local targets without the registry.
+func Sign(cfg *config.Config, log *logger.Logger) error {
+	if (cfg.Signing.Key == "" && !cfg.Signing.Keyless()) || cfg.PrebuiltImage != "" || cfg.Target != "" {
+		return nil
+	}
+	if err := ssh.LookPath(cfg.Context(), "cosign"); err != nil && !ssh.DryRun() {
+		return fmt.Errorf("cosign is not installed locally, install it to sign the image")
+	}
+
+	args := []string{"cosign", "sign", "--yes"}
+	if cfg.Signing.Key != "" {
+		args = append(args, "--key", cfg.Signing.Key)
+	}
+	args = append(args, cfg.ImageRef())
+	if _, err := ssh.ExecuteCommand(cfg.Context(), log, args, fmt.Sprintf("Signing image %s", cfg.ImageRef())); err != nil {
+		return fmt.Errorf("failed to sign image: %v", err)
+	}
+	return nil
+}
+
+// VerifySignature verifies the signature of the image on the host with
+// cosign before the container is started from it. The digest the host pulled
+// is verified, rather than whatever the tag points to in the registry now,
+// so an unsigned or tampered image is refused.
+func VerifySignature(cfg *config.Config, log *logger.Logger) error {
+	if !cfg.Signing.Enabled() || cfg.Target != "" {
+		return nil
+	}
+
+	result, err := ssh.Capture(cfg, log, "command -v cosign >/dev/null && echo yes || echo no", "Checking for cosign on server")
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(result.Stdout) != "yes" && !ssh.DryRun() {
+		return fmt.Errorf("cosign is not installed on %s, install it to verify the signature of the image", cfg.Host)
+	}
+
+	digest, err := PulledDigest(cfg, log)
+	if err != nil {
+		return err
+	}
+
+	args := []string{"cosign", "verify"}
+	if cfg.Signing.Keyless() {
+		args = append(args, "--certificate-identity", cfg.Signing.Identity, "--certificate-oidc-issuer", cfg.Signing.Issuer)
+	} else {
+		keyPath, err := uploadPublicKey(cfg, log)
+		if err != nil {
+			return err
+		}
+		args = append(args, "--key", keyPath)
+	}
+	args = append(args, digest)
+
+	if _, err := ssh.Run(cfg, log, ssh.Command(args...)+" >/dev/null", fmt.Sprintf("Verifying signature of %s", digest)); err != nil {
+		return fmt.Errorf("signature of %s could not be verified on %s, refusing to start an unsigned or tampered image: %v",
+			cfg.ImageRef(), cfg.Host, err)
+	}
+	return log.Info(fmt.Sprintf("Signature of %s verified", digest))
+}
+
+// PulledDigest returns the image on the host as repository@digest
+func PulledDigest(cfg *config.Config, log *logger.Logger) (string, error) {
+	inspectCmd := ssh.Command("docker", "image", "inspect", "--format", "{{range .RepoDigests}}{{println .}}{{end}}", cfg.ImageRef())
+	result, err := ssh.Capture(cfg, log, inspectCmd, "Reading image digest")
+	if err != nil {
+		return "", fmt.Errorf("failed to read image digest: %v", err)
+	}
+	if ssh.DryRun() {
+		return cfg.Repository() + "@sha256:<digest>", nil
+	}
+
+	for _, digest := range strings.Fields(result.Stdout) {
+		if strings.HasPrefix(digest, cfg.Repository()+"@") {
+			return digest, nil
+		}
+	}
+	return "", fmt.Errorf("image %s on %s has no registry digest, its signature cannot be verified", cfg.ImageRef(), cfg.Host)
+}
+
+// uploadPublicKey copies the public key verifying the image to the state
+// directory of the app on the host and returns its path there
+func uploadPublicKey(cfg *config.Config, log *logger.Logger) (string, error) {
+	key, err := os.Open(cfg.Signing.PublicKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to read signing public key: %v", err)
+	}
+	defer key.Close()
+
+	keyPath := cfg.StateDir() + "/cosign.pub"
+	uploadCmd := ssh.Command("mkdir", "-p", cfg.StateDir()) + " && cat > " + ssh.Command(keyPath)
+	if _, err := ssh.RunWithInput(cfg, log, uploadCmd, "Uploading signing public key", key); err != nil {
+		return "", fmt.Errorf("failed to upload signing public key: %v", err)
+	}
+	return keyPath, nil
+}
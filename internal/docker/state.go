@@ -0,0 +1,29 @@
+package docker
+
+import (
+	"fmt"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/logger"
+	"github.com/bjarneo/pipe/internal/ssh"
+)
+
+// PrepareState creates the state directory layout on the host and moves the
+// files earlier versions kept elsewhere into it. An environment file that
+// was copied to ~/<envFile> becomes the app's env file, or is moved to the
+// backups directory when the app already has one.
+func PrepareState(cfg *config.Config, log *logger.Logger, envFile string) error {
+	prepareCmd := ssh.Command("mkdir", "-p", cfg.LocksDir(), cfg.BackupsDir())
+	if envFile != "" {
+		legacy := "~/" + envFile
+		prepareCmd += fmt.Sprintf(" && if [ -f %s ]; then if [ -e %s ]; then %s; else %s; fi; fi",
+			ssh.Command(legacy), ssh.Command(cfg.RemoteEnvFile()),
+			ssh.Command("mv", "-f", legacy, cfg.BackupsDir()+"/env"),
+			ssh.Command("mv", legacy, cfg.RemoteEnvFile()))
+	}
+
+	if _, err := ssh.Run(cfg, log, prepareCmd, "Preparing state directory"); err != nil {
+		return fmt.Errorf("failed to prepare state directory %s: %v", cfg.StateDir(), err)
+	}
+	return nil
+}
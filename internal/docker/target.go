@@ -0,0 +1,139 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/logger"
+	"github.com/bjarneo/pipe/internal/ssh"
+)
+
+const (
+	// localTargetImage runs a Docker daemon inside a container
+	localTargetImage = "docker:dind"
+
+	// localTargetTimeout is how long a local target may take to start its
+	// Docker daemon
+	localTargetTimeout = 60 * time.Second
+)
+
+// unsafeNameChars are the characters of a host not allowed in a container name
+var unsafeNameChars = regexp.MustCompile(`[^a-zA-Z0-9_.-]`)
+
+// LocalTarget stands in for the hosts of a deployment with Docker-in-Docker
+// containers on this machine, one per host, started on first use. The
+// commands meant for a host run in its container with docker exec, so the
+// whole pipeline can be tested without touching real servers.
+type LocalTarget struct {
+	cfg        *config.Config
+	log        *logger.Logger
+	mu         sync.Mutex
+	containers map[string]string
+}
+
+// NewLocalTarget returns a local target, which must be closed to remove its
+// containers
+func NewLocalTarget(cfg *config.Config, log *logger.Logger) *LocalTarget {
+	return &LocalTarget{cfg: cfg, log: log, containers: make(map[string]string)}
+}
+
+// Run runs a shell command in the container of the host, from the home
+// directory like an SSH login
+func (t *LocalTarget) Run(ctx context.Context, host string, command string, stdin io.Reader, stdout io.Writer, stderr io.Writer) (int, error) {
+	name, err := t.container(ctx, host)
+	if err != nil {
+		return -1, err
+	}
+
+	args := []string{"exec", "-w", "/root"}
+	if stdin != nil {
+		args = append(args, "-i")
+	}
+	cmd := exec.CommandContext(ctx, "docker", append(args, name, "sh", "-c", command)...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = stdin, stdout, stderr
+
+	err = cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode(), nil
+	}
+	if err != nil {
+		return -1, err
+	}
+	return 0, nil
+}
+
+// Upload writes a file in the container of the host
+func (t *LocalTarget) Upload(ctx context.Context, host string, file string, data io.Reader, mode os.FileMode) error {
+	command := ssh.Command("mkdir", "-p", path.Dir(file)) + " && " + ssh.Command("cat") + " > " + ssh.Command(file)
+	if mode != 0 {
+		command += " && " + ssh.Command("chmod", fmt.Sprintf("%o", mode), file)
+	}
+
+	code, err := t.Run(ctx, host, command, data, io.Discard, io.Discard)
+	if err == nil && code != 0 {
+		err = fmt.Errorf("exit status %d", code)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %v", file, err)
+	}
+	return nil
+}
+
+// Close removes the containers of the local target
+func (t *LocalTarget) Close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	ctx := t.cfg.Detached().Context()
+	for host, name := range t.containers {
+		if _, err := ssh.ExecuteCommand(ctx, t.log, []string{"docker", "rm", "-f", "-v", name},
+			fmt.Sprintf("Removing local target for %s", host)); err != nil {
+			t.log.Warn(fmt.Sprintf("failed to remove local target %s: %v", name, err))
+		}
+		delete(t.containers, host)
+	}
+}
+
+// container returns the container standing in for host, starting it and
+// waiting for its Docker daemon the first time the host is used
+func (t *LocalTarget) container(ctx context.Context, host string) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if name, ok := t.containers[host]; ok {
+		return name, nil
+	}
+
+	name := fmt.Sprintf("pipe-target-%d-%s", os.Getpid(), unsafeNameChars.ReplaceAllString(host, "-"))
+	runArgs := []string{"docker", "run", "-d", "--privileged", "--name", name, "--label", "pipe.target=" + host, localTargetImage}
+	if _, err := ssh.ExecuteCommand(ctx, t.log, runArgs, fmt.Sprintf("Starting local target for %s", host)); err != nil {
+		return "", fmt.Errorf("failed to start local target for %s: %v", host, err)
+	}
+	t.containers[host] = name
+
+	deadline := time.Now().Add(localTargetTimeout)
+	for {
+		if exec.CommandContext(ctx, "docker", "exec", name, "docker", "info").Run() == nil {
+			return name, nil
+		}
+		if time.Now().After(deadline) {
+			return "", fmt.Errorf("docker in local target for %s did not start within %s", host, localTargetTimeout)
+		}
+
+		select {
+		case <-time.After(time.Second):
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package docker
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/logger"
+	"github.com/bjarneo/pipe/internal/ssh"
+)
+
+// updatesConfigPath is the apt configuration written for unattended upgrades
+const updatesConfigPath = "/etc/apt/apt.conf.d/52pipe-unattended-upgrades"
+
+// asRoot runs a command directly as root or through passwordless sudo
+func asRoot(command string) string {
+	return fmt.Sprintf(`if [ "$(id -u)" = 0 ]; then %s; else sudo -n %s; fi`, command, command)
+}
+
+// updatesConfig returns the apt configuration enabling unattended upgrades,
+// rebooting at the given time when one is set
+func updatesConfig(rebootTime string) string {
+	lines := []string{
+		`APT::Periodic::Update-Package-Lists "1";`,
+		`APT::Periodic::Unattended-Upgrade "1";`,
+	}
+	if rebootTime == "" {
+		lines = append(lines, `Unattended-Upgrade::Automatic-Reboot "false";`)
+	} else {
+		lines = append(lines,
+			`Unattended-Upgrade::Automatic-Reboot "true";`,
+			fmt.Sprintf(`Unattended-Upgrade::Automatic-Reboot-Time "%s";`, rebootTime))
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// UpdateProblems returns the reasons unattended upgrades are not set up as
+// configured. Nothing is checked unless unattended updates are enabled.
+func UpdateProblems(cfg *config.Config, log *logger.Logger) ([]string, error) {
+	if !cfg.Updates.Unattended {
+		return nil, nil
+	}
+
+	rebootTime, err := cfg.RebootTime()
+	if err != nil {
+		return nil, err
+	}
+
+	checkCmd := fmt.Sprintf("if ! command -v apt-get >/dev/null; then echo unsupported; "+
+		"elif ! dpkg -s unattended-upgrades >/dev/null 2>&1; then echo missing; "+
+		"else cat %s 2>/dev/null; fi", updatesConfigPath)
+	result, err := ssh.Capture(cfg, log, checkCmd, "Checking unattended upgrades")
+	if err != nil {
+		return nil, fmt.Errorf("failed to check unattended upgrades: %v", err)
+	}
+
+	switch current := strings.TrimSpace(result.Stdout); current {
+	case "unsupported":
+		return []string{"unattended upgrades are only supported on hosts with apt"}, nil
+	case "missing":
+		return []string{"unattended-upgrades is not installed"}, nil
+	case strings.TrimSpace(updatesConfig(rebootTime)):
+		return nil, nil
+	case "":
+		return []string{"unattended upgrades are not configured"}, nil
+	default:
+		return []string{"unattended upgrades configuration is out of date"}, nil
+	}
+}
+
+// ConfigureUpdates installs unattended-upgrades and configures it to install
+// updates daily, rebooting at the host's time in the reboot window when needed
+func ConfigureUpdates(cfg *config.Config, log *logger.Logger) error {
+	if !cfg.Updates.Unattended {
+		return nil
+	}
+
+	rebootTime, err := cfg.RebootTime()
+	if err != nil {
+		return err
+	}
+
+	installCmd := "dpkg -s unattended-upgrades >/dev/null 2>&1 || " +
+		asRoot("env DEBIAN_FRONTEND=noninteractive apt-get install -y unattended-upgrades")
+	if _, err := ssh.Run(cfg, log, installCmd, "Installing unattended-upgrades"); err != nil {
+		return fmt.Errorf("failed to install unattended-upgrades, this needs root or passwordless sudo: %v", err)
+	}
+
+	description := "Configuring unattended upgrades without automatic reboots"
+	if rebootTime != "" {
+		description = fmt.Sprintf("Configuring unattended upgrades with reboots at %s", rebootTime)
+	}
+	writeCmd := asRoot(fmt.Sprintf("tee %s >/dev/null", updatesConfigPath))
+	if _, err := ssh.RunWithInput(cfg, log, writeCmd, description, strings.NewReader(updatesConfig(rebootTime))); err != nil {
+		return fmt.Errorf("failed to write %s: %v", updatesConfigPath, err)
+	}
+
+	return nil
+}
+
+// RebootRequired reports whether updates installed on the host need a reboot,
+// and the packages that asked for it
+func RebootRequired(cfg *config.Config, log *logger.Logger) (bool, []string, error) {
+	checkCmd := "if [ -f /var/run/reboot-required ]; then echo required; cat /var/run/reboot-required.pkgs 2>/dev/null; fi"
+	result, err := ssh.Capture(cfg, log, checkCmd, "Checking if a reboot is required")
+	if err != nil {
+		return false, nil, fmt.Errorf("failed to check if a reboot is required: %v", err)
+	}
+
+	lines := strings.Fields(result.Stdout)
+	if len(lines) == 0 || lines[0] != "required" {
+		return false, nil, nil
+	}
+	return true, lines[1:], nil
+}
@@ -0,0 +1,94 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/logger"
+	"github.com/bjarneo/pipe/internal/ssh"
+)
+
+// WatchLogs follows the logs of a new container for the configured window
+// after it passed its health checks, and fails when it logs a line matching
+// a failure pattern, or stops or restarts in the meantime. This catches apps
+// that crash after starting while docker still reports them as up.
+func WatchLogs(cfg *config.Config, log *logger.Logger, name string) error {
+	window, err := cfg.WatchLogsDuration()
+	if err != nil || window == 0 {
+		return err
+	}
+	patterns, err := cfg.LogFailurePatterns()
+	if err != nil {
+		return err
+	}
+
+	logsCmd := ssh.Command("docker", "logs", "--follow", name) + " 2>&1"
+	description := fmt.Sprintf("Watching logs of %s for %s", name, window)
+	if ssh.DryRun() {
+		_, err := ssh.Run(cfg, log, logsCmd, description)
+		return err
+	}
+
+	restarts, err := restartCount(cfg, log, name)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(cfg.Context(), window)
+	defer cancel()
+
+	var failure string
+	err = ssh.Follow(cfg.WithContext(ctx), log, logsCmd, description, func(line string) {
+		if failure != "" {
+			return
+		}
+		for _, pattern := range patterns {
+			if pattern.MatchString(line) {
+				failure = fmt.Sprintf("container %s logged %q, matching the failure pattern %s", name, strings.TrimSpace(line), pattern)
+				cancel()
+				return
+			}
+		}
+	})
+	if failure != "" {
+		return errors.New(failure)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to watch the logs of %s: %v", name, err)
+	}
+
+	// The logs end early when the container stops, and a crashing container
+	// restarted by its restart policy shows in its restart count
+	inspectCmd := ssh.Command("docker", "inspect", "--format", "{{.State.Status}} {{.RestartCount}}", name)
+	result, err := ssh.Capture(cfg, log, inspectCmd, fmt.Sprintf("Checking %s is still running", name))
+	if err != nil {
+		return err
+	}
+	status, count, _ := strings.Cut(strings.TrimSpace(result.Stdout), " ")
+	if status != "running" {
+		return fmt.Errorf("container %s is %s after watching its logs", name, status)
+	}
+	if n, err := strconv.Atoi(count); err == nil && n > restarts {
+		return fmt.Errorf("container %s restarted %d times while its logs were watched", name, n-restarts)
+	}
+
+	return log.Info(fmt.Sprintf("No failures in the logs of %s within %s", name, window))
+}
+
+// restartCount returns how often docker restarted a container
+func restartCount(cfg *config.Config, log *logger.Logger, name string) (int, error) {
+	inspectCmd := ssh.Command("docker", "inspect", "--format", "{{.RestartCount}}", name)
+	result, err := ssh.Capture(cfg, log, inspectCmd, fmt.Sprintf("Reading restart count of %s", name))
+	if err != nil {
+		return 0, err
+	}
+	count, err := strconv.Atoi(strings.TrimSpace(result.Stdout))
+	if err != nil {
+		return 0, fmt.Errorf("unexpected restart count of %s: %q", name, result.Stdout)
+	}
+	return count, nil
+}
@@ -0,0 +1,243 @@
+package history
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/logger"
+	"github.com/bjarneo/pipe/internal/ssh"
+)
+
+// maxRecords is the number of records kept in the history file of an app on
+// a host, older records are dropped
+const maxRecords = 200
+
+// Record describes a single deployment or rollback on a host. The history of
+// records is the deployment manifest of the app on the host.
+type Record struct {
+	ID              string            `json:"id"`
+	Action          string            `json:"action"`
+	Host            string            `json:"host"`
+	Image           string            `json:"image"`
+	Tag             string            `json:"tag"`
+	ImageRef        string            `json:"imageRef,omitempty"`
+	ImageID         string            `json:"imageId,omitempty"`
+	BuildArgsHash   string            `json:"buildArgsHash,omitempty"`
+	EnvFileChecksum string            `json:"envFileChecksum,omitempty"`
+	ContainerEnv    map[string]string `json:"containerEnv,omitempty"`
+	Deployer        string            `json:"deployer,omitempty"`
+	Timestamp       time.Time         `json:"timestamp"`
+	Duration        time.Duration     `json:"duration"`
+	Status          string            `json:"status"`
+	Error           string            `json:"error,omitempty"`
+	ConfigHash      string            `json:"configHash"`
+	Settings        map[string]string `json:"settings"`
+	Steps           []logger.Step     `json:"steps"`
+}
+
+// NewRecord creates a record for the current run from the logger's transcript
+func NewRecord(cfg *config.Config, log *logger.Logger, action string, runErr error) Record {
+	settings := cfg.Settings()
+	record := Record{
+		ID:              log.Started().Format("20060102-150405"),
+		Action:          action,
+		Host:            cfg.Host,
+		Image:           cfg.Repository(),
+		Tag:             cfg.Tag,
+		ImageRef:        cfg.ImageRef(),
+		BuildArgsHash:   settings["buildArgs"],
+		EnvFileChecksum: EnvFileChecksum(cfg.EnvFilePaths()),
+		Deployer:        Deployer(),
+		Timestamp:       log.Started(),
+		Duration:        time.Since(log.Started()),
+		Status:          "success",
+		ConfigHash:      cfg.Hash(),
+		Settings:        settings,
+		Steps:           log.Transcript(cfg.Host),
+	}
+	if runErr != nil {
+		record.Status = "failed"
+		record.Error = runErr.Error()
+	}
+	return record
+}
+
+// Ref returns the image reference the record deployed
+func (r Record) Ref() string {
+	if r.ImageRef != "" {
+		return r.ImageRef
+	}
+	return fmt.Sprintf("%s:%s", r.Image, r.Tag)
+}
+
+// EnvFileChecksum returns the SHA-256 of the local env files, if there are
+// any, with the local environment variables they refer to filled in
+func EnvFileChecksum(envFiles []string) string {
+	if len(envFiles) == 0 {
+		return ""
+	}
+	hash := sha256.New()
+	for _, envFile := range envFiles {
+		data, err := os.ReadFile(envFile)
+		if err != nil {
+			return ""
+		}
+		if interpolated, err := config.Interpolate(string(data)); err == nil {
+			data = []byte(interpolated)
+		}
+		hash.Write(data)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// EnvHashes returns the variables of a container environment, given as
+// NAME=value, with short hashes of their values so the values are not stored
+// in plain text
+func EnvHashes(env []string) map[string]string {
+	hashes := make(map[string]string, len(env))
+	for _, variable := range env {
+		name, value, _ := strings.Cut(variable, "=")
+		sum := sha256.Sum256([]byte(value))
+		hashes[name] = hex.EncodeToString(sum[:])[:12]
+	}
+	return hashes
+}
+
+// Deployer identifies who ran pipe as user@hostname
+func Deployer() string {
+	name := "unknown"
+	if current, err := user.Current(); err == nil {
+		name = current.Username
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return name
+	}
+	return fmt.Sprintf("%s@%s", name, hostname)
+}
+
+// Append adds a record to the history file on the remote host and drops the
+// records beyond the last maxRecords
+func Append(cfg *config.Config, log *logger.Logger, record Record) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to encode history record: %v", err)
+	}
+
+	file := cfg.HistoryFile()
+	appendCmd := ssh.Command("mkdir", "-p", cfg.StateDir()) + " && cat >> " + ssh.Command(file) +
+		" && " + ssh.Command("tail", "-n", strconv.Itoa(maxRecords), file) + " > " + ssh.Command(file+".tmp") +
+		" && " + ssh.Command("mv", file+".tmp", file)
+	_, err = ssh.RunWithInput(cfg, log, appendCmd, "Recording deployment history",
+		bytes.NewReader(append(data, '\n')))
+	return err
+}
+
+// LastSuccessful returns the most recent successful record for any of the
+// given actions, or nil if there is none
+func LastSuccessful(records []Record, actions ...string) *Record {
+	for i := len(records) - 1; i >= 0; i-- {
+		if slices.Contains(actions, records[i].Action) && records[i].Status == "success" {
+			return &records[i]
+		}
+	}
+	return nil
+}
+
+// ImageID returns the image ID last recorded for a successful deployment or
+// rollback of the image reference, or an empty string if none was recorded
+func ImageID(records []Record, ref string) string {
+	for i := len(records) - 1; i >= 0; i-- {
+		if records[i].Status == "success" && records[i].Ref() == ref && records[i].ImageID != "" {
+			return records[i].ImageID
+		}
+	}
+	return ""
+}
+
+// Previous returns the image deployed before the current image. It steps back
+// from the last successful deployment of the current image to the deployment
+// before it with a different image, or returns "" if there is none.
+func Previous(records []Record, current string) string {
+	deployments := make([]Record, 0, len(records))
+	for _, record := range records {
+		if (record.Action == "deploy" || record.Action == "adopt") && record.Status == "success" {
+			deployments = append(deployments, record)
+		}
+	}
+
+	i := len(deployments) - 1
+	for i >= 0 && deployments[i].Ref() != current {
+		i--
+	}
+	if i < 0 {
+		return ""
+	}
+
+	for ; i >= 0; i-- {
+		if ref := deployments[i].Ref(); ref != current {
+			return ref
+		}
+	}
+	return ""
+}
+
+// Load reads all records from the history file on the remote host, oldest first
+func Load(cfg *config.Config, log *logger.Logger) ([]Record, error) {
+	return load(cfg, log, "")
+}
+
+// LoadRecent reads the last n records from the history file on the remote
+// host, oldest first, or all of them when n is zero
+func LoadRecent(cfg *config.Config, log *logger.Logger, n int) ([]Record, error) {
+	if n <= 0 {
+		return Load(cfg, log)
+	}
+	return load(cfg, log, " | "+ssh.Command("tail", "-n", strconv.Itoa(n)))
+}
+
+// LoadSuccessful reads the last n records of successful runs of the given
+// actions from the history file on the remote host, oldest first, or all of
+// them when n is zero. Only the matching records are read from the host.
+func LoadSuccessful(cfg *config.Config, log *logger.Logger, n int, actions ...string) ([]Record, error) {
+	filter := " | " + ssh.Command("grep", "-F", `"status":"success"`) +
+		" | " + ssh.Command("grep", "-E", `"action":"(`+strings.Join(actions, "|")+`)"`)
+	if n > 0 {
+		filter += " | " + ssh.Command("tail", "-n", strconv.Itoa(n))
+	}
+	return load(cfg, log, filter)
+}
+
+// load reads the records from the history file on the remote host that pass
+// the filter, a shell pipeline appended to reading the file
+func load(cfg *config.Config, log *logger.Logger, filter string) ([]Record, error) {
+	readCmd := ssh.Command("cat", cfg.HistoryFile()) + " 2>/dev/null" + filter + " || true"
+	result, err := ssh.Capture(cfg, log, readCmd, "Reading deployment history")
+	if err != nil {
+		return nil, err
+	}
+
+	var records []Record
+	for _, line := range strings.Split(result.Stdout, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		var record Record
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			return nil, fmt.Errorf("failed to parse history record: %v", err)
+		}
+		records = append(records, record)
+	}
+
+	return records, nil
+}
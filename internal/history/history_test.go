@@ -0,0 +1,145 @@
+package history
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/logger"
+	"github.com/bjarneo/pipe/internal/ssh"
+)
+
+// shellHost runs the remote commands with the local shell, with the home
+// directory in a temporary directory
+type shellHost struct {
+	home string
+}
+
+func (h shellHost) Run(ctx context.Context, host string, command string, stdin io.Reader, stdout io.Writer, stderr io.Writer) (int, error) {
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Env = append(os.Environ(), "HOME="+h.home)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = stdin, stdout, stderr
+	if err := cmd.Run(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return exitErr.ExitCode(), err
+		}
+		return -1, err
+	}
+	return 0, nil
+}
+
+func (h shellHost) Upload(ctx context.Context, host string, path string, data io.Reader, mode os.FileMode) error {
+	return nil
+}
+
+// testHost returns the configuration of an app on a host with an empty
+// history
+func testHost(t *testing.T) *config.Config {
+	t.Helper()
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+
+	cfg := config.Defaults()
+	cfg.Host = "example.com"
+	cfg.ContainerName = "myapp"
+	cfg.Image = "myapp"
+	return cfg.WithContext(ssh.WithExecutor(context.Background(), shellHost{home: t.TempDir()}))
+}
+
+// testLogger returns a logger of a new run that prints nothing
+func testLogger(t *testing.T) *logger.Logger {
+	t.Helper()
+	log, err := logger.New("", logger.Rotation{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	log.SetOutput(io.Discard, io.Discard)
+	return log
+}
+
+// TestAppendAndLoad records several deployments the way a deployment does,
+// reading the history before appending to it, and loads them again
+func TestAppendAndLoad(t *testing.T) {
+	cfg := testHost(t)
+
+	const deployments = 12
+	for i := 0; i < deployments; i++ {
+		log := testLogger(t)
+		if _, err := Load(cfg, log); err != nil {
+			t.Fatalf("failed to load the history before deployment %d: %v", i+1, err)
+		}
+		if err := Append(cfg, log, NewRecord(cfg, log, "deploy", nil)); err != nil {
+			t.Fatalf("failed to record deployment %d: %v", i+1, err)
+		}
+	}
+
+	records, err := Load(cfg, testLogger(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != deployments {
+		t.Fatalf("expected %d records, got %d", deployments, len(records))
+	}
+	for _, record := range records {
+		for _, step := range record.Steps {
+			if strings.Contains(step.Output, `"action"`) {
+				t.Fatalf("step %q of record %s contains earlier records: %s", step.Description, record.ID, step.Output)
+			}
+		}
+	}
+}
+
+// TestAppendKeepsLastRecords checks that only the last records are kept and
+// that the records can be read selectively
+func TestAppendKeepsLastRecords(t *testing.T) {
+	cfg := testHost(t)
+	log := testLogger(t)
+
+	for i := 0; i < maxRecords+5; i++ {
+		record := Record{Action: "deploy", Status: "success", Image: "myapp", Tag: strconv.Itoa(i)}
+		if i%2 == 1 {
+			record.Status = "failed"
+		}
+		if err := Append(cfg, log, record); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	records, err := Load(cfg, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != maxRecords || records[0].Tag != "5" {
+		t.Fatalf("expected the last %d records from tag 5, got %d from tag %s", maxRecords, len(records), records[0].Tag)
+	}
+
+	recent, err := LoadRecent(cfg, log, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recent) != 3 || recent[2].Tag != strconv.Itoa(maxRecords+4) {
+		t.Fatalf("expected the last 3 records, got %+v", recent)
+	}
+
+	successful, err := LoadSuccessful(cfg, log, 1, "deploy", "adopt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(successful) != 1 || successful[0].Tag != strconv.Itoa(maxRecords+4) {
+		t.Fatalf("expected the last successful record, got %+v", successful)
+	}
+
+	none, err := LoadSuccessful(cfg, log, 0, "rollback")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(none) != 0 {
+		t.Fatalf("expected no rollbacks, got %d", len(none))
+	}
+}
@@ -0,0 +1,84 @@
+package history
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/logger"
+	"github.com/bjarneo/pipe/internal/ssh"
+)
+
+// keepJobLogs is the number of job outputs kept on the host
+const keepJobLogs = 50
+
+// Job is a single run of a job on a host. The host records it when the job
+// exits, so a job is recorded even when pipe lost the connection.
+type Job struct {
+	ID       string    `json:"id"`
+	Command  string    `json:"command"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	ExitCode int       `json:"exitCode"`
+}
+
+// JobCommand wraps the shell command of a job so the host writes its output
+// to the job's log in the jobs directory and records its exit code in the
+// job history, and only the latest job logs are kept. The wrapped command
+// exits with the exit code of the job.
+func JobCommand(cfg *config.Config, id string, name string, command string) string {
+	return jobCommand(cfg, "id="+ssh.Quote(id), name, command)
+}
+
+// ScheduledJobCommand wraps the shell command of a scheduled job like
+// JobCommand. Every run of the job is recorded under an ID from the time it
+// started, in UTC.
+func ScheduledJobCommand(cfg *config.Config, name string, command string) string {
+	return jobCommand(cfg, "id=$(date -u +%Y%m%d-%H%M%S)", name, command)
+}
+
+// jobCommand wraps the shell command of a job whose ID is set by the shell
+// assignment assignID
+func jobCommand(cfg *config.Config, assignID string, name string, command string) string {
+	dir := ssh.Command(cfg.JobsDir())
+	logFile := `"$log"`
+
+	// The host fills in the ID, times and exit code of the record
+	suffix, _ := json.Marshal(struct {
+		Command string `json:"command"`
+	}{name})
+	record := strings.TrimSuffix(strings.TrimPrefix(string(suffix), "{"), "}")
+	format := `{"id":"%s",%s,"started":"%s","finished":"%s","exitCode":%s}\n`
+
+	now := "$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+	return fmt.Sprintf("mkdir -p %s && %s && log=%s/\"$id\".log && started=%s && { (%s) 2>&1; echo $? > %s.exit; } | tee %s; "+
+		"code=$(cat %s.exit 2>/dev/null); code=${code:-1}; rm -f %s.exit; printf %s \"$id\" %s \"$started\" \"%s\" \"$code\" >> %s; "+
+		"ls -1t %s/*.log | tail -n +%d | xargs rm -f; exit \"$code\"",
+		dir, assignID, dir, now, command, logFile, logFile, logFile, logFile, ssh.Quote(format),
+		ssh.Quote(record), now, ssh.Command(cfg.JobsHistory()), dir, keepJobLogs+1)
+}
+
+// LoadJobs reads the job history from the remote host, oldest first
+func LoadJobs(cfg *config.Config, log *logger.Logger) ([]Job, error) {
+	readCmd := ssh.Command("cat", cfg.JobsHistory()) + " 2>/dev/null || true"
+	result, err := ssh.Capture(cfg, log, readCmd, "Reading job history")
+	if err != nil {
+		return nil, err
+	}
+
+	var jobs []Job
+	for _, line := range strings.Split(result.Stdout, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		var job Job
+		if err := json.Unmarshal([]byte(line), &job); err != nil {
+			return nil, fmt.Errorf("failed to parse job record: %v", err)
+		}
+		jobs = append(jobs, job)
+	}
+
+	return jobs, nil
+}
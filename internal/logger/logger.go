@@ -1,61 +1,625 @@
 package logger
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
 	"os"
+	"strings"
+	"sync"
 	"time"
+
+	"golang.org/x/term"
 )
 
-// Logger handles logging to both console and file
+// Logger handles logging to both console and file. It is safe for
+// concurrent use, and loggers derived from it share its file, console
+// settings, transcript and lock.
 type Logger struct {
-	file *os.File
+	file       *os.File
+	mu         *sync.Mutex
+	prefix     string
+	host       string
+	service    string
+	app        string
+	step       string
+	quiet      bool
+	settings   *settings
+	transcript *transcript
+	secrets    *secrets
+	group      *group
+}
+
+// Level is the severity of a log message
+type Level int
+
+// Log levels, from the most to the least detailed
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+// String returns the name of the level as written to the log file
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelWarn:
+		return "WARN"
+	case LevelError:
+		return "ERROR"
+	default:
+		return "INFO"
+	}
+}
+
+// settings holds the console options shared by derived loggers
+type settings struct {
+	level         Level
+	json          bool
+	githubActions bool
+	stdout        io.Writer
+	stderr        io.Writer
+
+	// progress is set while a progress line is drawn in place on the
+	// terminal, and lastProgress is when progress was last printed as a line
+	progress     bool
+	lastProgress time.Time
+}
+
+// consoleLine is a message printed to the console in the JSON log format
+type consoleLine struct {
+	Time    string `json:"time"`
+	Level   string `json:"level"`
+	Host    string `json:"host,omitempty"`
+	Service string `json:"service,omitempty"`
+	App     string `json:"app,omitempty"`
+	Step    string `json:"step,omitempty"`
+	Message string `json:"message"`
+	Error   string `json:"error,omitempty"`
+}
+
+// groupInterval is how long a grouped logger holds back console output
+// before printing it as a block
+const groupInterval = 2 * time.Second
+
+// group holds the console output of a grouped logger until it is printed
+type group struct {
+	lines []groupedLine
+	timer *time.Timer
+}
+
+// groupedLine is a line of console output held back by a group
+type groupedLine struct {
+	console io.Writer
+	text    string
+}
+
+// Step is a single executed command recorded in the run transcript
+type Step struct {
+	Host        string        `json:"host,omitempty"`
+	Service     string        `json:"service,omitempty"`
+	Description string        `json:"description"`
+	Command     string        `json:"command"`
+	Duration    time.Duration `json:"duration"`
+	ExitCode    int           `json:"exitCode"`
+	Output      string        `json:"output,omitempty"`
 }
 
-// New creates a new logger instance
-func New(filename string) (*Logger, error) {
-	file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+// Timing is the wall time and outcome of a step of the pipeline, such as
+// the transfer to a host, recorded for the run report
+type Timing struct {
+	Step     string        `json:"step"`
+	Host     string        `json:"host,omitempty"`
+	Service  string        `json:"service,omitempty"`
+	Started  time.Time     `json:"started"`
+	Duration time.Duration `json:"duration"`
+	Status   string        `json:"status"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// transcript collects the steps executed during a run
+type transcript struct {
+	mu      sync.Mutex
+	started time.Time
+	steps   []Step
+	timings []Timing
+}
+
+// secrets holds the values hidden from the console, the log file and the
+// transcript
+type secrets struct {
+	mu       sync.RWMutex
+	values   []string
+	replacer *strings.Replacer
+}
+
+// maskedSecret replaces secret values in everything the logger writes
+const maskedSecret = "****"
+
+// New creates a new logger instance writing to the given file after
+// rotating it. An empty filename disables the log file.
+func New(filename string, rotation Rotation) (*Logger, error) {
+	var file *os.File
+	if filename != "" {
+		if err := rotate(filename, rotation, time.Now()); err != nil {
+			return nil, err
+		}
+
+		var err error
+		file, err = os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &Logger{
+		file:       file,
+		mu:         &sync.Mutex{},
+		settings:   &settings{level: LevelInfo, stdout: os.Stdout, stderr: os.Stderr},
+		transcript: &transcript{started: time.Now().UTC()},
+		secrets:    &secrets{},
+	}, nil
+}
+
+// derive returns a copy of the logger sharing the same file, transcript and
+// console output
+func (l *Logger) derive() *Logger {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	derived := *l
+	return &derived
+}
+
+// WithPrefix returns a logger for a single host sharing the same file and
+// transcript that prefixes every message with the host name
+func (l *Logger) WithPrefix(host string) *Logger {
+	derived := l.derive()
+	derived.prefix = fmt.Sprintf("%s[%s] ", l.prefix, host)
+	derived.host = host
+	return derived
+}
+
+// WithService returns a logger for a single service of a stack sharing the
+// same file and transcript that prefixes every message with the service name
+func (l *Logger) WithService(service string) *Logger {
+	derived := l.derive()
+	derived.prefix = fmt.Sprintf("%s[%s] ", l.prefix, service)
+	derived.service = service
+	return derived
+}
+
+// WithApp returns a logger for an app of the workspace. The app is a field
+// of the JSON console output and the log file, not a prefix, as a run
+// handles a single app.
+func (l *Logger) WithApp(app string) *Logger {
+	derived := l.derive()
+	derived.app = app
+	return derived
+}
+
+// WithStep returns a logger for a step of the pipeline, such as the image
+// transfer. Like the app, the step is a field of the JSON console output
+// and the log file.
+func (l *Logger) WithStep(step string) *Logger {
+	derived := l.derive()
+	derived.step = step
+	return derived
+}
+
+// Grouped returns a logger that holds back its console output and prints it
+// in blocks at most a few seconds apart, so the output of hosts working in
+// parallel does not interleave line by line. JSON lines name their host and
+// are printed at once. Call Flush when the work is done.
+func (l *Logger) Grouped() *Logger {
+	derived := l.derive()
+	derived.group = &group{}
+	return derived
+}
+
+// Flush prints the console output held back by a grouped logger
+func (l *Logger) Flush() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.flush()
+}
+
+// SetLevel sets the least severe level printed to the console by the logger
+// and the loggers derived from it. The log file receives every level.
+func (l *Logger) SetLevel(level Level) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.settings.level = level
+}
+
+// SetOutput sends the console output of the logger and the loggers derived
+// from it to the given writers instead of stdout and stderr
+func (l *Logger) SetOutput(stdout io.Writer, stderr io.Writer) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.settings.stdout = stdout
+	l.settings.stderr = stderr
+}
+
+// SetJSON prints console messages as JSON lines, for CI log ingestion
+func (l *Logger) SetJSON(enabled bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.settings.json = enabled
+}
+
+// SetGitHubActions prints warnings and errors as GitHub Actions annotations
+// and enables log groups, when running in a GitHub Actions workflow
+func (l *Logger) SetGitHubActions(enabled bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.settings.githubActions = enabled
+}
+
+// Group starts a collapsible group of the GitHub Actions log with the title
+// and returns the function ending it. Outside GitHub Actions, and with JSON
+// console output, it does nothing.
+func (l *Logger) Group(title string) func() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.settings.githubActions || l.settings.json {
+		return func() {}
+	}
+	l.emit(l.settings.stdout, "::group::"+workflowEscape(l.Redact(title))+"\n")
+	return func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		l.emit(l.settings.stdout, "::endgroup::\n")
+	}
+}
+
+// SetQuiet stops info messages from being printed to the console and sends
+// errors to stderr, for commands with machine-readable output. Messages are
+// still written to the log file.
+func (l *Logger) SetQuiet(quiet bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.quiet = quiet
+}
+
+// Mask hides the given secret values in all output of the logger and of the
+// loggers derived from it, including the transcript
+func (l *Logger) Mask(values ...string) {
+	l.secrets.mu.Lock()
+	defer l.secrets.mu.Unlock()
+	for _, value := range values {
+		if value != "" {
+			l.secrets.values = append(l.secrets.values, value, maskedSecret)
+		}
+	}
+	l.secrets.replacer = strings.NewReplacer(l.secrets.values...)
+}
+
+// MaskAssignments hides the values of KEY=value assignments, such as the
+// environment variables and build arguments passed to docker, without hiding
+// the same value elsewhere
+func (l *Logger) MaskAssignments(assignments map[string]string) {
+	l.secrets.mu.Lock()
+	defer l.secrets.mu.Unlock()
+	for key, value := range assignments {
+		if value != "" {
+			l.secrets.values = append(l.secrets.values, key+"="+value, key+"="+maskedSecret)
+		}
+	}
+	l.secrets.replacer = strings.NewReplacer(l.secrets.values...)
+}
+
+// Redact replaces the secret values in text
+func (l *Logger) Redact(text string) string {
+	l.secrets.mu.RLock()
+	defer l.secrets.mu.RUnlock()
+	if l.secrets.replacer == nil {
+		return text
+	}
+	return l.secrets.replacer.Replace(text)
+}
+
+// Started returns the time the run started
+func (l *Logger) Started() time.Time {
+	return l.transcript.started
+}
+
+// Record adds an executed step to the run transcript
+func (l *Logger) Record(step Step) {
+	step.Host = l.host
+	step.Service = l.service
+	step.Command = l.Redact(step.Command)
+	step.Output = l.Redact(step.Output)
+	l.transcript.mu.Lock()
+	defer l.transcript.mu.Unlock()
+	l.transcript.steps = append(l.transcript.steps, step)
+}
+
+// Transcript returns the steps recorded so far for the given host and the
+// logger's service, including steps that ran locally
+func (l *Logger) Transcript(host string) []Step {
+	l.transcript.mu.Lock()
+	defer l.transcript.mu.Unlock()
+	var steps []Step
+	for _, step := range l.transcript.steps {
+		if (step.Host == "" || step.Host == host) && step.Service == l.service {
+			steps = append(steps, step)
+		}
+	}
+	return steps
+}
+
+// LastFailure returns the most recent step of the run that failed, if any
+func (l *Logger) LastFailure() (Step, bool) {
+	l.transcript.mu.Lock()
+	defer l.transcript.mu.Unlock()
+	for i := len(l.transcript.steps) - 1; i >= 0; i-- {
+		if l.transcript.steps[i].ExitCode != 0 {
+			return l.transcript.steps[i], true
+		}
+	}
+	return Step{}, false
+}
+
+// Time runs fn as the step of the logger, recording how long it took and
+// whether it failed
+func (l *Logger) Time(fn func() error) error {
+	started := time.Now()
+	err := fn()
+
+	timing := Timing{
+		Step:     l.step,
+		Host:     l.host,
+		Service:  l.service,
+		Started:  started,
+		Duration: time.Since(started),
+		Status:   "success",
+	}
 	if err != nil {
-		return nil, err
+		timing.Status = "failed"
+		timing.Error = l.Redact(err.Error())
+	}
+	l.transcript.mu.Lock()
+	defer l.transcript.mu.Unlock()
+	l.transcript.timings = append(l.transcript.timings, timing)
+	return err
+}
+
+// Timings returns the step timings recorded for steps started since a time,
+// in the order the steps finished
+func (l *Logger) Timings(since time.Time) []Timing {
+	l.transcript.mu.Lock()
+	defer l.transcript.mu.Unlock()
+	var timings []Timing
+	for _, timing := range l.transcript.timings {
+		if !timing.Started.Before(since) {
+			timings = append(timings, timing)
+		}
 	}
-	return &Logger{file: file}, nil
+	return timings
+}
+
+// Debug logs a detailed message, such as an executed command, that is only
+// printed to the console in verbose mode
+func (l *Logger) Debug(message string) error {
+	return l.write(LevelDebug, message, nil)
 }
 
 // Info logs an informational message
 func (l *Logger) Info(message string) error {
-	timestamp := time.Now().UTC().Format(time.RFC3339)
-	logMessage := fmt.Sprintf("[%s] INFO: %s\n", timestamp, message)
-	fmt.Print(message + "\n")
-	_, err := l.file.WriteString(logMessage)
-	return err
+	return l.write(LevelInfo, message, nil)
+}
+
+// Warn logs a warning about something that did not stop the command
+func (l *Logger) Warn(message string) error {
+	return l.write(LevelWarn, message, nil)
 }
 
 // Error logs an error message
 func (l *Logger) Error(message string, err error) error {
+	return l.write(LevelError, message, err)
+}
+
+// Fatal logs a fatal error message and exits the program
+func (l *Logger) Fatal(err error) {
+	l.write(LevelError, err.Error(), nil)
+	l.Close()
+	os.Exit(1)
+}
+
+// Output prints a line of a command's result to the console only, whatever
+// the level
+func (l *Logger) Output(line string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.print(l.settings.stdout, LevelInfo, l.Redact(line), "")
+}
+
+// Stream prints a line of output of a running command to the console only,
+// unless only warnings and errors are shown
+func (l *Logger) Stream(line string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.settings.level <= LevelInfo && !l.quiet {
+		l.print(l.settings.stdout, LevelInfo, l.Redact(line), "")
+	}
+}
+
+// progressInterval is how often progress is printed when the console is not
+// a terminal
+const progressInterval = 10 * time.Second
+
+// Progress shows the progress of a long-running step on the console only,
+// unless only warnings and errors are shown. On a terminal the line is drawn
+// in place, otherwise it is printed as a line of its own every few seconds.
+func (l *Logger) Progress(line string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.settings.level > LevelInfo || l.quiet {
+		return
+	}
+
+	line = l.Redact(line)
+	if !l.settings.json && l.prefix == "" && isTerminal(l.settings.stdout) {
+		fmt.Fprintf(l.settings.stdout, "\r\033[K%s", line)
+		l.settings.progress = true
+		return
+	}
+
+	if time.Since(l.settings.lastProgress) < progressInterval {
+		return
+	}
+	l.settings.lastProgress = time.Now()
+	l.print(l.settings.stdout, LevelInfo, line, "")
+}
+
+// isTerminal reports whether the console writer is a terminal
+func isTerminal(console io.Writer) bool {
+	file, ok := console.(*os.File)
+	return ok && term.IsTerminal(int(file.Fd()))
+}
+
+// write logs a message to the log file, and to the console when its level is
+// shown. In quiet mode only warnings and errors reach the console, on stderr.
+func (l *Logger) write(level Level, message string, err error) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
 	timestamp := time.Now().UTC().Format(time.RFC3339)
-	errStr := ""
+	message = l.Redact(message)
+	details := ""
 	if err != nil {
-		errStr = err.Error()
+		details = l.Redact(err.Error())
+	}
+
+	if level >= l.settings.level && (level >= LevelWarn || !l.quiet) {
+		console := l.settings.stdout
+		if l.quiet {
+			console = l.settings.stderr
+		}
+		l.print(console, level, message, details)
 	}
-	logMessage := fmt.Sprintf("[%s] ERROR: %s\n%s\n", timestamp, message, errStr)
-	fmt.Printf("ERROR: %s\n", message)
+
+	if l.file == nil {
+		return nil
+	}
+	logMessage := fmt.Sprintf("[%s] %s: %s%s%s\n", timestamp, level, l.prefix, message, l.fields())
 	if err != nil {
-		fmt.Printf("Error details: %s\n", err)
+		logMessage += details + "\n"
 	}
 	_, writeErr := l.file.WriteString(logMessage)
 	return writeErr
 }
 
-// Fatal logs a fatal error message and exits the program
-func (l *Logger) Fatal(err error) {
-	timestamp := time.Now().UTC().Format(time.RFC3339)
-	logMessage := fmt.Sprintf("[%s] FATAL: %s\n", timestamp, err.Error())
-	fmt.Printf("FATAL: %s\n", err)
-	l.file.WriteString(logMessage)
-	l.Close()
-	os.Exit(1)
+// fields returns the app and step of the logger as written to the log file
+func (l *Logger) fields() string {
+	var fields string
+	if l.app != "" {
+		fields += " app=" + l.app
+	}
+	if l.step != "" {
+		fields += " step=" + l.step
+	}
+	return fields
+}
+
+// print writes a message to the console as text or as a JSON line
+func (l *Logger) print(console io.Writer, level Level, message string, details string) {
+	if l.settings.json {
+		line, _ := json.Marshal(consoleLine{
+			Time:    time.Now().UTC().Format(time.RFC3339),
+			Level:   strings.ToLower(level.String()),
+			Host:    l.host,
+			Service: l.service,
+			App:     l.app,
+			Step:    l.step,
+			Message: message,
+			Error:   details,
+		})
+		l.emit(console, string(line)+"\n")
+		return
+	}
+
+	var text string
+	switch {
+	case l.settings.githubActions && level == LevelWarn:
+		text = fmt.Sprintf("::warning::%s\n", workflowEscape(l.prefix+message))
+	case l.settings.githubActions && level == LevelError:
+		if details == "" {
+			details = message
+		}
+		title := strings.NewReplacer(":", "%3A", ",", "%2C").Replace(workflowEscape(l.prefix + message))
+		text = fmt.Sprintf("::error title=%s::%s\n", title, workflowEscape(details))
+	case level == LevelWarn:
+		text = fmt.Sprintf("%sWARNING: %s\n", l.prefix, message)
+	case level == LevelError:
+		text = fmt.Sprintf("%sERROR: %s\n", l.prefix, message)
+		if details != "" {
+			text += fmt.Sprintf("%sError details: %s\n", l.prefix, details)
+		}
+	default:
+		text = l.prefix + message + "\n"
+	}
+	l.emit(console, text)
+}
+
+// workflowEscape escapes a value of a GitHub Actions workflow command, which
+// ends at the first line break
+func workflowEscape(value string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(value)
+}
+
+// emit writes text to the console, or holds it back in the group of a
+// grouped logger. The lock must be held.
+func (l *Logger) emit(console io.Writer, text string) {
+	if l.group == nil || l.settings.json {
+		l.endProgress()
+		fmt.Fprint(console, text)
+		return
+	}
+
+	l.group.lines = append(l.group.lines, groupedLine{console: console, text: text})
+	if l.group.timer == nil {
+		l.group.timer = time.AfterFunc(groupInterval, func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			l.flush()
+		})
+	}
+}
+
+// flush prints the lines held back by the group of the logger as one
+// block. The lock must be held.
+func (l *Logger) flush() {
+	if l.group == nil || len(l.group.lines) == 0 {
+		return
+	}
+	l.endProgress()
+	for _, line := range l.group.lines {
+		fmt.Fprint(line.console, line.text)
+	}
+	l.group.lines = nil
+	if l.group.timer != nil {
+		l.group.timer.Stop()
+		l.group.timer = nil
+	}
 }
 
-// Close closes the log file
+// endProgress keeps the next line from overwriting a progress line. The
+// lock must be held.
+func (l *Logger) endProgress() {
+	if l.settings.progress {
+		fmt.Fprintln(l.settings.stdout)
+		l.settings.progress = false
+	}
+}
+
+// Close prints the console output held back by the logger and closes the
+// log file
 func (l *Logger) Close() error {
+	l.Flush()
+	if l.file == nil {
+		return nil
+	}
 	return l.file.Close()
-} 
\ No newline at end of file
+}
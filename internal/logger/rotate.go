@@ -0,0 +1,60 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Rotation limits the size of the log file and how long rotated files are
+// kept. Zero values disable the limit.
+type Rotation struct {
+	MaxSize int64
+	MaxAge  time.Duration
+}
+
+// rotatedTimeFormat is the timestamp added to the name of rotated log files
+const rotatedTimeFormat = "20060102T150405"
+
+// rotate moves the log file aside once it reached the maximum size, naming it
+// after its last write, and removes rotated files older than the maximum age
+func rotate(filename string, rotation Rotation, now time.Time) error {
+	info, err := os.Stat(filename)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	ext := filepath.Ext(filename)
+	base := strings.TrimSuffix(filename, ext)
+
+	if err == nil && rotation.MaxSize > 0 && info.Size() >= rotation.MaxSize {
+		rotated := fmt.Sprintf("%s-%s%s", base, info.ModTime().UTC().Format(rotatedTimeFormat), ext)
+		if err := os.Rename(filename, rotated); err != nil {
+			return fmt.Errorf("failed to rotate log file: %v", err)
+		}
+	}
+
+	if rotation.MaxAge <= 0 {
+		return nil
+	}
+
+	rotated, err := filepath.Glob(base + "-*" + ext)
+	if err != nil {
+		return err
+	}
+	for _, path := range rotated {
+		stamp := strings.TrimSuffix(strings.TrimPrefix(path, base+"-"), ext)
+		rotatedAt, err := time.Parse(rotatedTimeFormat, stamp)
+		if err != nil {
+			continue
+		}
+		if now.Sub(rotatedAt) > rotation.MaxAge {
+			if err := os.Remove(path); err != nil {
+				return fmt.Errorf("failed to remove old log file: %v", err)
+			}
+		}
+	}
+	return nil
+}
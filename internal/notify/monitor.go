@@ -0,0 +1,122 @@
+package notify
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	neturl "net/url"
+	"path"
+	"strings"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/logger"
+)
+
+// healthchecksAPI is the Healthchecks.io API for reading a check by its UUID
+const healthchecksAPI = "https://healthchecks.io/api/v3/checks/"
+
+// Ping tells the uptime monitor that a deployment succeeded. Uptime Kuma push
+// monitors get the message as well.
+func Ping(log *logger.Logger, monitor config.Monitor, message string) error {
+	pingURL, err := resolve(log, monitor.PingURL)
+	if err != nil {
+		return err
+	}
+
+	if strings.Contains(pingURL, "/api/push/") && !strings.Contains(pingURL, "msg=") {
+		separator := "?"
+		if strings.Contains(pingURL, "?") {
+			separator = "&"
+		}
+		pingURL += separator + "status=up&msg=" + neturl.QueryEscape(message)
+	}
+
+	client := &http.Client{Timeout: requestTimeout}
+	response, err := client.Get(pingURL)
+	if err != nil {
+		return requestError(err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode >= 300 {
+		return fmt.Errorf("monitor returned %s", response.Status)
+	}
+	return nil
+}
+
+// MonitorStatus reads the state of the uptime monitor, such as up, down or
+// paused. Healthchecks.io checks are found from the ping URL, other monitors
+// need a status URL returning JSON with a status field.
+func MonitorStatus(log *logger.Logger, monitor config.Monitor) (string, error) {
+	statusURL, err := resolve(log, monitor.StatusURL)
+	if err != nil {
+		return "", err
+	}
+	if statusURL == "" {
+		pingURL, err := resolve(log, monitor.PingURL)
+		if err != nil {
+			return "", err
+		}
+		if !strings.Contains(pingURL, "hc-ping.com/") {
+			return "", fmt.Errorf("set monitor.statusUrl to read the state of this monitor")
+		}
+		statusURL = healthchecksAPI + path.Base(pingURL)
+	}
+
+	apiKey, err := resolve(log, monitor.APIKey)
+	if err != nil {
+		return "", err
+	}
+
+	request, err := http.NewRequest(http.MethodGet, statusURL, nil)
+	if err != nil {
+		return "", err
+	}
+	if apiKey != "" {
+		request.Header.Set("X-Api-Key", apiKey)
+		request.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+
+	client := &http.Client{Timeout: requestTimeout}
+	response, err := client.Do(request)
+	if err != nil {
+		return "", requestError(err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode >= 300 {
+		return "", fmt.Errorf("monitor returned %s", response.Status)
+	}
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return "", err
+	}
+	return monitorState(body)
+}
+
+// monitorState finds the status in a monitor API response, either at the top
+// level (Healthchecks.io) or under data.attributes (Better Stack)
+func monitorState(body []byte) (string, error) {
+	var response struct {
+		Status string `json:"status"`
+		Data   struct {
+			Attributes struct {
+				Status string `json:"status"`
+			} `json:"attributes"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return "", fmt.Errorf("failed to parse monitor status: %v", err)
+	}
+
+	switch {
+	case response.Status != "":
+		return response.Status, nil
+	case response.Data.Attributes.Status != "":
+		return response.Data.Attributes.Status, nil
+	default:
+		return "", fmt.Errorf("monitor status response has no status field")
+	}
+}
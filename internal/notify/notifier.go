@@ -0,0 +1,50 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/bjarneo/pipe/internal/logger"
+)
+
+// Notifier receives the events of deployments and rollbacks, for chat and
+// incident tools without a built-in webhook format
+type Notifier interface {
+	// Name identifies the notifier in warnings
+	Name() string
+
+	// Notify handles an event. Like failing webhooks, a failing notifier is
+	// reported as a warning and never fails the deployment.
+	Notify(event Event) error
+}
+
+// notifiersKey is the context key of the notifiers programs embedding pipe
+// registered
+type notifiersKey struct{}
+
+// WithNotifiers returns a context passing the events of the deployments and
+// rollbacks run with it to the given notifiers
+func WithNotifiers(ctx context.Context, registered []Notifier) context.Context {
+	return context.WithValue(ctx, notifiersKey{}, registered)
+}
+
+// notifiersOf returns the notifiers registered in a context
+func notifiersOf(ctx context.Context) []Notifier {
+	registered, _ := ctx.Value(notifiersKey{}).([]Notifier)
+	return registered
+}
+
+// Registered reports whether any notifiers are registered in the context
+func Registered(ctx context.Context) bool {
+	return len(notifiersOf(ctx)) > 0
+}
+
+// notifyRegistered passes the event to every notifier registered in the
+// context
+func notifyRegistered(ctx context.Context, log *logger.Logger, event Event) {
+	for _, notifier := range notifiersOf(ctx) {
+		if err := notifier.Notify(event); err != nil {
+			log.Warn(fmt.Sprintf("failed to send %s notification to %s: %v", event.Status, notifier.Name(), err))
+		}
+	}
+}
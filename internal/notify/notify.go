@@ -0,0 +1,189 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	neturl "net/url"
+	"strings"
+	"time"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/logger"
+	"github.com/bjarneo/pipe/internal/secrets"
+)
+
+// Event statuses
+const (
+	Started   = "started"
+	Succeeded = "succeeded"
+	Failed    = "failed"
+)
+
+// requestTimeout bounds how long a webhook may delay a deployment
+const requestTimeout = 10 * time.Second
+
+// discordLimit is the maximum length of a Discord message
+const discordLimit = 2000
+
+// Event describes a deployment or rollback. It is the payload of generic
+// webhooks and the data of payload templates. The ID matches the ID of the
+// run in the deployment history of the hosts.
+type Event struct {
+	ID       string    `json:"id"`
+	Action   string    `json:"action"`
+	Status   string    `json:"status"`
+	Deployer string    `json:"deployer"`
+	Apps     []string  `json:"apps"`
+	Hosts    []string  `json:"hosts"`
+	Started  time.Time `json:"started"`
+	Duration string    `json:"duration,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	Excerpt  string    `json:"excerpt,omitempty"`
+}
+
+// verbs holds the wording of each action for every status
+var verbs = map[string]map[string]string{
+	"deployment": {Started: "🚀 %s started deploying", Succeeded: "✅ %s deployed", Failed: "❌ %s failed to deploy"},
+	"rollback":   {Started: "⏪ %s started rolling back", Succeeded: "🔄 %s rolled back", Failed: "❌ %s failed to roll back"},
+}
+
+// Message returns the event as a chat message
+func (e Event) Message() string {
+	message := fmt.Sprintf(verbs[e.Action][e.Status], e.Deployer)
+	message += fmt.Sprintf(" %s to %s", strings.Join(e.Apps, ", "), strings.Join(e.Hosts, ", "))
+	switch e.Status {
+	case Succeeded:
+		message += " in " + e.Duration
+	case Failed:
+		message += fmt.Sprintf(" after %s: %s", e.Duration, e.Error)
+		if e.Excerpt != "" {
+			message += "\n```\n" + e.Excerpt + "\n```"
+		}
+	}
+	return message
+}
+
+// Send posts the event to every configured notification and passes it to the
+// notifiers registered in ctx. Failing webhooks are reported as warnings and
+// never fail the deployment.
+func Send(ctx context.Context, log *logger.Logger, notifications []config.Notification, event Event) {
+	client := &http.Client{Timeout: requestTimeout}
+	for _, notification := range notifications {
+		if !notification.Wants(event.Status) {
+			continue
+		}
+		if err := send(client, log, notification, event); err != nil {
+			log.Warn(fmt.Sprintf("failed to send %s notification: %v", event.Status, err))
+		}
+	}
+	notifyRegistered(ctx, log, event)
+}
+
+// send posts the event to a single webhook in the format of its type
+func send(client *http.Client, log *logger.Logger, notification config.Notification, event Event) error {
+	url, err := resolve(log, notification.URL)
+	if err != nil {
+		return err
+	}
+
+	body, err := payload(notification, url, event)
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequest(notification.RequestMethod(), url, bytes.NewReader(body))
+	if err != nil {
+		return requestError(err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+	for name, value := range notification.Headers {
+		value, err := resolve(log, value)
+		if err != nil {
+			return err
+		}
+		request.Header.Set(name, value)
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		return requestError(err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", response.Status)
+	}
+	return nil
+}
+
+// payload returns the JSON body of the request, rendered from the template
+// of the notification or in the format of its type
+func payload(notification config.Notification, url string, event Event) ([]byte, error) {
+	tmpl, err := notification.ParseTemplate()
+	if err != nil {
+		return nil, err
+	}
+	if tmpl != nil {
+		var body bytes.Buffer
+		if err := tmpl.Execute(&body, event); err != nil {
+			return nil, fmt.Errorf("failed to render notification template: %v", err)
+		}
+		if !json.Valid(body.Bytes()) {
+			return nil, fmt.Errorf("notification template did not render valid JSON: %s", body.String())
+		}
+		return body.Bytes(), nil
+	}
+
+	switch notificationType(notification, url) {
+	case config.NotifySlack:
+		return json.Marshal(map[string]string{"text": event.Message()})
+	case config.NotifyDiscord:
+		message := event.Message()
+		if len(message) > discordLimit {
+			message = message[:discordLimit]
+		}
+		return json.Marshal(map[string]string{"content": message})
+	default:
+		return json.Marshal(event)
+	}
+}
+
+// resolve returns the value of a URL or key that may be a secret reference,
+// masking resolved secrets in the log
+func resolve(log *logger.Logger, value string) (string, error) {
+	if !secrets.IsReference(value) {
+		return value, nil
+	}
+	resolved, err := secrets.Resolve(value)
+	if err != nil {
+		return "", err
+	}
+	log.Mask(resolved)
+	return resolved, nil
+}
+
+// requestError strips the URL from a failed request, as webhook and monitor
+// URLs hold credentials
+func requestError(err error) error {
+	if urlErr, ok := err.(*neturl.Error); ok {
+		return urlErr.Err
+	}
+	return err
+}
+
+// notificationType returns the configured type, or detects it from the URL
+func notificationType(notification config.Notification, url string) string {
+	switch {
+	case notification.Type != "":
+		return notification.Type
+	case strings.Contains(url, "hooks.slack.com"):
+		return config.NotifySlack
+	case strings.Contains(url, "discord.com/api/webhooks"), strings.Contains(url, "discordapp.com/api/webhooks"):
+		return config.NotifyDiscord
+	default:
+		return config.NotifyWebhook
+	}
+}
@@ -0,0 +1,57 @@
+package notify
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/logger"
+)
+
+// sbomContentTypes are the media types of the SBOM formats
+var sbomContentTypes = map[string]string{
+	config.SBOMCycloneDX: "application/vnd.cyclonedx+json",
+	config.SBOMSPDX:      "application/spdx+json",
+}
+
+// UploadSBOM posts the SBOM at path to the artifact endpoint of the
+// configuration, with the image reference in the X-Image-Ref header
+func UploadSBOM(cfg *config.Config, log *logger.Logger, path string) error {
+	url, err := resolve(log, cfg.SBOM.Upload)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to read SBOM: %v", err)
+	}
+	defer file.Close()
+
+	request, err := http.NewRequest(http.MethodPost, url, file)
+	if err != nil {
+		return requestError(err)
+	}
+	request.Header.Set("Content-Type", sbomContentTypes[cfg.SBOM.OutputFormat()])
+	request.Header.Set("X-Image-Ref", cfg.ImageRef())
+	for name, value := range cfg.SBOM.Headers {
+		value, err := resolve(log, value)
+		if err != nil {
+			return err
+		}
+		request.Header.Set(name, value)
+	}
+
+	client := &http.Client{Timeout: requestTimeout}
+	response, err := client.Do(request)
+	if err != nil {
+		return requestError(err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode >= 300 {
+		return fmt.Errorf("SBOM endpoint returned %s", response.Status)
+	}
+	return nil
+}
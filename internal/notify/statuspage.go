@@ -0,0 +1,212 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/logger"
+)
+
+// API base URLs of the hosted status pages
+const (
+	statuspageAPI = "https://api.statuspage.io/v1"
+	instatusAPI   = "https://api.instatus.com/v1"
+)
+
+// incidentPrefix starts the name of incidents pipe opens, so they are the
+// only ones it resolves
+const incidentPrefix = "pipe: "
+
+// Component states, as sent to self-hosted status pages
+const (
+	componentMaintenance = "maintenance"
+	componentOperational = "operational"
+	componentOutage      = "outage"
+)
+
+// componentStates maps the component states to the values of each API
+var componentStates = map[string]map[string]string{
+	config.StatusPageStatuspage: {componentMaintenance: "under_maintenance", componentOperational: "operational", componentOutage: "partial_outage"},
+	config.StatusPageInstatus:   {componentMaintenance: "UNDERMAINTENANCE", componentOperational: "OPERATIONAL", componentOutage: "PARTIALOUTAGE"},
+}
+
+// statusPageClient talks to the API of a status page
+type statusPageClient struct {
+	client *http.Client
+	page   config.StatusPage
+	apiKey string
+}
+
+// UpdateStatusPage moves the status page component along with a deployment
+// or rollback: under maintenance when it starts, operational when it
+// succeeds and an outage with an incident when it fails. Incidents pipe
+// opened earlier are resolved by the next success.
+func UpdateStatusPage(log *logger.Logger, page config.StatusPage, event Event) error {
+	apiKey, err := resolve(log, page.APIKey)
+	if err != nil {
+		return err
+	}
+	c := statusPageClient{client: &http.Client{Timeout: requestTimeout}, page: page, apiKey: apiKey}
+
+	state := map[string]string{Started: componentMaintenance, Succeeded: componentOperational, Failed: componentOutage}[event.Status]
+	if page.Type == config.StatusPageWebhook {
+		url, err := resolve(log, page.URL)
+		if err != nil {
+			return err
+		}
+		return c.request(http.MethodPost, url, map[string]any{"component": page.ComponentID, "status": state, "event": event}, nil)
+	}
+
+	switch event.Status {
+	case Failed:
+		return c.openIncident(event)
+	case Succeeded:
+		if err := c.resolveIncidents(event); err != nil {
+			return err
+		}
+	}
+	return c.setComponent(state)
+}
+
+// incidentName returns the name of the incident opened for a failed event
+func incidentName(event Event) string {
+	return fmt.Sprintf("%s%s of %s failed", incidentPrefix, event.Action, strings.Join(event.Apps, ", "))
+}
+
+// setComponent sets the state of the component
+func (c statusPageClient) setComponent(state string) error {
+	status := componentStates[c.page.Type][state]
+	if c.page.Type == config.StatusPageInstatus {
+		return c.request(http.MethodPut, fmt.Sprintf("%s/%s/components/%s", instatusAPI, c.page.PageID, c.page.ComponentID),
+			map[string]any{"status": status}, nil)
+	}
+	return c.request(http.MethodPatch, fmt.Sprintf("%s/pages/%s/components/%s", statuspageAPI, c.page.PageID, c.page.ComponentID),
+		map[string]any{"component": map[string]string{"status": status}}, nil)
+}
+
+// openIncident opens an incident for the failed event, marking the
+// component as an outage
+func (c statusPageClient) openIncident(event Event) error {
+	status := componentStates[c.page.Type][componentOutage]
+	message := fmt.Sprintf("%s failed on %s: %s", event.Action, strings.Join(event.Hosts, ", "), event.Error)
+	if c.page.Type == config.StatusPageInstatus {
+		return c.request(http.MethodPost, fmt.Sprintf("%s/%s/incidents", instatusAPI, c.page.PageID), map[string]any{
+			"name":       incidentName(event),
+			"message":    message,
+			"components": []string{c.page.ComponentID},
+			"statuses":   []map[string]string{{"id": c.page.ComponentID, "status": status}},
+			"started":    time.Now().UTC().Format(time.RFC3339),
+			"status":     "INVESTIGATING",
+			"notify":     true,
+		}, nil)
+	}
+	return c.request(http.MethodPost, fmt.Sprintf("%s/pages/%s/incidents", statuspageAPI, c.page.PageID), map[string]any{
+		"incident": map[string]any{
+			"name":          incidentName(event),
+			"status":        "investigating",
+			"body":          message,
+			"component_ids": []string{c.page.ComponentID},
+			"components":    map[string]string{c.page.ComponentID: status},
+		},
+	}, nil)
+}
+
+// resolveIncidents resolves the open incidents pipe opened for earlier
+// failures
+func (c statusPageClient) resolveIncidents(event Event) error {
+	message := fmt.Sprintf("%s of %s succeeded", event.Action, strings.Join(event.Apps, ", "))
+	operational := componentStates[c.page.Type][componentOperational]
+
+	var incidents []struct {
+		ID     string `json:"id"`
+		Name   string `json:"name"`
+		Status string `json:"status"`
+	}
+	if c.page.Type == config.StatusPageInstatus {
+		if err := c.request(http.MethodGet, fmt.Sprintf("%s/%s/incidents", instatusAPI, c.page.PageID), nil, &incidents); err != nil {
+			return err
+		}
+		for _, incident := range incidents {
+			if !strings.HasPrefix(incident.Name, incidentPrefix) || incident.Status == "RESOLVED" {
+				continue
+			}
+			if err := c.request(http.MethodPost, fmt.Sprintf("%s/%s/incidents/%s/incident-updates", instatusAPI, c.page.PageID, incident.ID), map[string]any{
+				"message":    message,
+				"components": []string{c.page.ComponentID},
+				"statuses":   []map[string]string{{"id": c.page.ComponentID, "status": operational}},
+				"started":    time.Now().UTC().Format(time.RFC3339),
+				"status":     "RESOLVED",
+				"notify":     true,
+			}, nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := c.request(http.MethodGet, fmt.Sprintf("%s/pages/%s/incidents/unresolved", statuspageAPI, c.page.PageID), nil, &incidents); err != nil {
+		return err
+	}
+	for _, incident := range incidents {
+		if !strings.HasPrefix(incident.Name, incidentPrefix) {
+			continue
+		}
+		if err := c.request(http.MethodPatch, fmt.Sprintf("%s/pages/%s/incidents/%s", statuspageAPI, c.page.PageID, incident.ID), map[string]any{
+			"incident": map[string]any{
+				"status":     "resolved",
+				"body":       message,
+				"components": map[string]string{c.page.ComponentID: operational},
+			},
+		}, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// request sends a JSON request to the status page API and decodes the
+// response into result, unless it is nil
+func (c statusPageClient) request(method string, url string, body any, result any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	request, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		return requestError(err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if c.apiKey != "" {
+		switch c.page.Type {
+		case config.StatusPageStatuspage:
+			request.Header.Set("Authorization", "OAuth "+c.apiKey)
+		default:
+			request.Header.Set("Authorization", "Bearer "+c.apiKey)
+		}
+	}
+
+	response, err := c.client.Do(request)
+	if err != nil {
+		return requestError(err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode >= 300 {
+		return fmt.Errorf("status page returned %s", response.Status)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(response.Body).Decode(result)
+}
@@ -0,0 +1,302 @@
+package notify
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/logger"
+)
+
+// Run is a finished deployment or rollback with the timing of every step,
+// exported as metrics and a trace
+type Run struct {
+	Action   string
+	Status   string
+	Error    string
+	Started  time.Time
+	Duration time.Duration
+	Steps    []logger.Timing
+}
+
+// ExportTelemetry exports the run to the pushgateway, StatsD and the OTLP
+// endpoint of the configuration. Failing exports are reported as warnings
+// and never fail the run.
+func ExportTelemetry(log *logger.Logger, telemetry config.Telemetry, run Run) {
+	if telemetry.Pushgateway != "" {
+		if err := pushMetrics(log, telemetry, run); err != nil {
+			log.Warn(fmt.Sprintf("failed to push metrics: %v", err))
+		}
+	}
+	if telemetry.StatsD != "" {
+		if err := sendStatsD(telemetry, run); err != nil {
+			log.Warn(fmt.Sprintf("failed to send StatsD metrics: %v", err))
+		}
+	}
+	if telemetry.OTLP != "" {
+		if err := exportTrace(log, telemetry, run); err != nil {
+			log.Warn(fmt.Sprintf("failed to export trace: %v", err))
+		}
+	}
+}
+
+// stepKey identifies a step on a host of a service
+type stepKey struct {
+	step, host, service string
+}
+
+// stepTotals sums the timings of each step per host and service, failed
+// when any run of it failed, sorted by step, service and host
+func stepTotals(steps []logger.Timing) ([]stepKey, map[stepKey]logger.Timing) {
+	var keys []stepKey
+	totals := make(map[stepKey]logger.Timing)
+	for _, step := range steps {
+		key := stepKey{step.Step, step.Host, step.Service}
+		total, ok := totals[key]
+		if !ok {
+			keys = append(keys, key)
+			total = step
+		} else {
+			total.Duration += step.Duration
+			if step.Status != "success" {
+				total.Status = step.Status
+			}
+		}
+		totals[key] = total
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.step != b.step {
+			return a.step < b.step
+		}
+		if a.service != b.service {
+			return a.service < b.service
+		}
+		return a.host < b.host
+	})
+	return keys, totals
+}
+
+// success returns 1 for a succeeded status and 0 otherwise
+func success(status string) int {
+	if status == Succeeded || status == "success" {
+		return 1
+	}
+	return 0
+}
+
+// pushMetrics replaces the metrics of the action in the pushgateway with
+// the duration and outcome of the run and of each of its steps
+func pushMetrics(log *logger.Logger, telemetry config.Telemetry, run Run) error {
+	var body strings.Builder
+	action := fmt.Sprintf("action=%q", run.Action)
+	body.WriteString("# TYPE pipe_run_duration_seconds gauge\n")
+	fmt.Fprintf(&body, "pipe_run_duration_seconds{%s} %g\n", action, run.Duration.Seconds())
+	body.WriteString("# TYPE pipe_run_success gauge\n")
+	fmt.Fprintf(&body, "pipe_run_success{%s} %d\n", action, success(run.Status))
+	body.WriteString("# TYPE pipe_run_timestamp_seconds gauge\n")
+	fmt.Fprintf(&body, "pipe_run_timestamp_seconds{%s} %d\n", action, run.Started.Unix())
+
+	keys, totals := stepTotals(run.Steps)
+	body.WriteString("# TYPE pipe_step_duration_seconds gauge\n")
+	for _, key := range keys {
+		fmt.Fprintf(&body, "pipe_step_duration_seconds{%s,step=%q,host=%q,service=%q} %g\n",
+			action, key.step, key.host, key.service, totals[key].Duration.Seconds())
+	}
+	body.WriteString("# TYPE pipe_step_success gauge\n")
+	for _, key := range keys {
+		fmt.Fprintf(&body, "pipe_step_success{%s,step=%q,host=%q,service=%q} %d\n",
+			action, key.step, key.host, key.service, success(totals[key].Status))
+	}
+
+	url, err := resolve(log, telemetry.Pushgateway)
+	if err != nil {
+		return err
+	}
+	url = fmt.Sprintf("%s/metrics/job/%s/action/%s", strings.TrimSuffix(url, "/"), telemetry.JobName(), run.Action)
+	return post(log, telemetry, http.MethodPut, url, "text/plain; version=0.0.4", []byte(body.String()))
+}
+
+// sendStatsD sends the duration of the run and of each step as timers and
+// its outcome as a counter to StatsD
+func sendStatsD(telemetry config.Telemetry, run Run) error {
+	conn, err := net.DialTimeout("udp", telemetry.StatsD, requestTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	prefix := telemetry.JobName() + "." + run.Action
+	outcome := "success"
+	if success(run.Status) == 0 {
+		outcome = "failure"
+	}
+	lines := []string{
+		fmt.Sprintf("%s.duration:%d|ms", prefix, run.Duration.Milliseconds()),
+		fmt.Sprintf("%s.%s:1|c", prefix, outcome),
+	}
+	for _, step := range run.Steps {
+		lines = append(lines, fmt.Sprintf("%s.step.%s.duration:%d|ms", prefix, step.Step, step.Duration.Milliseconds()))
+		if success(step.Status) == 0 {
+			lines = append(lines, fmt.Sprintf("%s.step.%s.failure:1|c", prefix, step.Step))
+		}
+	}
+
+	// Send one metric per packet, as a packet must fit the MTU
+	for _, line := range lines {
+		if _, err := conn.Write([]byte(line)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// otlpSpan is a span of an OTLP/HTTP JSON trace export
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+// otlpAttribute is a string attribute of a span or resource
+type otlpAttribute struct {
+	Key   string `json:"key"`
+	Value struct {
+		StringValue string `json:"stringValue"`
+	} `json:"value"`
+}
+
+// otlpStatus is the outcome of a span
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+// OTLP span kind and status codes
+const (
+	otlpKindInternal = 1
+	otlpStatusOK     = 1
+	otlpStatusError  = 2
+)
+
+// exportTrace exports the run as a trace to the OTLP/HTTP endpoint, with a
+// span for the run and a child span for every step
+func exportTrace(log *logger.Logger, telemetry config.Telemetry, run Run) error {
+	traceID := randomID(16)
+	root := newSpan(traceID, "", run.Action, run.Started, run.Duration, run.Status, run.Error)
+	spans := []otlpSpan{root}
+	for _, step := range run.Steps {
+		span := newSpan(traceID, root.SpanID, step.Step, step.Started, step.Duration, step.Status, step.Error)
+		span.Attributes = attributes(map[string]string{"host": step.Host, "service": step.Service})
+		spans = append(spans, span)
+	}
+
+	body, err := json.Marshal(map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{
+				"attributes": attributes(map[string]string{"service.name": telemetry.JobName()}),
+			},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]string{"name": "pipe"},
+				"spans": spans,
+			}},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+
+	url, err := resolve(log, telemetry.OTLP)
+	if err != nil {
+		return err
+	}
+	if !strings.HasSuffix(url, "/v1/traces") {
+		url = strings.TrimSuffix(url, "/") + "/v1/traces"
+	}
+	return post(log, telemetry, http.MethodPost, url, "application/json", body)
+}
+
+// newSpan returns a span of the trace ending after the duration
+func newSpan(traceID string, parentID string, name string, started time.Time, duration time.Duration, status string, message string) otlpSpan {
+	span := otlpSpan{
+		TraceID:           traceID,
+		SpanID:            randomID(8),
+		ParentSpanID:      parentID,
+		Name:              name,
+		Kind:              otlpKindInternal,
+		StartTimeUnixNano: strconv.FormatInt(started.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(started.Add(duration).UnixNano(), 10),
+		Status:            otlpStatus{Code: otlpStatusOK},
+	}
+	if success(status) == 0 {
+		span.Status = otlpStatus{Code: otlpStatusError, Message: message}
+	}
+	return span
+}
+
+// attributes returns the non-empty values as span attributes, sorted by key
+func attributes(values map[string]string) []otlpAttribute {
+	var attrs []otlpAttribute
+	for key, value := range values {
+		if value == "" {
+			continue
+		}
+		attr := otlpAttribute{Key: key}
+		attr.Value.StringValue = value
+		attrs = append(attrs, attr)
+	}
+	sort.Slice(attrs, func(i, j int) bool {
+		return attrs[i].Key < attrs[j].Key
+	})
+	return attrs
+}
+
+// randomID returns a random trace or span ID of n bytes in hex
+func randomID(n int) string {
+	id := make([]byte, n)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// post sends the body to a telemetry endpoint with the configured headers
+func post(log *logger.Logger, telemetry config.Telemetry, method string, url string, contentType string, body []byte) error {
+	request, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return requestError(err)
+	}
+	request.Header.Set("Content-Type", contentType)
+	for name, value := range telemetry.Headers {
+		value, err := resolve(log, value)
+		if err != nil {
+			return err
+		}
+		request.Header.Set(name, value)
+	}
+
+	client := &http.Client{Timeout: requestTimeout}
+	response, err := client.Do(request)
+	if err != nil {
+		return requestError(err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode >= 300 {
+		return fmt.Errorf("endpoint returned %s", response.Status)
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package secrets
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// Provider resolves a secret reference, without its prefix, to its value
+type Provider func(ref string) (string, error)
+
+// providers maps reference prefixes to the provider resolving them
+var providers = map[string]Provider{
+	"vault:":  vault,
+	"op://":   onePassword,
+	"aws-sm:": awsSecretsManager,
+}
+
+// Register adds a provider for references starting with prefix
+func Register(prefix string, provider Provider) {
+	providers[prefix] = provider
+}
+
+// Resolver resolves secret references of a secret manager without a
+// built-in provider, such as an internal secret service
+type Resolver interface {
+	// Resolve returns the value of a reference, without its prefix
+	Resolve(ref string) (string, error)
+}
+
+// RegisterResolver adds a resolver for references starting with prefix,
+// replacing the provider of the same prefix
+func RegisterResolver(prefix string, resolver Resolver) error {
+	if prefix == "" {
+		return fmt.Errorf("a secret resolver needs a prefix, such as secrets://")
+	}
+	Register(prefix, resolver.Resolve)
+	return nil
+}
+
+// IsReference reports whether a config value refers to an external secret
+func IsReference(value string) bool {
+	_, _, ok := lookup(value)
+	return ok
+}
+
+// Resolve returns the value of a secret reference such as
+// vault:kv/app#API_KEY, op://vault/item/field or aws-sm:my-secret
+func Resolve(value string) (string, error) {
+	prefix, provider, ok := lookup(value)
+	if !ok {
+		return "", fmt.Errorf("%s is not a secret reference", value)
+	}
+
+	secret, err := provider(strings.TrimPrefix(value, prefix))
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve secret %s: %v", value, err)
+	}
+	return secret, nil
+}
+
+// lookup finds the provider of a reference, preferring the longest prefix
+func lookup(value string) (string, Provider, bool) {
+	prefixes := make([]string, 0, len(providers))
+	for prefix := range providers {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Slice(prefixes, func(i, j int) bool { return len(prefixes[i]) > len(prefixes[j]) })
+
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(value, prefix) {
+			return prefix, providers[prefix], true
+		}
+	}
+	return "", nil, false
+}
+
+// vault reads a field of a HashiCorp Vault KV secret: kv/app#API_KEY
+func vault(ref string) (string, error) {
+	path, field, ok := strings.Cut(ref, "#")
+	if !ok || path == "" || field == "" {
+		return "", fmt.Errorf("expected vault:<path>#<field>")
+	}
+	return run("vault", "kv", "get", "-field="+field, path)
+}
+
+// onePassword reads a 1Password secret reference: vault/item/field
+func onePassword(ref string) (string, error) {
+	return run("op", "read", "op://"+ref)
+}
+
+// awsSecretsManager reads an AWS Secrets Manager secret, or a single key of a
+// JSON secret: my-secret or my-secret#API_KEY
+func awsSecretsManager(ref string) (string, error) {
+	id, key, hasKey := strings.Cut(ref, "#")
+	secret, err := run("aws", "secretsmanager", "get-secret-value", "--secret-id", id,
+		"--query", "SecretString", "--output", "text")
+	if err != nil || !hasKey {
+		return secret, err
+	}
+
+	var values map[string]any
+	if err := json.Unmarshal([]byte(secret), &values); err != nil {
+		return "", fmt.Errorf("secret %s is not a JSON object: %v", id, err)
+	}
+	value, ok := values[key]
+	if !ok {
+		return "", fmt.Errorf("secret %s has no key %s", id, key)
+	}
+	return fmt.Sprint(value), nil
+}
+
+// run runs a provider command and returns its output without the trailing
+// newline. The output is never logged.
+func run(name string, args ...string) (string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command(name, args...)
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%s: %v: %s", name, err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimRight(string(output), "\r\n"), nil
+}
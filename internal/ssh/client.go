@@ -0,0 +1,316 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/bjarneo/pipe/internal/config"
+)
+
+// dialTimeout is the maximum time allowed to establish an SSH connection
+const dialTimeout = 30 * time.Second
+
+// defaultIdentities are the key files tried when no SSH key is configured
+var defaultIdentities = []string{"id_ed25519", "id_ecdsa", "id_rsa"}
+
+var (
+	clientsMu sync.Mutex
+	clients   = make(map[string]*gossh.Client)
+)
+
+// address returns the host:port address of a host, defaulting to the given port
+func address(host string, port string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// connect returns a connection to the configured host, reusing an existing
+// connection when one is already open
+func connect(cfg *config.Config) (*gossh.Client, error) {
+	addr := address(cfg.Host, cfg.SSHPort)
+	key := fmt.Sprintf("%s@%s", cfg.User, addr)
+
+	clientsMu.Lock()
+	client, ok := clients[key]
+	clientsMu.Unlock()
+	if ok {
+		return client, nil
+	}
+
+	auth, err := authMethods(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	hostKeyCallback, err := hostKeyCallback(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err = dial(cfg, &gossh.ClientConfig{
+		User:            cfg.User,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         dialTimeout,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	if existing, ok := clients[key]; ok {
+		// Another goroutine connected first, keep a single connection
+		client.Close()
+		return existing, nil
+	}
+	clients[key] = client
+
+	return client, nil
+}
+
+// dial opens a connection to the configured host, through the jump host when
+// one is configured. The jump host uses the same keys and known hosts, and
+// its connection is closed together with the one to the host.
+func dial(cfg *config.Config, clientConfig *gossh.ClientConfig) (*gossh.Client, error) {
+	addr := address(cfg.Host, cfg.SSHPort)
+	if cfg.JumpHost == "" {
+		client, err := gossh.Dial("tcp", addr, clientConfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to %s: %v", addr, err)
+		}
+		return client, nil
+	}
+
+	jumpUser, jumpHost := cfg.Jump()
+	jumpAddr := address(jumpHost, "22")
+	jumpConfig := *clientConfig
+	jumpConfig.User = jumpUser
+	jump, err := gossh.Dial("tcp", jumpAddr, &jumpConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to jump host %s: %v", jumpAddr, err)
+	}
+
+	conn, err := jump.Dial("tcp", addr)
+	if err != nil {
+		jump.Close()
+		return nil, fmt.Errorf("failed to connect to %s through %s: %v", addr, jumpAddr, err)
+	}
+
+	clientConn, channels, requests, err := gossh.NewClientConn(conn, addr, clientConfig)
+	if err != nil {
+		conn.Close()
+		jump.Close()
+		return nil, fmt.Errorf("failed to connect to %s through %s: %v", addr, jumpAddr, err)
+	}
+
+	client := gossh.NewClient(clientConn, channels, requests)
+	go func() {
+		client.Wait()
+		jump.Close()
+	}()
+	return client, nil
+}
+
+// Disconnect closes the connection to the configured host, so the next
+// command opens a new one
+func Disconnect(cfg *config.Config) {
+	key := fmt.Sprintf("%s@%s", cfg.User, address(cfg.Host, cfg.SSHPort))
+
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	if client, ok := clients[key]; ok {
+		client.Close()
+		delete(clients, key)
+	}
+}
+
+// CloseAll closes all open SSH connections
+func CloseAll() {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	for key, client := range clients {
+		client.Close()
+		delete(clients, key)
+	}
+}
+
+// authMethods returns the SSH authentication methods to try. With
+// --ssh-agent only the ssh-agent is used, and a configured SSH key is used
+// exclusively, otherwise the ssh-agent and the default identity files in
+// ~/.ssh are tried. A password from SSH_PASSWORD is tried last, also for
+// keyboard-interactive prompts.
+func authMethods(cfg *config.Config) ([]gossh.AuthMethod, error) {
+	var methods []gossh.AuthMethod
+
+	switch {
+	case cfg.SSHAgent:
+		keys, err := sshAgent()
+		if err != nil {
+			return nil, err
+		}
+		methods = append(methods, gossh.PublicKeysCallback(keys.Signers))
+	case cfg.SSHKey != "":
+		signer, err := loadKey(cfg.SSHKey)
+		if err != nil {
+			return nil, err
+		}
+		methods = append(methods, gossh.PublicKeys(signer))
+	default:
+		if keys, err := sshAgent(); err == nil {
+			methods = append(methods, gossh.PublicKeysCallback(keys.Signers))
+		}
+
+		if home, err := os.UserHomeDir(); err == nil {
+			var signers []gossh.Signer
+			for _, name := range defaultIdentities {
+				if signer, err := loadKey(filepath.Join(home, ".ssh", name)); err == nil {
+					signers = append(signers, signer)
+				}
+			}
+			if len(signers) > 0 {
+				methods = append(methods, gossh.PublicKeys(signers...))
+			}
+		}
+	}
+
+	if cfg.SSHPassword != "" {
+		password := cfg.SSHPassword
+		methods = append(methods,
+			gossh.Password(password),
+			gossh.KeyboardInteractive(func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+				answers := make([]string, len(questions))
+				for i := range answers {
+					answers[i] = password
+				}
+				return answers, nil
+			}))
+	}
+
+	if len(methods) == 0 {
+		return nil, fmt.Errorf("no SSH credentials found: provide --ssh-key, SSH_PASSWORD or start an ssh-agent")
+	}
+
+	return methods, nil
+}
+
+// sshAgent connects to the running ssh-agent
+func sshAgent() (agent.ExtendedAgent, error) {
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if socket == "" {
+		return nil, fmt.Errorf("no ssh-agent running: SSH_AUTH_SOCK is not set")
+	}
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to ssh-agent: %v", err)
+	}
+	return agent.NewClient(conn), nil
+}
+
+// loadKey reads and parses a private key file
+func loadKey(path string) (gossh.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read SSH key %s: %v", path, err)
+	}
+
+	signer, err := gossh.ParsePrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse SSH key %s: %v", path, err)
+	}
+
+	return signer, nil
+}
+
+// knownHostsMu serializes the keys recorded by accept-new
+var knownHostsMu sync.Mutex
+
+// hostKeyCallback verifies host keys against the known_hosts file. In
+// accept-new mode the key of a host missing from the file is recorded, like
+// OpenSSH's StrictHostKeyChecking=accept-new, while a changed key is still
+// rejected.
+func hostKeyCallback(cfg *config.Config) (gossh.HostKeyCallback, error) {
+	knownHostsFile, err := cfg.KnownHostsFile()
+	if err != nil {
+		return nil, err
+	}
+
+	acceptNew := cfg.HostKeyCheck == config.HostKeyAcceptNew
+	if acceptNew {
+		if err := ensureFile(knownHostsFile); err != nil {
+			return nil, err
+		}
+	}
+
+	callback, err := knownhosts.New(knownHostsFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", knownHostsFile, err)
+	}
+
+	return func(hostname string, remote net.Addr, key gossh.PublicKey) error {
+		err := callback(hostname, remote, key)
+		var keyErr *knownhosts.KeyError
+		if !errors.As(err, &keyErr) || len(keyErr.Want) > 0 {
+			return err
+		}
+
+		host, _, _ := net.SplitHostPort(hostname)
+		if !acceptNew {
+			return fmt.Errorf("host key for %s is not in %s, add it with: ssh-keyscan %s >> %s, or use --host-key-check accept-new",
+				host, knownHostsFile, host, knownHostsFile)
+		}
+		return acceptKey(knownHostsFile, hostname, remote, key)
+	}, nil
+}
+
+// acceptKey records the key of a host missing from the known_hosts file,
+// unless another connection recorded a key for it in the meantime
+func acceptKey(knownHostsFile string, hostname string, remote net.Addr, key gossh.PublicKey) error {
+	knownHostsMu.Lock()
+	defer knownHostsMu.Unlock()
+
+	current, err := knownhosts.New(knownHostsFile)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", knownHostsFile, err)
+	}
+	err = current(hostname, remote, key)
+	var keyErr *knownhosts.KeyError
+	if !errors.As(err, &keyErr) || len(keyErr.Want) > 0 {
+		return err
+	}
+
+	file, err := os.OpenFile(knownHostsFile, os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to record host key in %s: %v", knownHostsFile, err)
+	}
+	defer file.Close()
+
+	line := knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key)
+	if _, err := fmt.Fprintln(file, line); err != nil {
+		return fmt.Errorf("failed to record host key in %s: %v", knownHostsFile, err)
+	}
+	return nil
+}
+
+// ensureFile creates an empty file, and its directory, if it does not exist
+func ensureFile(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("failed to create %s: %v", filepath.Dir(path), err)
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %v", path, err)
+	}
+	return file.Close()
+}
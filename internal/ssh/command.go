@@ -0,0 +1,74 @@
+package ssh
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/bjarneo/pipe/internal/config"
+)
+
+// dockerWord matches docker as the command word of a Docker command, after
+// any variable assignments, so it is not taken for the docker function
+var dockerWord = regexp.MustCompile(`^((?:[A-Za-z_][A-Za-z0-9_]*=\S*\s+)*)docker(\s|$)`)
+
+// shellSafe matches arguments that need no quoting in a shell command
+var shellSafe = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+
+// Command builds a shell command from an argument vector, quoting every
+// argument that contains characters the shell would interpret. A leading ~/
+// is kept outside the quotes so it still expands to the home directory.
+// Shell operators such as pipes and redirects are appended to its result.
+func Command(args ...string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		switch {
+		case shellSafe.MatchString(arg):
+			quoted[i] = arg
+		case strings.HasPrefix(arg, "~/") && len(arg) > 2:
+			quoted[i] = "~/" + Command(arg[2:])
+		default:
+			quoted[i] = Quote(arg)
+		}
+	}
+	return strings.Join(quoted, " ")
+}
+
+// UserCommand wraps a command from the configuration, such as a remote hook,
+// to run in the configured working directory and shell. Without them it runs
+// in the login directory with the login shell of the SSH user.
+func UserCommand(cfg *config.Config, command string) string {
+	if cfg.RemoteDir != "" {
+		command = Command("cd", cfg.RemoteDir) + " && " + command
+	}
+	if cfg.RemoteShell != "" {
+		command = Command(cfg.RemoteShell, "-c", command)
+	}
+	return command
+}
+
+// AsDockerUser makes the docker commands in a shell command run with the
+// configured Docker command, or as the configured Docker user through
+// passwordless sudo, for hosts where the SSH user may not access the Docker
+// socket. The rest of the command still runs as the SSH user.
+func AsDockerUser(cfg *config.Config, command string) string {
+	return DockerFunction(cfg) + command
+}
+
+// DockerFunction returns the shell function replacing docker in remote
+// commands and scripts with the configured Docker command or Docker user,
+// or an empty string when docker runs as is
+func DockerFunction(cfg *config.Config) string {
+	switch {
+	case cfg.DockerCommand != "":
+		command := dockerWord.ReplaceAllString(strings.TrimSpace(cfg.DockerCommand), "${1}command docker$2")
+		return "docker() { " + command + ` "$@"; }; `
+	case cfg.DockerUser != "":
+		return "docker() { " + Command("sudo", "-n", "-H", "-u", cfg.DockerUser, "docker") + ` "$@"; }; `
+	}
+	return ""
+}
+
+// Quote quotes a value for use as a single argument in a remote shell command
+func Quote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
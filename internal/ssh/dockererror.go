@@ -0,0 +1,127 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Exit codes of pipe for failures with a known cause. Other failures exit
+// with 1, and invalid options with 2.
+const (
+	ExitPortInUse     = 3
+	ExitNoSpace       = 4
+	ExitAccessDenied  = 5
+	ExitImageNotFound = 6
+	ExitOutOfMemory   = 7
+)
+
+// dockerFailure is a known cause of a failed docker command, recognized by
+// parts of its output
+type dockerFailure struct {
+	patterns []string
+	message  string
+	code     int
+}
+
+// dockerFailures are the known causes of failed docker commands. The
+// message of the port failure is completed with the port.
+var dockerFailures = []dockerFailure{
+	{
+		patterns: []string{"port is already allocated", "address already in use"},
+		message:  "is already in use on the host: stop what holds it or choose another --host-port",
+		code:     ExitPortInUse,
+	},
+	{
+		patterns: []string{"no space left on device"},
+		message:  "out of disk space: free some, e.g. with `docker system prune`, or keep fewer releases with --keep-releases",
+		code:     ExitNoSpace,
+	},
+	{
+		patterns: []string{"pull access denied", "unauthorized", "denied: requested access"},
+		message:  "the registry denied access to the image: check the image name and the registry credentials (--registry-user, DOCKER_REGISTRY_PASSWORD)",
+		code:     ExitAccessDenied,
+	},
+	{
+		patterns: []string{"manifest unknown", "not found: manifest", "no such image"},
+		message:  "the image does not exist: check the image name and tag",
+		code:     ExitImageNotFound,
+	},
+	{
+		patterns: []string{"oomkilled", "out of memory"},
+		message:  "the container was killed for running out of memory: raise --memory or lower the memory use of the app",
+		code:     ExitOutOfMemory,
+	},
+}
+
+// DockerError is a failed command whose output points at a known cause,
+// with a message saying what to do about it, or a job whose exit code pipe
+// exits with
+type DockerError struct {
+	Message string
+	Code    int
+	Err     error
+}
+
+func (e *DockerError) Error() string {
+	return fmt.Sprintf("%s (%v)", e.Message, e.Err)
+}
+
+func (e *DockerError) Unwrap() error {
+	return e.Err
+}
+
+// ExitCode returns the exit code for err: the code of its known cause, or 1.
+// The cause is also recognized in the message of errors that wrapped a
+// DockerError as text.
+func ExitCode(err error) int {
+	var dockerErr *DockerError
+	if errors.As(err, &dockerErr) {
+		return dockerErr.Code
+	}
+	for _, failure := range dockerFailures {
+		if strings.Contains(err.Error(), failure.message) {
+			return failure.code
+		}
+	}
+	return 1
+}
+
+// boundPort finds the port in docker's "port is already allocated" and
+// "address already in use" messages
+var boundPort = regexp.MustCompile(`(?:0\.0\.0\.0|\[::\]|[0-9.]+):([0-9]+)`)
+
+// diagnose wraps err in a DockerError when the failed command ran docker
+// and its output matches a known docker failure
+func diagnose(command string, err error, output string) error {
+	if err == nil || !strings.Contains(command, "docker") {
+		return err
+	}
+
+	lower := strings.ToLower(output)
+	for _, failure := range dockerFailures {
+		for _, pattern := range failure.patterns {
+			if !strings.Contains(lower, pattern) {
+				continue
+			}
+
+			message := failure.message
+			if failure.code == ExitPortInUse {
+				port := "the host port"
+				if match := boundPort.FindStringSubmatch(output); match != nil {
+					port = "port " + match[1]
+				}
+				message = port + " " + message
+			}
+			return &DockerError{Message: message, Code: failure.code, Err: err}
+		}
+	}
+	return err
+}
+
+// OutOfMemory returns the error of a container killed for running out of
+// memory
+func OutOfMemory(err error) error {
+	return diagnose("docker", err, "oomkilled")
+}
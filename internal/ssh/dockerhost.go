@@ -0,0 +1,87 @@
+package ssh
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+
+	"github.com/bjarneo/pipe/internal/config"
+)
+
+// shellOperators are the characters that make a command more than a single
+// program with arguments when they appear outside of quotes
+const shellOperators = "|&;<>()$`\\\"*?[]{}~#!\n\t"
+
+// dockerArgs returns the arguments of a command that is a single docker
+// invocation built with Command, such as docker ps -a. Commands using shell
+// operators, variables or other programs are not split and keep running on
+// the host.
+func dockerArgs(command string) ([]string, bool) {
+	var args []string
+	var arg strings.Builder
+	inArg := false
+	for i := 0; i < len(command); i++ {
+		switch c := command[i]; {
+		case c == ' ':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		case c == '\'':
+			end := strings.IndexByte(command[i+1:], '\'')
+			if end < 0 {
+				return nil, false
+			}
+			arg.WriteString(command[i+1 : i+1+end])
+			i += end + 1
+			inArg = true
+		case strings.IndexByte(shellOperators, c) >= 0:
+			return nil, false
+		default:
+			arg.WriteByte(c)
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+
+	// Quote turns a quote inside a value into '\'' which is rejected above,
+	// so values with quotes keep running on the host as well
+	if len(args) < 2 || args[0] != "docker" {
+		return nil, false
+	}
+	return args[1:], true
+}
+
+// startDockerHost starts a docker command with the local docker CLI against
+// the engine of the host and returns its stdout and stderr and a function
+// waiting for its exit code
+func startDockerHost(ctx context.Context, cfg *config.Config, args []string, input io.Reader) (io.Reader, io.Reader, func() (int, error), error) {
+	cmd := exec.CommandContext(ctx, "docker", append(cfg.DockerHostArgs(), args...)...)
+	cmd.Stdin = input
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to create stdout pipe: %v", err)
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to create stderr pipe: %v", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to start docker: %v", err)
+	}
+
+	wait := func() (int, error) {
+		err := cmd.Wait()
+		if ctx.Err() != nil {
+			return -1, contextError(ctx)
+		}
+		return cmd.ProcessState.ExitCode(), err
+	}
+	return stdout, stderr, wait, nil
+}
@@ -0,0 +1,71 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+
+	"github.com/bjarneo/pipe/internal/config"
+)
+
+// engineHelperImage runs the shell commands of the docker-tls transport,
+// which reach the engine through its socket
+const engineHelperImage = "docker:cli"
+
+// engineHomeVolume is the home directory of the helper container. It keeps
+// the state directory and other files pipe writes to ~/ across commands.
+const engineHomeVolume = "copepod-home"
+
+// errNoSSH is returned by the commands that need an SSH connection of their
+// own in the docker-tls transport, such as agent forwarding
+var errNoSSH = errors.New("this command needs SSH and cannot run with --transport " + config.TransportDockerTLS)
+
+// helperArgs returns the docker arguments running a shell command in a
+// throwaway helper container on the engine of the host, in the host's
+// network and with the engine's socket, as there is no SSH to run it on
+// the host itself
+func helperArgs(command string) []string {
+	return []string{"run", "--rm", "-i", "--network", "host",
+		"-v", engineHomeVolume + ":/root",
+		"-v", "/var/run/docker.sock:/var/run/docker.sock",
+		"-w", "/root", "-e", "HOME=/root",
+		engineHelperImage, "sh", "-c", command}
+}
+
+// uploadDockerTLS writes a file through the helper container of the
+// docker-tls transport. A non-zero mode is applied before any data is
+// written.
+func uploadDockerTLS(cfg *config.Config, src io.Reader, remotePath string, mode os.FileMode) error {
+	command := fmt.Sprintf("mkdir -p %s && : > %s", Quote(path.Dir(remotePath)), Quote(remotePath))
+	if mode != 0 {
+		command += fmt.Sprintf(" && chmod %o %s", mode, Quote(remotePath))
+	}
+	command += " && cat > " + Quote(remotePath)
+
+	stdout, stderr, wait, err := startDockerHost(cfg.Context(), cfg, helperArgs(command), src)
+	if err != nil {
+		return err
+	}
+	go io.Copy(io.Discard, stdout)
+	output, _ := io.ReadAll(stderr)
+	if _, err := wait(); err != nil {
+		return fmt.Errorf("failed to write %s: %v: %s", remotePath, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// interactiveDockerTLS runs a single docker command, such as docker exec -it,
+// with the local docker CLI connected to the terminal
+func interactiveDockerTLS(cfg *config.Config, command string) error {
+	args, ok := dockerArgs(command)
+	if !ok {
+		return errNoSSH
+	}
+	cmd := exec.CommandContext(cfg.Context(), "docker", append(cfg.DockerHostArgs(), args...)...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	return cmd.Run()
+}
@@ -0,0 +1,40 @@
+package ssh
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bjarneo/pipe/internal/logger"
+)
+
+// dryRun holds the state of a dry run, in which commands that change
+// anything are printed instead of executed. Commands run with Capture only
+// read state and still run, so the printed commands match a real run.
+var dryRun struct {
+	enabled bool
+	masker  *strings.Replacer
+}
+
+// SetDryRun enables a dry run. Masks are pairs of strings, each first string
+// being replaced by the second in the printed commands to hide secrets.
+func SetDryRun(masks ...string) {
+	dryRun.enabled = true
+	dryRun.masker = strings.NewReplacer(masks...)
+}
+
+// DryRun reports whether commands are printed instead of executed
+func DryRun() bool {
+	return dryRun.enabled
+}
+
+// printDryRun prints a command that would have been executed, with secrets
+// masked, in place of running it
+func printDryRun(log *logger.Logger, description string, where string, command string) (*CommandResult, error) {
+	if err := log.Info(fmt.Sprintf("%s...", description)); err != nil {
+		return nil, err
+	}
+	if err := log.Info(fmt.Sprintf("[dry-run] %s: %s", where, dryRun.masker.Replace(command))); err != nil {
+		return nil, err
+	}
+	return &CommandResult{}, nil
+}
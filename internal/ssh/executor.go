@@ -0,0 +1,187 @@
+package ssh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	gossh "golang.org/x/crypto/ssh"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/logger"
+)
+
+// Executor runs commands on and uploads files to the remote hosts in place
+// of the built-in SSH client, for tools that embed pipe
+type Executor interface {
+	// Run runs a shell command on the host with stdin, stdout and stderr
+	// connected and returns its exit code. The command is stopped when ctx
+	// is cancelled.
+	Run(ctx context.Context, host string, command string, stdin io.Reader, stdout io.Writer, stderr io.Writer) (int, error)
+
+	// Upload writes a file on the host, creating missing directories. The
+	// path is relative to the login directory unless it is absolute, and a
+	// zero mode keeps the default permissions.
+	Upload(ctx context.Context, host string, path string, data io.Reader, mode os.FileMode) error
+}
+
+// executorKey is the context key of the executor
+type executorKey struct{}
+
+// errExecutorUnsupported is returned by the commands that need an SSH
+// connection of their own, such as interactive sessions
+var errExecutorUnsupported = errors.New("this command needs the built-in SSH client and cannot run with a custom executor")
+
+// WithExecutor returns a context running the remote commands of the
+// configurations using it with the given executor instead of over SSH. A
+// nil executor keeps the SSH client.
+func WithExecutor(ctx context.Context, e Executor) context.Context {
+	return context.WithValue(ctx, executorKey{}, e)
+}
+
+// executorOf returns the executor of the configuration, or nil when its
+// commands go over SSH
+func executorOf(cfg *config.Config) Executor {
+	e, _ := cfg.Context().Value(executorKey{}).(Executor)
+	return e
+}
+
+// startRemote starts a command on the remote host and returns its stdout and
+// stderr, which must be read until they are closed, and a function waiting
+// for its exit code. The command is stopped when the context of cfg is
+// cancelled.
+func startRemote(cfg *config.Config, log *logger.Logger, command string, input io.Reader) (io.Reader, io.Reader, func() (int, error), error) {
+	ctx := cfg.Context()
+	if ctx.Err() != nil {
+		return nil, nil, nil, contextError(ctx)
+	}
+
+	// Single docker commands go to the remote engine through the local
+	// docker CLI in the docker-host and docker-tls transports. Without SSH,
+	// other commands run in a helper container on the engine.
+	executor := executorOf(cfg)
+	if executor == nil && cfg.DockerHost() {
+		if args, ok := dockerArgs(command); ok {
+			return startDockerHost(ctx, cfg, args, input)
+		}
+		if cfg.DockerTLS() {
+			return startDockerHost(ctx, cfg, helperArgs(command), input)
+		}
+	}
+	command = AsDockerUser(cfg, command)
+
+	if executor != nil {
+		stdout, stderr, wait := startExecutor(ctx, func(stdout io.Writer, stderr io.Writer) (int, error) {
+			return executor.Run(ctx, cfg.Host, command, input, stdout, stderr)
+		})
+		return stdout, stderr, wait, nil
+	}
+
+	session, err := newSession(cfg, log)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	session.Stdin = input
+
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		session.Close()
+		return nil, nil, nil, fmt.Errorf("failed to create stdout pipe: %v", err)
+	}
+
+	stderr, err := session.StderrPipe()
+	if err != nil {
+		session.Close()
+		return nil, nil, nil, fmt.Errorf("failed to create stderr pipe: %v", err)
+	}
+
+	if err := session.Start(command); err != nil {
+		session.Close()
+		return nil, nil, nil, fmt.Errorf("failed to start command: %v", err)
+	}
+
+	// Ask the command to terminate and drop the session when cancelled, as
+	// not every SSH server delivers signals
+	stop := context.AfterFunc(ctx, func() {
+		session.Signal(gossh.SIGTERM)
+		session.Close()
+	})
+
+	wait := func() (int, error) {
+		defer session.Close()
+		err := session.Wait()
+		if !stop() && ctx.Err() != nil {
+			return -1, contextError(ctx)
+		}
+		if err == nil {
+			return 0, nil
+		}
+		if exitErr, ok := err.(*gossh.ExitError); ok {
+			return exitErr.ExitStatus(), err
+		}
+		return -1, err
+	}
+	return stdout, stderr, wait, nil
+}
+
+// startExecutor runs a command of an executor in the background and returns
+// its stdout and stderr, which must be read until they are closed, and a
+// function waiting for its exit code
+func startExecutor(ctx context.Context, run func(stdout io.Writer, stderr io.Writer) (int, error)) (io.Reader, io.Reader, func() (int, error)) {
+	stdoutReader, stdoutWriter := io.Pipe()
+	stderrReader, stderrWriter := io.Pipe()
+	done := make(chan struct{})
+	var exitCode int
+	var err error
+	go func() {
+		exitCode, err = run(stdoutWriter, stderrWriter)
+		stdoutWriter.Close()
+		stderrWriter.Close()
+		close(done)
+	}()
+
+	wait := func() (int, error) {
+		<-done
+		if ctx.Err() != nil {
+			return -1, contextError(ctx)
+		}
+		if err == nil && exitCode != 0 {
+			err = fmt.Errorf("exit status %d", exitCode)
+		}
+		if err != nil && exitCode == 0 {
+			exitCode = -1
+		}
+		return exitCode, err
+	}
+	return stdoutReader, stderrReader, wait
+}
+
+// newSession opens a session on the connection to the host, reconnecting
+// while the network fails. Nothing has run on the host yet, so this is
+// always safe to retry.
+func newSession(cfg *config.Config, log *logger.Logger) (*gossh.Session, error) {
+	var session *gossh.Session
+	err := Retry(cfg, log, fmt.Sprintf("Connecting to %s", cfg.Host), func() error {
+		client, err := connect(cfg)
+		if err != nil {
+			return classify(err, "")
+		}
+		session, err = client.NewSession()
+		if err != nil {
+			return classify(fmt.Errorf("failed to open SSH session: %v", err), "")
+		}
+		return nil
+	})
+	return session, err
+}
+
+// contextError describes why the context of a command ended
+func contextError(ctx context.Context) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return errors.New("timed out")
+	}
+	return errors.New("cancelled")
+}
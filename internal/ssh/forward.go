@@ -0,0 +1,158 @@
+package ssh
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/logger"
+)
+
+// RunForwarded executes a command on the remote host with the local SSH
+// credentials forwarded through an agent, so the command can connect to
+// other hosts as the same user. It uses its own connection, since an agent
+// can only be forwarded once per connection.
+func RunForwarded(cfg *config.Config, log *logger.Logger, command string, description string) (*CommandResult, error) {
+	if DryRun() {
+		return printDryRun(log, description, cfg.Host, command)
+	}
+
+	if err := logCommand(log, description, fmt.Sprintf("Executing on %s with agent forwarding: %s", cfg.Host, command)); err != nil {
+		return nil, err
+	}
+
+	if executorOf(cfg) != nil {
+		return nil, errExecutorUnsupported
+	}
+	if cfg.DockerTLS() {
+		return nil, errNoSSH
+	}
+
+	keys, err := forwardedAgent(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	auth, err := authMethods(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	hostKeyCallback, err := hostKeyCallback(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	started := time.Now()
+
+	client, err := dial(cfg, &gossh.ClientConfig{
+		User:            cfg.User,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         dialTimeout,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	if err := agent.ForwardToAgent(client, keys); err != nil {
+		return nil, fmt.Errorf("failed to forward SSH agent: %v", err)
+	}
+
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open SSH session: %v", err)
+	}
+	defer session.Close()
+
+	if err := agent.RequestAgentForwarding(session); err != nil {
+		return nil, fmt.Errorf("failed to request agent forwarding, check AllowAgentForwarding on %s: %v", cfg.Host, err)
+	}
+
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stdout pipe: %v", err)
+	}
+
+	stderr, err := session.StderrPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stderr pipe: %v", err)
+	}
+
+	if err := session.Start(command); err != nil {
+		return nil, fmt.Errorf("failed to start command: %v", err)
+	}
+
+	ctx := cfg.Context()
+	stop := context.AfterFunc(ctx, func() {
+		session.Signal(gossh.SIGTERM)
+		session.Close()
+	})
+
+	stdoutText, stderrText, _ := readOutput(log, stdout, stderr, true)
+
+	err = session.Wait()
+	if !stop() && ctx.Err() != nil {
+		err = contextError(ctx)
+	}
+	exitCode := 0
+	if err != nil {
+		exitCode = -1
+		if exitErr, ok := err.(*gossh.ExitError); ok {
+			exitCode = exitErr.ExitStatus()
+		}
+	}
+
+	return finish(log, command, description, started, stdoutText, stderrText, stdoutText+stderrText, exitCode, err)
+}
+
+// forwardedAgent returns the agent to forward: an in-memory agent holding
+// the configured SSH key, or else the running ssh-agent, which --ssh-agent
+// requires, or else an in-memory agent holding the default identities
+func forwardedAgent(cfg *config.Config) (agent.Agent, error) {
+	if cfg.SSHKey != "" {
+		keyring := agent.NewKeyring()
+		if err := addKey(keyring, cfg.SSHKey); err != nil {
+			return nil, err
+		}
+		return keyring, nil
+	}
+
+	if keys, err := sshAgent(); err == nil || cfg.SSHAgent {
+		return keys, err
+	}
+
+	keyring := agent.NewKeyring()
+	if home, err := os.UserHomeDir(); err == nil {
+		for _, name := range defaultIdentities {
+			addKey(keyring, filepath.Join(home, ".ssh", name))
+		}
+	}
+
+	if keys, _ := keyring.List(); len(keys) == 0 {
+		return nil, fmt.Errorf("no SSH credentials found to forward: provide --ssh-key or start an ssh-agent")
+	}
+	return keyring, nil
+}
+
+// addKey reads a private key file and adds it to the agent
+func addKey(keyring agent.Agent, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read SSH key %s: %v", path, err)
+	}
+
+	key, err := gossh.ParseRawPrivateKey(data)
+	if err != nil {
+		return fmt.Errorf("failed to parse SSH key %s: %v", path, err)
+	}
+
+	return keyring.Add(agent.AddedKey{PrivateKey: key})
+}
@@ -0,0 +1,81 @@
+package ssh
+
+import (
+	"fmt"
+	"os"
+
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/logger"
+)
+
+// Interactive executes a command on the remote host with a TTY, connecting it
+// to the local terminal until the command exits
+func Interactive(cfg *config.Config, log *logger.Logger, command string) error {
+	if err := log.Debug(fmt.Sprintf("Executing on %s: %s", cfg.Host, command)); err != nil {
+		return err
+	}
+
+	if executorOf(cfg) != nil {
+		return errExecutorUnsupported
+	}
+	if cfg.DockerTLS() {
+		return interactiveDockerTLS(cfg, command)
+	}
+
+	client, err := connect(cfg)
+	if err != nil {
+		return err
+	}
+
+	session, err := client.NewSession()
+	if err != nil {
+		return fmt.Errorf("failed to open SSH session: %v", err)
+	}
+	defer session.Close()
+
+	fd := int(os.Stdin.Fd())
+	width, height, err := term.GetSize(fd)
+	if err != nil {
+		width, height = 80, 24
+	}
+
+	termType := os.Getenv("TERM")
+	if termType == "" {
+		termType = "xterm-256color"
+	}
+
+	modes := gossh.TerminalModes{
+		gossh.ECHO:          1,
+		gossh.TTY_OP_ISPEED: 14400,
+		gossh.TTY_OP_OSPEED: 14400,
+	}
+	if err := session.RequestPty(termType, height, width, modes); err != nil {
+		return fmt.Errorf("failed to request TTY: %v", err)
+	}
+
+	// Pass keystrokes such as Ctrl-C through to the remote command
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return fmt.Errorf("failed to set terminal to raw mode: %v", err)
+	}
+	defer term.Restore(fd, state)
+
+	stopResize := watchResize(fd, session)
+	defer stopResize()
+
+	session.Stdin = os.Stdin
+	session.Stdout = os.Stdout
+	session.Stderr = os.Stderr
+
+	if err := session.Run(AsDockerUser(cfg, command)); err != nil {
+		if exitErr, ok := err.(*gossh.ExitError); ok {
+			return fmt.Errorf("command failed with exit code %d: %v", exitErr.ExitStatus(), err)
+		}
+		return fmt.Errorf("command failed: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,137 @@
+package ssh
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// LocalExecutor runs the commands pipe runs on this machine, such as docker
+// build, docker save and local hooks, in place of starting processes, for
+// tools that embed pipe and tests of the whole pipeline without docker
+type LocalExecutor interface {
+	// Run runs a command given as an argument vector with env added to the
+	// environment of pipe and stdin, stdout and stderr connected, and
+	// returns its exit code. The command is stopped when ctx is cancelled.
+	Run(ctx context.Context, args []string, env []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) (int, error)
+}
+
+// localExecutorKey is the context key of the local executor
+type localExecutorKey struct{}
+
+// WithLocalExecutor returns a context running the local commands started
+// with it with the given executor instead of starting processes. A nil
+// executor keeps running them directly.
+func WithLocalExecutor(ctx context.Context, e LocalExecutor) context.Context {
+	return context.WithValue(ctx, localExecutorKey{}, e)
+}
+
+// localExecutorOf returns the local executor of a context, or nil when
+// local commands start processes
+func localExecutorOf(ctx context.Context) LocalExecutor {
+	e, _ := ctx.Value(localExecutorKey{}).(LocalExecutor)
+	return e
+}
+
+// LookPath returns an error when a local tool is not installed. Tools are
+// taken to be available when a local executor runs the commands.
+func LookPath(ctx context.Context, file string) error {
+	if localExecutorOf(ctx) != nil {
+		return nil
+	}
+	_, err := exec.LookPath(file)
+	return err
+}
+
+// startLocal starts a local command and returns its stdout and stderr, which
+// must be read until they are closed, and a function waiting for its exit
+// code. The command is killed when ctx is cancelled.
+func startLocal(ctx context.Context, args []string, env []string, input io.Reader) (io.Reader, io.Reader, func() (int, error), error) {
+	if localExecutor := localExecutorOf(ctx); localExecutor != nil {
+		stdout, stderr, wait := startExecutor(ctx, func(stdout io.Writer, stderr io.Writer) (int, error) {
+			return localExecutor.Run(ctx, args, env, input, stdout, stderr)
+		})
+		return stdout, stderr, wait, nil
+	}
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdin = input
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to create stdout pipe: %v", err)
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to create stderr pipe: %v", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, nil, nil, err
+	}
+
+	wait := func() (int, error) {
+		err := cmd.Wait()
+		if err != nil && ctx.Err() != nil {
+			return -1, contextError(ctx)
+		}
+		return cmd.ProcessState.ExitCode(), err
+	}
+	return stdout, stderr, wait, nil
+}
+
+// LocalProcess is a local command streaming its output into pipe, such as
+// docker save during a transfer
+type LocalProcess struct {
+	// Stdout is the output of the command, which must be read until it is
+	// closed unless the command is stopped
+	Stdout io.Reader
+
+	cancel context.CancelFunc
+	wait   func() (int, error)
+	errors string
+	done   chan struct{}
+}
+
+// StartLocal starts a local command given as an argument vector with the
+// given input connected to its stdin. Unlike ExecuteCommand it neither logs
+// the command nor records its output, which is left to the caller.
+func StartLocal(ctx context.Context, args []string, input io.Reader) (*LocalProcess, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	stdout, stderr, wait, err := startLocal(ctx, args, nil, input)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	process := &LocalProcess{Stdout: stdout, cancel: cancel, wait: wait, done: make(chan struct{})}
+	go func() {
+		data, _ := io.ReadAll(stderr)
+		process.errors = strings.TrimSpace(string(data))
+		close(process.done)
+	}()
+	return process, nil
+}
+
+// Wait waits for the command to exit and returns an error with its error
+// output when it failed
+func (p *LocalProcess) Wait() error {
+	<-p.done
+	_, err := p.wait()
+	p.cancel()
+	if err != nil && p.errors != "" {
+		return fmt.Errorf("%v: %s", err, p.errors)
+	}
+	return err
+}
+
+// Stop kills the command and waits for it to exit
+func (p *LocalProcess) Stop() {
+	p.cancel()
+	p.Wait()
+}
@@ -0,0 +1,151 @@
+package ssh
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/bjarneo/pipe/internal/logger"
+)
+
+const (
+	// maxOutputBuffer is the amount of a command's stdout or stderr kept in
+	// memory. Longer output keeps its end in memory and is written in full
+	// to a temporary file.
+	maxOutputBuffer = 1 << 20
+
+	// maxLineLength is the longest line of logged output read at once,
+	// longer lines are split
+	maxLineLength = 64 * 1024
+
+	// maxCaptureOutput is the most output a captured command may return.
+	// Captured output is parsed by the caller, so it is kept whole and the
+	// command fails beyond it.
+	maxCaptureOutput = 64 << 20
+)
+
+// lineBuffer collects the output of a command line by line
+type lineBuffer interface {
+	WriteLine(line string)
+	String() string
+}
+
+// outputBuffer collects the output of a command, keeping at most
+// maxOutputBuffer bytes of it in memory
+type outputBuffer struct {
+	log   *logger.Logger
+	name  string
+	tail  []byte
+	total int64
+	file  *os.File
+	err   error
+}
+
+// WriteLine adds a line of output. Once the output outgrows the buffer it
+// is written, with secrets masked, to a temporary file.
+func (b *outputBuffer) WriteLine(line string) {
+	b.total += int64(len(line)) + 1
+
+	if b.file == nil && b.err == nil && len(b.tail)+len(line)+1 > maxOutputBuffer {
+		b.file, b.err = os.CreateTemp("", "pipe-"+b.name+"-*.log")
+		if b.err == nil {
+			_, b.err = b.file.WriteString(b.log.Redact(string(b.tail)))
+		}
+	}
+	if b.file != nil && b.err == nil {
+		_, b.err = b.file.WriteString(b.log.Redact(line) + "\n")
+	}
+
+	b.tail = append(b.tail, line...)
+	b.tail = append(b.tail, '\n')
+	if excess := len(b.tail) - maxOutputBuffer; excess > 0 {
+		// Drop whole lines from the start where possible
+		if i := bytes.IndexByte(b.tail[excess:], '\n'); i >= 0 && i < len(b.tail)-excess-1 {
+			excess += i + 1
+		}
+		b.tail = b.tail[:copy(b.tail, b.tail[excess:])]
+	}
+}
+
+// String returns the output kept in memory, preceded by a note naming the
+// file with the full output when the start was dropped
+func (b *outputBuffer) String() string {
+	omitted := b.total - int64(len(b.tail))
+	if omitted <= 0 {
+		return string(b.tail)
+	}
+	if b.file == nil {
+		return fmt.Sprintf("[%d bytes of output omitted]\n%s", omitted, b.tail)
+	}
+	return fmt.Sprintf("[%d bytes of output omitted, full output in %s]\n%s", omitted, b.file.Name(), b.tail)
+}
+
+// Close closes the file with the full output and reports where it is
+func (b *outputBuffer) Close() {
+	if b.err != nil {
+		b.log.Warn(fmt.Sprintf("failed to keep the full %s of the command: %v", b.name, b.err))
+	}
+	if b.file == nil {
+		return
+	}
+	b.file.Close()
+	b.log.Info(fmt.Sprintf("The %s of the command was too long to keep in memory, the full output is in %s", b.name, b.file.Name()))
+}
+
+// captureBuffer collects the output of a captured command whole, up to
+// maxCaptureOutput bytes
+type captureBuffer struct {
+	data     strings.Builder
+	total    int64
+	exceeded bool
+}
+
+// WriteLine adds a line of output, or marks the output as too large once it
+// outgrows maxCaptureOutput
+func (b *captureBuffer) WriteLine(line string) {
+	b.total += int64(len(line)) + 1
+	if b.exceeded || b.total > maxCaptureOutput {
+		b.exceeded = true
+		return
+	}
+	b.data.WriteString(line)
+	b.data.WriteByte('\n')
+}
+
+// Err returns an error when the output was too large to keep
+func (b *captureBuffer) Err() error {
+	if !b.exceeded {
+		return nil
+	}
+	return fmt.Errorf("command output of %d bytes exceeds the limit of %d bytes", b.total, maxCaptureOutput)
+}
+
+// String returns the output
+func (b *captureBuffer) String() string {
+	return b.data.String()
+}
+
+// readLines calls fn with every line read from r until it is closed. Lines
+// longer than maxLength are passed on in parts, so no single line can
+// exhaust the memory.
+func readLines(r io.Reader, maxLength int, fn func(line string)) {
+	reader := bufio.NewReaderSize(r, maxLineLength)
+	var line []byte
+	for {
+		part, err := reader.ReadSlice('\n')
+		line = append(line, part...)
+		if err == bufio.ErrBufferFull && len(line) < maxLength {
+			continue
+		}
+		if len(line) > 0 {
+			fn(string(bytes.TrimRight(line, "\r\n")))
+		}
+		line = line[:0]
+		if err != nil && err != bufio.ErrBufferFull {
+			return
+		}
+	}
+}
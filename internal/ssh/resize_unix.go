@@ -0,0 +1,32 @@
+//go:build !windows
+
+package ssh
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+)
+
+// watchResize forwards local terminal size changes to the remote TTY until
+// the returned function is called
+func watchResize(fd int, session *gossh.Session) func() {
+	resized := make(chan os.Signal, 1)
+	signal.Notify(resized, syscall.SIGWINCH)
+
+	go func() {
+		for range resized {
+			if width, height, err := term.GetSize(fd); err == nil {
+				session.WindowChange(height, width)
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(resized)
+		close(resized)
+	}
+}
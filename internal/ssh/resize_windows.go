@@ -0,0 +1,12 @@
+//go:build windows
+
+package ssh
+
+import (
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// watchResize is a no-op on Windows, which has no SIGWINCH
+func watchResize(fd int, session *gossh.Session) func() {
+	return func() {}
+}
@@ -0,0 +1,96 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/logger"
+)
+
+// transientMessages are parts of error messages caused by a flaky network
+// rather than by the command itself
+var transientMessages = []string{
+	"connection reset",
+	"connection refused",
+	"connection timed out",
+	"broken pipe",
+	"i/o timeout",
+	"tls handshake timeout",
+	"no route to host",
+	"network is unreachable",
+	"unexpected eof",
+	"without exit status",
+}
+
+// transientError is a failure caused by the network, which is worth retrying
+type transientError struct {
+	err error
+}
+
+func (e *transientError) Error() string {
+	return e.err.Error()
+}
+
+func (e *transientError) Unwrap() error {
+	return e.err
+}
+
+// Transient reports whether err was caused by the network, such as a reset
+// or timed out connection, rather than by a command failing on its own
+func Transient(err error) bool {
+	var transient *transientError
+	return errors.As(err, &transient)
+}
+
+// classify marks err as transient when it or the output of the command
+// points at the network. A command that exited with an error of its own
+// stays a permanent failure.
+func classify(err error, output string) error {
+	if err == nil || Transient(err) {
+		return err
+	}
+
+	message := strings.ToLower(err.Error())
+	if message == "eof" || strings.HasSuffix(message, ": eof") {
+		return &transientError{err}
+	}
+	message += "\n" + strings.ToLower(output)
+	for _, transient := range transientMessages {
+		if strings.Contains(message, transient) {
+			return &transientError{err}
+		}
+	}
+	return err
+}
+
+// Retry runs fn until it succeeds or fails permanently, retrying transient
+// failures up to the configured number of times. The wait between attempts
+// starts at the retry delay and doubles after every attempt. The connection
+// to the host is reopened before each retry.
+func Retry(cfg *config.Config, log *logger.Logger, description string, fn func() error) error {
+	delay, err := cfg.RetryBackoff()
+	if err != nil {
+		return err
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || !Transient(err) || attempt > cfg.Retries || cfg.Context().Err() != nil {
+			return err
+		}
+
+		log.Warn(fmt.Sprintf("%s failed on a network error, retrying in %s (%d/%d): %v",
+			description, delay, attempt, cfg.Retries, err))
+		Disconnect(cfg)
+
+		select {
+		case <-time.After(delay):
+		case <-cfg.Context().Done():
+			return err
+		}
+		delay *= 2
+	}
+}
@@ -2,107 +2,474 @@ package ssh
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"fmt"
-	"os/exec"
+	"io"
+	"os"
+	"path"
+	"slices"
 	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
 
 	"github.com/bjarneo/pipe/internal/config"
 	"github.com/bjarneo/pipe/internal/logger"
 )
 
+// maxTranscriptLines is the number of output lines kept per transcript step
+const maxTranscriptLines = 20
+
 // CommandResult contains the output of a command
 type CommandResult struct {
-	Stdout string
-	Stderr string
+	Stdout   string
+	Stderr   string
+	ExitCode int
+	Duration time.Duration
+}
+
+// Check checks SSH connection to the remote host
+func Check(cfg *config.Config, log *logger.Logger) error {
+	_, err := Run(cfg, log, "echo 'SSH connection successful'", "Checking SSH connection")
+	return err
 }
 
-// GetKeyFlag returns the SSH key flag if SSHKey is set
-func GetKeyFlag(cfg *config.Config) string {
-	if cfg.SSHKey != "" {
-		return fmt.Sprintf("-i %s", cfg.SSHKey)
+// ExecuteCommand executes a local command given as an argument vector,
+// without a shell, and streams the output. The command is killed when ctx is
+// cancelled.
+func ExecuteCommand(ctx context.Context, log *logger.Logger, args []string, description string) (*CommandResult, error) {
+	return ExecuteCommandWithInput(ctx, log, args, description, nil)
+}
+
+// ExecuteCommandWithInput executes a local command given as an argument
+// vector with the given input connected to its stdin and streams the output
+func ExecuteCommandWithInput(ctx context.Context, log *logger.Logger, args []string, description string, input io.Reader) (*CommandResult, error) {
+	return executeLocal(ctx, log, args, nil, description, input)
+}
+
+// ExecuteCommandWithEnv executes a local command given as an argument vector
+// with extra KEY=VALUE environment variables and streams the output
+func ExecuteCommandWithEnv(ctx context.Context, log *logger.Logger, args []string, env []string, description string) (*CommandResult, error) {
+	return executeLocal(ctx, log, args, env, description, nil)
+}
+
+// ExecuteShell executes a command line with the local shell, such as a local
+// hook, with extra KEY=VALUE environment variables and the given input
+// connected to its stdin, and streams the output
+func ExecuteShell(ctx context.Context, log *logger.Logger, command string, env []string, description string, input io.Reader) (*CommandResult, error) {
+	return executeLocal(ctx, log, config.LocalShell(command), env, description, input)
+}
+
+// ExecuteFile executes a local executable file, such as a hook executable,
+// with extra KEY=VALUE environment variables and the given input connected
+// to its stdin, and streams the output
+func ExecuteFile(ctx context.Context, log *logger.Logger, path string, env []string, description string, input io.Reader) (*CommandResult, error) {
+	return executeLocal(ctx, log, config.LocalExecutable(path), env, description, input)
+}
+
+// executeLocal executes a local command, streams its output and records it
+// in the transcript
+func executeLocal(ctx context.Context, log *logger.Logger, args []string, env []string, description string, input io.Reader) (*CommandResult, error) {
+	command := Command(append(slices.Clone(env), args...)...)
+	if DryRun() {
+		return printDryRun(log, description, "local", command)
 	}
-	return ""
+
+	if err := logCommand(log, description, fmt.Sprintf("Executing: %s", command)); err != nil {
+		return nil, err
+	}
+
+	if ctx.Err() != nil {
+		return nil, contextError(ctx)
+	}
+
+	started := time.Now()
+	stdout, stderr, wait, err := startLocal(ctx, args, env, input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start command: %v", err)
+	}
+
+	stdoutText, stderrText, _ := readOutput(log, stdout, stderr, true)
+	exitCode, err := wait()
+	return finish(log, command, description, started, stdoutText, stderrText, stdoutText+stderrText, exitCode, err)
+}
+
+// Run executes a command on the remote host and streams the output
+func Run(cfg *config.Config, log *logger.Logger, command string, description string) (*CommandResult, error) {
+	return runRemote(cfg, log, command, description, nil, true)
+}
+
+// RunWithInput executes a command on the remote host with the given input
+// connected to its stdin and streams the output
+func RunWithInput(cfg *config.Config, log *logger.Logger, command string, description string, input io.Reader) (*CommandResult, error) {
+	return runRemote(cfg, log, command, description, input, true)
+}
+
+// Capture executes a command on the remote host and returns its output
+// without echoing it to the console
+func Capture(cfg *config.Config, log *logger.Logger, command string, description string) (*CommandResult, error) {
+	return runRemote(cfg, log, command, description, nil, false)
 }
 
-// GetCommand returns the full SSH command with or without the key flag
-func GetCommand(cfg *config.Config) string {
-	sshKeyFlag := GetKeyFlag(cfg)
-	if sshKeyFlag != "" {
-		return fmt.Sprintf("ssh %s %s@%s", sshKeyFlag, cfg.User, cfg.Host)
+// RunWithOutput executes a command on the remote host with its stdout
+// connected to the given writer, for binary output such as saved images.
+// Only stderr is kept in the result.
+func RunWithOutput(cfg *config.Config, log *logger.Logger, command string, description string, output io.Writer) (*CommandResult, error) {
+	if err := logCommand(log, description, fmt.Sprintf("Executing on %s: %s", cfg.Host, command)); err != nil {
+		return nil, err
 	}
-	return fmt.Sprintf("ssh %s@%s", cfg.User, cfg.Host)
+
+	started := time.Now()
+
+	stdout, stderr, wait, err := startRemote(cfg, log, command, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var stderrText strings.Builder
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		io.Copy(&stderrText, stderr)
+	}()
+
+	// Keep reading when the writer fails, so the command can finish
+	_, copyErr := io.Copy(output, stdout)
+	if copyErr != nil {
+		io.Copy(io.Discard, stdout)
+	}
+	wg.Wait()
+
+	exitCode, err := wait()
+	if err == nil && copyErr != nil {
+		exitCode, err = -1, copyErr
+	}
+
+	return finish(log, command, description, started, "", stderrText.String(), stderrText.String(), exitCode, err)
 }
 
-// Check checks SSH connection to the remote host
-func Check(cfg *config.Config, log *logger.Logger) error {
-	command := fmt.Sprintf("%s echo \"SSH connection successful\"", GetCommand(cfg))
-	_, err := ExecuteCommand(log, command, "Checking SSH connection")
-	return err
+// Stream executes a long-running command on the remote host and echoes its
+// output line by line until it exits or the command is cancelled. The output
+// is not kept in memory or recorded in the transcript. A command exiting with
+// a non-zero code returns an ExitError. A dry run only prints the command.
+func Stream(cfg *config.Config, log *logger.Logger, command string, description string) error {
+	return Follow(cfg, log, command, description, nil)
 }
 
-// ExecuteCommand executes a shell command and streams the output
-func ExecuteCommand(log *logger.Logger, command string, description string) (*CommandResult, error) {
-	if err := log.Info(fmt.Sprintf("%s...", description)); err != nil {
+// Follow streams a command like Stream and also passes every line of its
+// output to fn, one line at a time, so the caller can stop the command by
+// cancelling the context of cfg
+func Follow(cfg *config.Config, log *logger.Logger, command string, description string, fn func(line string)) error {
+	if DryRun() {
+		_, err := printDryRun(log, description, cfg.Host, command)
+		return err
+	}
+
+	if err := logCommand(log, description, fmt.Sprintf("Executing on %s: %s", cfg.Host, command)); err != nil {
+		return err
+	}
+
+	stdout, stderr, wait, err := startRemote(cfg, log, command, nil)
+	if err != nil {
+		return err
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	wg.Add(2)
+	for _, output := range []io.Reader{stdout, stderr} {
+		go func(output io.Reader) {
+			defer wg.Done()
+			scanner := bufio.NewScanner(output)
+			for scanner.Scan() {
+				log.Output(scanner.Text())
+				if fn != nil {
+					mu.Lock()
+					fn(scanner.Text())
+					mu.Unlock()
+				}
+			}
+		}(output)
+	}
+	wg.Wait()
+
+	if exitCode, err := wait(); err != nil {
+		if cfg.Context().Err() != nil {
+			return nil
+		}
+		if exitCode > 0 {
+			return &ExitError{Code: exitCode, Err: err}
+		}
+		return fmt.Errorf("command failed: %v", err)
+	}
+
+	return nil
+}
+
+// ExitError is a streamed command that ran to completion with a non-zero
+// exit code
+type ExitError struct {
+	Code int
+	Err  error
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("command failed with exit code %d: %v", e.Code, e.Err)
+}
+
+func (e *ExitError) Unwrap() error {
+	return e.Err
+}
+
+// runRemote executes a command in a new session on the remote host
+func runRemote(cfg *config.Config, log *logger.Logger, command string, description string, input io.Reader, stream bool) (*CommandResult, error) {
+	if DryRun() && stream {
+		return printDryRun(log, description, cfg.Host, command)
+	}
+
+	if err := logCommand(log, description, fmt.Sprintf("Executing on %s: %s", cfg.Host, command)); err != nil {
 		return nil, err
 	}
-	if err := log.Info(fmt.Sprintf("Executing: %s", command)); err != nil {
+
+	started := time.Now()
+
+	stdout, stderr, wait, err := startRemote(cfg, log, command, input)
+	if err != nil {
 		return nil, err
 	}
 
-	cmd := exec.Command("sh", "-c", command)
+	stdoutText, stderrText, readErr := readOutput(log, stdout, stderr, stream)
+	exitCode, err := wait()
+	if err == nil && readErr != nil {
+		exitCode, err = -1, readErr
+	}
+
+	// The output of captured commands is data for the caller, such as the
+	// deployment history, and is left out of the transcript
+	output := stdoutText + stderrText
+	if !stream {
+		output = stderrText
+	}
+	return finish(log, command, description, started, stdoutText, stderrText, output, exitCode, err)
+}
+
+// CopyFile copies a local file to the remote host over SFTP. Remote paths
+// starting with ~/ are relative to the login directory. A non-zero mode sets
+// the permissions of the remote file.
+func CopyFile(cfg *config.Config, log *logger.Logger, localPath string, remotePath string, mode os.FileMode, description string) error {
+	if DryRun() {
+		put := "sftp put"
+		if mode != 0 {
+			put = fmt.Sprintf("sftp put (mode %04o)", mode)
+		}
+		_, err := printDryRun(log, description, "local", fmt.Sprintf("%s %s %s:%s", put, localPath, cfg.Host, remotePath))
+		return err
+	}
+
+	if err := logCommand(log, description, fmt.Sprintf("Copying %s to %s:%s", localPath, cfg.Host, remotePath)); err != nil {
+		return err
+	}
+
+	started := time.Now()
+	err := copyFile(cfg, localPath, strings.TrimPrefix(remotePath, "~/"), mode)
+
+	exitCode := 0
+	if err != nil {
+		exitCode = 1
+	}
+	_, err = finish(log, fmt.Sprintf("sftp put %s %s", localPath, remotePath), description,
+		started, "", "", "", exitCode, err)
+	return err
+}
+
+// WriteFile writes data to a file on the remote host over SFTP, readable
+// only by the SSH user. It is meant for secrets, so the data is neither
+// logged nor recorded in the transcript.
+func WriteFile(cfg *config.Config, log *logger.Logger, data []byte, remotePath string, description string) error {
+	if DryRun() {
+		_, err := printDryRun(log, description, "local", fmt.Sprintf("sftp put (mode 0600) %s:%s", cfg.Host, remotePath))
+		return err
+	}
 
-	stdout, err := cmd.StdoutPipe()
+	if err := logCommand(log, description, fmt.Sprintf("Writing %d bytes to %s:%s", len(data), cfg.Host, remotePath)); err != nil {
+		return err
+	}
+
+	started := time.Now()
+	err := upload(cfg, bytes.NewReader(data), strings.TrimPrefix(remotePath, "~/"), 0600)
+
+	exitCode := 0
 	if err != nil {
-		return nil, fmt.Errorf("failed to create stdout pipe: %v", err)
+		exitCode = 1
 	}
+	_, err = finish(log, fmt.Sprintf("sftp put %s", remotePath), description, started, "", "", "", exitCode, err)
+	return err
+}
 
-	stderr, err := cmd.StderrPipe()
+// copyFile uploads a local file over SFTP
+func copyFile(cfg *config.Config, localPath string, remotePath string, mode os.FileMode) error {
+	src, err := os.Open(localPath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create stderr pipe: %v", err)
+		return err
 	}
+	defer src.Close()
 
-	if err := cmd.Start(); err != nil {
-		return nil, fmt.Errorf("failed to start command: %v", err)
+	if err := upload(cfg, src, remotePath, mode); err != nil {
+		return fmt.Errorf("failed to upload %s: %v", localPath, err)
+	}
+	return nil
+}
+
+// upload performs the SFTP upload, creating the remote directory if needed.
+// A non-zero mode is applied before any data is written.
+func upload(cfg *config.Config, src io.Reader, remotePath string, mode os.FileMode) error {
+	if ctx := cfg.Context(); ctx.Err() != nil {
+		return contextError(ctx)
+	}
+	if executor := executorOf(cfg); executor != nil {
+		return executor.Upload(cfg.Context(), cfg.Host, remotePath, src, mode)
+	}
+	if cfg.DockerTLS() {
+		return uploadDockerTLS(cfg, src, remotePath, mode)
+	}
+
+	client, err := connect(cfg)
+	if err != nil {
+		return classify(err, "")
 	}
 
-	var stdoutBuilder, stderrBuilder strings.Builder
+	sftpClient, err := sftp.NewClient(client)
+	if err != nil {
+		return fmt.Errorf("failed to start SFTP session: %v", err)
+	}
+	defer sftpClient.Close()
+
+	if dir := path.Dir(remotePath); dir != "." {
+		if err := sftpClient.MkdirAll(dir); err != nil {
+			return fmt.Errorf("failed to create remote directory %s: %v", dir, err)
+		}
+	}
+
+	dst, err := sftpClient.Create(remotePath)
+	if err != nil {
+		return fmt.Errorf("failed to create remote file %s: %v", remotePath, err)
+	}
+	defer dst.Close()
+
+	if mode != 0 {
+		if err := dst.Chmod(mode); err != nil {
+			return fmt.Errorf("failed to set permissions of %s: %v", remotePath, err)
+		}
+	}
+
+	_, err = io.Copy(dst, src)
+	return err
+}
+
+// logCommand logs the description and details of a command about to run
+func logCommand(log *logger.Logger, description string, details string) error {
+	if err := log.Info(fmt.Sprintf("%s...", description)); err != nil {
+		return err
+	}
+	return log.Debug(details)
+}
+
+// readOutput reads stdout and stderr until both are closed, optionally
+// echoing each line to the console. Only the end of very long streamed
+// output is returned, the full output is kept in a temporary file. Captured
+// output is returned whole, or fails the command when it is too large.
+func readOutput(log *logger.Logger, stdout io.Reader, stderr io.Reader, stream bool) (string, string, error) {
+	stdoutBuffer := &outputBuffer{log: log, name: "output"}
+	captured := &captureBuffer{}
+	var output lineBuffer = stdoutBuffer
+	maxLength := maxLineLength
+	if !stream {
+		output = captured
+		maxLength = maxCaptureOutput + 1
+	}
+	stderrBuffer := &outputBuffer{log: log, name: "errors"}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	wg.Add(2)
 
 	// Read stdout in real-time
 	go func() {
-		scanner := bufio.NewScanner(stdout)
-		for scanner.Scan() {
-			line := scanner.Text()
-			fmt.Println(line)
-			stdoutBuilder.WriteString(line + "\n")
-		}
+		defer wg.Done()
+		readLines(stdout, maxLength, func(line string) {
+			if stream {
+				log.Stream(line)
+			}
+			mu.Lock()
+			output.WriteLine(line)
+			mu.Unlock()
+		})
 	}()
 
 	// Read stderr in real-time
 	go func() {
-		scanner := bufio.NewScanner(stderr)
-		for scanner.Scan() {
-			line := scanner.Text()
+		defer wg.Done()
+		readLines(stderr, maxLength, func(line string) {
+			mu.Lock()
 			if strings.Contains(line, "error") || strings.Contains(line, "Error") {
-				fmt.Println("ERROR:", line)
-				stderrBuilder.WriteString(line + "\n")
+				if stream {
+					log.Stream("ERROR: " + line)
+				}
+				stderrBuffer.WriteLine(line)
 			} else {
-				fmt.Println(line)
-				stdoutBuilder.WriteString(line + "\n")
+				if stream {
+					log.Stream(line)
+				}
+				output.WriteLine(line)
 			}
-		}
+			mu.Unlock()
+		})
 	}()
 
-	if err := cmd.Wait(); err != nil {
-		if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() != 0 {
-			return nil, fmt.Errorf("command failed with exit code %d: %v", exitErr.ExitCode(), err)
-		}
-		return nil, fmt.Errorf("command failed: %v", err)
-	}
+	wg.Wait()
+	stdoutBuffer.Close()
+	stderrBuffer.Close()
+
+	return output.String(), stderrBuffer.String(), captured.Err()
+}
 
+// finish records a completed command in the transcript, with the end of the
+// given output, and builds its result
+func finish(log *logger.Logger, command string, description string, started time.Time, stdout string, stderr string, output string, exitCode int, err error) (*CommandResult, error) {
 	result := &CommandResult{
-		Stdout: stdoutBuilder.String(),
-		Stderr: stderrBuilder.String(),
+		Stdout:   stdout,
+		Stderr:   stderr,
+		ExitCode: exitCode,
+		Duration: time.Since(started),
+	}
+
+	log.Record(logger.Step{
+		Description: description,
+		Command:     command,
+		Duration:    result.Duration,
+		ExitCode:    result.ExitCode,
+		Output:      trimOutput(output),
+	})
+
+	if err != nil {
+		if exitCode > 0 {
+			err = fmt.Errorf("command failed with exit code %d: %v", exitCode, err)
+		} else {
+			err = fmt.Errorf("command failed: %v", err)
+		}
+		return nil, classify(diagnose(command, err, stdout+stderr), stdout+stderr)
 	}
 
 	return result, nil
-} 
\ No newline at end of file
+}
+
+// trimOutput keeps the last lines of a command's output for the transcript
+func trimOutput(output string) string {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	if len(lines) > maxTranscriptLines {
+		lines = lines[len(lines)-maxTranscriptLines:]
+	}
+	return strings.Join(lines, "\n")
+}
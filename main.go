@@ -1,36 +1,241 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
 
 	"github.com/bjarneo/pipe/internal/config"
 	"github.com/bjarneo/pipe/internal/deploy"
 	"github.com/bjarneo/pipe/internal/logger"
+	"github.com/bjarneo/pipe/internal/ssh"
 )
 
 func main() {
-	log := initLogger()
+	// The command defaults to deploy so plain `pipe [options]` keeps working
+	command, args := "deploy", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+
+	switch command {
+	case "help":
+		config.PrintHelp()
+		return
+	case "version":
+		config.PrintVersion()
+		return
+	case "completion":
+		if len(args) != 1 {
+			fmt.Fprintln(os.Stderr, "ERROR: usage: pipe completion bash|zsh|fish")
+			os.Exit(2)
+		}
+		script, err := config.Completion(args[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+			os.Exit(2)
+		}
+		fmt.Print(script)
+		return
+	case "__complete":
+		// Lists the app or service names for the completion scripts
+		if len(args) == 0 {
+			os.Exit(2)
+		}
+		names, err := config.CompletionNames(args[0], args[1:])
+		if err != nil {
+			os.Exit(1)
+		}
+		for _, name := range names {
+			fmt.Println(name)
+		}
+		return
+	}
+
+	// Show the general help for `pipe --help` without a command
+	if len(os.Args) > 1 && (os.Args[1] == "--help" || os.Args[1] == "-help" || os.Args[1] == "-h") {
+		config.PrintHelp()
+		return
+	}
+
+	cfg, err := config.Load(command, args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		os.Exit(2)
+	}
+
+	log := initLogger(&cfg)
 	defer log.Close()
+	defer ssh.CloseAll()
+
+	for _, value := range config.ResolvedValues() {
+		log.Info("Resolved " + value)
+	}
 
-	cfg := config.Load()
+	ctx, cancel := commandContext(&cfg, log)
+	defer cancel()
 
-	if cfg.Rollback {
-		if err := deploy.Rollback(&cfg, log); err != nil {
-			log.Error("Rollback failed", err)
-			os.Exit(1)
+	err = runCommand(cfg.WithContext(ctx), log)
+	if resultErr := deploy.PrintResult(&cfg, log, os.Stdout, err); resultErr != nil {
+		log.Warn(resultErr.Error())
+	}
+	if err != nil {
+		log.Error(fmt.Sprintf("%s failed", commandTitle(&cfg)), err)
+		os.Exit(ssh.ExitCode(err))
+	}
+}
+
+// runCommand runs the configured command
+func runCommand(cfg *config.Config, log *logger.Logger) error {
+	args := cfg.Args
+
+	if err := cfg.CheckReadOnly(); err != nil {
+		return err
+	}
+
+	// Only deploy, rollback, plan and validate handle a whole stack at once, while
+	// accessories, the fleet and its metrics are shared by the stack, and
+	// list and discover look at every app
+	if len(cfg.Stack) > 0 && !slices.Contains([]string{"deploy", "rollback", "plan", "validate", "list", "discover", "accessory", "fleet", "metrics"}, cfg.Command) {
+		return fmt.Errorf("%s works on a single service of the stack, choose one with --service", cfg.Command)
+	}
+
+	switch cfg.Command {
+	case "deploy":
+		if cfg.Rollback {
+			return deploy.Rollback(cfg, log)
 		}
-	} else {
-		if err := deploy.Deploy(&cfg, log); err != nil {
-			log.Error("Deployment failed", err)
-			os.Exit(1)
+		return deploy.Deploy(cfg, log)
+	case "rollback":
+		return deploy.Rollback(cfg, log)
+	case "plan":
+		return deploy.Plan(cfg, log)
+	case "releases":
+		return deploy.Releases(cfg, log, args)
+	case "history":
+		return deploy.History(cfg, log)
+	case "maintenance":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: pipe maintenance on|off")
 		}
+		return deploy.Maintenance(cfg, log, args[0])
+	case "destroy":
+		return deploy.Destroy(cfg, log)
+	case "compare":
+		return deploy.Compare(cfg, log, args)
+	case "init":
+		return deploy.Init(cfg, log)
+	case "validate":
+		return deploy.Validate(cfg, log)
+	case "adopt":
+		return deploy.Adopt(cfg, log, args)
+	case "logs":
+		return deploy.Logs(cfg, log)
+	case "status":
+		return deploy.Status(cfg, log)
+	case "list":
+		return deploy.List(cfg, log)
+	case "discover":
+		return deploy.Discover(cfg, log)
+	case "exec":
+		return deploy.Exec(cfg, log, args)
+	case "run":
+		return deploy.Run(cfg, log, args)
+	case "jobs":
+		return deploy.Jobs(cfg, log, args)
+	case "unlock":
+		return deploy.Unlock(cfg, log)
+	case "doctor":
+		return deploy.Doctor(cfg, log)
+	case "host":
+		return deploy.Host(cfg, log, args)
+	case "mirror":
+		return deploy.Mirror(cfg, log)
+	case "pull-remote":
+		return deploy.PullRemote(cfg, log)
+	case "accessory":
+		return deploy.Accessory(cfg, log, args)
+	case "fleet":
+		return deploy.Fleet(cfg, log, args)
+	case "agent":
+		return deploy.Agent(cfg, log, args)
+	case "metrics":
+		return deploy.Metrics(cfg, log, args)
+	default:
+		return fmt.Errorf("unknown command %q", cfg.Command)
+	}
+}
+
+// commandTitle returns the name used for a command in failure messages
+func commandTitle(cfg *config.Config) string {
+	switch {
+	case cfg.Command == "deploy" && cfg.Rollback, cfg.Command == "rollback":
+		return "Rollback"
+	case cfg.Command == "deploy":
+		return "Deployment"
+	default:
+		return strings.ToUpper(cfg.Command[:1]) + cfg.Command[1:]
 	}
 }
 
-func initLogger() *logger.Logger {
-	log, err := logger.New("deploy.log")
+// commandContext returns the context the command runs under. It is cancelled
+// on Ctrl+C or SIGTERM, or when the timeout passes, which stops the running
+// remote command and restores the previous container if a deployment was
+// switching over. A second Ctrl+C exits at once.
+func commandContext(cfg *config.Config, log *logger.Logger) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	if timeout, _ := cfg.TimeoutDuration(); timeout > 0 {
+		cancel()
+		ctx, cancel = context.WithTimeout(context.Background(), timeout)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-signals:
+			signal.Reset(os.Interrupt, syscall.SIGTERM)
+			log.Warn("Cancelling, press Ctrl+C again to exit immediately")
+			cancel()
+		case <-ctx.Done():
+			signal.Reset(os.Interrupt, syscall.SIGTERM)
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				log.Warn(fmt.Sprintf("Timed out after %s, cancelling", cfg.Timeout))
+			}
+		}
+	}()
+
+	return ctx, cancel
+}
+
+func initLogger(cfg *config.Config) *logger.Logger {
+	maxSize, maxAge, _ := cfg.LogRotation()
+	log, err := logger.New(cfg.LogFilename(), logger.Rotation{MaxSize: maxSize, MaxAge: maxAge})
 	if err != nil {
-		log.Fatal(err)
+		fmt.Fprintf(os.Stderr, "FATAL: %v\n", err)
+		os.Exit(1)
+	}
+
+	switch {
+	case cfg.Verbose:
+		log.SetLevel(logger.LevelDebug)
+	case cfg.Quiet:
+		log.SetLevel(logger.LevelWarn)
+	}
+	log.SetJSON(cfg.LogFormat == config.LogFormatJSON)
+	log.SetGitHubActions(os.Getenv("GITHUB_ACTIONS") == "true")
+	// Keep stdout for the result document
+	if cfg.OutputFormat == config.OutputJSON {
+		log.SetOutput(os.Stderr, os.Stderr)
+	}
+	deploy.MaskSecrets(cfg, log)
+	if cfg.AppName != "" {
+		return log.WithApp(cfg.AppName)
 	}
 	return log
 }
@@ -0,0 +1,199 @@
+// Package copepodtest starts throwaway hosts for end-to-end tests of
+// deployments, hooks and custom steps. Each host is a Docker-in-Docker
+// container on this machine running sshd, so pipe reaches it over SSH and
+// deploys to its Docker daemon like to a real server.
+//
+//	func TestDeploy(t *testing.T) {
+//		host := copepodtest.Start(t)
+//
+//		cfg := host.Config()
+//		cfg.ContainerName = "myapp"
+//		cfg.PrebuiltImage = "nginx:alpine"
+//		cfg.ContainerPort = "80"
+//
+//		deployer := pipe.NewDeployer(cfg, pipe.NewLogger(io.Discard), nil)
+//		defer deployer.Close()
+//		if err := deployer.Deploy(); err != nil {
+//			t.Fatal(err)
+//		}
+//
+//		if out := host.Run(t, "docker ps --format {{.Names}}"); !strings.Contains(out, "myapp") {
+//			t.Fatalf("container not running: %s", out)
+//		}
+//	}
+//
+// Tests using a host are skipped when docker is not available. Starting a
+// host needs privileged containers and, the first time, network access to
+// pull docker:dind and install openssh.
+package copepodtest
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/pkg/pipe"
+)
+
+const (
+	// image runs the Docker daemon of a host
+	image = "docker:dind"
+
+	// startTimeout is how long a host may take to start sshd and its Docker
+	// daemon
+	startTimeout = 2 * time.Minute
+)
+
+// setupScript installs and starts sshd with the authorized key given as
+// its first argument, then hands over to the Docker daemon
+const setupScript = `set -e
+apk add --no-cache openssh-server >/dev/null
+ssh-keygen -A >/dev/null
+sed -i 's/^root:!*:/root:*:/' /etc/shadow
+mkdir -p /root/.ssh && chmod 700 /root/.ssh
+printf '%s\n' "$1" > /root/.ssh/authorized_keys && chmod 600 /root/.ssh/authorized_keys
+/usr/sbin/sshd
+exec dockerd-entrypoint.sh`
+
+// unsafeNameChars are the characters of a test name not allowed in a
+// container name
+var unsafeNameChars = regexp.MustCompile(`[^a-zA-Z0-9_.-]`)
+
+// Host is a throwaway host running sshd and a Docker daemon
+type Host struct {
+	// Container is the name of the container of the host
+	Container string
+
+	// Address is the host and port sshd listens on, such as 127.0.0.1:32768
+	Address string
+
+	// User is the SSH user, which has access to Docker
+	User string
+
+	// KeyPath is the private key authorized for the user
+	KeyPath string
+
+	// KnownHosts is the known_hosts file recording the key of the host
+	KnownHosts string
+}
+
+// Start starts a host and removes it again when the test and its subtests
+// have finished. The test is skipped when docker is not available, and
+// fails when the host does not start.
+func Start(t testing.TB) *Host {
+	t.Helper()
+	if err := exec.Command("docker", "info").Run(); err != nil {
+		t.Skipf("docker is not available: %v", err)
+	}
+
+	dir := t.TempDir()
+	keyPath, publicKey, err := writeKey(dir)
+	if err != nil {
+		t.Fatalf("failed to create SSH key: %v", err)
+	}
+
+	name := fmt.Sprintf("copepodtest-%d-%s", os.Getpid(), strings.ToLower(unsafeNameChars.ReplaceAllString(t.Name(), "-")))
+	if err := docker("run", "-d", "--privileged", "--name", name, "-p", "127.0.0.1::22",
+		"--entrypoint", "sh", image, "-c", setupScript, "sh", publicKey); err != nil {
+		t.Fatalf("failed to start host: %v", err)
+	}
+	t.Cleanup(func() {
+		docker("rm", "-f", "-v", name)
+	})
+
+	host := &Host{Container: name, User: "root", KeyPath: keyPath, KnownHosts: filepath.Join(dir, "known_hosts")}
+	if err := host.wait(); err != nil {
+		logs, _ := exec.Command("docker", "logs", name).CombinedOutput()
+		t.Fatalf("host did not start: %v\n%s", err, logs)
+	}
+	return host
+}
+
+// Config returns a configuration deploying to the host, with the defaults
+// of the pipe command otherwise
+func (h *Host) Config() pipe.Config {
+	cfg := pipe.DefaultConfig()
+	cfg.Host = h.Address
+	cfg.Hosts = []string{h.Address}
+	cfg.User = h.User
+	cfg.SSHKey = h.KeyPath
+	cfg.HostKeyCheck = config.HostKeyAcceptNew
+	cfg.KnownHosts = h.KnownHosts
+	return cfg
+}
+
+// Run runs a shell command on the host, in the home directory of the user,
+// and returns its output. The test fails when the command fails.
+func (h *Host) Run(t testing.TB, command string) string {
+	t.Helper()
+	output, err := exec.Command("docker", "exec", "-w", "/root", h.Container, "sh", "-c", command).CombinedOutput()
+	if err != nil {
+		t.Fatalf("%s failed on %s: %v\n%s", command, h.Container, err, output)
+	}
+	return string(output)
+}
+
+// wait waits for sshd and the Docker daemon of the host and looks up the
+// address sshd is published on
+func (h *Host) wait() error {
+	deadline := time.Now().Add(startTimeout)
+	for {
+		ready := exec.Command("docker", "exec", h.Container, "sh", "-c", "pgrep sshd >/dev/null && docker info >/dev/null").Run() == nil
+		if ready {
+			output, err := exec.Command("docker", "port", h.Container, "22/tcp").Output()
+			if err != nil {
+				return fmt.Errorf("failed to look up the SSH port: %v", err)
+			}
+			h.Address = strings.TrimSpace(strings.Split(string(output), "\n")[0])
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("sshd and docker did not start within %s", startTimeout)
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// writeKey writes a new private key to dir and returns its path and the
+// public key in authorized_keys format
+func writeKey(dir string) (string, string, error) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	block, err := gossh.MarshalPrivateKey(private, "copepodtest")
+	if err != nil {
+		return "", "", err
+	}
+	keyPath := filepath.Join(dir, "id_ed25519")
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(block), 0600); err != nil {
+		return "", "", err
+	}
+
+	publicKey, err := gossh.NewPublicKey(public)
+	if err != nil {
+		return "", "", err
+	}
+	return keyPath, strings.TrimSpace(string(gossh.MarshalAuthorizedKey(publicKey))), nil
+}
+
+// docker runs a docker command, returning its output with the error
+func docker(args ...string) error {
+	output, err := exec.Command("docker", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
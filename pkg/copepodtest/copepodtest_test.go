@@ -0,0 +1,61 @@
+package copepodtest_test
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/bjarneo/pipe/pkg/copepodtest"
+	"github.com/bjarneo/pipe/pkg/pipe"
+)
+
+// TestDeployAndRollback deploys two versions of an app to a host and rolls
+// back to the first
+func TestDeployAndRollback(t *testing.T) {
+	host := copepodtest.Start(t)
+
+	deploy := func(image string) {
+		t.Helper()
+		deployer := pipe.NewDeployer(appConfig(host, image), pipe.NewLogger(io.Discard), nil)
+		defer deployer.Close()
+		if err := deployer.Deploy(); err != nil {
+			t.Fatalf("failed to deploy %s: %v", image, err)
+		}
+		if running := runningImage(t, host); running != image {
+			t.Fatalf("expected %s to run after the deployment, got %s", image, running)
+		}
+	}
+	deploy("nginx:1.26-alpine")
+	deploy("nginx:1.27-alpine")
+
+	deployer := pipe.NewDeployer(appConfig(host, "nginx:1.27-alpine"), pipe.NewLogger(io.Discard), nil)
+	defer deployer.Close()
+	if err := deployer.Rollback(); err != nil {
+		t.Fatalf("failed to roll back: %v", err)
+	}
+	if running := runningImage(t, host); running != "nginx:1.26-alpine" {
+		t.Fatalf("expected nginx:1.26-alpine to run after the rollback, got %s", running)
+	}
+}
+
+// appConfig returns the configuration deploying the app with an image to
+// the host
+func appConfig(host *copepodtest.Host, image string) pipe.Config {
+	cfg := host.Config()
+	cfg.ContainerName = "myapp"
+	cfg.PrebuiltImage = image
+	cfg.ContainerPort = "80"
+	cfg.AutoApprove = true
+	return cfg
+}
+
+// runningImage returns the image of the running app container on the host
+func runningImage(t *testing.T, host *copepodtest.Host) string {
+	t.Helper()
+	out := host.Run(t, "docker inspect --format '{{.State.Status}} {{.Config.Image}}' myapp")
+	status, image, _ := strings.Cut(strings.TrimSpace(out), " ")
+	if status != "running" {
+		t.Fatalf("container myapp is %s", status)
+	}
+	return image
+}
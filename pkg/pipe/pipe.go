@@ -0,0 +1,312 @@
+// Package pipe deploys Docker containers to remote hosts over SSH. It runs
+// the same deploy, rollback, plan and status pipelines as the pipe command,
+// for tools that embed deployments instead of shelling out to the binary.
+//
+//	cfg := pipe.DefaultConfig()
+//	cfg.Hosts = []string{"example.com"}
+//	cfg.User = "deploy"
+//	cfg.ContainerName = "myapp"
+//	cfg.AutoApprove = true
+//
+//	deployer := pipe.NewDeployer(cfg, pipe.NewLogger(os.Stdout), nil)
+//	defer deployer.Close()
+//	if err := deployer.Deploy(); err != nil {
+//		// ...
+//	}
+//
+// Cancelling a context set with cfg = *cfg.WithContext(ctx) stops the
+// running command and restores the previous container, like Ctrl+C does for
+// the pipe command.
+//
+// Deployers keep their executors, steps, notifiers and report handlers to
+// themselves. The SSH connections, the dry run mode and the secret resolvers
+// are shared by the process.
+package pipe
+
+import (
+	"io"
+	"maps"
+	"slices"
+
+	"github.com/bjarneo/pipe/internal/config"
+	"github.com/bjarneo/pipe/internal/deploy"
+	"github.com/bjarneo/pipe/internal/logger"
+	"github.com/bjarneo/pipe/internal/notify"
+	"github.com/bjarneo/pipe/internal/secrets"
+	"github.com/bjarneo/pipe/internal/ssh"
+)
+
+// Config is the configuration of an app, with the same fields as the config
+// file
+type Config = config.Config
+
+// Configuration sections, for building a Config in code
+type (
+	Hooks        = config.Hooks
+	Hook         = config.Hook
+	Agent        = config.Agent
+	Accessory    = config.Accessory
+	Notification = config.Notification
+	Monitor      = config.Monitor
+	Updates      = config.Updates
+	LogShipping  = config.LogShipping
+	Metrics      = config.Metrics
+)
+
+// Logger writes the progress of a deployment to the console and a log file
+type Logger = logger.Logger
+
+// Log levels for Logger.SetLevel
+const (
+	LevelDebug = logger.LevelDebug
+	LevelInfo  = logger.LevelInfo
+	LevelWarn  = logger.LevelWarn
+	LevelError = logger.LevelError
+)
+
+// Executor runs commands on the remote hosts in place of the built-in SSH
+// client, for example to go through a bastion API or to record commands in
+// tests. Local commands such as docker build still run on this machine
+// unless a LocalExecutor is set.
+type Executor = ssh.Executor
+
+// LocalExecutor runs the commands pipe runs on this machine, such as docker
+// build, docker save and local hooks, in place of starting processes.
+// Together with a fake Executor the whole pipeline runs in tests without
+// docker or a host.
+type LocalExecutor = ssh.LocalExecutor
+
+// Step is a custom step inserted into the pipeline, such as an update of an
+// internal inventory. Its Run method gets the configuration of the host it
+// runs for, and the deployment's failure in onFailure steps.
+type Step = deploy.Step
+
+// Positions in the pipeline for Deployer.AddStep. Steps run right after the
+// hooks of the same name: PreBuild once per deployment, the others on every
+// host. PreRollback and PostRollback run around a rollback.
+const (
+	PreBuild     = deploy.StepPreBuild
+	PreDeploy    = deploy.StepPreDeploy
+	PostDeploy   = deploy.StepPostDeploy
+	OnFailure    = deploy.StepOnFailure
+	PreRollback  = deploy.StepPreRollback
+	PostRollback = deploy.StepPostRollback
+)
+
+// NewStep returns a step that runs fn
+func NewStep(name string, fn func(cfg *Config, log *Logger, runErr error) error) Step {
+	return funcStep{name: name, fn: fn}
+}
+
+// funcStep is a step backed by a function
+type funcStep struct {
+	name string
+	fn   func(cfg *Config, log *Logger, runErr error) error
+}
+
+func (s funcStep) Name() string {
+	return s.name
+}
+
+func (s funcStep) Run(cfg *Config, log *Logger, runErr error) error {
+	return s.fn(cfg, log, runErr)
+}
+
+// Event describes a deployment or rollback that started, succeeded or failed
+type Event = notify.Event
+
+// Event statuses
+const (
+	Started   = notify.Started
+	Succeeded = notify.Succeeded
+	Failed    = notify.Failed
+)
+
+// Notifier receives the events of deployments and rollbacks next to the
+// webhooks of the configuration, for backends without built-in support such
+// as Mattermost. A failing notifier is reported as a warning and never fails
+// the deployment.
+type Notifier = notify.Notifier
+
+// NewNotifier returns a notifier that runs fn
+func NewNotifier(name string, fn func(event Event) error) Notifier {
+	return funcNotifier{name: name, fn: fn}
+}
+
+// funcNotifier is a notifier backed by a function
+type funcNotifier struct {
+	name string
+	fn   func(event Event) error
+}
+
+func (n funcNotifier) Name() string {
+	return n.name
+}
+
+func (n funcNotifier) Notify(event Event) error {
+	return n.fn(event)
+}
+
+// Report is the outcome of a deployment or rollback with the timing of
+// every step on every host, for feeding SLO dashboards
+type Report = deploy.Report
+
+// StepTiming is the wall time and outcome of a step on a host, such as
+// build, transfer, preDeploy, start or postDeploy
+type StepTiming = logger.Timing
+
+// SecretResolver resolves the secret references of a secret manager without
+// built-in support, such as an internal secret service
+type SecretResolver = secrets.Resolver
+
+// SecretResolverFunc is a secret resolver backed by a function
+type SecretResolverFunc func(ref string) (string, error)
+
+// Resolve returns the value of a reference, without its prefix
+func (f SecretResolverFunc) Resolve(ref string) (string, error) {
+	return f(ref)
+}
+
+// RegisterSecretResolver resolves the config values starting with prefix,
+// such as secrets://, with the resolver, wherever the built-in vault:, op://
+// and aws-sm: references are accepted. The resolver gets the reference
+// without the prefix. Register resolvers before loading or deploying a
+// configuration that uses them.
+func RegisterSecretResolver(prefix string, resolver SecretResolver) error {
+	return secrets.RegisterResolver(prefix, resolver)
+}
+
+// DefaultConfig returns the configuration the pipe command starts from
+func DefaultConfig() Config {
+	return config.Defaults()
+}
+
+// LoadConfig builds the configuration of a command the way the pipe command
+// does, from the config file, environment variables and the given
+// command-line arguments
+func LoadConfig(command string, args []string) (Config, error) {
+	return config.Load(command, args)
+}
+
+// NewLogger returns a logger printing to out without a log file. Use
+// SetLevel and SetJSON to change what is printed.
+func NewLogger(out io.Writer) *Logger {
+	log, _ := logger.New("", logger.Rotation{})
+	log.SetOutput(out, out)
+	return log
+}
+
+// NewFileLogger returns a logger printing to out that also writes to the log
+// file at path
+func NewFileLogger(out io.Writer, path string) (*Logger, error) {
+	log, err := logger.New(path, logger.Rotation{})
+	if err != nil {
+		return nil, err
+	}
+	log.SetOutput(out, out)
+	return log, nil
+}
+
+// Deployer runs the deployment pipelines of an app
+type Deployer struct {
+	cfg           Config
+	log           *Logger
+	executor      Executor
+	localExecutor LocalExecutor
+	steps         map[string][]Step
+	notifiers     []Notifier
+	reporters     []func(report Report)
+}
+
+// NewDeployer returns a deployer for the app. A nil executor runs the remote
+// commands over SSH. Secrets in the configuration are masked in the log.
+func NewDeployer(cfg Config, log *Logger, executor Executor) *Deployer {
+	// Accept either of the host fields, as the command line does
+	if len(cfg.Hosts) == 0 && cfg.Host != "" {
+		cfg.Hosts = []string{cfg.Host}
+	}
+	if cfg.Host == "" && len(cfg.Hosts) > 0 {
+		cfg.Host = cfg.Hosts[0]
+	}
+	if cfg.BuildArgs == nil {
+		cfg.BuildArgs = make(map[string]string)
+	}
+
+	deploy.MaskSecrets(&cfg, log)
+	return &Deployer{cfg: cfg, log: log, executor: executor, steps: make(map[string][]Step)}
+}
+
+// SetLocalExecutor runs the local commands of the deployer with executor
+// instead of starting processes. A nil executor runs them directly.
+func (d *Deployer) SetLocalExecutor(executor LocalExecutor) {
+	d.localExecutor = executor
+}
+
+// AddStep inserts a custom step into the pipeline at a position, after the
+// steps already added there. A failing step fails the deployment like a
+// hook at the same position does.
+func (d *Deployer) AddStep(position string, step Step) error {
+	if err := deploy.CheckStepPosition(position); err != nil {
+		return err
+	}
+	d.steps[position] = append(d.steps[position], step)
+	return nil
+}
+
+// AddNotifier passes the events of the deployments and rollbacks of the
+// deployer to a notifier, after the notifiers already added
+func (d *Deployer) AddNotifier(notifier Notifier) {
+	d.notifiers = append(d.notifiers, notifier)
+}
+
+// OnReport passes the report of every deployment and rollback of the
+// deployer to fn once it has finished, successfully or not
+func (d *Deployer) OnReport(fn func(report Report)) {
+	d.reporters = append(d.reporters, fn)
+}
+
+// Deploy builds, transfers and starts the app on its hosts. Without
+// AutoApprove the plan is shown and confirmed first in interactive
+// terminals.
+func (d *Deployer) Deploy() error {
+	return deploy.Deploy(d.config(), d.log)
+}
+
+// Rollback rolls the app back to the previous version, or to the tag in
+// RollbackTo
+func (d *Deployer) Rollback() error {
+	return deploy.Rollback(d.config(), d.log)
+}
+
+// Plan prints the actions a deployment would perform
+func (d *Deployer) Plan() error {
+	return deploy.Plan(d.config(), d.log)
+}
+
+// Status prints the state of the app on its hosts, as JSON when the JSON
+// field of the configuration is set
+func (d *Deployer) Status() error {
+	return deploy.Status(d.config(), d.log)
+}
+
+// config returns a copy of the configuration of the deployer whose context
+// carries its executors, steps, notifiers and report handlers
+func (d *Deployer) config() *Config {
+	ctx := d.cfg.Context()
+	if d.executor != nil {
+		ctx = ssh.WithExecutor(ctx, d.executor)
+	}
+	if d.localExecutor != nil {
+		ctx = ssh.WithLocalExecutor(ctx, d.localExecutor)
+	}
+	ctx = deploy.WithSteps(ctx, maps.Clone(d.steps))
+	ctx = notify.WithNotifiers(ctx, slices.Clone(d.notifiers))
+	ctx = deploy.WithReportHandlers(ctx, slices.Clone(d.reporters))
+	return d.cfg.WithContext(ctx)
+}
+
+// Close closes the SSH connections and the log file
+func (d *Deployer) Close() error {
+	ssh.CloseAll()
+	return d.log.Close()
+}
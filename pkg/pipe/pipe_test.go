@@ -0,0 +1,475 @@
+package pipe
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"testing"
+	"text/template"
+)
+
+// fakeDockerState is the environment variable naming the state file of the
+// fake docker. The test binary runs as the fake docker when it is set.
+const fakeDockerState = "PIPE_FAKE_DOCKER_STATE"
+
+func TestMain(m *testing.M) {
+	if path := os.Getenv(fakeDockerState); path != "" {
+		os.Exit(fakeDocker(path, os.Args[1:]))
+	}
+	os.Exit(m.Run())
+}
+
+// fakeHost runs the remote commands with the local shell, in a temporary
+// home directory and with the test binary standing in for docker
+type fakeHost struct {
+	home  string
+	bin   string
+	state string
+}
+
+// newFakeHost returns a host without containers or images
+func newFakeHost(t *testing.T) *fakeHost {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake host needs a POSIX shell")
+	}
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	host := &fakeHost{home: filepath.Join(dir, "home"), bin: filepath.Join(dir, "bin"), state: filepath.Join(dir, "docker.json")}
+	for _, path := range []string{host.home, host.bin} {
+		if err := os.Mkdir(path, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	script := fmt.Sprintf("#!/bin/sh\nexec %q \"$@\"\n", executable)
+	if err := os.WriteFile(filepath.Join(host.bin, "docker"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return host
+}
+
+func (h *fakeHost) Run(ctx context.Context, host string, command string, stdin io.Reader, stdout io.Writer, stderr io.Writer) (int, error) {
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Env = append(os.Environ(), "HOME="+h.home, "PATH="+h.bin+string(os.PathListSeparator)+os.Getenv("PATH"), fakeDockerState+"="+h.state)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = stdin, stdout, stderr
+	if err := cmd.Run(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return exitErr.ExitCode(), err
+		}
+		return -1, err
+	}
+	return 0, nil
+}
+
+func (h *fakeHost) Upload(ctx context.Context, host string, path string, data io.Reader, mode os.FileMode) error {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(h.home, path)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	content, err := io.ReadAll(data)
+	if err != nil {
+		return err
+	}
+	if mode == 0 {
+		mode = 0644
+	}
+	return os.WriteFile(path, content, mode)
+}
+
+// Containers returns the containers of the host
+func (h *fakeHost) Containers(t *testing.T) []fakeContainer {
+	t.Helper()
+	state, err := loadDockerState(h.state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return state.Containers
+}
+
+// fakeContainer is a container of the fake docker
+type fakeContainer struct {
+	Name    string
+	Image   string
+	ImageID string
+	Running bool
+	Restart string
+	Env     []string
+}
+
+// dockerState is what the fake docker keeps between commands
+type dockerState struct {
+	Images     map[string]string
+	Containers []fakeContainer
+}
+
+// loadDockerState reads the state of the fake docker
+func loadDockerState(path string) (*dockerState, error) {
+	state := &dockerState{Images: make(map[string]string)}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return state, json.Unmarshal(data, state)
+}
+
+// container returns the container with a name, or nil
+func (s *dockerState) container(name string) *fakeContainer {
+	for i := range s.Containers {
+		if s.Containers[i].Name == name {
+			return &s.Containers[i]
+		}
+	}
+	return nil
+}
+
+// fakeDocker runs a docker command against the state in path and returns its
+// exit code
+func fakeDocker(path string, args []string) int {
+	state, err := loadDockerState(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	log, _ := os.OpenFile(path+".log", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	fmt.Fprintln(log, strings.Join(args, " "))
+	log.Close()
+
+	code := state.run(args)
+	data, _ := json.Marshal(state)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return code
+}
+
+// run runs a docker command
+func (s *dockerState) run(args []string) int {
+	if len(args) == 0 {
+		return 1
+	}
+	command, args := args[0], args[1:]
+	if command == "image" || command == "container" {
+		command, args = command+" "+args[0], args[1:]
+	}
+	flags, operands := parseDockerArgs(args, command == "run")
+
+	switch command {
+	case "info":
+		return printValues(flags["--format"], []any{map[string]any{
+			"ServerVersion": "27.0.0", "OSType": "linux", "Architecture": "x86_64",
+			"Server": map[string]any{"Version": "27.0.0", "Os": "linux", "Arch": "amd64"},
+		}})
+	case "pull":
+		s.Images[operands[0]] = imageID(operands[0])
+		return 0
+	case "tag":
+		id, ok := s.Images[operands[0]]
+		if !ok {
+			return noSuch("image", operands[0])
+		}
+		s.Images[operands[1]] = id
+		return 0
+	case "rmi", "image rm":
+		for _, ref := range operands {
+			delete(s.Images, ref)
+		}
+		return 0
+	case "image inspect":
+		var values []any
+		for _, ref := range operands {
+			id, ok := s.Images[ref]
+			if !ok {
+				return noSuch("image", ref)
+			}
+			values = append(values, imageValue(ref, id))
+		}
+		return printValues(flags["--format"], values)
+	case "images":
+		var values []any
+		for ref, id := range s.Images {
+			repository, tag, _ := strings.Cut(ref, ":")
+			if len(operands) > 0 && operands[0] != repository {
+				continue
+			}
+			values = append(values, map[string]any{"Repository": repository, "Tag": tag, "ID": id[7:19]})
+		}
+		return printValues(flags["--format"], values)
+	case "inspect", "container inspect":
+		var values []any
+		for _, name := range operands {
+			if c := s.container(name); c != nil {
+				values = append(values, c.value())
+			} else if id, ok := s.Images[name]; ok {
+				values = append(values, imageValue(name, id))
+			} else {
+				return noSuch("object", name)
+			}
+		}
+		return printValues(flags["--format"], values)
+	case "ps":
+		var values []any
+		for _, c := range s.Containers {
+			if (c.Running || flags["-a"] != "") && matchesFilter(c, flags["--filter"]) {
+				values = append(values, c.value())
+			}
+		}
+		return printValues(flags["--format"], values)
+	case "run":
+		image := operands[0]
+		if _, ok := s.Images[image]; !ok {
+			s.Images[image] = imageID(image)
+		}
+		name := flags["--name"]
+		if s.container(name) != nil {
+			fmt.Fprintf(os.Stderr, "docker: Error response from daemon: Conflict. The container name %q is already in use.\n", "/"+name)
+			return 125
+		}
+		var env []string
+		if flags["-e"] != "" {
+			env = strings.Split(flags["-e"], "\n")
+		}
+		s.Containers = append(s.Containers, fakeContainer{Name: name, Image: image, ImageID: s.Images[image], Running: true,
+			Restart: flags["--restart"], Env: env})
+		return 0
+	case "start", "stop":
+		for _, name := range operands {
+			c := s.container(name)
+			if c == nil {
+				return noSuch("container", name)
+			}
+			c.Running = command == "start"
+		}
+		return 0
+	case "rm":
+		for _, name := range operands {
+			if s.container(name) == nil && flags["-f"] == "" {
+				return noSuch("container", name)
+			}
+			s.Containers = removeContainer(s.Containers, name)
+		}
+		return 0
+	case "rename":
+		c := s.container(operands[0])
+		if c == nil {
+			return noSuch("container", operands[0])
+		}
+		if s.container(operands[1]) != nil {
+			fmt.Fprintf(os.Stderr, "Error response from daemon: name %s is in use\n", operands[1])
+			return 1
+		}
+		c.Name = operands[1]
+		return 0
+	}
+	return 0
+}
+
+// printValues prints the values with a docker format template, or as a JSON
+// array like docker inspect without one
+func printValues(format string, values []any) int {
+	if format == "" {
+		if values == nil {
+			values = []any{}
+		}
+		data, _ := json.MarshalIndent(values, "", "    ")
+		fmt.Println(string(data))
+		return 0
+	}
+	tmpl, err := template.New("format").Option("missingkey=zero").Parse(format)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "template parsing error: %v\n", err)
+		return 1
+	}
+	for _, value := range values {
+		var out strings.Builder
+		if err := tmpl.Execute(&out, value); err != nil {
+			fmt.Fprintf(os.Stderr, "template: %v\n", err)
+			return 1
+		}
+		fmt.Println(out.String())
+	}
+	return 0
+}
+
+// value returns the inspect output of a container
+func (c fakeContainer) value() map[string]any {
+	status := "exited"
+	if c.Running {
+		status = "running"
+	}
+	return map[string]any{
+		"Id": imageID("container " + c.Name), "Name": "/" + c.Name, "Names": c.Name,
+		"Image": c.ImageID, "State": map[string]any{"Status": status, "Running": c.Running},
+		"RestartCount": 0, "Ports": "",
+		"Config":     map[string]any{"Image": c.Image, "Env": c.Env},
+		"HostConfig": map[string]any{"RestartPolicy": map[string]any{"Name": c.Restart}},
+	}
+}
+
+// imageValue returns the inspect output of an image
+func imageValue(ref string, id string) map[string]any {
+	return map[string]any{"Id": id, "ID": id, "RepoTags": []string{ref}, "Os": "linux", "Architecture": "amd64"}
+}
+
+// imageID returns the ID of a pulled image or a container
+func imageID(ref string) string {
+	sum := sha256.Sum256([]byte(ref))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// matchesFilter reports whether a container passes a ps filter
+func matchesFilter(c fakeContainer, filter string) bool {
+	for _, f := range strings.Split(filter, "\n") {
+		key, value, _ := strings.Cut(f, "=")
+		switch key {
+		case "name":
+			if !regexp.MustCompile(value).MatchString(c.Name) && !regexp.MustCompile(value).MatchString("/"+c.Name) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// noSuch reports a missing object like docker does
+func noSuch(kind string, name string) int {
+	fmt.Fprintf(os.Stderr, "Error: No such %s: %s\n", kind, name)
+	return 1
+}
+
+// removeContainer removes the container with a name
+func removeContainer(containers []fakeContainer, name string) []fakeContainer {
+	kept := containers[:0]
+	for _, c := range containers {
+		if c.Name != name {
+			kept = append(kept, c)
+		}
+	}
+	return kept
+}
+
+// dockerBoolFlags are the flags of docker commands without a value
+var dockerBoolFlags = map[string]bool{
+	"-a": true, "--all": true, "-d": true, "--detach": true, "-f": true, "--force": true, "-q": true,
+	"--quiet": true, "--rm": true, "--init": true, "--privileged": true, "--read-only": true,
+	"-i": true, "-t": true, "-it": true, "--no-stream": true, "--no-trunc": true, "--follow": true,
+	"--timestamps": true,
+}
+
+// parseDockerArgs splits the arguments of a docker command into its flags,
+// with repeated values joined by newlines, and its operands. With
+// stopAtOperand the arguments after the first operand are operands too, as
+// they belong to the container in docker run.
+func parseDockerArgs(args []string, stopAtOperand bool) (map[string]string, []string) {
+	flags := make(map[string]string)
+	var operands []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "-") || stopAtOperand && len(operands) > 0 {
+			operands = append(operands, arg)
+			continue
+		}
+		name, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			if dockerBoolFlags[name] {
+				value = "true"
+			} else if i+1 < len(args) {
+				i++
+				value = args[i]
+			}
+		}
+		switch name {
+		case "--all":
+			name = "-a"
+		case "--force":
+			name = "-f"
+		case "--env":
+			name = "-e"
+		}
+		if flags[name] != "" {
+			value = flags[name] + "\n" + value
+		}
+		flags[name] = value
+	}
+	return flags, operands
+}
+
+// TestDeployAndRollback deploys two versions of an app to a fake host and
+// rolls back to the first
+func TestDeployAndRollback(t *testing.T) {
+	host := newFakeHost(t)
+
+	deploy := func(image string) {
+		t.Helper()
+		deployer := NewDeployer(fakeHostConfig(image), NewLogger(io.Discard), host)
+		defer deployer.Close()
+		if err := deployer.Deploy(); err != nil {
+			t.Fatalf("failed to deploy %s: %v\n%s", image, err, dockerLog(host))
+		}
+		if running := runningImage(t, host); running != image {
+			t.Fatalf("expected %s to run after the deployment, got %s", image, running)
+		}
+	}
+	deploy("nginx:1.26-alpine")
+	deploy("nginx:1.27-alpine")
+
+	deployer := NewDeployer(fakeHostConfig("nginx:1.27-alpine"), NewLogger(io.Discard), host)
+	defer deployer.Close()
+	if err := deployer.Rollback(); err != nil {
+		t.Fatalf("failed to roll back: %v\n%s", err, dockerLog(host))
+	}
+	if running := runningImage(t, host); running != "nginx:1.26-alpine" {
+		t.Fatalf("expected nginx:1.26-alpine to run after the rollback, got %s", running)
+	}
+}
+
+// fakeHostConfig returns the configuration deploying an image to the fake
+// host
+func fakeHostConfig(image string) Config {
+	cfg := DefaultConfig()
+	cfg.Hosts = []string{"example.com"}
+	cfg.User = "deploy"
+	cfg.ContainerName = "myapp"
+	cfg.PrebuiltImage = image
+	cfg.ContainerPort = "80"
+	cfg.AutoApprove = true
+	return cfg
+}
+
+// runningImage returns the image of the app container on the host, which
+// must be the only container and running
+func runningImage(t *testing.T, host *fakeHost) string {
+	t.Helper()
+	containers := host.Containers(t)
+	if len(containers) != 1 || containers[0].Name != "myapp" || !containers[0].Running {
+		t.Fatalf("expected only myapp running, got %+v", containers)
+	}
+	return containers[0].Image
+}
+
+// dockerLog returns the docker commands run on the host
+func dockerLog(host *fakeHost) string {
+	data, _ := os.ReadFile(host.state + ".log")
+	return string(data)
+}